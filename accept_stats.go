@@ -0,0 +1,48 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build darwin netbsd freebsd openbsd dragonfly linux
+
+package gnet
+
+import "sync/atomic"
+
+// AcceptStats records the counters of accepting connections, for verifying the pressure of accepting.
+type AcceptStats struct {
+	// Accepted is the number of connections accepted from the listener.
+	Accepted int64
+
+	// Deferred is the number of times the accept time slice ran out while accepting, which leaves the rest
+	// of pending connections to the next iteration of the event-loop.
+	Deferred int64
+
+	// Filtered is the number of accepted connections rejected by the filter set by WithConnectionFilter.
+	Filtered int64
+
+	// Rejected is the number of accepted connections rejected by OnAccept.
+	Rejected int64
+
+	// Throttled is the number of times accepting was paused by the rate limit set by WithAcceptRateLimit.
+	Throttled int64
+
+	// Limited is the number of accepted connections rejected as their hosts reached the limit set by
+	// WithMaxConnsPerHost.
+	Limited int64
+
+	// Failed is the number of times accepting failed by reason of running out of file descriptors or memory.
+	Failed int64
+}
+
+// AcceptStats returns the counters of accepting connections.
+func (s Server) AcceptStats() AcceptStats {
+	return AcceptStats{
+		Accepted:  atomic.LoadInt64(&s.svr.acceptStats.Accepted),
+		Deferred:  atomic.LoadInt64(&s.svr.acceptStats.Deferred),
+		Filtered:  atomic.LoadInt64(&s.svr.acceptStats.Filtered),
+		Rejected:  atomic.LoadInt64(&s.svr.acceptStats.Rejected),
+		Throttled: atomic.LoadInt64(&s.svr.acceptStats.Throttled),
+		Limited:   atomic.LoadInt64(&s.svr.acceptStats.Limited),
+		Failed:    atomic.LoadInt64(&s.svr.acceptStats.Failed),
+	}
+}
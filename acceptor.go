@@ -9,34 +9,193 @@ package gnet
 import (
 	"net"
 	"os"
+	"sync/atomic"
+	"time"
 
+	"github.com/panjf2000/gnet/netpoll"
 	"golang.org/x/sys/unix"
 )
 
 func (svr *server) acceptNewConnection(fd int) error {
+	if svr.mainLoop.throttleAccept(fd) {
+		return nil
+	}
 	nfd, sa, err := unix.Accept(fd)
 	if err != nil {
 		if err == unix.EAGAIN {
 			return nil
 		}
+		return svr.mainLoop.loopAcceptError(fd, err)
+	}
+	atomic.AddInt64(&svr.acceptStats.Accepted, 1)
+	if svr.isShedding() {
+		// Refuse new connections until the memory usage falls back.
+		return unix.Close(nfd)
+	}
+	key, ok, err := svr.admit(nfd, sa)
+	if !ok {
 		return err
 	}
 	if err := unix.SetNonblock(nfd, true); err != nil {
+		svr.releaseHost(key)
 		return err
 	}
+	if err := svr.applySocketOptionHook(nfd); err != nil {
+		sniffError(err)
+		svr.releaseHost(key)
+		return unix.Close(nfd)
+	}
 	lp := svr.subLoopGroup.next()
 	c := newConn(nfd, lp, sa)
+	c.hostKey = key
 	_ = lp.poller.Trigger(func() (err error) {
-		if err = lp.poller.AddRead(nfd); err != nil {
+		if err = lp.watchConn(nfd); err != nil {
+			svr.releaseHost(key)
 			return
 		}
-		lp.connections[nfd] = c
+		lp.connections.set(nfd, c)
 		err = lp.loopOpen(c)
 		return
 	})
 	return nil
 }
 
+// acceptErrorBackoff is the time during which accepting pauses after running out of resources.
+const acceptErrorBackoff = 50 * time.Millisecond
+
+// loopAcceptError handles the failure of accepting, running out of file descriptors or memory doesn't stop
+// the server but pauses accepting for a while, so that the existing connections are kept being served.
+func (lp *loop) loopAcceptError(fd int, errno error) error {
+	err := os.NewSyscallError("accept", errno)
+	switch errno {
+	case unix.EINTR, unix.ECONNABORTED:
+		return nil
+	case unix.EMFILE, unix.ENFILE, unix.ENOBUFS, unix.ENOMEM:
+		atomic.AddInt64(&lp.svr.acceptStats.Failed, 1)
+		if lp.svr.eventHandler.OnAcceptError(err) == Shutdown {
+			return ErrServerShutdown
+		}
+		lp.svr.rejectWithSpareFd(fd)
+		lp.pauseAccept(fd, acceptErrorBackoff)
+		return nil
+	default:
+		return err
+	}
+}
+
+// openSpareFd reserves a file descriptor for rejecting pending connections when file descriptors run out.
+func (svr *server) openSpareFd() {
+	svr.spareMu.Lock()
+	defer svr.spareMu.Unlock()
+	var err error
+	if svr.spareFd, err = unix.Open(os.DevNull, unix.O_RDONLY|unix.O_CLOEXEC, 0); err != nil {
+		svr.spareFd = -1
+	}
+}
+
+// closeSpareFd releases the spare file descriptor.
+func (svr *server) closeSpareFd() {
+	svr.spareMu.Lock()
+	defer svr.spareMu.Unlock()
+	if svr.spareFd >= 0 {
+		_ = unix.Close(svr.spareFd)
+		svr.spareFd = -1
+	}
+}
+
+// rejectWithSpareFd releases the spare file descriptor to accept a pending connection and close it right away,
+// so that the client doesn't hang in the backlog, then reserves the spare file descriptor again.
+func (svr *server) rejectWithSpareFd(fd int) {
+	svr.spareMu.Lock()
+	if svr.spareFd < 0 {
+		svr.spareMu.Unlock()
+		return
+	}
+	_ = unix.Close(svr.spareFd)
+	svr.spareFd = -1
+	if nfd, _, err := unix.Accept(fd); err == nil {
+		_ = unix.Close(nfd)
+	}
+	svr.spareMu.Unlock()
+	svr.openSpareFd()
+}
+
+// throttleAccept reports whether the rate of accepting is exceeded, in which case the loop stops watching the
+// listener until a token of the rate limiter is available, leaving the pending connections in the backlog.
+func (lp *loop) throttleAccept(fd int) bool {
+	limiter := lp.svr.tunables().acceptLimiter
+	if limiter == nil {
+		return false
+	}
+	ok, wait := limiter.Take(time.Now())
+	if ok || !lp.pauseAccept(fd, wait) {
+		return false
+	}
+	atomic.AddInt64(&lp.svr.acceptStats.Throttled, 1)
+	return true
+}
+
+// pauseAccept stops watching the listener for the given duration.
+func (lp *loop) pauseAccept(fd int, d time.Duration) bool {
+	if lp.poller.DeleteRead(fd) != nil {
+		return false
+	}
+	time.AfterFunc(d, func() {
+		_ = lp.poller.Trigger(func() error {
+			return lp.svr.bindListener(lp.poller, fd)
+		})
+	})
+	return true
+}
+
+// admit evaluates the filter of connections, OnAccept and the limit of connections per host with the remote
+// address of accepted socket, the socket is closed if it's rejected by any of them, and the limit of connections
+// is reported to OnAcceptError with ErrTooManyConnections. The key of host counted in is returned, which must be
+// released once the connection goes away.
+func (svr *server) admit(nfd int, sa unix.Sockaddr) (string, bool, error) {
+	remote := netpoll.SockaddrToTCPOrUnixAddr(sa)
+	if filter := svr.tunables().connectionFilter; filter != nil && !filter(remote) {
+		atomic.AddInt64(&svr.acceptStats.Filtered, 1)
+		return "", false, unix.Close(nfd)
+	}
+	action, reason := svr.eventHandler.OnAccept(remote)
+	switch action {
+	case Close, Shutdown:
+		atomic.AddInt64(&svr.acceptStats.Rejected, 1)
+		if len(reason) > 0 {
+			// The socket is still blocking, the short reason fits in the send buffer.
+			_, _ = unix.Write(nfd, reason)
+		}
+		err := unix.Close(nfd)
+		if action == Shutdown {
+			err = ErrServerShutdown
+		}
+		return "", false, err
+	}
+	limit := svr.tunables().maxConnsPerHost
+	if limit <= 0 {
+		return "", true, nil
+	}
+	key := svr.hostKey(sa)
+	if !svr.acquireHost(key, limit) {
+		atomic.AddInt64(&svr.acceptStats.Limited, 1)
+		err := unix.Close(nfd)
+		if svr.eventHandler.OnAcceptError(ErrTooManyConnections) == Shutdown {
+			err = ErrServerShutdown
+		}
+		return "", false, err
+	}
+	return key, true, nil
+}
+
+// applySocketOptionHook invokes the user-defined hook of socket options on the accepted socket.
+func (svr *server) applySocketOptionHook(fd int) error {
+	if svr.opts.SocketOptionHook == nil {
+		return nil
+	}
+	return svr.opts.SocketOptionHook(fd, svr.ln.network)
+}
+
 func (ln *listener) close() {
 	if ln.f != nil {
 		sniffError(ln.f.Close())
@@ -47,7 +206,7 @@ func (ln *listener) close() {
 	if ln.pconn != nil {
 		sniffError(ln.pconn.Close())
 	}
-	if ln.network == "unix" {
+	if ln.isUnix() && !ln.keepSocket {
 		sniffError(os.RemoveAll(ln.addr))
 	}
 }
@@ -61,6 +220,8 @@ func (ln *listener) system() error {
 		switch pconn := ln.pconn.(type) {
 		case *net.UDPConn:
 			ln.f, err = pconn.File()
+		case *net.UnixConn:
+			ln.f, err = pconn.File()
 		}
 	case *net.TCPListener:
 		ln.f, err = netln.File()
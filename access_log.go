@@ -0,0 +1,53 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build darwin netbsd freebsd openbsd dragonfly linux
+
+package gnet
+
+import (
+	"net"
+	"time"
+
+	"github.com/panjf2000/gnet/accesslog"
+)
+
+// logOpen records the opening of the given connection into the access log.
+func (svr *server) logOpen(c *conn) {
+	if svr.opts.AccessLog == nil {
+		return
+	}
+	c.openedAt = time.Now()
+	sniffError(svr.opts.AccessLog.Log(&accesslog.Record{
+		Time:       c.openedAt,
+		Event:      accesslog.EventOpen,
+		Network:    svr.ln.network,
+		LocalAddr:  addrString(c.localAddr),
+		RemoteAddr: addrString(c.remoteAddr),
+	}))
+}
+
+// logClose records the closing of the given connection into the access log.
+func (svr *server) logClose(c *conn, err error) {
+	if svr.opts.AccessLog == nil || c.openedAt.IsZero() {
+		return
+	}
+	now := time.Now()
+	sniffError(svr.opts.AccessLog.Log(&accesslog.Record{
+		Time:       now,
+		Event:      accesslog.EventClose,
+		Network:    svr.ln.network,
+		LocalAddr:  addrString(c.localAddr),
+		RemoteAddr: addrString(c.remoteAddr),
+		Duration:   now.Sub(c.openedAt),
+		Err:        err,
+	}))
+}
+
+func addrString(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	return addr.String()
+}
@@ -0,0 +1,201 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// Package accesslog records the access of gnet servers, such as connections being opened/closed and requests
+// of application protocols, with pluggable formats and an asynchronous buffered writer which never blocks
+// the event-loops.
+package accesslog
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultQueueSize is the default number of records that can be queued before being written.
+	DefaultQueueSize = 4096
+
+	// DefaultFlushInterval is the default interval of flushing the buffered records to the underlying writer.
+	DefaultFlushInterval = time.Second
+)
+
+// ErrClosed occurs when logging to a closed Logger.
+var ErrClosed = errors.New("access logger is closed")
+
+// Event is the kind of access record.
+type Event string
+
+const (
+	// EventOpen is recorded when a connection has been opened.
+	EventOpen Event = "open"
+
+	// EventClose is recorded when a connection has been closed.
+	EventClose Event = "close"
+
+	// EventRequest is recorded when a request of application protocol has been handled.
+	EventRequest Event = "request"
+)
+
+// Record is an entry of access log.
+type Record struct {
+	// Time is when the event occurred.
+	Time time.Time
+
+	// Event is the kind of this record.
+	Event Event
+
+	// Network is the network of the connection, e.g. tcp, unix.
+	Network string
+
+	// LocalAddr is the local address of the connection.
+	LocalAddr string
+
+	// RemoteAddr is the remote address of the connection.
+	RemoteAddr string
+
+	// Duration is the lifetime of the connection for EventClose or the time taken by the request for EventRequest.
+	Duration time.Duration
+
+	// Err is the error that closed the connection.
+	Err error
+
+	// Method is the method of the request, e.g. GET.
+	Method string
+
+	// URI is the URI of the request.
+	URI string
+
+	// Proto is the protocol of the request, e.g. HTTP/1.1.
+	Proto string
+
+	// Status is the status code of the response.
+	Status int
+
+	// Bytes is the size of the response body.
+	Bytes int
+}
+
+// Formatter formats a record into a line of access log.
+type Formatter interface {
+	// Format appends the formatted record to buf and returns the extended buffer, the line feed is appended by Logger.
+	Format(buf []byte, r *Record) []byte
+}
+
+// Option is a function that will set up option.
+type Option func(l *Logger)
+
+// WithQueueSize sets up the number of records that can be queued before being written,
+// records are dropped when the queue is full.
+func WithQueueSize(size int) Option {
+	return func(l *Logger) {
+		l.queue = make(chan *Record, size)
+	}
+}
+
+// WithFlushInterval sets up the interval of flushing the buffered records to the underlying writer.
+func WithFlushInterval(interval time.Duration) Option {
+	return func(l *Logger) {
+		l.flushInterval = interval
+	}
+}
+
+// Logger writes access records asynchronously with a buffered writer.
+type Logger struct {
+	dropped       uint64
+	w             *bufio.Writer
+	formatter     Formatter
+	queue         chan *Record
+	flushInterval time.Duration
+	mu            sync.RWMutex
+	closed        bool
+	done          chan struct{}
+	err           error
+}
+
+// New instantiates a Logger writing records formatted by formatter into w.
+func New(w io.Writer, formatter Formatter, opts ...Option) *Logger {
+	l := &Logger{
+		w:             bufio.NewWriter(w),
+		formatter:     formatter,
+		flushInterval: DefaultFlushInterval,
+		done:          make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	if l.queue == nil {
+		l.queue = make(chan *Record, DefaultQueueSize)
+	}
+	go l.run()
+	return l
+}
+
+// Log queues the record for writing without blocking, the record is dropped when the queue is full.
+func (l *Logger) Log(r *Record) error {
+	if r.Time.IsZero() {
+		r.Time = time.Now()
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.closed {
+		return ErrClosed
+	}
+	select {
+	case l.queue <- r:
+	default:
+		atomic.AddUint64(&l.dropped, 1)
+	}
+	return nil
+}
+
+// Dropped returns the number of records dropped by reason of the full queue.
+func (l *Logger) Dropped() uint64 {
+	return atomic.LoadUint64(&l.dropped)
+}
+
+// Close writes all the queued records and stops the logger, it returns the first error of writing.
+func (l *Logger) Close() error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return ErrClosed
+	}
+	l.closed = true
+	close(l.queue)
+	l.mu.Unlock()
+	<-l.done
+	return l.err
+}
+
+func (l *Logger) run() {
+	defer close(l.done)
+	ticker := time.NewTicker(l.flushInterval)
+	defer ticker.Stop()
+	var line []byte
+	for {
+		select {
+		case r, ok := <-l.queue:
+			if !ok {
+				l.flush()
+				return
+			}
+			line = append(l.formatter.Format(line[:0], r), '\n')
+			if _, err := l.w.Write(line); err != nil && l.err == nil {
+				l.err = err
+			}
+		case <-ticker.C:
+			l.flush()
+		}
+	}
+}
+
+func (l *Logger) flush() {
+	if err := l.w.Flush(); err != nil && l.err == nil {
+		l.err = err
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package accesslog
+
+import (
+	"net"
+	"strconv"
+	"time"
+)
+
+// clfTimeFormat is the time format of Common Log Format.
+const clfTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+// CLFFormatter formats records in the Common Log Format:
+//  host ident authuser [date] "request" status bytes
+// The request line of connection events is made up of the event and the network.
+type CLFFormatter struct{}
+
+// Format ...
+func (f *CLFFormatter) Format(buf []byte, r *Record) []byte {
+	host := r.RemoteAddr
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	buf = appendCLFField(buf, host)
+	buf = append(buf, " - - ["...)
+	buf = r.Time.AppendFormat(buf, clfTimeFormat)
+	buf = append(buf, "] \""...)
+	if r.Event == EventRequest {
+		buf = append(buf, r.Method...)
+		buf = append(buf, ' ')
+		buf = append(buf, r.URI...)
+		buf = append(buf, ' ')
+		buf = append(buf, r.Proto...)
+	} else {
+		buf = append(buf, r.Event...)
+		buf = append(buf, ' ')
+		buf = append(buf, r.Network...)
+	}
+	buf = append(buf, "\" "...)
+	if r.Status > 0 {
+		buf = strconv.AppendInt(buf, int64(r.Status), 10)
+	} else {
+		buf = append(buf, '-')
+	}
+	buf = append(buf, ' ')
+	if r.Bytes > 0 {
+		buf = strconv.AppendInt(buf, int64(r.Bytes), 10)
+	} else {
+		buf = append(buf, '-')
+	}
+	return buf
+}
+
+func appendCLFField(buf []byte, s string) []byte {
+	if s == "" {
+		return append(buf, '-')
+	}
+	return append(buf, s...)
+}
+
+// JSONFormatter formats records as JSON objects, the empty fields are omitted.
+type JSONFormatter struct{}
+
+// Format ...
+func (f *JSONFormatter) Format(buf []byte, r *Record) []byte {
+	buf = append(buf, `{"time":`...)
+	buf = strconv.AppendQuote(buf, r.Time.Format(time.RFC3339Nano))
+	buf = appendJSONString(buf, "event", string(r.Event))
+	buf = appendJSONString(buf, "network", r.Network)
+	buf = appendJSONString(buf, "local_addr", r.LocalAddr)
+	buf = appendJSONString(buf, "remote_addr", r.RemoteAddr)
+	if r.Duration > 0 {
+		buf = append(buf, `,"duration_ns":`...)
+		buf = strconv.AppendInt(buf, int64(r.Duration), 10)
+	}
+	if r.Err != nil {
+		buf = appendJSONString(buf, "error", r.Err.Error())
+	}
+	buf = appendJSONString(buf, "method", r.Method)
+	buf = appendJSONString(buf, "uri", r.URI)
+	buf = appendJSONString(buf, "proto", r.Proto)
+	if r.Status > 0 {
+		buf = append(buf, `,"status":`...)
+		buf = strconv.AppendInt(buf, int64(r.Status), 10)
+	}
+	if r.Bytes > 0 {
+		buf = append(buf, `,"bytes":`...)
+		buf = strconv.AppendInt(buf, int64(r.Bytes), 10)
+	}
+	return append(buf, '}')
+}
+
+func appendJSONString(buf []byte, key, value string) []byte {
+	if value == "" {
+		return buf
+	}
+	buf = append(buf, ',', '"')
+	buf = append(buf, key...)
+	buf = append(buf, '"', ':')
+	return strconv.AppendQuote(buf, value)
+}
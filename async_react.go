@@ -0,0 +1,77 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build darwin netbsd freebsd openbsd dragonfly linux
+
+package gnet
+
+func (c *conn) AsyncReact(task ReactTask) {
+	if c.loop != nil {
+		sniffError(c.loop.poller.Trigger(func() error {
+			return c.loop.loopAsyncReact(c, task)
+		}))
+	}
+}
+
+// loopAsyncReact queues the task of connection, there is at most one task in flight for each connection,
+// which keeps the outputs in order.
+func (lp *loop) loopAsyncReact(c *conn, task ReactTask) error {
+	if lp.connections.get(c.fd) != c {
+		return nil // ignore tasks of the closed connection.
+	}
+	c.reactTasks = append(c.reactTasks, task)
+	if c.reacting {
+		return nil
+	}
+	return lp.loopNextReactTask(c)
+}
+
+// loopNextReactTask hands the next task of connection over to the worker pool.
+func (lp *loop) loopNextReactTask(c *conn) error {
+	for len(c.reactTasks) > 0 {
+		task := c.reactTasks[0]
+		c.reactTasks[0] = nil
+		c.reactTasks = c.reactTasks[1:]
+		if reactPool := lp.svr.reactPool; reactPool != nil {
+			c.reacting = true
+			if reactPool.Submit(func() {
+				out, action := task()
+				sniffError(lp.poller.Trigger(func() error {
+					return lp.loopReacted(c, out, action)
+				}))
+			}) == nil {
+				return nil
+			}
+			c.reacting = false
+		}
+		// The worker pool is disabled or overloaded, run the task in the event-loop instead.
+		out, action := task()
+		if err := lp.loopApplyReact(c, out, action); err != nil || !c.opened {
+			return err
+		}
+	}
+	return nil
+}
+
+// loopReacted applies the output of task to the connection and moves on to the next task.
+func (lp *loop) loopReacted(c *conn, out []byte, action Action) error {
+	if lp.connections.get(c.fd) != c {
+		return nil // ignore outputs of the closed connection.
+	}
+	c.reacting = false
+	if err := lp.loopApplyReact(c, out, action); err != nil || !c.opened {
+		return err
+	}
+	return lp.loopNextReactTask(c)
+}
+
+func (lp *loop) loopApplyReact(c *conn, out []byte, action Action) error {
+	if len(out) != 0 {
+		if encodedBuf, err := lp.svr.encode(c, out); err == nil {
+			c.write(encodedBuf)
+		}
+	}
+	c.action = action
+	return lp.handleAction(c)
+}
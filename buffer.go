@@ -0,0 +1,103 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build darwin netbsd freebsd openbsd dragonfly linux
+
+package gnet
+
+import (
+	"os"
+	"time"
+
+	"github.com/panjf2000/gnet/pool"
+	"github.com/panjf2000/gnet/ringbuffer"
+)
+
+// readBufferCap returns the initial capacity of the inbound ring-buffers.
+func (svr *server) readBufferCap() int {
+	return svr.bufferCap(svr.opts.ReadBufferCap)
+}
+
+// writeBufferCap returns the initial capacity of the outbound ring-buffers.
+func (svr *server) writeBufferCap() int {
+	return svr.bufferCap(svr.opts.WriteBufferCap)
+}
+
+func (svr *server) bufferCap(size int) int {
+	if size <= 0 {
+		size = socketRingBufferSize
+	}
+	if svr.opts.MirroredBuffers {
+		// Keep the capacities of mirror-mapped buffers and the plain ones falling back the same.
+		pageSize := os.Getpagesize()
+		size = (size + pageSize - 1) / pageSize * pageSize
+	}
+	return size
+}
+
+func (svr *server) newInboundBuffer() *ringbuffer.RingBuffer {
+	return svr.newBuffer(svr.readBufferCap())
+}
+
+func (svr *server) newOutboundBuffer() *ringbuffer.RingBuffer {
+	return svr.newBuffer(svr.writeBufferCap())
+}
+
+func (svr *server) newBuffer(size int) *ringbuffer.RingBuffer {
+	if svr.opts.MirroredBuffers {
+		if rb, err := ringbuffer.NewMirrored(size, svr.opts.BufferGrowth); err == nil {
+			return rb
+		}
+	}
+	return ringbuffer.NewWithAllocator(size, svr.opts.BufferGrowth, svr.allocator())
+}
+
+// allocator returns the allocator of the byte slices of buffers.
+func (svr *server) allocator() pool.Allocator {
+	if svr.opts.Allocator != nil {
+		return svr.opts.Allocator
+	}
+	return pool.DefaultAllocator
+}
+
+// startShrink starts sweeping the ring-buffers of connections periodically if the shrinking is enabled.
+func (lp *loop) startShrink() {
+	if interval := lp.svr.opts.BufferShrink; interval > 0 {
+		lp.timers.AfterFunc(time.Now(), interval, lp.shrinkBuffers)
+	}
+}
+
+// shrinkBuffers replaces the ring-buffers which have grown beyond their initial capacities and stayed empty
+// since the last sweep with the new ones, the ones emptied during the interval are only marked for the next
+// sweep, so that the buffers of busy connections aren't reallocated over and over again.
+func (lp *loop) shrinkBuffers() {
+	svr := lp.svr
+	lp.connections.iterate(func(c *conn) bool {
+		if !c.opened {
+			return true
+		}
+		shrunk := false
+		if c.inboundBuffer.IsEmpty() && c.inboundBuffer.Capacity() > svr.readBufferCap() {
+			if c.inboundIdle {
+				c.inboundBuffer.Release()
+				c.inboundBuffer = svr.newInboundBuffer()
+				shrunk = true
+			}
+			c.inboundIdle = !c.inboundIdle
+		}
+		if c.outboundBuffer != nil && c.outboundBuffer.IsEmpty() && c.outboundBuffer.Capacity() > svr.writeBufferCap() {
+			if c.outboundIdle {
+				c.outboundBuffer.Release()
+				c.outboundBuffer = svr.newOutboundBuffer()
+				shrunk = true
+			}
+			c.outboundIdle = !c.outboundIdle
+		}
+		if shrunk {
+			svr.trackMemory(c)
+		}
+		return true
+	})
+	lp.timers.AfterFunc(time.Now(), svr.opts.BufferShrink, lp.shrinkBuffers)
+}
@@ -0,0 +1,36 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+)
+
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+// ChecksumStage is the FrameStage appending the CRC32C of every outbound frame to it in big endian, and validating
+// the one of every inbound frame, the connection is closed with a StageError wrapping ErrChecksumMismatch once
+// a corrupted frame is found, which is reported to OnClosed.
+type ChecksumStage struct {
+}
+
+// Encode appends the checksum to the frame.
+func (s *ChecksumStage) Encode(c Conn, frame []byte) ([]byte, error) {
+	out := make([]byte, len(frame), len(frame)+crc32.Size)
+	copy(out, frame)
+	var sum [crc32.Size]byte
+	binary.BigEndian.PutUint32(sum[:], crc32.Checksum(frame, castagnoliTable))
+	return append(out, sum[:]...), nil
+}
+
+// Decode validates and strips the checksum of the frame.
+func (s *ChecksumStage) Decode(c Conn, frame []byte) ([]byte, error) {
+	n := len(frame) - crc32.Size
+	if n < 0 || binary.BigEndian.Uint32(frame[n:]) != crc32.Checksum(frame[:n], castagnoliTable) {
+		return nil, ErrChecksumMismatch
+	}
+	return frame[:n], nil
+}
@@ -21,6 +21,13 @@ type ICodec interface {
 	Decode(c Conn) ([]byte, error)
 }
 
+// ConnEncoder is implemented by the codecs encoding frames depending on the connection, e.g. CodecChain with stages
+// keeping states per connection. The event-loop prefers EncodeConn to Encode, and invokes it within the event-loop.
+// Such codecs also decode within the event-loop with WithDecodeOffload, so that they share the states of connection.
+type ConnEncoder interface {
+	EncodeConn(c Conn, buf []byte) ([]byte, error)
+}
+
 //type Decoder interface {
 //	Decode(c Conn) ([]byte, error)
 //}
@@ -46,6 +53,9 @@ func (cc *BuiltInFrameCodec) Decode(c Conn) ([]byte, error) {
 
 // LineBasedFrameCodec encodes/decodes line-separated frames into/from TCP stream.
 type LineBasedFrameCodec struct {
+	// MaxFrameLength is the maximum length of frames excluding the delimiter, the connection is closed with
+	// ErrTooLongFrame once it's exceeded. Zero means no limit.
+	MaxFrameLength int
 }
 
 // Encode ...
@@ -58,8 +68,14 @@ func (cc *LineBasedFrameCodec) Decode(c Conn) ([]byte, error) {
 	buf := c.Read()
 	idx := bytes.IndexByte(buf, CRLFByte)
 	if idx == -1 {
+		if exceedsFrameLength(cc.MaxFrameLength, len(buf)) {
+			return nil, ErrTooLongFrame
+		}
 		return nil, ErrCRLFNotFound
 	}
+	if exceedsFrameLength(cc.MaxFrameLength, idx) {
+		return nil, ErrTooLongFrame
+	}
 	_, buf = c.ReadN(idx + 1)
 	return buf[:idx], nil
 }
@@ -67,11 +83,15 @@ func (cc *LineBasedFrameCodec) Decode(c Conn) ([]byte, error) {
 // DelimiterBasedFrameCodec encodes/decodes specific-delimiter-separated frames into/from TCP stream.
 type DelimiterBasedFrameCodec struct {
 	delimiter byte
+
+	// MaxFrameLength is the maximum length of frames excluding the delimiter, the connection is closed with
+	// ErrTooLongFrame once it's exceeded. Zero means no limit.
+	MaxFrameLength int
 }
 
 // NewDelimiterBasedFrameCodec instantiates and returns a codec with a specific delimiter.
 func NewDelimiterBasedFrameCodec(delimiter byte) *DelimiterBasedFrameCodec {
-	return &DelimiterBasedFrameCodec{delimiter}
+	return &DelimiterBasedFrameCodec{delimiter: delimiter}
 }
 
 // Encode ...
@@ -84,13 +104,20 @@ func (cc *DelimiterBasedFrameCodec) Decode(c Conn) ([]byte, error) {
 	buf := c.Read()
 	idx := bytes.IndexByte(buf, cc.delimiter)
 	if idx == -1 {
+		if exceedsFrameLength(cc.MaxFrameLength, len(buf)) {
+			return nil, ErrTooLongFrame
+		}
 		return nil, ErrDelimiterNotFound
 	}
+	if exceedsFrameLength(cc.MaxFrameLength, idx) {
+		return nil, ErrTooLongFrame
+	}
 	_, buf = c.ReadN(idx + 1)
 	return buf[:idx], nil
 }
 
-// FixedLengthFrameCodec encodes/decodes fixed-length-separated frames into/from TCP stream.
+// FixedLengthFrameCodec encodes/decodes fixed-length-separated frames into/from TCP stream, the length of frames is
+// bounded by itself.
 type FixedLengthFrameCodec struct {
 	frameLength int
 }
@@ -156,6 +183,9 @@ type DecoderConfig struct {
 	LengthAdjustment int
 	// InitialBytesToStrip is the number of first bytes to strip out from the decoded frame
 	InitialBytesToStrip int
+	// MaxFrameLength is the maximum value of the length field adjusted by LengthAdjustment, the connection is closed
+	// with ErrTooLongFrame once it's exceeded, before the frame is buffered. Zero means no limit.
+	MaxFrameLength int
 }
 
 // Encode ...
@@ -215,6 +245,10 @@ func (cc *LengthFieldBasedFrameCodec) Decode(c Conn) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
+	if max := cc.decoderConfig.MaxFrameLength; max > 0 &&
+		(frameLength > uint64(max) || exceedsFrameLength(max, int(frameLength)+cc.decoderConfig.LengthAdjustment)) {
+		return nil, ErrTooLongFrame
+	}
 
 	if cc.decoderConfig.LengthAdjustment > 0 { //discard adjust header
 		size, _ := c.ReadN(cc.decoderConfig.LengthAdjustment)
@@ -274,6 +308,20 @@ func (cc *LengthFieldBasedFrameCodec) getUnadjustedFrameLength(c Conn) (lenBuf [
 	}
 }
 
+// isFatalCodecError reports whether the error of decoding is fatal to the stream, the connection is closed with it.
+func isFatalCodecError(err error) bool {
+	if err == ErrTooLongFrame || err == ErrMalformedFrame {
+		return true
+	}
+	_, ok := err.(*StageError)
+	return ok
+}
+
+// exceedsFrameLength reports whether the length of frame exceeds the maximum length, zero means no limit.
+func exceedsFrameLength(max, length int) bool {
+	return max > 0 && length > max
+}
+
 func readUint24(byteOrder binary.ByteOrder, b []byte) uint64 {
 	_ = b[2]
 	if byteOrder == binary.LittleEndian {
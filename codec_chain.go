@@ -0,0 +1,74 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import "fmt"
+
+// FrameStage transforms the frames between the framing codec of CodecChain and handlers, e.g. decompressing or
+// decrypting them, so that layered protocols needn't be implemented as a monolithic codec. Both methods are invoked
+// within the event-loop of the connection, even with WithDecodeOffload since CodecChain implements ConnEncoder,
+// and the connection is nil if the chain is used by ICodec.Encode directly.
+type FrameStage interface {
+	// Encode transforms an outbound frame before it's passed to the next stage towards the framing codec.
+	Encode(c Conn, frame []byte) ([]byte, error)
+	// Decode transforms an inbound frame before it's passed to the next stage towards handlers.
+	Decode(c Conn, frame []byte) ([]byte, error)
+}
+
+// StageError is the error of a stage of CodecChain failing to decode a frame, the connection is closed with it
+// since the stream can't be trusted any more.
+type StageError struct {
+	// Stage is the index of the failed stage in the chain.
+	Stage int
+	// Err is the error returned by the stage.
+	Err error
+}
+
+func (e *StageError) Error() string {
+	return fmt.Sprintf("stage %d of codec chain failed to decode frame: %v", e.Stage, e.Err)
+}
+
+// CodecChain is the codec which splits the TCP stream into frames with a framing codec, then passes the inbound
+// frames through the stages in order, and the outbound frames through the stages in reverse order before framing.
+type CodecChain struct {
+	framer ICodec
+	stages []FrameStage
+}
+
+// NewCodecChain instantiates and returns a codec chaining the framing codec with the stages, e.g.
+// NewCodecChain(lengthFieldCodec, decompressor, envelope) for WithCodec.
+func NewCodecChain(framer ICodec, stages ...FrameStage) *CodecChain {
+	return &CodecChain{framer: framer, stages: stages}
+}
+
+// Encode passes the frame through the stages in reverse order without the connection and frames it.
+func (cc *CodecChain) Encode(buf []byte) ([]byte, error) {
+	return cc.EncodeConn(nil, buf)
+}
+
+// EncodeConn passes the frame of connection through the stages in reverse order and frames it.
+func (cc *CodecChain) EncodeConn(c Conn, buf []byte) (out []byte, err error) {
+	for i := len(cc.stages) - 1; i >= 0; i-- {
+		if buf, err = cc.stages[i].Encode(c, buf); err != nil {
+			return nil, err
+		}
+	}
+	return cc.framer.Encode(buf)
+}
+
+// Decode decodes a frame from the TCP stream and passes it through the stages in order, the error is a *StageError
+// if any stage fails.
+func (cc *CodecChain) Decode(c Conn) ([]byte, error) {
+	frame, err := cc.framer.Decode(c)
+	if err != nil || len(frame) == 0 {
+		return frame, err
+	}
+	for i, stage := range cc.stages {
+		if frame, err = stage.Decode(c, frame); err != nil {
+			return nil, &StageError{Stage: i, Err: err}
+		}
+	}
+	return frame, nil
+}
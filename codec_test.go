@@ -1,9 +1,14 @@
 package gnet
 
 import (
+	"bytes"
 	"encoding/binary"
 	"math/rand"
+	"net"
+	"strings"
 	"testing"
+
+	"github.com/panjf2000/gnet/ringbuffer"
 )
 
 func TestLengthFieldBasedFrameCodec(t *testing.T) {
@@ -59,3 +64,416 @@ func TestLengthFieldBasedFrameCodec(t *testing.T) {
 		t.Fatalf("data don't match with little endian, raw data: %s, recovered data: %s\n", string(buf), string(p))
 	}
 }
+
+func TestMaxFrameLength(t *testing.T) {
+	newConn := func(data string) *conn {
+		return &conn{inboundBuffer: ringbuffer.New(64), cache: []byte(data)}
+	}
+	lineCodec := &LineBasedFrameCodec{MaxFrameLength: 4}
+	if frame, err := lineCodec.Decode(newConn("abcd\n")); err != nil || string(frame) != "abcd" {
+		t.Fatalf("unexpected result of line codec: %q, %v", frame, err)
+	}
+	if _, err := lineCodec.Decode(newConn("abcde\n")); err != ErrTooLongFrame {
+		t.Fatalf("expected ErrTooLongFrame from line codec, got %v", err)
+	}
+	if _, err := lineCodec.Decode(newConn("abcde")); err != ErrTooLongFrame {
+		t.Fatalf("expected ErrTooLongFrame from line codec without delimiter, got %v", err)
+	}
+	delimiterCodec := NewDelimiterBasedFrameCodec('|')
+	delimiterCodec.MaxFrameLength = 4
+	if _, err := delimiterCodec.Decode(newConn("abcde|")); err != ErrTooLongFrame {
+		t.Fatalf("expected ErrTooLongFrame from delimiter codec, got %v", err)
+	}
+
+	lengthCodec := NewLengthFieldBasedFrameCodec(EncoderConfig{}, DecoderConfig{
+		ByteOrder:           binary.BigEndian,
+		LengthFieldLength:   4,
+		InitialBytesToStrip: 4,
+		MaxFrameLength:      4,
+	})
+	if frame, err := lengthCodec.Decode(newConn("\x00\x00\x00\x04abcd")); err != nil || string(frame) != "abcd" {
+		t.Fatalf("unexpected result of length field codec: %q, %v", frame, err)
+	}
+	// The absurd length is rejected before the frame arrives.
+	if _, err := lengthCodec.Decode(newConn("\x7f\xff\xff\xffabcd")); err != ErrTooLongFrame {
+		t.Fatalf("expected ErrTooLongFrame from length field codec, got %v", err)
+	}
+}
+
+// testPrefixStage adds the prefix to outbound frames and strips it from inbound frames.
+type testPrefixStage string
+
+func (s testPrefixStage) Encode(c Conn, frame []byte) ([]byte, error) {
+	return append([]byte(s), frame...), nil
+}
+
+func (s testPrefixStage) Decode(c Conn, frame []byte) ([]byte, error) {
+	if !bytes.HasPrefix(frame, []byte(s)) {
+		return nil, ErrDelimiterNotFound
+	}
+	return frame[len(s):], nil
+}
+
+func TestCodecChain(t *testing.T) {
+	codec := NewCodecChain(new(LineBasedFrameCodec), testPrefixStage("outer:"), testPrefixStage("inner:"))
+	out, err := codec.Encode([]byte("hello"))
+	if err != nil || string(out) != "outer:inner:hello\n" {
+		t.Fatalf("unexpected encoded frame: %q, %v", out, err)
+	}
+	c := &conn{inboundBuffer: ringbuffer.New(64), cache: append(out, "inner:outer:hello\n"...)}
+	if frame, err := codec.Decode(c); err != nil || string(frame) != "hello" {
+		t.Fatalf("unexpected decoded frame: %q, %v", frame, err)
+	}
+	_, err = codec.Decode(c)
+	if se, ok := err.(*StageError); !ok || se.Stage != 0 || se.Err != ErrDelimiterNotFound {
+		t.Fatalf("expected StageError of the first stage, got %v", err)
+	}
+	if !isFatalCodecError(err) {
+		t.Fatal("StageError should be fatal to the stream")
+	}
+}
+
+func TestCompressionStage(t *testing.T) {
+	dict := []byte(`{"type":"event","payload":`)
+	stage := NewCompressionStage(CompressionConfig{
+		MinSize:    16,
+		MaxSize:    1024,
+		Dictionary: dict,
+		Negotiate: func(c Conn) Compression {
+			if c.Get("gzip") != nil {
+				return CompressionGzip
+			}
+			return CompressionDeflate
+		},
+	})
+	frame := []byte(`{"type":"event","payload":"` + strings.Repeat("abc", 64) + `"}`)
+	deflateConn, gzipConn := new(conn), new(conn)
+	gzipConn.Set("gzip", true)
+	for _, c := range []*conn{deflateConn, gzipConn, deflateConn, gzipConn} {
+		out, err := stage.Encode(c, frame)
+		if err != nil || len(out) >= len(frame) {
+			t.Fatalf("failed to compress frame: %d bytes, %v", len(out), err)
+		}
+		in, err := stage.Decode(c, out)
+		if err != nil || !bytes.Equal(in, frame) {
+			t.Fatalf("failed to decompress frame: %q, %v", in, err)
+		}
+	}
+	if out, _ := stage.Encode(gzipConn, frame); Compression(out[0]) != CompressionGzip {
+		t.Fatalf("expected the negotiated gzip, got %d", out[0])
+	}
+
+	// Small frames are sent uncompressed.
+	if out, _ := stage.Encode(deflateConn, []byte("ping")); string(out) != "\x00ping" {
+		t.Fatalf("unexpected small frame: %q", out)
+	}
+	stage.SetCompression(deflateConn, CompressionNone)
+	if out, _ := stage.Encode(deflateConn, frame); Compression(out[0]) != CompressionNone {
+		t.Fatalf("expected compression to be switched off, got %d", out[0])
+	}
+
+	// Decompression bombs are rejected.
+	bomb, err := stage.Encode(gzipConn, make([]byte, 4096))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = stage.Decode(gzipConn, bomb); err != ErrTooLongFrame {
+		t.Fatalf("expected ErrTooLongFrame, got %v", err)
+	}
+	if _, err = stage.Decode(gzipConn, []byte{0xff}); err == nil {
+		t.Fatal("expected the unknown algorithm to fail")
+	}
+}
+
+func TestEncryptionStage(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 32)
+	keys := 0
+	supply := func(c Conn) ([]byte, error) {
+		keys++
+		return key, nil
+	}
+	server, client := NewEncryptionStage(supply), NewEncryptionStage(supply)
+	client.Client = true
+	first, err := client.Encode(nil, []byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := client.Encode(nil, []byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(first, second) || bytes.Contains(first, []byte("hello")) {
+		t.Fatal("frames should be sealed with different nonces")
+	}
+	c := new(conn)
+	for _, frame := range [][]byte{first, second} {
+		if frame, err := server.Decode(c, frame); err != nil || string(frame) != "hello" {
+			t.Fatalf("unexpected decrypted frame: %q, %v", frame, err)
+		}
+	}
+	if _, err = server.Decode(c, first); err != ErrReplayedFrame {
+		t.Fatalf("expected ErrReplayedFrame, got %v", err)
+	}
+	reply, err := server.Encode(c, []byte("transfer 100"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The frames sent by server can't be reflected back to it.
+	if _, err = server.Decode(c, reply); err != ErrDecryptFrame {
+		t.Fatalf("expected ErrDecryptFrame, got %v", err)
+	}
+	if frame, err := client.Decode(nil, reply); err != nil || string(frame) != "transfer 100" {
+		t.Fatalf("unexpected decrypted frame: %q, %v", frame, err)
+	}
+	third, _ := client.Encode(nil, []byte("hello"))
+	third[len(third)-1] ^= 1
+	if _, err = server.Decode(c, third); err != ErrDecryptFrame {
+		t.Fatalf("expected ErrDecryptFrame, got %v", err)
+	}
+	if keys != 2 {
+		t.Fatalf("expected the key to be supplied once per connection, got %d times", keys)
+	}
+}
+
+func TestChecksumStage(t *testing.T) {
+	framer := NewLengthFieldBasedFrameCodec(
+		EncoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 2},
+		DecoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 2, InitialBytesToStrip: 2},
+	)
+	codec := NewCodecChain(framer, new(ChecksumStage))
+	out, err := codec.Encode([]byte("hello"))
+	if err != nil || len(out) != 2+len("hello")+4 {
+		t.Fatalf("unexpected encoded frame: %q, %v", out, err)
+	}
+	corrupted := append([]byte(nil), out...)
+	corrupted[2] ^= 1
+	c := &conn{inboundBuffer: ringbuffer.New(64), cache: append(out, corrupted...)}
+	if frame, err := codec.Decode(c); err != nil || string(frame) != "hello" {
+		t.Fatalf("unexpected decoded frame: %q, %v", frame, err)
+	}
+	if _, err = codec.Decode(c); err.(*StageError).Err != ErrChecksumMismatch {
+		t.Fatalf("expected ErrChecksumMismatch, got %v", err)
+	}
+}
+
+type testNDJSONEvent struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+}
+
+func TestNDJSONCodec(t *testing.T) {
+	codec := &NDJSONCodec{DisallowUnknownFields: true}
+	codec.Register("event", testNDJSONEvent{})
+	out, err := codec.Encode([]byte("{\n  \"type\": \"event\",\n  \"name\": \"a\"\n}"))
+	if err != nil || string(out) != `{"type":"event","name":"a"}`+"\n" {
+		t.Fatalf("unexpected encoded frame: %q, %v", out, err)
+	}
+	c := &conn{inboundBuffer: ringbuffer.New(64), cache: append(out, `{"type":"event","name":"b"}`+"\r\n"+`{"type":"other"}`+"\n"+`{} {}`+"\n"...)}
+	for _, name := range []string{"a", "b"} {
+		frame, err := codec.Decode(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v, err := codec.DecodeValue(frame)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if event, ok := v.(*testNDJSONEvent); !ok || event.Name != name {
+			t.Fatalf("unexpected value: %#v", v)
+		}
+	}
+	// The members of nested objects aren't taken for the type.
+	lenient := new(NDJSONCodec)
+	lenient.Register("event", testNDJSONEvent{})
+	v, err := lenient.DecodeValue([]byte(`{"name":"d","nested":{"type":"other"},"type":"event"}`))
+	if event, ok := v.(*testNDJSONEvent); err != nil || !ok || event.Name != "d" {
+		t.Fatalf("unexpected value: %#v, %v", v, err)
+	}
+	frame, _ := codec.Decode(c)
+	if _, err = codec.DecodeValue(frame); err != ErrUnregisteredType {
+		t.Fatalf("expected ErrUnregisteredType, got %v", err)
+	}
+	frame, _ = codec.Decode(c)
+	if err = codec.Unmarshal(frame, new(struct{})); err == nil {
+		t.Fatal("expected the trailing data to fail")
+	}
+	var event testNDJSONEvent
+	if err = codec.Unmarshal([]byte(`{"type":"event","name":"c"}`), &event); err != nil || event.Name != "c" {
+		t.Fatalf("unexpected value: %#v, %v", event, err)
+	}
+}
+
+func TestStompCodec(t *testing.T) {
+	codec := &StompCodec{MaxFrameLength: 256}
+	send := &StompFrame{Command: "SEND", Body: []byte("a\x00b")}
+	send.AddHeader("destination", "/queue/a:b")
+	out, err := codec.Encode(send.Marshal())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out, _ := codec.Encode(StompHeartbeat); string(out) != "\n" {
+		t.Fatalf("unexpected heart-beat: %q", out)
+	}
+	stream := append([]byte("\n\r\n"), out...)
+	stream = append(stream, "\nCONNECT\r\naccept-version:1.2\r\nhost:a:b\r\n\r\n\x00"...)
+	c := &conn{inboundBuffer: ringbuffer.New(64), cache: stream}
+	frame, err := codec.Decode(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := ParseStompFrame(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dest, _ := f.Header("destination"); f.Command != "SEND" || dest != "/queue/a:b" || string(f.Body) != "a\x00b" {
+		t.Fatalf("unexpected frame: %+v", f)
+	}
+	if frame, err = codec.Decode(c); err != nil {
+		t.Fatal(err)
+	}
+	if f, err = ParseStompFrame(frame); err != nil {
+		t.Fatal(err)
+	}
+	// The headers of CONNECT frames aren't escaped.
+	if host, _ := f.Header("host"); f.Command != "CONNECT" || host != "a:b" || len(f.Body) != 0 {
+		t.Fatalf("unexpected frame: %+v", f)
+	}
+	if _, err = codec.Decode(c); err != ErrUnexpectedEOF || c.BufferLength() != 0 {
+		t.Fatalf("expected no more frames, got %v", err)
+	}
+
+	c = &conn{inboundBuffer: ringbuffer.New(64), cache: []byte("SEND\ncontent-length:x\n\n\x00")}
+	if _, err = codec.Decode(c); err != ErrMalformedFrame {
+		t.Fatalf("expected ErrMalformedFrame, got %v", err)
+	}
+	c = &conn{inboundBuffer: ringbuffer.New(64), cache: []byte("SEND\ncontent-length:1024\n\n")}
+	if _, err = codec.Decode(c); err != ErrTooLongFrame {
+		t.Fatalf("expected ErrTooLongFrame, got %v", err)
+	}
+	huge := []byte("SEND\ncontent-length:9223372036854775800\n\n\x00")
+	c = &conn{inboundBuffer: ringbuffer.New(64), cache: huge}
+	if _, err = codec.Decode(c); err != ErrTooLongFrame {
+		t.Fatalf("expected ErrTooLongFrame, got %v", err)
+	}
+	c = &conn{inboundBuffer: ringbuffer.New(64), cache: huge}
+	if _, err = new(StompCodec).Decode(c); err != ErrUnexpectedEOF {
+		t.Fatalf("expected ErrUnexpectedEOF, got %v", err)
+	}
+	if _, err = ParseStompFrame([]byte("SEND\nbad\\xheader:1\n\n")); err != ErrMalformedFrame {
+		t.Fatalf("expected ErrMalformedFrame, got %v", err)
+	}
+}
+
+func TestModbusCodec(t *testing.T) {
+	codec := new(ModbusCodec)
+	adu := &ModbusADU{TransactionID: 7, UnitID: 3, FunctionCode: 0x06, Data: []byte{0, 1, 0, 3}}
+	frame := adu.Marshal()
+	c := &conn{inboundBuffer: ringbuffer.New(64), cache: frame[:5]}
+	if _, err := codec.Decode(c); err != ErrUnexpectedEOF {
+		t.Fatalf("expected ErrUnexpectedEOF, got %v", err)
+	}
+	c.cache = append(frame, 0, 1, 0, 1, 0, 2, 1)
+	decoded, err := codec.Decode(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParseModbusADU(decoded)
+	if err != nil || parsed.TransactionID != 7 || parsed.UnitID != 3 || parsed.FunctionCode != 0x06 ||
+		!bytes.Equal(parsed.Data, adu.Data) {
+		t.Fatalf("unexpected ADU: %+v, %v", parsed, err)
+	}
+	// The protocol identifier of the second ADU isn't zero.
+	if _, err = codec.Decode(c); err != ErrMalformedFrame {
+		t.Fatalf("expected ErrMalformedFrame, got %v", err)
+	}
+}
+
+func TestDNSCodec(t *testing.T) {
+	codec := new(DNSCodec)
+	query := testDNSQuery(1, 1232)
+	frame, err := codec.Encode(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &conn{inboundBuffer: ringbuffer.New(64), cache: frame[:len(frame)-1]}
+	if _, err = codec.Decode(c); err != ErrUnexpectedEOF {
+		t.Fatalf("expected ErrUnexpectedEOF, got %v", err)
+	}
+	c.cache = append(frame, 0, 3, 0, 0, 0)
+	decoded, err := codec.Decode(c)
+	if err != nil || !bytes.Equal(decoded, query) {
+		t.Fatalf("unexpected message: %x, %v", decoded, err)
+	}
+	// The length of the second message is shorter than the header.
+	if _, err = codec.Decode(c); err != ErrMalformedFrame {
+		t.Fatalf("expected ErrMalformedFrame, got %v", err)
+	}
+	if _, err = codec.Encode(make([]byte, 0x10000)); err != ErrTooLongFrame {
+		t.Fatalf("expected ErrTooLongFrame, got %v", err)
+	}
+
+	if size := DNSMaxUDPSize(query); size != 1232 {
+		t.Fatalf("expected the payload size of 1232, got %d", size)
+	}
+	if size := DNSMaxUDPSize(testDNSQuery(1, 0)); size != 512 {
+		t.Fatalf("expected the payload size of 512, got %d", size)
+	}
+	resp := append(testDNSQuery(1, 0), make([]byte, 600)...)
+	resp[7] = 1
+	c = &conn{remoteAddr: &net.TCPAddr{}}
+	if fitted := DNSFitResponse(c, testDNSQuery(1, 0), resp); !bytes.Equal(fitted, resp) {
+		t.Fatal("expected the response over TCP as is")
+	}
+	c = &conn{remoteAddr: &net.UDPAddr{}}
+	fitted := DNSFitResponse(c, testDNSQuery(1, 0), resp)
+	if !DNSTruncated(fitted) || DNSTruncated(resp) || !bytes.Equal(fitted[12:], resp[12:len(fitted)]) ||
+		len(fitted) != len(testDNSQuery(1, 0)) || fitted[7] != 0 {
+		t.Fatalf("unexpected truncated response: %x", fitted)
+	}
+}
+
+func TestSyslogCodec(t *testing.T) {
+	codec := new(SyslogCodec)
+	msg := []byte("<34>1 2003-10-11T22:14:15.003Z host su - ID47 - 'su root' failed")
+
+	octetCounting := &conn{inboundBuffer: ringbuffer.New(64), cache: []byte("6")}
+	frame, _ := codec.Encode(msg)
+	if _, err := codec.Decode(octetCounting); err != ErrUnexpectedEOF {
+		t.Fatalf("expected ErrUnexpectedEOF, got %v", err)
+	}
+	if framing := codec.ConnFraming(octetCounting); framing != SyslogOctetCounting {
+		t.Fatalf("expected the octet-counting framing, got %d", framing)
+	}
+	octetCounting.cache = append(append(frame, frame...), "01 x"...)
+	for i := 0; i < 2; i++ {
+		if decoded, err := codec.Decode(octetCounting); err != nil || !bytes.Equal(decoded, msg) {
+			t.Fatalf("unexpected message: %q, %v", decoded, err)
+		}
+	}
+	// The length of the third message has a leading zero.
+	if _, err := codec.Decode(octetCounting); err != ErrMalformedFrame {
+		t.Fatalf("expected ErrMalformedFrame, got %v", err)
+	}
+	if out, _ := codec.EncodeConn(octetCounting, []byte("<13>x")); string(out) != "5 <13>x" {
+		t.Fatalf("unexpected output: %q", out)
+	}
+
+	nonTransparent := &conn{inboundBuffer: ringbuffer.New(64), cache: append(append(msg, '\n'), "<13>x\n<13>"...)}
+	if decoded, err := codec.Decode(nonTransparent); err != nil || !bytes.Equal(decoded, msg) {
+		t.Fatalf("unexpected message: %q, %v", decoded, err)
+	}
+	if decoded, err := codec.Decode(nonTransparent); err != nil || string(decoded) != "<13>x" {
+		t.Fatalf("unexpected message: %q, %v", decoded, err)
+	}
+	if _, err := codec.Decode(nonTransparent); err != ErrUnexpectedEOF {
+		t.Fatalf("expected ErrUnexpectedEOF, got %v", err)
+	}
+	if out, _ := codec.EncodeConn(nonTransparent, []byte("<13>x")); string(out) != "<13>x\n" {
+		t.Fatalf("unexpected output: %q", out)
+	}
+
+	codec.MaxFrameLength = 16
+	if _, err := codec.Decode(&conn{inboundBuffer: ringbuffer.New(64), cache: frame}); err != ErrTooLongFrame {
+		t.Fatalf("expected ErrTooLongFrame, got %v", err)
+	}
+}
@@ -0,0 +1,267 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// Package compat provides an API in the style of upstream gnet v2 (OnTraffic, Engine, Conn.Next/Peek/Discard)
+// on top of gnet, so that handlers can be migrated between the two projects without being rewritten.
+package compat
+
+import (
+	"io"
+	"net"
+	"time"
+
+	"github.com/panjf2000/gnet"
+)
+
+// Action is an action that occurs after the completion of an event.
+type Action = gnet.Action
+
+const (
+	// None indicates that no action should occur following an event.
+	None = gnet.None
+
+	// Close closes the connection.
+	Close = gnet.Close
+
+	// Shutdown shutdowns the engine.
+	Shutdown = gnet.Shutdown
+)
+
+// Engine represents an engine context which provides information about the running server.
+type Engine struct {
+	gnet.Server
+}
+
+// AsyncCallback is a callback which will be invoked within the event-loop after the asynchronous data has been written.
+type AsyncCallback func(c Conn, err error) error
+
+// Conn is an interface of connection in the style of upstream gnet v2.
+type Conn interface {
+	// Next returns the next n bytes from the inbound buffer and advances it, it returns all the buffered
+	// bytes if n <= 0, io.ErrShortBuffer is returned when there are not enough buffered bytes.
+	Next(n int) (buf []byte, err error)
+
+	// Peek returns the next n bytes from the inbound buffer without advancing it, it returns all the buffered
+	// bytes if n <= 0, io.ErrShortBuffer is returned when there are not enough buffered bytes.
+	Peek(n int) (buf []byte, err error)
+
+	// Discard skips the next n bytes of the inbound buffer, it discards all the buffered bytes if n <= 0
+	// or n is larger than the number of the buffered bytes.
+	Discard(n int) (discarded int, err error)
+
+	// InboundBuffered returns the number of bytes that can be read from the inbound buffer.
+	InboundBuffered() (n int)
+
+	// Write writes data to the connection, the data is sent after the current event returns.
+	Write(buf []byte) (n int, err error)
+
+	// AsyncWrite writes data to the connection asynchronously, usually you would invoke it in a biz goroutine
+	// instead of the event-loop goroutine.
+	AsyncWrite(buf []byte, callback AsyncCallback) (err error)
+
+	// Wake triggers an OnTraffic event for this connection if there is buffered inbound data.
+	Wake() (err error)
+
+	// Context returns a user-defined context.
+	Context() (ctx interface{})
+
+	// SetContext sets a user-defined context.
+	SetContext(ctx interface{})
+
+	// LocalAddr is the connection's local socket address.
+	LocalAddr() (addr net.Addr)
+
+	// RemoteAddr is the connection's remote peer address.
+	RemoteAddr() (addr net.Addr)
+}
+
+// EventHandler represents the engine events' callbacks in the style of upstream gnet v2.
+type EventHandler interface {
+	// OnBoot fires when the engine is ready for accepting connections.
+	OnBoot(eng Engine) (action Action)
+
+	// OnShutdown fires when the engine is being shut down.
+	OnShutdown(eng Engine)
+
+	// OnOpen fires when a new connection has been opened.
+	OnOpen(c Conn) (out []byte, action Action)
+
+	// OnClose fires when a connection has been closed.
+	OnClose(c Conn, err error) (action Action)
+
+	// OnTraffic fires when a connection receives data from the peer.
+	OnTraffic(c Conn) (action Action)
+
+	// OnTick fires immediately after the engine starts and will fire again
+	// following the duration specified by the delay return value.
+	OnTick() (delay time.Duration, action Action)
+}
+
+// BuiltinEventEngine is a built-in implementation of EventHandler which sets up each method with a default
+// implementation, you can compose it with your own implementation of EventHandler.
+type BuiltinEventEngine struct{}
+
+// OnBoot fires when the engine is ready for accepting connections.
+func (*BuiltinEventEngine) OnBoot(_ Engine) (action Action) {
+	return
+}
+
+// OnShutdown fires when the engine is being shut down.
+func (*BuiltinEventEngine) OnShutdown(_ Engine) {
+}
+
+// OnOpen fires when a new connection has been opened.
+func (*BuiltinEventEngine) OnOpen(_ Conn) (out []byte, action Action) {
+	return
+}
+
+// OnClose fires when a connection has been closed.
+func (*BuiltinEventEngine) OnClose(_ Conn, _ error) (action Action) {
+	return
+}
+
+// OnTraffic fires when a connection receives data from the peer.
+func (*BuiltinEventEngine) OnTraffic(_ Conn) (action Action) {
+	return
+}
+
+// OnTick fires immediately after the engine starts and will fire again
+// following the duration specified by the delay return value.
+func (*BuiltinEventEngine) OnTick() (delay time.Duration, action Action) {
+	return
+}
+
+// Run starts handling events on the specified address with the handler in the style of upstream gnet v2.
+func Run(eventHandler EventHandler, protoAddr string, opts ...gnet.Option) error {
+	h := &handler{eventHandler: eventHandler}
+	err := gnet.Serve(h, protoAddr, opts...)
+	eventHandler.OnShutdown(h.engine)
+	return err
+}
+
+// handler adapts EventHandler to gnet.EventHandler.
+type handler struct {
+	*gnet.EventServer
+	engine       Engine
+	eventHandler EventHandler
+}
+
+func (h *handler) OnInitComplete(server gnet.Server) (action gnet.Action) {
+	h.engine = Engine{server}
+	return h.eventHandler.OnBoot(h.engine)
+}
+
+func (h *handler) OnOpened(c gnet.Conn) (out []byte, action gnet.Action) {
+	wc := &conn{Conn: c}
+	c.Set(connKey{}, wc)
+	return h.eventHandler.OnOpen(wc)
+}
+
+func (h *handler) OnClosed(c gnet.Conn, err error) (action gnet.Action) {
+	return h.eventHandler.OnClose(wrap(c), err)
+}
+
+func (h *handler) React(c gnet.Conn) (out []byte, action gnet.Action) {
+	wc := wrap(c)
+	// gnet fires React again right after it returns data to write, OnTraffic is fired once per read like upstream,
+	// otherwise a handler writing without consuming the data would be fired forever. The action of OnTraffic
+	// is returned by the refired React, which is the one gnet takes.
+	if wc.refired {
+		wc.refired = false
+		action, wc.action = wc.action, None
+		return
+	}
+	if c.BufferLength() == 0 {
+		return
+	}
+	action = h.eventHandler.OnTraffic(wc)
+	out, wc.out = wc.out, nil
+	if len(out) != 0 {
+		wc.refired, wc.action = true, action
+	}
+	return
+}
+
+func (h *handler) Tick() (delay time.Duration, action gnet.Action) {
+	return h.eventHandler.OnTick()
+}
+
+// connKey is the key of the attachment holding the Conn of a connection, so that handlers
+// are handed the same Conn from OnOpen to OnClose.
+type connKey struct{}
+
+// conn adapts gnet.Conn to Conn.
+type conn struct {
+	gnet.Conn
+	out     []byte
+	refired bool
+	action  Action
+}
+
+// wrap returns the Conn of c, datagrams are not opened and are wrapped on every event.
+func wrap(c gnet.Conn) *conn {
+	if wc, ok := c.Get(connKey{}).(*conn); ok {
+		return wc
+	}
+	return &conn{Conn: c}
+}
+
+func (c *conn) Next(n int) (buf []byte, err error) {
+	total := c.BufferLength()
+	if n > total {
+		return nil, io.ErrShortBuffer
+	}
+	if n <= 0 {
+		n = total
+	}
+	_, buf = c.ReadN(n)
+	return
+}
+
+func (c *conn) Peek(n int) (buf []byte, err error) {
+	total := c.BufferLength()
+	if n > total {
+		return nil, io.ErrShortBuffer
+	}
+	if n <= 0 {
+		n = total
+	}
+	return c.Read()[:n], nil
+}
+
+func (c *conn) Discard(n int) (discarded int, err error) {
+	total := c.BufferLength()
+	if n <= 0 || n >= total {
+		c.ResetBuffer()
+		return total, nil
+	}
+	discarded, _ = c.ReadN(n)
+	return
+}
+
+func (c *conn) InboundBuffered() int {
+	return c.BufferLength()
+}
+
+func (c *conn) Write(buf []byte) (n int, err error) {
+	c.out = append(c.out, buf...)
+	return len(buf), nil
+}
+
+func (c *conn) AsyncWrite(buf []byte, callback AsyncCallback) (err error) {
+	c.Conn.AsyncWrite(buf)
+	if callback != nil {
+		// The jobs of a connection run in order, hence the callback runs once the data has been written.
+		c.Conn.Execute(func(gc gnet.Conn) error {
+			_ = callback(wrap(gc), nil)
+			return nil
+		})
+	}
+	return
+}
+
+func (c *conn) Wake() (err error) {
+	c.Conn.Wake()
+	return
+}
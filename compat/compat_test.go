@@ -0,0 +1,244 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package compat
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/panjf2000/gnet"
+)
+
+type testEchoServer struct {
+	*BuiltinEventEngine
+	addr     string
+	started  int32
+	shutdown int32
+	err      atomic.Value
+}
+
+func (s *testEchoServer) OnTraffic(c Conn) (action Action) {
+	// Echo line by line, leaving incomplete lines in the buffer.
+	for {
+		buf, _ := c.Peek(-1)
+		i := 0
+		for i < len(buf) && buf[i] != '\n' {
+			i++
+		}
+		if i == len(buf) {
+			return
+		}
+		line, err := c.Next(i + 1)
+		if err != nil {
+			panic(err)
+		}
+		_, _ = c.Write(line)
+	}
+}
+
+func (s *testEchoServer) OnShutdown(_ Engine) {
+	atomic.StoreInt32(&s.shutdown, 1)
+}
+
+func (s *testEchoServer) OnTick() (delay time.Duration, action Action) {
+	if atomic.CompareAndSwapInt32(&s.started, 0, 1) {
+		go func() {
+			defer atomic.StoreInt32(&s.started, 2)
+			c, err := net.Dial("tcp", s.addr)
+			if err != nil {
+				s.err.Store(err)
+				return
+			}
+			defer c.Close()
+			rd := bufio.NewReader(c)
+			for _, data := range []string{"hello, ", "world\nhello, ", "gnet\n"} {
+				if _, err = c.Write([]byte(data)); err != nil {
+					s.err.Store(err)
+					return
+				}
+				time.Sleep(time.Millisecond * 10)
+			}
+			for _, expected := range []string{"hello, world\n", "hello, gnet\n"} {
+				line, err := rd.ReadString('\n')
+				if err != nil {
+					s.err.Store(err)
+					return
+				}
+				if line != expected {
+					s.err.Store(fmt.Errorf("expected %q, got %q", expected, line))
+					return
+				}
+			}
+		}()
+	}
+	if atomic.LoadInt32(&s.started) == 2 {
+		action = Shutdown
+	}
+	delay = time.Millisecond * 50
+	return
+}
+
+func TestRun(t *testing.T) {
+	s := &testEchoServer{addr: "127.0.0.1:9991"}
+	if err := Run(s, "tcp://"+s.addr, gnet.WithTicker(true)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.err.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt32(&s.shutdown) != 1 {
+		t.Fatal("OnShutdown was not fired")
+	}
+}
+
+type testTrafficServer struct {
+	*BuiltinEventEngine
+	addr     string
+	started  int32
+	traffics int32
+	written  int32
+	err      atomic.Value
+}
+
+func (s *testTrafficServer) OnTraffic(c Conn) (action Action) {
+	atomic.AddInt32(&s.traffics, 1)
+	// Reply to every read, but wait for the whole line before consuming it.
+	_, _ = c.Write([]byte("ok\n"))
+	if buf, _ := c.Peek(-1); buf[len(buf)-1] == '\n' {
+		_, _ = c.Discard(-1)
+		_ = c.AsyncWrite([]byte("done\n"), func(c Conn, err error) error {
+			atomic.StoreInt32(&s.written, 1)
+			return nil
+		})
+	}
+	return
+}
+
+func (s *testTrafficServer) OnTick() (delay time.Duration, action Action) {
+	if atomic.CompareAndSwapInt32(&s.started, 0, 1) {
+		go func() {
+			defer atomic.StoreInt32(&s.started, 2)
+			c, err := net.Dial("tcp", s.addr)
+			if err != nil {
+				s.err.Store(err)
+				return
+			}
+			defer c.Close()
+			rd := bufio.NewReader(c)
+			for _, data := range []string{"hello", "\n"} {
+				if _, err = c.Write([]byte(data)); err != nil {
+					s.err.Store(err)
+					return
+				}
+				if line, err := rd.ReadString('\n'); err != nil || line != "ok\n" {
+					s.err.Store(fmt.Errorf("unexpected reply: %q, %v", line, err))
+					return
+				}
+			}
+			if line, err := rd.ReadString('\n'); err != nil || line != "done\n" {
+				s.err.Store(fmt.Errorf("unexpected reply: %q, %v", line, err))
+			}
+		}()
+	}
+	if atomic.LoadInt32(&s.started) == 2 {
+		action = Shutdown
+	}
+	delay = time.Millisecond * 50
+	return
+}
+
+func TestOnTrafficOncePerRead(t *testing.T) {
+	s := &testTrafficServer{addr: "127.0.0.1:9963"}
+	if err := Run(s, "tcp://"+s.addr, gnet.WithTicker(true)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.err.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&s.traffics); n != 2 {
+		t.Fatalf("expected OnTraffic to be fired once per read, got %d times", n)
+	}
+	if atomic.LoadInt32(&s.written) != 1 {
+		t.Fatal("the callback of AsyncWrite was not fired")
+	}
+}
+
+type testCloseServer struct {
+	*BuiltinEventEngine
+	addr    string
+	started int32
+	closed  int32
+	conns   map[Conn]int
+	err     atomic.Value
+}
+
+func (s *testCloseServer) OnOpen(c Conn) (out []byte, action Action) {
+	s.conns[c]++
+	return
+}
+
+func (s *testCloseServer) OnTraffic(c Conn) (action Action) {
+	s.conns[c]++
+	_, _ = c.Next(-1)
+	_, _ = c.Write([]byte("bye\n"))
+	return Close
+}
+
+func (s *testCloseServer) OnClose(c Conn, err error) (action Action) {
+	if s.conns[c] != 2 {
+		s.err.Store(fmt.Errorf("expected the same Conn from OnOpen to OnClose, got %d events", s.conns[c]))
+	}
+	atomic.StoreInt32(&s.closed, 1)
+	return
+}
+
+func (s *testCloseServer) OnTick() (delay time.Duration, action Action) {
+	if atomic.CompareAndSwapInt32(&s.started, 0, 1) {
+		go func() {
+			defer atomic.StoreInt32(&s.started, 2)
+			c, err := net.Dial("tcp", s.addr)
+			if err != nil {
+				s.err.Store(err)
+				return
+			}
+			defer c.Close()
+			if _, err = c.Write([]byte("hello\n")); err != nil {
+				s.err.Store(err)
+				return
+			}
+			_ = c.SetReadDeadline(time.Now().Add(time.Second))
+			rd := bufio.NewReader(c)
+			if line, err := rd.ReadString('\n'); err != nil || line != "bye\n" {
+				s.err.Store(fmt.Errorf("unexpected reply: %q, %v", line, err))
+				return
+			}
+			if _, err = rd.ReadByte(); err == nil {
+				s.err.Store(fmt.Errorf("expected the connection to be closed"))
+			}
+		}()
+	}
+	if atomic.LoadInt32(&s.started) == 2 {
+		action = Shutdown
+	}
+	delay = time.Millisecond * 50
+	return
+}
+
+func TestWriteThenClose(t *testing.T) {
+	s := &testCloseServer{addr: "127.0.0.1:9955", conns: make(map[Conn]int)}
+	if err := Run(s, "tcp://"+s.addr, gnet.WithTicker(true)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.err.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt32(&s.closed) != 1 {
+		t.Fatal("OnClose was not fired")
+	}
+}
@@ -0,0 +1,230 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+// Compression is the algorithm compressing the frames of CompressionStage. Only the algorithms of the standard
+// library are provided, as gnet doesn't depend on third-party packages besides golang.org/x/sys, snappy and zstd
+// aren't. Every frame is compressed on its own, the DEFLATE window isn't carried over between frames, and the
+// preset dictionary of CompressionConfig is the only state reused across frames.
+type Compression byte
+
+const (
+	// CompressionNone leaves frames uncompressed.
+	CompressionNone Compression = iota
+
+	// CompressionDeflate compresses frames with DEFLATE (RFC 1951) and the preset dictionary if any.
+	CompressionDeflate
+
+	// CompressionGzip compresses frames with gzip (RFC 1952).
+	CompressionGzip
+)
+
+// errUnknownCompression is the error of decoding a frame compressed by an unknown algorithm.
+var errUnknownCompression = errors.New("unknown algorithm of compression")
+
+// CompressionConfig is the configuration of CompressionStage.
+type CompressionConfig struct {
+	// Level is the level of compression defined by compress/flate, zero means flate.DefaultCompression.
+	Level int
+
+	// MinSize is the minimum size of frames to compress, the smaller ones are sent uncompressed since compressing
+	// them hardly pays off.
+	MinSize int
+
+	// MaxSize is the maximum size of decompressed frames, the connection is closed with a StageError wrapping
+	// ErrTooLongFrame once it's exceeded, which guards against decompression bombs. Zero means no limit.
+	MaxSize int
+
+	// Dictionary is the preset dictionary of DEFLATE shared by both sides, which improves the ratio of small frames
+	// with the common contents, it's reused by all the frames of all connections.
+	Dictionary []byte
+
+	// Negotiate picks the algorithm compressing the outbound frames of connection, it's invoked with the first
+	// outbound frame unless the algorithm has been set by CompressionStage.SetCompression, e.g. by the handler
+	// handshaking with the peer. Nil means CompressionDeflate for all connections.
+	Negotiate func(c Conn) Compression
+}
+
+// CompressionStage is the FrameStage compressing outbound frames with the algorithm negotiated per connection and
+// decompressing inbound frames, every frame is prefixed with a byte of the algorithm, hence the peer may pick
+// another algorithm or send some frames uncompressed.
+type CompressionStage struct {
+	config        CompressionConfig
+	deflaters     sync.Pool
+	inflaters     sync.Pool
+	gzipWriters   sync.Pool
+	gzipReaders   sync.Pool
+	bufferPool    sync.Pool
+	negotiatedKey compressionKey
+}
+
+// compressionKey is the key of the algorithm attached to connections.
+type compressionKey struct {
+	stage *CompressionStage
+}
+
+// NewCompressionStage instantiates and returns a stage compressing frames for CodecChain.
+func NewCompressionStage(config CompressionConfig) *CompressionStage {
+	if config.Level == 0 {
+		config.Level = flate.DefaultCompression
+	}
+	s := &CompressionStage{config: config}
+	s.negotiatedKey = compressionKey{s}
+	s.bufferPool.New = func() interface{} {
+		return new(bytes.Buffer)
+	}
+	return s
+}
+
+// SetCompression sets the algorithm compressing the outbound frames of connection, it must be invoked within the
+// event-loop of the connection.
+func (s *CompressionStage) SetCompression(c Conn, alg Compression) {
+	c.Set(s.negotiatedKey, alg)
+}
+
+// compression returns the algorithm negotiated for the connection.
+func (s *CompressionStage) compression(c Conn) Compression {
+	if c == nil {
+		return CompressionDeflate
+	}
+	if alg, ok := c.Get(s.negotiatedKey).(Compression); ok {
+		return alg
+	}
+	alg := CompressionDeflate
+	if s.config.Negotiate != nil {
+		alg = s.config.Negotiate(c)
+	}
+	c.Set(s.negotiatedKey, alg)
+	return alg
+}
+
+// Encode compresses the frame with the algorithm of connection.
+func (s *CompressionStage) Encode(c Conn, frame []byte) ([]byte, error) {
+	alg := s.compression(c)
+	if len(frame) < s.config.MinSize {
+		alg = CompressionNone
+	}
+	switch alg {
+	case CompressionNone:
+		return append([]byte{byte(CompressionNone)}, frame...), nil
+	case CompressionDeflate, CompressionGzip:
+	default:
+		return nil, errUnknownCompression
+	}
+	buf := s.bufferPool.Get().(*bytes.Buffer)
+	defer s.bufferPool.Put(buf)
+	buf.Reset()
+	buf.WriteByte(byte(alg))
+	w, err := s.compressor(alg, buf)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(frame); err == nil {
+		err = w.Close()
+	}
+	s.releaseCompressor(alg, w)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), buf.Bytes()...), nil
+}
+
+// Decode decompresses the frame with the algorithm it's prefixed with.
+func (s *CompressionStage) Decode(c Conn, frame []byte) ([]byte, error) {
+	if len(frame) == 0 {
+		return nil, errUnknownCompression
+	}
+	alg, payload := Compression(frame[0]), frame[1:]
+	if alg == CompressionNone {
+		if exceedsFrameLength(s.config.MaxSize, len(payload)) {
+			return nil, ErrTooLongFrame
+		}
+		return payload, nil
+	}
+	r, err := s.decompressor(alg, payload)
+	if err != nil {
+		return nil, err
+	}
+	var src io.Reader = r
+	if s.config.MaxSize > 0 {
+		src = io.LimitReader(r, int64(s.config.MaxSize)+1)
+	}
+	out, err := ioutil.ReadAll(src)
+	s.releaseDecompressor(alg, r)
+	if err != nil {
+		return nil, err
+	}
+	if exceedsFrameLength(s.config.MaxSize, len(out)) {
+		return nil, ErrTooLongFrame
+	}
+	return out, nil
+}
+
+func (s *CompressionStage) compressor(alg Compression, dst io.Writer) (io.WriteCloser, error) {
+	if alg == CompressionGzip {
+		if w, ok := s.gzipWriters.Get().(*gzip.Writer); ok {
+			w.Reset(dst)
+			return w, nil
+		}
+		return gzip.NewWriterLevel(dst, s.config.Level)
+	}
+	if w, ok := s.deflaters.Get().(*flate.Writer); ok {
+		// Reset keeps the level and dictionary of the writer.
+		w.Reset(dst)
+		return w, nil
+	}
+	return flate.NewWriterDict(dst, s.config.Level, s.config.Dictionary)
+}
+
+func (s *CompressionStage) releaseCompressor(alg Compression, w io.WriteCloser) {
+	if alg == CompressionGzip {
+		s.gzipWriters.Put(w)
+		return
+	}
+	s.deflaters.Put(w)
+}
+
+func (s *CompressionStage) decompressor(alg Compression, payload []byte) (io.ReadCloser, error) {
+	src := bytes.NewReader(payload)
+	switch alg {
+	case CompressionDeflate:
+		if r, ok := s.inflaters.Get().(io.ReadCloser); ok {
+			if err := r.(flate.Resetter).Reset(src, s.config.Dictionary); err != nil {
+				return nil, err
+			}
+			return r, nil
+		}
+		return flate.NewReaderDict(src, s.config.Dictionary), nil
+	case CompressionGzip:
+		if r, ok := s.gzipReaders.Get().(*gzip.Reader); ok {
+			if err := r.Reset(src); err != nil {
+				return nil, err
+			}
+			return r, nil
+		}
+		return gzip.NewReader(src)
+	default:
+		return nil, errUnknownCompression
+	}
+}
+
+func (s *CompressionStage) releaseDecompressor(alg Compression, r io.ReadCloser) {
+	_ = r.Close()
+	if alg == CompressionGzip {
+		s.gzipReaders.Put(r)
+		return
+	}
+	s.inflaters.Put(r)
+}
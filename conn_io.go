@@ -0,0 +1,55 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build darwin netbsd freebsd openbsd dragonfly linux
+
+package gnet
+
+import "io"
+
+// connReader reads the inbound data of connection, from the inbound ring-buffer first and then the event-loop-buffer.
+type connReader struct {
+	c *conn
+}
+
+func (c *conn) Reader() io.Reader {
+	return connReader{c}
+}
+
+func (r connReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	c := r.c
+	n, _ := c.inboundBuffer.Read(p)
+	if n < len(p) && len(c.cache) > 0 {
+		m := copy(p[n:], c.cache)
+		c.cache = c.cache[m:]
+		n += m
+	}
+	if n == 0 {
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+// connWriter writes the outgoing data to connection without encoding it.
+type connWriter struct {
+	c *conn
+}
+
+func (c *conn) Writer() io.Writer {
+	return connWriter{c}
+}
+
+func (w connWriter) Write(p []byte) (int, error) {
+	if !w.c.opened {
+		return 0, ErrConnectionClosed
+	}
+	w.c.write(p)
+	if !w.c.opened {
+		return 0, ErrConnectionClosed // closed by the failure of writing.
+	}
+	return len(p), nil
+}
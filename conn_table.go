@@ -0,0 +1,77 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build darwin netbsd freebsd openbsd dragonfly linux
+
+package gnet
+
+import "sync/atomic"
+
+const (
+	// connTableChunkBits decides the number of connections held by a chunk of connTable.
+	connTableChunkBits = 10
+	connTableChunkSize = 1 << connTableChunkBits
+	connTableChunkMask = connTableChunkSize - 1
+)
+
+// connTable is a two-level table of connections indexed by file-descriptor, which replaces map[int]*conn
+// to cut off the overhead of hashing in the hot path and the GC scanning of huge maps, as the kernel always
+// allocates the lowest-numbered fd available, chunks are allocated lazily and stay dense.
+type connTable struct {
+	count  int32     // number of connections in the table, accessed atomically to be read out of the event-loop
+	chunks [][]*conn // fd>>connTableChunkBits -> chunk, fd&connTableChunkMask -> conn
+}
+
+// get returns the connection associated with the given fd or nil if there isn't one.
+func (t *connTable) get(fd int) *conn {
+	i := fd >> connTableChunkBits
+	if fd < 0 || i >= len(t.chunks) || t.chunks[i] == nil {
+		return nil
+	}
+	return t.chunks[i][fd&connTableChunkMask]
+}
+
+// set associates the given connection with the given fd.
+func (t *connTable) set(fd int, c *conn) {
+	i := fd >> connTableChunkBits
+	if i >= len(t.chunks) {
+		chunks := make([][]*conn, i+1)
+		copy(chunks, t.chunks)
+		t.chunks = chunks
+	}
+	if t.chunks[i] == nil {
+		t.chunks[i] = make([]*conn, connTableChunkSize)
+	}
+	if t.chunks[i][fd&connTableChunkMask] == nil {
+		atomic.AddInt32(&t.count, 1)
+	}
+	t.chunks[i][fd&connTableChunkMask] = c
+}
+
+// delete removes the connection associated with the given fd.
+func (t *connTable) delete(fd int) {
+	i := fd >> connTableChunkBits
+	if fd < 0 || i >= len(t.chunks) || t.chunks[i] == nil || t.chunks[i][fd&connTableChunkMask] == nil {
+		return
+	}
+	t.chunks[i][fd&connTableChunkMask] = nil
+	atomic.AddInt32(&t.count, -1)
+}
+
+// len returns the number of connections in the table.
+func (t *connTable) len() int {
+	return int(atomic.LoadInt32(&t.count))
+}
+
+// iterate calls f sequentially for each connection in the table until f returns false,
+// it is safe to delete the current connection in f.
+func (t *connTable) iterate(f func(c *conn) bool) {
+	for _, chunk := range t.chunks {
+		for _, c := range chunk {
+			if c != nil && !f(c) {
+				return
+			}
+		}
+	}
+}
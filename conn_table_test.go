@@ -0,0 +1,109 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build darwin netbsd freebsd openbsd dragonfly linux
+
+package gnet
+
+import (
+	"math/rand"
+	"testing"
+)
+
+const benchConnCount = 100000
+
+func TestConnTable(t *testing.T) {
+	var table connTable
+	conns := make([]*conn, 3*connTableChunkSize)
+	for fd := range conns {
+		conns[fd] = &conn{fd: fd}
+		table.set(fd, conns[fd])
+	}
+	if table.len() != len(conns) {
+		t.Fatalf("expected %d connections, got %d", len(conns), table.len())
+	}
+	for fd, c := range conns {
+		if table.get(fd) != c {
+			t.Fatalf("connection mismatch on fd %d", fd)
+		}
+	}
+	if table.get(-1) != nil || table.get(len(conns)*2) != nil {
+		t.Fatalf("expected nil connection for unknown fd")
+	}
+	n := 0
+	table.iterate(func(c *conn) bool {
+		if c.fd%2 == 0 {
+			table.delete(c.fd)
+		}
+		n++
+		return true
+	})
+	if n != len(conns) || table.len() != len(conns)/2 {
+		t.Fatalf("expected %d iterated and %d left, got %d and %d", len(conns), len(conns)/2, n, table.len())
+	}
+	table.delete(0)
+	if table.len() != len(conns)/2 || table.get(0) != nil || table.get(1) != conns[1] {
+		t.Fatalf("unexpected table state after deleting")
+	}
+}
+
+func benchFds() []int {
+	fds := make([]int, benchConnCount)
+	for i := range fds {
+		fds[i] = rand.Intn(benchConnCount)
+	}
+	return fds
+}
+
+func BenchmarkConnMapGet(b *testing.B) {
+	m := make(map[int]*conn, benchConnCount)
+	for fd := 0; fd < benchConnCount; fd++ {
+		m[fd] = &conn{fd: fd}
+	}
+	fds := benchFds()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if c := m[fds[i%benchConnCount]]; c == nil {
+			b.Fatal("nil connection")
+		}
+	}
+}
+
+func BenchmarkConnTableGet(b *testing.B) {
+	var table connTable
+	for fd := 0; fd < benchConnCount; fd++ {
+		table.set(fd, &conn{fd: fd})
+	}
+	fds := benchFds()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if c := table.get(fds[i%benchConnCount]); c == nil {
+			b.Fatal("nil connection")
+		}
+	}
+}
+
+func BenchmarkConnMapSetDelete(b *testing.B) {
+	m := make(map[int]*conn, benchConnCount)
+	c := new(conn)
+	fds := benchFds()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		fd := fds[i%benchConnCount]
+		m[fd] = c
+		delete(m, fd)
+	}
+}
+
+func BenchmarkConnTableSetDelete(b *testing.B) {
+	var table connTable
+	c := new(conn)
+	fds := benchFds()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		fd := fds[i%benchConnCount]
+		table.set(fd, c)
+		table.delete(fd)
+	}
+}
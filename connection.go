@@ -8,92 +8,388 @@
 package gnet
 
 import (
+	"context"
 	"net"
+	"os"
+	"sync/atomic"
+	"time"
 
+	"github.com/panjf2000/gnet/netpoll"
 	"github.com/panjf2000/gnet/ringbuffer"
 	"golang.org/x/sys/unix"
 )
 
 type conn struct {
-	fd             int                    // file descriptor
-	sa             unix.Sockaddr          // remote socket address
-	ctx            interface{}            // user-defined context
-	loop           *loop                  // connected loop
-	cache          []byte                 // reuse memory of inbound data
-	opened         bool                   // connection opened event fired
-	action         Action                 // next user action
-	localAddr      net.Addr               // local addr
-	remoteAddr     net.Addr               // remote addr
-	inboundBuffer  *ringbuffer.RingBuffer // buffer for data from client
-	outboundBuffer *ringbuffer.RingBuffer // buffer for data that is ready to write to client
+	fd             int                         // file descriptor
+	sa             unix.Sockaddr               // remote socket address
+	ctx            interface{}                 // user-defined context
+	attachments    map[interface{}]interface{} // values attached by Set
+	loop           *loop                       // connected loop
+	cache          []byte                      // reuse memory of inbound data
+	opened         bool                        // connection opened event fired
+	datagram       bool                        // whether it's the datagram being handled by React of UDP and unixgram servers
+	svr            *server                     // server handling the datagram, which isn't bound to any loop
+	pktInfo        *PacketInfo                 // information of the datagram received with WithPacketInfo
+	action         Action                      // next user action
+	localAddr      net.Addr                    // local addr
+	remoteAddr     net.Addr                    // remote addr
+	peerCred       *PeerCredentials            // peer credentials of unix socket
+	frame          []byte                      // frame decoded by the worker pool
+	codecErr       error                       // fatal error of codec which the connection is closed with, e.g. ErrTooLongFrame
+	decoder        *frameDecoder               // decoder offloading frames to the worker pool
+	memory         int64                       // bytes of buffers accounted into the memory usage of server
+	closeCtx       context.Context             // context cancelled when the connection is closed
+	closeCancel    context.CancelFunc          // cancel function of closeCtx
+	openedAt       time.Time                   // time when the connection was opened, only set with the access log or the leak detector
+	lastEvent      string                      // last event recorded for the leak detector
+	lastEventAt    time.Time                   // time of lastEvent
+	leakReported   bool                        // whether the connection has been reported as leaked since lastEvent
+	handshaken     bool                        // whether the first frame has been read, for the handshake timeout
+	hostKey        string                      // key of the source host counted in for MaxConnsPerHost
+	lingering      bool                        // whether SO_LINGER is set with a timeout, so closing may block
+	reactTasks     []ReactTask                 // tasks queued by AsyncReact
+	reacting       bool                        // whether a task of AsyncReact is in flight
+	wakeCtx        interface{}                 // payload of WakeWith, only set during the React it triggers
+	timers         map[*Timer]struct{}         // pending timers set by SetTimer
+	lastActive     time.Time                   // time when data was received last, only set with the heartbeat
+	readClosed     bool                        // whether the peer has closed its writing side
+	writeClosed    bool                        // whether CloseWrite has been invoked
+	readPaused     bool                        // whether reading has been paused by PauseRead
+	netConn        *netConn                    // adapter to net.Conn taking over the inbound data
+	sources        []*writeSource              // streams queued by AsyncWriteFrom
+	inboundIdle    bool                        // whether the inbound buffer has stayed empty since the last shrink sweep
+	outboundIdle   bool                        // whether the outbound buffer has stayed empty since the last shrink sweep
+	inboundBuffer  *ringbuffer.RingBuffer      // buffer for data from client
+	outboundBuffer *ringbuffer.RingBuffer      // buffer for data that is ready to write to client, nil with outboundList
+	outboundList   *linkedBuffer               // buffer replacing outboundBuffer with OutboundLinkedBuffer
+	mirrored       MirrorDirection             // directions of traffic mirrored by Mirror
+	traceCtx       context.Context             // context of the span of React in progress with Tracer
 }
 
 func newConn(fd int, lp *loop, sa unix.Sockaddr) *conn {
-	return &conn{
-		fd:             fd,
-		loop:           lp,
-		sa:             sa,
-		inboundBuffer:  lp.svr.bytesPool.Get().(*ringbuffer.RingBuffer),
-		outboundBuffer: lp.svr.bytesPool.Get().(*ringbuffer.RingBuffer),
+	c := &conn{
+		fd:            fd,
+		loop:          lp,
+		sa:            sa,
+		inboundBuffer: lp.svr.inboundPool.Get().(*ringbuffer.RingBuffer),
 	}
+	if lp.svr.decodePool != nil {
+		c.decoder = newFrameDecoder(c)
+	}
+	if lp.svr.opts.OutboundBufferKind == OutboundLinkedBuffer {
+		c.outboundList = newLinkedBuffer(lp.svr.allocator())
+	} else {
+		c.outboundBuffer = lp.svr.outboundPool.Get().(*ringbuffer.RingBuffer)
+	}
+	if lp.svr.ln.network == "unix" {
+		if pid, uid, gid, err := netpoll.GetPeerCred(fd); err == nil {
+			c.peerCred = &PeerCredentials{PID: pid, UID: uid, GID: gid}
+		}
+	}
+	return c
 }
 
 func (c *conn) release() {
 	c.opened = false
 	c.sa = nil
 	c.ctx = nil
+	c.attachments = nil
 	c.cache = nil
 	c.localAddr = nil
 	c.remoteAddr = nil
+	c.peerCred = nil
+	c.frame = nil
+	c.codecErr = nil
+	c.decoder = nil
+	c.openedAt = time.Time{}
+	c.lastEvent = ""
+	c.lastEventAt = time.Time{}
+	c.leakReported = false
+	c.handshaken = false
+	c.loop.svr.releaseHost(c.hostKey)
+	c.hostKey = ""
+	c.lingering = false
+	c.lastActive = time.Time{}
+	c.readClosed = false
+	c.writeClosed = false
+	c.readPaused = false
+	c.mirrored = 0
+	c.netConn = nil
+	c.reactTasks = nil
+	c.reacting = false
+	for _, src := range c.sources {
+		src.close()
+	}
+	c.sources = nil
+	c.inboundIdle = false
+	c.outboundIdle = false
+	for t := range c.timers {
+		c.loop.loopStopTimer(t)
+	}
+	c.timers = nil
+	c.loop.svr.untrackMemory(c)
+	if c.closeCancel != nil {
+		c.closeCancel()
+		c.closeCtx = nil
+		c.closeCancel = nil
+	}
 	c.inboundBuffer.Reset()
-	c.outboundBuffer.Reset()
-	c.loop.svr.bytesPool.Put(c.inboundBuffer)
-	c.loop.svr.bytesPool.Put(c.outboundBuffer)
+	c.loop.svr.inboundPool.Put(c.inboundBuffer)
+	if c.outboundList != nil {
+		c.outboundList.reset()
+	} else {
+		c.outboundBuffer.Reset()
+		c.loop.svr.outboundPool.Put(c.outboundBuffer)
+	}
 	c.inboundBuffer = nil
 	c.outboundBuffer = nil
 }
 
 func (c *conn) open(buf []byte) {
+	c.loop.svr.mirror(c, MirrorOutbound, buf)
 	n, err := unix.Write(c.fd, buf)
 	if err != nil {
-		_, _ = c.outboundBuffer.Write(buf)
+		c.queueOutbound(buf, false)
 		return
 	}
+	atomic.AddInt64(&c.loop.counters.bytesWritten, int64(n))
+	c.touch(LeakEventWrite)
 
 	if n < len(buf) {
-		_, _ = c.outboundBuffer.Write(buf[n:])
+		c.queueOutbound(buf[n:], false)
 	}
 }
 
 func (c *conn) write(buf []byte) {
-	if !c.outboundBuffer.IsEmpty() {
-		_, _ = c.outboundBuffer.Write(buf)
+	if len(c.sources) > 0 {
+		c.queueSource(buf)
+		return
+	}
+	c.writeOut(buf, false)
+}
+
+// writeRef writes the data like write, but the data which can't be written right away is queued by reference
+// rather than being copied with OutboundLinkedBuffer, so it mustn't be modified afterwards.
+func (c *conn) writeRef(buf []byte) {
+	if len(c.sources) > 0 {
+		c.queueSource(buf)
+		return
+	}
+	c.writeOut(buf, true)
+}
+
+// writeOut writes the data to the socket and queues the data which can't be written right away to the outbound buffer.
+func (c *conn) writeOut(buf []byte, ref bool) {
+	c.loop.svr.mirror(c, MirrorOutbound, buf)
+	if !c.outboundEmpty() {
+		if c.queueOutbound(buf, ref) {
+			c.loop.svr.trackMemory(c)
+		}
 		return
 	}
 	n, err := unix.Write(c.fd, buf)
 	if err != nil {
 		if err == unix.EAGAIN {
-			_, _ = c.outboundBuffer.Write(buf)
-			_ = c.loop.poller.ModReadWrite(c.fd)
+			if c.queueOutbound(buf, ref) {
+				c.loop.watchWrite(c)
+				c.loop.svr.trackMemory(c)
+			}
 			return
 		}
-		_ = c.loop.loopCloseConn(c, err)
+		_ = c.loop.loopCloseConn(c, os.NewSyscallError("write", err))
 		return
 	}
-	if n < len(buf) {
-		_, _ = c.outboundBuffer.Write(buf[n:])
-		_ = c.loop.poller.ModReadWrite(c.fd)
+	atomic.AddInt64(&c.loop.counters.bytesWritten, int64(n))
+	c.touch(LeakEventWrite)
+	if n < len(buf) && c.queueOutbound(buf[n:], ref) {
+		c.loop.watchWrite(c)
+		c.loop.svr.trackMemory(c)
+	}
+}
+
+// outboundEmpty reports whether there is no data pending in the outbound buffer.
+func (c *conn) outboundEmpty() bool {
+	if c.outboundList != nil {
+		return c.outboundList.isEmpty()
+	}
+	return c.outboundBuffer.IsEmpty()
+}
+
+// outboundLength returns the length of data pending in the outbound buffer.
+func (c *conn) outboundLength() int {
+	if c.outboundList != nil {
+		return c.outboundList.length()
+	}
+	return c.outboundBuffer.Length()
+}
+
+// queueOutbound queues the data to the outbound buffer, the connection is closed with ErrBufferFull
+// if the buffer can't grow to hold the data. The data is queued by reference if ref is true with
+// OutboundLinkedBuffer, otherwise it's copied.
+func (c *conn) queueOutbound(buf []byte, ref bool) bool {
+	if c.outboundList != nil {
+		if ref {
+			c.outboundList.pushRef(buf)
+		} else {
+			c.outboundList.pushCopy(buf)
+		}
+		c.outboundIdle = false
+		return true
 	}
+	if _, err := c.outboundBuffer.Write(buf); err != nil {
+		_ = c.loop.loopCloseConn(c, ErrBufferFull)
+		return false
+	}
+	c.outboundIdle = false
+	return true
 }
 
 func (c *conn) sendTo(buf []byte, sa unix.Sockaddr) {
 	_ = unix.Sendto(c.fd, buf, 0, sa)
 }
 
+func (c *conn) RemoteSockaddr() unix.Sockaddr {
+	return c.sa
+}
+
+func (c *conn) SendTo(buf []byte, addr net.Addr) error {
+	if !c.datagram {
+		return ErrNotDatagram
+	}
+	sa := netpoll.AddrToSockaddr(addr, c.sa)
+	if sa == nil {
+		return ErrInvalidAddr
+	}
+	if err := unix.Sendto(c.fd, buf, 0, sa); err != nil {
+		return os.NewSyscallError("sendto", err)
+	}
+	return nil
+}
+
+func (c *conn) PacketInfo() *PacketInfo {
+	if !c.datagram {
+		return nil
+	}
+	return c.pktInfo
+}
+
+func (c *conn) SendPacket(buf []byte, addr net.Addr, info *PacketInfo) error {
+	if !c.datagram {
+		return ErrNotDatagram
+	}
+	sa := c.sa
+	if addr != nil {
+		if sa = netpoll.AddrToSockaddr(addr, c.sa); sa == nil {
+			return ErrInvalidAddr
+		}
+	}
+	var oob []byte
+	if info != nil && (info.Dst != nil || info.IfIndex != 0 || info.TrafficClass >= 0) {
+		// The IPv4 control messages apply to the IPv4 peers of dual-stack sockets as well.
+		ipv6 := false
+		if sa6, ok := sa.(*unix.SockaddrInet6); ok {
+			ipv6 = net.IP(sa6.Addr[:]).To4() == nil
+		}
+		if oob = netpoll.PacketInfoControl(info.Dst, info.IfIndex, info.TrafficClass, ipv6); oob == nil {
+			return ErrPacketInfoUnsupported
+		}
+	}
+	if _, err := unix.SendmsgN(c.fd, buf, oob, sa, 0); err != nil {
+		return os.NewSyscallError("sendmsg", err)
+	}
+	return nil
+}
+
+const (
+	maxUDPSegments   = 64    // maximum number of segments sent by one sendmsg with UDP_SEGMENT
+	maxUDPSegmentBuf = 65507 // maximum size of buffer sent by one sendmsg with UDP_SEGMENT
+)
+
+func (c *conn) SendSegments(buf []byte, segmentSize int, addr net.Addr) error {
+	if !c.datagram {
+		return ErrNotDatagram
+	}
+	if segmentSize <= 0 || segmentSize > maxUDPSegmentBuf {
+		return ErrInvalidSegmentSize
+	}
+	sa := c.sa
+	if addr != nil {
+		if sa = netpoll.AddrToSockaddr(addr, c.sa); sa == nil {
+			return ErrInvalidAddr
+		}
+	}
+	batch := segmentSize * maxUDPSegments
+	if batch > maxUDPSegmentBuf {
+		batch = maxUDPSegmentBuf / segmentSize * segmentSize
+	}
+	var oob []byte
+	if _, ok := c.sa.(*unix.SockaddrUnix); !ok {
+		oob = netpoll.UDPSegmentControl(segmentSize)
+	}
+	for len(buf) > 0 {
+		n := len(buf)
+		if n > batch {
+			n = batch
+		}
+		if oob != nil && n > segmentSize {
+			if _, err := unix.SendmsgN(c.fd, buf[:n], oob, sa, 0); err == nil {
+				buf = buf[n:]
+				continue
+			} else if err != unix.EIO && err != unix.EINVAL {
+				return os.NewSyscallError("sendmsg", err)
+			}
+			// The kernel or the device refuses the segmentation, fall back to splitting it here.
+			oob = nil
+		}
+		for segment := buf[:n]; len(segment) > 0; {
+			m := len(segment)
+			if m > segmentSize {
+				m = segmentSize
+			}
+			if err := unix.Sendto(c.fd, segment[:m], 0, sa); err != nil {
+				return os.NewSyscallError("sendto", err)
+			}
+			segment = segment[m:]
+		}
+		buf = buf[n:]
+	}
+	return nil
+}
+
 // ================================= Public APIs of gnet.Conn =================================
 
+func (c *conn) Set(key, value interface{}) {
+	if value == nil {
+		delete(c.attachments, key)
+		return
+	}
+	if c.attachments == nil {
+		c.attachments = make(map[interface{}]interface{})
+	}
+	c.attachments[key] = value
+}
+
 func (c *conn) ReadFrame() []byte {
-	buf, _ := c.loop.svr.codec.Decode(c)
+	if c.decoder != nil {
+		buf := c.frame
+		c.frame = nil
+		if buf != nil {
+			c.handshaken = true
+		}
+		return buf
+	}
+	if c.datagram {
+		buf := c.cache
+		c.cache = nil
+		return buf
+	}
+	buf, err := c.loop.svr.codec.Decode(c)
+	if isFatalCodecError(err) {
+		c.codecErr = err
+	}
+	if buf != nil {
+		c.handshaken = true
+	}
 	return buf
 }
 
@@ -147,6 +443,56 @@ func (c *conn) ReadN(n int) (size int, buf []byte) {
 	return
 }
 
+func (c *conn) Peek(n int) ([]byte, error) {
+	inBufferLen := c.inboundBuffer.Length()
+	if inBufferLen+len(c.cache) < n {
+		return nil, ErrUnexpectedEOF
+	}
+	if n <= 0 {
+		return nil, nil
+	}
+	if inBufferLen == 0 {
+		return c.cache[:n], nil
+	}
+	head, tail := c.inboundBuffer.LazyRead(n)
+	if len(head) == n {
+		return head, nil
+	}
+	// The bytes wrap around the ring-buffer or span the event-loop-buffer, copy them into a contiguous slice.
+	buf := make([]byte, 0, n)
+	buf = append(buf, head...)
+	buf = append(buf, tail...)
+	return append(buf, c.cache[:n-len(buf)]...), nil
+}
+
+func (c *conn) Discard(n int) int {
+	if n <= 0 {
+		return 0
+	}
+	inBufferLen := c.inboundBuffer.Length()
+	if n <= inBufferLen {
+		c.inboundBuffer.Shift(n)
+		return n
+	}
+	c.inboundBuffer.Reset()
+	rest := n - inBufferLen
+	if rest > len(c.cache) {
+		rest = len(c.cache)
+	}
+	c.cache = c.cache[rest:]
+	return inBufferLen + rest
+}
+
+func (c *conn) ReadUpTo(n int) (size int, buf []byte) {
+	if available := c.BufferLength(); available < n {
+		n = available
+	}
+	if n <= 0 {
+		return
+	}
+	return c.ReadN(n)
+}
+
 func (c *conn) InboundBuffer() *ringbuffer.RingBuffer {
 	return c.inboundBuffer
 }
@@ -155,15 +501,31 @@ func (c *conn) OutboundBuffer() *ringbuffer.RingBuffer {
 	return c.outboundBuffer
 }
 
+func (c *conn) OutboundBuffered() int {
+	return c.outboundLength()
+}
+
 func (c *conn) BufferLength() int {
 	return c.inboundBuffer.Length() + len(c.cache)
 }
 
 func (c *conn) AsyncWrite(buf []byte) {
+	if _, ok := c.loop.svr.codec.(ConnEncoder); ok {
+		// The codec may keep states per connection, which are confined to the event-loop.
+		_ = c.loop.poller.Trigger(func() error {
+			if c.opened {
+				if encodedBuf, err := c.loop.svr.encode(c, buf); err == nil {
+					c.writeRef(encodedBuf)
+				}
+			}
+			return nil
+		})
+		return
+	}
 	if encodedBuf, err := c.loop.svr.codec.Encode(buf); err == nil {
 		_ = c.loop.poller.Trigger(func() error {
 			if c.opened {
-				c.write(encodedBuf)
+				c.writeRef(encodedBuf)
 			}
 			return nil
 		})
@@ -173,16 +535,46 @@ func (c *conn) AsyncWrite(buf []byte) {
 func (c *conn) Wake() {
 	if c.loop != nil {
 		sniffError(c.loop.poller.Trigger(func() error {
-			return c.loop.loopWake(c)
+			return c.loop.loopWake(c, nil)
+		}))
+	}
+}
+
+func (c *conn) WakeWith(data interface{}) {
+	if c.loop != nil {
+		sniffError(c.loop.poller.Trigger(func() error {
+			return c.loop.loopWake(c, data)
+		}))
+	}
+}
+
+func (c *conn) Execute(task func(c Conn) error) {
+	if c.loop != nil {
+		sniffError(c.loop.poller.Trigger(func() error {
+			return c.loop.loopExecute(c, task)
 		}))
 	}
 }
 
-//func (c *conn) ShiftN(n int) {
-//	c.inboundBuffer.Shift(n)
-//}
+func (c *conn) CloseAbort() {
+	if c.loop != nil {
+		sniffError(c.loop.poller.Trigger(func() error {
+			return c.loop.loopCloseAbort(c)
+		}))
+	}
+}
+
+func (c *conn) LoopTime() time.Time {
+	if c.loop == nil {
+		return time.Now() // connections of UDP aren't bound to loops.
+	}
+	return c.loop.now
+}
 
-func (c *conn) Context() interface{}       { return c.ctx }
-func (c *conn) SetContext(ctx interface{}) { c.ctx = ctx }
-func (c *conn) LocalAddr() net.Addr        { return c.localAddr }
-func (c *conn) RemoteAddr() net.Addr       { return c.remoteAddr }
+func (c *conn) Context() interface{}              { return c.ctx }
+func (c *conn) SetContext(ctx interface{})        { c.ctx = ctx }
+func (c *conn) Get(key interface{}) interface{}   { return c.attachments[key] }
+func (c *conn) LocalAddr() net.Addr               { return c.localAddr }
+func (c *conn) RemoteAddr() net.Addr              { return c.remoteAddr }
+func (c *conn) PeerCredentials() *PeerCredentials { return c.peerCred }
+func (c *conn) WakeContext() interface{}          { return c.wakeCtx }
@@ -0,0 +1,105 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build darwin netbsd freebsd openbsd dragonfly linux
+
+package gnet
+
+import (
+	"github.com/panjf2000/gnet/ringbuffer"
+)
+
+// frameDecoder decodes the TCP stream of a connection into frames on the worker pool, there is
+// at most one decoding job in flight for each connection, which keeps frames in order. The codec decodes with a view
+// of the stream, so the codecs keeping states in connections aren't offloaded, see Options.DecodeOffload.
+type frameDecoder struct {
+	decoding bool   // whether a decoding job is in flight, only accessed by the event-loop
+	pending  []byte // inbound data arrived during decoding, only accessed by the event-loop
+	stream   *conn  // read-only view of the undecoded stream, only accessed by the in-flight job
+}
+
+func newFrameDecoder(c *conn) *frameDecoder {
+	return &frameDecoder{
+		stream: &conn{
+			loop:          c.loop,
+			inboundBuffer: ringbuffer.New(socketRingBufferSize),
+		},
+	}
+}
+
+// decode appends data to the undecoded stream and decodes as many frames as possible from it, the error is
+// the fatal error of codec if the stream can't be decoded any further, e.g. ErrTooLongFrame.
+func (d *frameDecoder) decode(codec ICodec, data []byte) (frames [][]byte, err error) {
+	d.stream.cache = data
+	for {
+		frame, e := codec.Decode(d.stream)
+		if isFatalCodecError(e) {
+			err = e
+		}
+		if e != nil || len(frame) == 0 {
+			break
+		}
+		// Frames may refer to the memory of stream, which will be overwritten by the subsequent data.
+		frames = append(frames, append([]byte{}, frame...))
+	}
+	_, _ = d.stream.inboundBuffer.Write(d.stream.cache)
+	d.stream.cache = nil
+	return
+}
+
+// loopDecode hands the inbound data of connection over to the worker pool for decoding.
+func (lp *loop) loopDecode(c *conn, data []byte) error {
+	d := c.decoder
+	d.pending = append(d.pending, data...)
+	if d.decoding {
+		return nil
+	}
+	d.decoding = true
+	data, d.pending = d.pending, nil
+	codec := lp.svr.codec
+	if err := lp.svr.decodePool.Submit(func() {
+		frames, err := d.decode(codec, data)
+		sniffError(lp.poller.Trigger(func() error {
+			return lp.loopDecoded(c, frames, err)
+		}))
+	}); err != nil {
+		// The worker pool is overloaded, decode in the event-loop instead.
+		frames, err := d.decode(codec, data)
+		return lp.loopDecoded(c, frames, err)
+	}
+	return nil
+}
+
+// loopDecoded delivers the decoded frames to React one by one in the event-loop, then closes the connection
+// with the error of decoding if any.
+func (lp *loop) loopDecoded(c *conn, frames [][]byte, err error) error {
+	if lp.connections.get(c.fd) != c {
+		return nil // ignore frames of the closed connection.
+	}
+	c.decoder.decoding = false
+	for _, frame := range frames {
+		c.frame = frame
+		out, action := lp.svr.eventHandler.React(c)
+		c.frame = nil
+		if !c.opened {
+			return nil // detached by React.
+		}
+		if len(out) != 0 {
+			if encodedBuf, err := lp.svr.encode(c, out); err == nil {
+				c.write(encodedBuf)
+			}
+		}
+		c.action = action
+		if err := lp.handleAction(c); err != nil || !c.opened {
+			return err
+		}
+	}
+	if err != nil {
+		return lp.loopCloseConn(c, err)
+	}
+	if len(c.decoder.pending) > 0 {
+		return lp.loopDecode(c, nil)
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build darwin netbsd freebsd openbsd dragonfly linux
+
+package gnet
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+func (c *conn) Dup() (int, error) {
+	fd, err := unix.FcntlInt(uintptr(c.fd), unix.F_DUPFD_CLOEXEC, 0)
+	if err != nil {
+		return -1, os.NewSyscallError("fcntl", err)
+	}
+	return fd, nil
+}
+
+func (c *conn) Detach() (net.Conn, error) {
+	lp := c.loop
+	if lp == nil || lp.connections.get(c.fd) != c {
+		return nil, ErrConnectionClosed
+	}
+	fd, err := c.Dup()
+	if err != nil {
+		return nil, err
+	}
+	f := os.NewFile(uintptr(fd), "gnet-"+strconv.Itoa(fd))
+	nc, err := net.FileConn(f)
+	_ = f.Close()
+	if err != nil {
+		return nil, err
+	}
+	dc := &detachedConn{
+		Conn:     nc,
+		inbound:  append([]byte(nil), c.Read()...),
+		outbound: append([]byte(nil), c.pendingOutbound()...),
+	}
+	// The socket stays open through the duplicated file-descriptor, so closing the original one
+	// neither sends FIN nor resets the connection.
+	_ = lp.poller.Delete(c.fd)
+	_ = unix.Close(c.fd)
+	lp.connections.delete(c.fd)
+	if c.netConn != nil {
+		c.netConn.closeWith(nil)
+	}
+	c.release()
+	return dc, nil
+}
+
+// pendingOutbound returns the data which hasn't been written to the socket yet.
+func (c *conn) pendingOutbound() []byte {
+	if c.outboundList != nil {
+		return c.outboundList.bytes()
+	}
+	head, tail := c.outboundBuffer.LazyReadAll()
+	return append(head, tail...)
+}
+
+// detachedConn is the net.Conn returned by Detach, it yields the inbound data left in the buffer of connection
+// before reading the socket, and writes the outbound data left in the buffer before any other operations.
+type detachedConn struct {
+	net.Conn
+	mu       sync.Mutex
+	inbound  []byte
+	outbound []byte
+	flushErr error
+}
+
+// flush writes the outbound data left by the event-loop, it's done once by the first operation on the connection.
+func (dc *detachedConn) flush() error {
+	if len(dc.outbound) > 0 {
+		_, dc.flushErr = dc.Conn.Write(dc.outbound)
+		dc.outbound = nil
+	}
+	return dc.flushErr
+}
+
+func (dc *detachedConn) Read(b []byte) (int, error) {
+	dc.mu.Lock()
+	if err := dc.flush(); err != nil {
+		dc.mu.Unlock()
+		return 0, err
+	}
+	if len(dc.inbound) > 0 {
+		n := copy(b, dc.inbound)
+		dc.inbound = dc.inbound[n:]
+		dc.mu.Unlock()
+		return n, nil
+	}
+	dc.mu.Unlock()
+	return dc.Conn.Read(b)
+}
+
+func (dc *detachedConn) Write(b []byte) (int, error) {
+	dc.mu.Lock()
+	err := dc.flush()
+	dc.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	return dc.Conn.Write(b)
+}
+
+func (dc *detachedConn) Close() error {
+	dc.mu.Lock()
+	_ = dc.flush()
+	dc.mu.Unlock()
+	return dc.Conn.Close()
+}
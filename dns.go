@@ -0,0 +1,163 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import (
+	"encoding/binary"
+	"net"
+)
+
+const (
+	// dnsHeaderLength is the length of the header of DNS messages.
+	dnsHeaderLength = 12
+
+	// dnsMaxPlainUDPSize is the maximum size of DNS messages over UDP without EDNS(0).
+	dnsMaxPlainUDPSize = 512
+
+	// dnsTypeOPT is the type of the OPT pseudo-record of EDNS(0).
+	dnsTypeOPT = 41
+
+	// dnsFlagTC is the bit of the truncated flag in the header.
+	dnsFlagTC = 0x0200
+)
+
+// DNSCodec encodes/decodes the DNS messages prefixed with the length of 2 bytes into/from TCP stream as RFC 1035
+// specifies. Since every datagram is a frame for UDP, the handlers of FrameEventHandler receive the bare messages
+// from both TCP and UDP, and their output is framed for TCP by the codec while sent as is for UDP, so they can be
+// shared by both transports with DNSFitResponse applied to the output.
+type DNSCodec struct {
+}
+
+// Encode prefixes the message with its length.
+func (cc *DNSCodec) Encode(buf []byte) ([]byte, error) {
+	if len(buf) > 0xFFFF {
+		return nil, ErrTooLongFrame
+	}
+	out := make([]byte, 2, 2+len(buf))
+	binary.BigEndian.PutUint16(out, uint16(len(buf)))
+	return append(out, buf...), nil
+}
+
+// Decode decodes a message from TCP stream, the connection is closed with ErrMalformedFrame if its length is
+// shorter than the header.
+func (cc *DNSCodec) Decode(c Conn) ([]byte, error) {
+	prefix, err := c.Peek(2)
+	if err != nil {
+		return nil, ErrUnexpectedEOF
+	}
+	length := int(binary.BigEndian.Uint16(prefix))
+	if length < dnsHeaderLength {
+		return nil, ErrMalformedFrame
+	}
+	size, frame := c.ReadN(2 + length)
+	if size == 0 {
+		return nil, ErrUnexpectedEOF
+	}
+	return frame[2:], nil
+}
+
+// DNSTruncated reports whether the TC flag of the DNS message is set, i.e. it's been truncated to fit in a datagram
+// and should be queried again over TCP.
+func DNSTruncated(msg []byte) bool {
+	return len(msg) >= dnsHeaderLength && binary.BigEndian.Uint16(msg[2:])&dnsFlagTC != 0
+}
+
+// DNSMaxUDPSize returns the maximum size of the response over UDP to the DNS request, which is the payload size
+// advertised by the OPT record of EDNS(0) in the request, or 512 bytes without it.
+func DNSMaxUDPSize(req []byte) int {
+	if len(req) < dnsHeaderLength {
+		return dnsMaxPlainUDPSize
+	}
+	off, ok := skipDNSQuestions(req)
+	if !ok {
+		return dnsMaxPlainUDPSize
+	}
+	records := int(binary.BigEndian.Uint16(req[6:])) + int(binary.BigEndian.Uint16(req[8:])) +
+		int(binary.BigEndian.Uint16(req[10:]))
+	for i := 0; i < records; i++ {
+		if off, ok = skipDNSName(req, off); !ok || off+10 > len(req) {
+			return dnsMaxPlainUDPSize
+		}
+		if binary.BigEndian.Uint16(req[off:]) == dnsTypeOPT {
+			if size := int(binary.BigEndian.Uint16(req[off+2:])); size > dnsMaxPlainUDPSize {
+				return size
+			}
+			return dnsMaxPlainUDPSize
+		}
+		off += 10 + int(binary.BigEndian.Uint16(req[off+8:]))
+	}
+	return dnsMaxPlainUDPSize
+}
+
+// DNSFitResponse fits the DNS response to the transport of connection, the response over UDP exceeding
+// DNSMaxUDPSize of the request is truncated to the header and question section with the TC flag set, so that
+// the client retries over TCP. The responses over other transports are returned as is.
+func DNSFitResponse(c Conn, req, resp []byte) []byte {
+	if !isDatagramAddr(c.RemoteAddr()) || len(resp) <= DNSMaxUDPSize(req) {
+		return resp
+	}
+	off, ok := skipDNSQuestions(resp)
+	if !ok {
+		if len(resp) < dnsHeaderLength {
+			return resp
+		}
+		off = dnsHeaderLength
+	}
+	truncated := append([]byte(nil), resp[:off]...)
+	binary.BigEndian.PutUint16(truncated[2:], binary.BigEndian.Uint16(truncated[2:])|dnsFlagTC)
+	if !ok {
+		binary.BigEndian.PutUint16(truncated[4:], 0) // drop the questions which can't be parsed.
+	}
+	for i := 6; i < dnsHeaderLength; i++ {
+		truncated[i] = 0 // drop the answer, authority and additional sections.
+	}
+	return truncated
+}
+
+func isDatagramAddr(addr net.Addr) bool {
+	switch addr := addr.(type) {
+	case *net.UDPAddr:
+		return true
+	case *net.UnixAddr:
+		return addr.Net == "unixgram"
+	}
+	return false
+}
+
+// skipDNSQuestions returns the offset following the question section of the DNS message.
+func skipDNSQuestions(msg []byte) (int, bool) {
+	if len(msg) < dnsHeaderLength {
+		return 0, false
+	}
+	off, ok := dnsHeaderLength, true
+	for i := binary.BigEndian.Uint16(msg[4:]); i > 0; i-- {
+		if off, ok = skipDNSName(msg, off); !ok || off+4 > len(msg) {
+			return 0, false
+		}
+		off += 4 // type and class
+	}
+	return off, true
+}
+
+// skipDNSName returns the offset following the domain name at the offset of the DNS message.
+func skipDNSName(msg []byte, off int) (int, bool) {
+	for off < len(msg) {
+		switch l := int(msg[off]); {
+		case l == 0:
+			return off + 1, true
+		case l&0xC0 == 0xC0:
+			// A pointer of compression ends the name.
+			if off+2 > len(msg) {
+				return 0, false
+			}
+			return off + 2, true
+		case l&0xC0 != 0:
+			return 0, false
+		default:
+			off += 1 + l
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,150 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"sync"
+)
+
+// The directions of frames bound into the sealed frames as additional data, so that a frame reflected back to its
+// sender can't be opened.
+var (
+	encryptionToClient = []byte{'s'}
+	encryptionToServer = []byte{'c'}
+)
+
+// EncryptionStage is the FrameStage sealing every frame with AES-GCM, for securing the internal links where TLS is
+// overkill. Every sealed frame is prefixed with its random nonce of 12 bytes, and carries a counter of 8 bytes of
+// its connection in the sealed part. The counters of inbound frames must increase, hence the frames replayed and
+// reordered within a connection are rejected, and the connection is closed with a StageError wrapping
+// ErrDecryptFrame or ErrReplayedFrame. The counters restart with every connection though, so a recorded session
+// can be replayed in full onto a new connection sharing its key, the protection against replay is per connection
+// only, unless the key supplied for every connection is distinct, e.g. derived from a handshake of the protocol.
+// The direction of every frame is sealed with it, hence the stage used by clients must have Client set.
+// As the nonces are random, a key shouldn't seal more than 2^32 frames in total.
+type EncryptionStage struct {
+	// Client is set if the stage is used by the client side, whose frames are sealed towards the server and vice versa.
+	Client bool
+
+	key      func(c Conn) ([]byte, error)
+	stateKey encryptionKey
+
+	mu       sync.Mutex
+	connless *encryptionState // state of the frames sealed and opened without connection
+}
+
+// encryptionKey is the key of the encryption state attached to connections.
+type encryptionKey struct {
+	stage *EncryptionStage
+}
+
+// encryptionState is the state of encryption of a connection.
+type encryptionState struct {
+	aead    cipher.AEAD
+	sendSeq uint64
+	recvSeq uint64
+}
+
+// NewEncryptionStage instantiates and returns a stage encrypting frames for CodecChain, key supplies the key of
+// 16, 24 or 32 bytes for AES-128, AES-192 or AES-256, it's invoked once per connection with the first frame, or
+// once with nil if the CodecChain is used by ICodec.Encode directly, e.g. by clients, which then share the counters.
+func NewEncryptionStage(key func(c Conn) ([]byte, error)) *EncryptionStage {
+	s := &EncryptionStage{key: key}
+	s.stateKey = encryptionKey{s}
+	return s
+}
+
+// state returns the encryption state of connection, which is created with the key supplied for it. Without
+// connection, the state is kept by the stage and s.mu must be held.
+func (s *EncryptionStage) state(c Conn) (*encryptionState, error) {
+	if c == nil {
+		if s.connless != nil {
+			return s.connless, nil
+		}
+	} else if st, ok := c.Get(s.stateKey).(*encryptionState); ok {
+		return st, nil
+	}
+	key, err := s.key(c)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	st := &encryptionState{aead: aead}
+	if c == nil {
+		s.connless = st
+	} else {
+		c.Set(s.stateKey, st)
+	}
+	return st, nil
+}
+
+// directions returns the additional data of the frames sealed and opened by the stage.
+func (s *EncryptionStage) directions() (send, recv []byte) {
+	if s.Client {
+		return encryptionToServer, encryptionToClient
+	}
+	return encryptionToClient, encryptionToServer
+}
+
+// Encode seals the frame with the next counter of connection.
+func (s *EncryptionStage) Encode(c Conn, frame []byte) ([]byte, error) {
+	if c == nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+	}
+	st, err := s.state(c)
+	if err != nil {
+		return nil, err
+	}
+	nonceSize := st.aead.NonceSize()
+	out := make([]byte, nonceSize, nonceSize+8+len(frame)+st.aead.Overhead())
+	if _, err = rand.Read(out); err != nil {
+		return nil, err
+	}
+	st.sendSeq++
+	plain := make([]byte, 8+len(frame))
+	binary.BigEndian.PutUint64(plain, st.sendSeq)
+	copy(plain[8:], frame)
+	send, _ := s.directions()
+	return st.aead.Seal(out, out, plain, send), nil
+}
+
+// Decode opens the frame and checks that its counter increases.
+func (s *EncryptionStage) Decode(c Conn, frame []byte) ([]byte, error) {
+	if c == nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+	}
+	st, err := s.state(c)
+	if err != nil {
+		return nil, err
+	}
+	nonceSize := st.aead.NonceSize()
+	if len(frame) < nonceSize+8+st.aead.Overhead() {
+		return nil, ErrDecryptFrame
+	}
+	_, recv := s.directions()
+	plain, err := st.aead.Open(nil, frame[:nonceSize], frame[nonceSize:], recv)
+	if err != nil {
+		return nil, ErrDecryptFrame
+	}
+	seq := binary.BigEndian.Uint64(plain)
+	if seq <= st.recvSeq {
+		return nil, ErrReplayedFrame
+	}
+	st.recvSeq = seq
+	return plain[8:], nil
+}
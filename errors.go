@@ -3,8 +3,16 @@ package gnet
 import "errors"
 
 var (
-	// errShutdown server is closing.
-	errShutdown = errors.New("server is going to be shutdown")
+	// ErrServerShutdown server is closing, connections closed by the shutdown receive it in OnClosed.
+	ErrServerShutdown = errors.New("server is going to be shutdown")
+	// ErrConnectionClosed connection is closed by the peer.
+	ErrConnectionClosed = errors.New("connection is closed by the peer")
+	// ErrBufferFull buffer of connection can't grow to hold more data within the maximum capacity.
+	ErrBufferFull = errors.New("buffer of connection is full")
+	// ErrTooManyConnections number of connections from the host exceeds the limit, it's passed to OnAcceptError.
+	ErrTooManyConnections = errors.New("too many connections")
+	// ErrUnsupportedProtocol network of the address isn't supported.
+	ErrUnsupportedProtocol = errors.New("only tcp, tcp4, tcp6, udp, udp4, udp6, unix and unixgram are supported")
 	// ErrInvalidFixedLength invalid fixed length.
 	ErrInvalidFixedLength = errors.New("invalid fixed length of bytes")
 	// ErrUnexpectedEOF no enough data to read.
@@ -17,4 +25,44 @@ var (
 	ErrUnsupportedLength = errors.New("unsupported lengthFieldLength. (expected: 1, 2, 3, 4, or 8)")
 	// ErrTooLessLength adjusted frame length is less than zero.
 	ErrTooLessLength = errors.New("adjusted frame length is less than zero")
+	// ErrMemoryLimitExceeded connection is shed by reason of exceeding the memory limit.
+	ErrMemoryLimitExceeded = errors.New("connection is shed by reason of exceeding the memory limit")
+	// ErrHeartbeatTimeout connection is closed by reason of missing the heartbeat.
+	ErrHeartbeatTimeout = errors.New("connection is closed by reason of missing the heartbeat")
+	// ErrTickerExists a ticker with the same name has been scheduled.
+	ErrTickerExists = errors.New("ticker with the same name has been scheduled")
+	// ErrInvalidTickerInterval interval of ticker is not positive.
+	ErrInvalidTickerInterval = errors.New("interval of ticker must be positive")
+	// ErrInvalidFD file-descriptor, events or handler passed to RegisterFD is invalid.
+	ErrInvalidFD = errors.New("invalid file-descriptor, events or handler to register")
+	// ErrNotStream operation isn't available to the datagrams of UDP and unixgram servers, which aren't bound to loops.
+	ErrNotStream = errors.New("operation is only available to stream connections")
+	// ErrNotDatagram operation is only available to the datagrams of UDP and unixgram servers.
+	ErrNotDatagram = errors.New("operation is only available to datagrams")
+	// ErrInvalidAddr address can't be converted into the socket address of the listener.
+	ErrInvalidAddr = errors.New("address doesn't match the network of the listener")
+	// ErrInvalidSegmentSize size of segments passed to SendSegments is out of range.
+	ErrInvalidSegmentSize = errors.New("size of segments must be positive and fit in a datagram")
+	// ErrPacketInfoUnsupported information of datagrams can't be sent on the current platform.
+	ErrPacketInfoUnsupported = errors.New("information of datagrams is not supported on this platform")
+	// ErrFDRegistered file-descriptor has been registered by RegisterFD.
+	ErrFDRegistered = errors.New("file-descriptor has been registered")
+	// ErrTooLongFrame frame exceeds the maximum length of codec, the connection is closed with it.
+	ErrTooLongFrame = errors.New("frame exceeds the maximum length")
+	// ErrDecryptFrame frame can't be decrypted by EncryptionStage, i.e. it's forged, corrupted or sealed with another key.
+	ErrDecryptFrame = errors.New("frame can't be decrypted")
+	// ErrReplayedFrame counter of frame doesn't increase, i.e. it's replayed or reordered.
+	ErrReplayedFrame = errors.New("frame is replayed")
+	// ErrChecksumMismatch checksum of frame doesn't match its content, i.e. it's corrupted.
+	ErrChecksumMismatch = errors.New("checksum of frame mismatches")
+	// ErrUnregisteredType JSON object names no type registered to NDJSONCodec.
+	ErrUnregisteredType = errors.New("type of JSON object is not registered")
+	// ErrMalformedFrame frame violates the protocol of codec, the connection is closed with it.
+	ErrMalformedFrame = errors.New("frame is malformed")
+	// ErrConnectionLeaked occurs when the connection suspected to be leaked is closed by OnLeak.
+	ErrConnectionLeaked = errors.New("connection is closed as leaked")
+	// ErrUnreloadableOption occurs when Server.ApplyOptions changes an option which can't be changed while serving.
+	ErrUnreloadableOption = errors.New("option can't be changed while serving")
+	// ErrHandshakeTimeout occurs when the connection is closed as its first frame doesn't arrive in time.
+	ErrHandshakeTimeout = errors.New("first frame of connection doesn't arrive in time")
 )
@@ -9,26 +9,36 @@ package gnet
 
 import (
 	"net"
+	"os"
+	"sync/atomic"
 	"time"
 
+	"github.com/panjf2000/gnet/internal"
 	"github.com/panjf2000/gnet/netpoll"
 	"github.com/panjf2000/gnet/ringbuffer"
 	"golang.org/x/sys/unix"
 )
 
 type loop struct {
-	idx         int             // loop index in the server loops list
-	svr         *server         // server in loop
-	packet      []byte          // read packet buffer
-	poller      *netpoll.Poller // epoll or kqueue
-	connections map[int]*conn   // loop connections fd -> conn
+	counters    loopCounters          // counters read by Server.Stats, kept first for the alignment of 64-bit atomics
+	idx         int                   // loop index in the server loops list
+	svr         *server               // server in loop
+	packet      []byte                // read packet buffer
+	oob         []byte                // buffer of control messages received with datagrams
+	poller      netpoll.Poller        // epoll or kqueue by default
+	connections connTable             // loop connections fd -> conn
+	timers      *internal.TimingWheel // timers run in the loop
+	timerErr    error                 // first error returned by the expired timers
+	tickerFd    int                   // timerfd driving Tick on Linux
+	now         time.Time             // coarse clock updated once per poll iteration
 }
 
 func (lp *loop) loopRun() {
 	defer lp.svr.signalShutdown()
+	lp.markGoroutine()
 
 	if lp.idx == 0 && lp.svr.opts.Ticker {
-		go lp.loopTicker()
+		lp.startTicker()
 	}
 
 	_ = lp.poller.Polling(lp.handleEvent)
@@ -39,119 +49,349 @@ func (lp *loop) loopAccept(fd int) error {
 		if lp.svr.ln.pconn != nil {
 			return lp.loopUDPIn(fd)
 		}
-		nfd, sa, err := unix.Accept(fd)
-		if err != nil {
-			if err == unix.EAGAIN {
-				return nil
-			}
+		slice := lp.svr.opts.AcceptTimeSlice
+		if slice <= 0 {
+			_, err := lp.acceptOne(fd)
 			return err
 		}
-		if err := unix.SetNonblock(nfd, true); err != nil {
-			return err
-		}
-		c := newConn(nfd, lp, sa)
-		if err = lp.poller.AddReadWrite(c.fd); err == nil {
-			lp.connections[c.fd] = c
-		} else {
-			return err
+		// Keep accepting within the time slice, the rest of pending connections are left in the backlog
+		// until the next iteration, so that the IO of existing connections isn't starved by floods.
+		start := time.Now()
+		for {
+			accepted, err := lp.acceptOne(fd)
+			if err != nil || !accepted {
+				return err
+			}
+			if time.Since(start) >= slice {
+				atomic.AddInt64(&lp.svr.acceptStats.Deferred, 1)
+				return nil
+			}
 		}
 	}
 	return nil
 }
 
+// acceptOne accepts a connection from the listener, it reports false when there is no pending connection.
+func (lp *loop) acceptOne(fd int) (bool, error) {
+	if lp.throttleAccept(fd) {
+		return false, nil
+	}
+	nfd, sa, err := unix.Accept(fd)
+	if err != nil {
+		if err == unix.EAGAIN {
+			return false, nil
+		}
+		return false, lp.loopAcceptError(fd, err)
+	}
+	atomic.AddInt64(&lp.svr.acceptStats.Accepted, 1)
+	if lp.svr.isShedding() {
+		// Refuse new connections until the memory usage falls back.
+		return true, unix.Close(nfd)
+	}
+	key, ok, err := lp.svr.admit(nfd, sa)
+	if !ok {
+		return true, err
+	}
+	if err := unix.SetNonblock(nfd, true); err != nil {
+		lp.svr.releaseHost(key)
+		return true, err
+	}
+	if err := lp.svr.applySocketOptionHook(nfd); err != nil {
+		sniffError(err)
+		lp.svr.releaseHost(key)
+		return true, unix.Close(nfd)
+	}
+	c := newConn(nfd, lp, sa)
+	c.hostKey = key
+	if err = lp.watchConn(c.fd); err != nil {
+		lp.svr.releaseHost(key)
+		return true, err
+	}
+	lp.connections.set(c.fd, c)
+	// Fire OnOpened right away so that any data which has already arrived on the
+	// new socket stays in the kernel buffer until the next readable event,
+	// by which time OnOpened has returned and its output has been applied.
+	return true, lp.loopOpen(c)
+}
+
 func (lp *loop) loopOpen(c *conn) error {
 	c.opened = true
 	c.localAddr = lp.svr.ln.lnaddr
 	c.remoteAddr = netpoll.SockaddrToTCPOrUnixAddr(c.sa)
+	lp.svr.logOpen(c)
+	if lp.svr.opts.LeakTimeout > 0 && c.openedAt.IsZero() {
+		c.openedAt = lp.now
+	}
+	c.touch(LeakEventOpen)
+	lp.svr.selectMirror(c)
 	out, action := lp.svr.eventHandler.OnOpened(c)
+	if !c.opened {
+		return nil // detached by OnOpened.
+	}
 	c.action = action
-	if lp.svr.opts.TCPKeepAlive > 0 {
-		if _, ok := lp.svr.ln.ln.(*net.TCPListener); ok {
-			sniffError(netpoll.SetKeepAlive(c.fd, int(lp.svr.opts.TCPKeepAlive/time.Second)))
+	tuned := lp.svr.tunables()
+	if _, ok := lp.svr.ln.ln.(*net.TCPListener); ok {
+		if tuned.tcpKeepAlive > 0 {
+			sniffError(netpoll.SetKeepAlive(c.fd, int(tuned.tcpKeepAlive/time.Second)))
+		}
+		if tuned.tcpUserTimeout > 0 {
+			sniffError(netpoll.SetUserTimeout(c.fd, int(tuned.tcpUserTimeout/time.Millisecond)))
 		}
 	}
+	if tuned.linger > 0 {
+		c.lingering = netpoll.SetLinger(c.fd, tuned.linger) == nil
+	}
 	if out != nil {
-		c.open(out)
+		if c.open(out); !c.opened {
+			return nil // closed by the failure of writing.
+		}
 	}
+	lp.startHeartbeat(c)
+	lp.startHandshakeTimer(c)
 
-	if !c.outboundBuffer.IsEmpty() {
-		_ = lp.poller.AddWrite(c.fd)
+	if !c.outboundEmpty() {
+		lp.watchWrite(c)
+		lp.svr.trackMemory(c)
 	}
 
 	return lp.handleAction(c)
 }
 
 func (lp *loop) loopIn(c *conn) error {
-	n, err := unix.Read(c.fd, lp.packet)
-	if n == 0 || err != nil {
-		if err == unix.EAGAIN {
+	if c.readClosed {
+		// Only the exceptional events are reported for the connection whose reading side has been closed,
+		// except in the edge-triggered mode where all the events are reported at once, and the exceptional
+		// ones are handled by loopEdgeTriggered.
+		if lp.svr.opts.EdgeTriggered {
+			return nil
+		}
+		return lp.loopCloseConn(c, ErrConnectionClosed)
+	}
+	for {
+		n, err := unix.Read(c.fd, lp.packet)
+		if n == 0 || err != nil {
+			if err == unix.EAGAIN {
+				return nil
+			}
+			if n == 0 && err == nil {
+				return lp.loopReadClosed(c)
+			}
+			return lp.loopCloseConn(c, os.NewSyscallError("read", err))
+		}
+		atomic.AddInt64(&lp.counters.bytesRead, int64(n))
+		c.touch(LeakEventRead)
+		if lp.svr.opts.HeartbeatInterval > 0 {
+			c.lastActive = lp.now
+		}
+		lp.svr.mirror(c, MirrorInbound, lp.packet[:n])
+		if err = lp.loopReact(c, lp.packet[:n]); err != nil || !c.opened {
+			return err
+		}
+		// The readable event won't be reported again in the edge-triggered mode, so drain the socket unless reading
+		// has been paused, the rest is read once resumed.
+		if !lp.svr.opts.EdgeTriggered || c.readPaused {
 			return nil
 		}
-		return lp.loopCloseConn(c, err)
 	}
-	c.cache = lp.packet[:n]
+}
+
+func (lp *loop) loopReact(c *conn, data []byte) error {
+	if c.netConn != nil {
+		c.netConn.feed(data)
+		return nil
+	}
+	if c.decoder != nil {
+		return lp.loopDecode(c, data)
+	}
+	c.cache = data
 
 loopReact:
 	out, action := lp.svr.eventHandler.React(c)
+	if !c.opened {
+		return nil // detached by React.
+	}
 	if len(out) != 0 {
-		if frame, err := lp.svr.codec.Encode(out); err == nil {
+		if frame, err := lp.svr.encode(c, out); err == nil {
 			c.write(frame)
 		}
+		if !c.opened {
+			return nil // closed by the failure of writing.
+		}
 		goto loopReact
 	}
-	_, _ = c.inboundBuffer.Write(c.cache)
+	if len(c.cache) > 0 {
+		if _, err := c.inboundBuffer.Write(c.cache); err != nil {
+			return lp.loopCloseConn(c, ErrBufferFull)
+		}
+		c.inboundIdle = false
+	}
+	lp.svr.trackMemory(c)
 
 	c.action = action
 	return lp.handleAction(c)
 }
 
 func (lp *loop) loopOut(c *conn) error {
+	if lp.svr.opts.Tracer != nil {
+		return lp.loopTracedOut(c)
+	}
+	return lp.loopFlush(c)
+}
+
+// loopFlush flushes the outbound buffer of the writable connection.
+func (lp *loop) loopFlush(c *conn) error {
 	lp.svr.eventHandler.PreWrite()
+	c.touch(LeakEventWrite)
+
+	for {
+		if err := c.flushOutbound(); err != nil {
+			if err == unix.EAGAIN {
+				return nil
+			}
+			return lp.loopCloseConn(c, err)
+		}
 
+		if c.outboundEmpty() {
+			lp.unwatchWrite(c)
+			lp.svr.trackMemory(c)
+			if c.netConn != nil {
+				c.netConn.flushed()
+			}
+			if len(c.sources) > 0 {
+				return lp.loopFill(c)
+			}
+			if c.writeClosed {
+				return lp.loopShutdownWrite(c)
+			}
+			return nil
+		}
+		// The writable event won't be reported again in the edge-triggered mode, so write until EAGAIN.
+		if !lp.svr.opts.EdgeTriggered {
+			return nil
+		}
+	}
+}
+
+// flushOutbound writes the data pending in the outbound buffer to the socket once, the linked buffer is written
+// with writev while the ring-buffer is written in two segments at most.
+func (c *conn) flushOutbound() error {
+	if c.outboundList != nil {
+		n, err := netpoll.Writev(c.fd, c.outboundList.peek(netpoll.MaxIovecs))
+		if err != nil {
+			if err == unix.EAGAIN {
+				return err
+			}
+			return os.NewSyscallError("writev", err)
+		}
+		c.outboundList.discard(n)
+		atomic.AddInt64(&c.loop.counters.bytesWritten, int64(n))
+		return nil
+	}
 	head, tail := c.outboundBuffer.LazyReadAll()
 	n, err := unix.Write(c.fd, head)
 	if err != nil {
 		if err == unix.EAGAIN {
-			return nil
+			return err
 		}
-		return lp.loopCloseConn(c, err)
+		return os.NewSyscallError("write", err)
 	}
 	c.outboundBuffer.Shift(n)
+	atomic.AddInt64(&c.loop.counters.bytesWritten, int64(n))
 
 	if len(head) == n && tail != nil {
 		n, err = unix.Write(c.fd, tail)
 		if err != nil {
 			if err == unix.EAGAIN {
-				return nil
+				return err
 			}
-			return lp.loopCloseConn(c, err)
+			return os.NewSyscallError("write", err)
 		}
 		c.outboundBuffer.Shift(n)
+		atomic.AddInt64(&c.loop.counters.bytesWritten, int64(n))
 	}
+	return nil
+}
 
-	if c.outboundBuffer.IsEmpty() {
+// watchConn registers the connection to the poller, for readable events in the level-triggered mode, or for
+// both readable and writable events in the edge-triggered mode.
+func (lp *loop) watchConn(fd int) error {
+	if lp.svr.opts.EdgeTriggered {
+		return lp.poller.AddReadWriteEdge(fd)
+	}
+	return lp.poller.AddRead(fd)
+}
+
+// watchWrite starts watching the writable event of connection, which is always watched in the edge-triggered mode.
+func (lp *loop) watchWrite(c *conn) {
+	switch {
+	case lp.svr.opts.EdgeTriggered:
+	case c.readClosed, c.readPaused:
+		_ = lp.poller.ModWrite(c.fd)
+	default:
+		_ = lp.poller.ModReadWrite(c.fd)
+	}
+}
+
+// unwatchWrite stops watching the writable event of connection, which is always watched in the edge-triggered mode.
+func (lp *loop) unwatchWrite(c *conn) {
+	switch {
+	case lp.svr.opts.EdgeTriggered:
+	case c.readClosed, c.readPaused:
+		_ = lp.poller.ModNone(c.fd)
+	default:
 		_ = lp.poller.ModRead(c.fd)
 	}
-	return nil
 }
 
 func (lp *loop) loopCloseConn(c *conn, err error) error {
-	if lp.poller.Delete(c.fd) == nil && unix.Close(c.fd) == nil {
-		delete(lp.connections, c.fd)
+	if lp.poller.Delete(c.fd) == nil && lp.closeFd(c) == nil {
+		lp.connections.delete(c.fd)
+		if c.netConn != nil {
+			c.netConn.closeWith(err)
+		}
+		lp.svr.logClose(c, err)
 		switch lp.svr.eventHandler.OnClosed(c, err) {
 		case Shutdown:
-			return errShutdown
+			return ErrServerShutdown
 		}
 		c.release()
 	}
 	return nil
 }
 
-func (lp *loop) loopWake(c *conn) error {
-	if co, ok := lp.connections[c.fd]; !ok || co != c {
+// closeFd closes the socket of connection. Closing the socket with SO_LINGER blocks until the unsent data has been
+// sent or the timeout expires, even if it's non-blocking, so it's closed on another goroutine to keep the other
+// connections of loop going, the file descriptor isn't reused until then.
+func (lp *loop) closeFd(c *conn) error {
+	if !c.lingering {
+		return unix.Close(c.fd)
+	}
+	go func(fd int) {
+		sniffError(unix.Close(fd))
+	}(c.fd)
+	return nil
+}
+
+func (lp *loop) loopCloseAbort(c *conn) error {
+	if lp.connections.get(c.fd) != c {
+		return nil // ignore stale closes.
+	}
+	sniffError(netpoll.SetLinger(c.fd, 0))
+	c.lingering = false
+	return lp.loopCloseConn(c, nil)
+}
+
+func (lp *loop) loopWake(c *conn, data interface{}) error {
+	if lp.connections.get(c.fd) != c {
 		return nil // ignore stale wakes.
 	}
+	c.touch(LeakEventWake)
+	c.wakeCtx = data
 	out, action := lp.svr.eventHandler.React(c)
+	c.wakeCtx = nil
+	if !c.opened {
+		return nil // detached by React.
+	}
 	c.action = action
 	if out != nil {
 		c.write(out)
@@ -159,6 +399,24 @@ func (lp *loop) loopWake(c *conn) error {
 	return lp.handleAction(c)
 }
 
+func (lp *loop) loopExecute(c *conn, task func(c Conn) error) error {
+	if lp.connections.get(c.fd) != c {
+		return nil // ignore tasks of the closed connection.
+	}
+	c.touch(LeakEventWake)
+	if err := task(c); err != nil && c.opened {
+		return lp.loopCloseConn(c, err)
+	}
+	return nil
+}
+
+// updateClock updates the coarse clock of loop, it's invoked every time the poller returns from waiting.
+func (lp *loop) updateClock() {
+	lp.now = time.Now()
+	atomic.AddInt64(&lp.counters.pollIterations, 1)
+	lp.markBusy()
+}
+
 func (lp *loop) loopTicker() {
 	for {
 		if err := lp.poller.Trigger(func() (err error) {
@@ -167,7 +425,7 @@ func (lp *loop) loopTicker() {
 			switch action {
 			case None:
 			case Shutdown:
-				err = errShutdown
+				err = ErrServerShutdown
 			}
 			return
 		}); err != nil {
@@ -178,42 +436,113 @@ func (lp *loop) loopTicker() {
 }
 
 func (lp *loop) handleAction(c *conn) error {
+	if c.codecErr != nil {
+		return lp.loopCloseConn(c, c.codecErr)
+	}
 	switch c.action {
 	case None:
 		return nil
 	case Close:
 		return lp.loopCloseConn(c, nil)
 	case Shutdown:
-		return errShutdown
+		return ErrServerShutdown
 	default:
 		return nil
 	}
 }
 
 func (lp *loop) loopUDPIn(fd int) error {
+	if lp.svr.opts.UDPGRO || lp.svr.opts.PacketInfo || lp.svr.ln.network == "unixgram" {
+		return lp.loopUDPInMsg(fd)
+	}
 	n, sa, err := unix.Recvfrom(fd, lp.packet, 0)
 	if err != nil || n == 0 {
 		return nil
 	}
+	return lp.loopDatagram(fd, sa, lp.packet[:n], nil, nil)
+}
+
+// oobSize is the size of buffer for the control messages received with datagrams.
+const oobSize = 256
+
+// loopUDPInMsg reads the datagram with the control messages. The datagrams coalesced by UDP_GRO are split into
+// the segments and handled one by one as if they were received separately. The datagrams of unixgram carry the
+// credentials of their senders, since SO_PASSCRED is enabled on the socket.
+func (lp *loop) loopUDPInMsg(fd int) error {
+	if lp.oob == nil {
+		lp.oob = make([]byte, oobSize)
+	}
+	n, oobn, _, sa, err := unix.Recvmsg(fd, lp.packet, lp.oob, 0)
+	if err != nil || n == 0 {
+		return nil
+	}
+	oob := lp.oob[:oobn]
+	var info *PacketInfo
+	if lp.svr.opts.PacketInfo {
+		info = new(PacketInfo)
+		info.Dst, info.IfIndex, info.TrafficClass = netpoll.ParsePacketInfo(oob)
+	}
+	var cred *PeerCredentials
+	if lp.svr.ln.network == "unixgram" {
+		if pid, uid, gid, ok := netpoll.ParseCredentials(oob); ok {
+			cred = &PeerCredentials{PID: pid, UID: uid, GID: gid}
+		}
+	}
+	size := n
+	if lp.svr.opts.UDPGRO {
+		if size = netpoll.UDPGROSegmentSize(oob); size <= 0 {
+			size = n
+		}
+	}
+	for data := lp.packet[:n]; len(data) > 0; {
+		segment := data
+		if len(segment) > size {
+			segment = segment[:size]
+		}
+		data = data[len(segment):]
+		if err = lp.loopDatagram(fd, sa, segment, info, cred); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loopDatagram fires React for the datagram received from sa.
+func (lp *loop) loopDatagram(fd int, sa unix.Sockaddr, data []byte, info *PacketInfo, cred *PeerCredentials) error {
 	c := &conn{
 		fd:            fd,
+		sa:            sa,
+		datagram:      true,
+		svr:           lp.svr,
+		pktInfo:       info,
+		peerCred:      cred,
 		localAddr:     lp.svr.ln.lnaddr,
-		remoteAddr:    netpoll.SockaddrToUDPAddr(sa),
-		inboundBuffer: lp.svr.bytesPool.Get().(*ringbuffer.RingBuffer),
+		inboundBuffer: lp.svr.inboundPool.Get().(*ringbuffer.RingBuffer),
+	}
+	if lp.svr.ln.network == "unixgram" {
+		c.remoteAddr = netpoll.SockaddrToUnixgramAddr(sa)
+	} else {
+		c.remoteAddr = netpoll.SockaddrToUDPAddr(sa)
 	}
-	c.cache = lp.packet[:n]
+	c.cache = data
+	atomic.AddInt64(&lp.counters.bytesRead, int64(len(data)))
+	lp.svr.selectMirror(c)
+	lp.svr.mirror(c, MirrorInbound, data)
 	out, action := lp.svr.eventHandler.React(c)
 	if out != nil {
 		lp.svr.eventHandler.PreWrite()
+		lp.svr.mirror(c, MirrorOutbound, out)
+		atomic.AddInt64(&lp.counters.bytesWritten, int64(len(out)))
 		c.sendTo(out, sa)
 	}
 	switch action {
 	case Shutdown:
-		return errShutdown
+		return ErrServerShutdown
 	}
 
 	c.inboundBuffer.Reset()
-	lp.svr.bytesPool.Put(c.inboundBuffer)
+	lp.svr.inboundPool.Put(c.inboundBuffer)
+	c.datagram = false // SendTo mustn't be used once React returns.
 	c = nil
 
 	return nil
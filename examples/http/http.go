@@ -16,6 +16,7 @@ import (
 	"time"
 
 	"github.com/panjf2000/gnet"
+	"github.com/panjf2000/gnet/accesslog"
 )
 
 var res string
@@ -29,7 +30,8 @@ type request struct {
 
 type httpServer struct {
 	*gnet.EventServer
-	noparse bool
+	noparse   bool
+	accessLog *accesslog.Logger
 }
 
 func (hs *httpServer) OnInitComplete(srv gnet.Server) (action gnet.Action) {
@@ -59,9 +61,28 @@ func (hs *httpServer) React(c gnet.Conn) (out []byte, action gnet.Action) {
 		return
 	}
 	// handle the request
+	start := time.Now()
+	uri := req.path
+	if req.query != "" {
+		uri += "?" + req.query
+	}
 	req.remoteAddr = c.RemoteAddr().String()
 	out = appendhandle(out, &req)
 	c.ResetBuffer()
+	if hs.accessLog != nil {
+		_ = hs.accessLog.Log(&accesslog.Record{
+			Event:      accesslog.EventRequest,
+			Network:    "tcp",
+			LocalAddr:  c.LocalAddr().String(),
+			RemoteAddr: req.remoteAddr,
+			Duration:   time.Since(start),
+			Method:     req.method,
+			URI:        uri,
+			Proto:      req.proto,
+			Status:     200,
+			Bytes:      len(res),
+		})
+	}
 	return
 }
 
@@ -70,12 +91,14 @@ func main() {
 	var multicore bool
 	var aaaa bool
 	var noparse bool
+	var accessLog string
 
 	// Example command: go run http.go --port 8080 --multicore true
 	flag.IntVar(&port, "port", 8080, "server port")
 	flag.BoolVar(&aaaa, "aaaa", false, "aaaaa....")
 	flag.BoolVar(&noparse, "noparse", true, "do not parse requests")
 	flag.BoolVar(&multicore, "multicore", true, "multicore")
+	flag.StringVar(&accessLog, "accesslog", "", "format of access log written to stdout: clf or json, empty disables it")
 	flag.Parse()
 
 	if os.Getenv("NOPARSE") == "1" {
@@ -89,10 +112,24 @@ func main() {
 	}
 
 	http := &httpServer{noparse: noparse}
+	opts := []gnet.Option{gnet.WithMulticore(multicore)}
+	switch accessLog {
+	case "clf":
+		http.accessLog = accesslog.New(os.Stdout, new(accesslog.CLFFormatter))
+	case "json":
+		http.accessLog = accesslog.New(os.Stdout, new(accesslog.JSONFormatter))
+	}
+	if http.accessLog != nil {
+		opts = append(opts, gnet.WithAccessLog(http.accessLog))
+	}
 	// We at least want the single http address.
 	addr := fmt.Sprintf("tcp"+"://:%d", port)
 	// Start serving!
-	log.Fatal(gnet.Serve(http, addr, gnet.WithMulticore(multicore)))
+	err := gnet.Serve(http, addr, opts...)
+	if http.accessLog != nil {
+		_ = http.accessLog.Close()
+	}
+	log.Fatal(err)
 }
 
 // appendhandle handles the incoming request and appends the response to
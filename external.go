@@ -0,0 +1,160 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build darwin netbsd freebsd openbsd dragonfly linux
+
+package gnet
+
+import "sync/atomic"
+
+// FDEvent is the set of events of a file-descriptor registered by Server.RegisterFD.
+type FDEvent uint8
+
+const (
+	// FDReadable indicates the file-descriptor is readable, or has been hung up.
+	FDReadable FDEvent = 1 << iota
+
+	// FDWritable indicates the file-descriptor is writable.
+	FDWritable
+)
+
+// FDHandler handles the events of a file-descriptor registered by Server.RegisterFD, it runs in the event-loop
+// watching the file-descriptor. The file-descriptor is unregistered if Close is returned, and the server is shut
+// down if Shutdown is returned.
+type FDHandler func(fd int, events FDEvent) Action
+
+// externalFD is a file-descriptor owned by the user and watched by an event-loop.
+type externalFD struct {
+	fd      int
+	events  FDEvent
+	handler FDHandler
+	lp      *loop
+}
+
+// RegisterFD watches the file-descriptor owned by the user, e.g. timerfd, signalfd, inotify, pipe and serial port,
+// in one of the event-loops, handler is run in the event-loop whenever any of the given events occurs. The events
+// are level-triggered, so handler should consume the readable data, or unregister the file-descriptor.
+// The file-descriptor registered before the event-loops are started, e.g. in OnInitComplete, is watched once
+// they are started, and failures of watching it are logged.
+//
+// The file-descriptor is never closed by the server, it must be unregistered before being closed by the user.
+func (s Server) RegisterFD(fd int, events FDEvent, handler FDHandler) error {
+	return s.svr.registerFD(fd, events, handler)
+}
+
+// UnregisterFD stops watching the file-descriptor registered by RegisterFD, it reports false if there is no such
+// file-descriptor. The handler may still be run once if it's being run at the moment.
+func (s Server) UnregisterFD(fd int) bool {
+	return s.svr.unregisterFD(fd)
+}
+
+func (svr *server) registerFD(fd int, events FDEvent, handler FDHandler) error {
+	if fd < 0 || events&(FDReadable|FDWritable) == 0 || handler == nil {
+		return ErrInvalidFD
+	}
+	f := &externalFD{fd: fd, events: events, handler: handler}
+	svr.externalMu.Lock()
+	if _, ok := svr.externals[fd]; ok {
+		svr.externalMu.Unlock()
+		return ErrFDRegistered
+	}
+	if svr.externals == nil {
+		svr.externals = make(map[int]*externalFD)
+	}
+	svr.externals[fd] = f
+	if !svr.externalsReady {
+		// The event-loops haven't been started yet, e.g. in OnInitComplete.
+		svr.externalMu.Unlock()
+		return nil
+	}
+	svr.assignExternal(f)
+	svr.externalMu.Unlock()
+	// The file-descriptor is found by the event-loop as soon as it's watched, for it has been added above.
+	if err := f.watch(); err != nil {
+		svr.externalMu.Lock()
+		delete(svr.externals, fd)
+		svr.externalMu.Unlock()
+		return err
+	}
+	return nil
+}
+
+func (svr *server) unregisterFD(fd int) bool {
+	svr.externalMu.Lock()
+	f, ok := svr.externals[fd]
+	delete(svr.externals, fd)
+	svr.externalMu.Unlock()
+	if ok && f.lp != nil {
+		f.unwatch()
+	}
+	return ok
+}
+
+// assignExternal must be called with svr.externalMu held, it distributes the file-descriptors to the event-loops
+// in a round-robin fashion.
+func (svr *server) assignExternal(f *externalFD) {
+	idx := int(atomic.AddUint32(&svr.externalSeq, 1) % uint32(svr.subLoopGroupSize))
+	svr.subLoopGroup.iterate(func(i int, lp *loop) bool {
+		if i == idx {
+			f.lp = lp
+			return false
+		}
+		return true
+	})
+}
+
+// startExternals watches the file-descriptors registered before the event-loops are started.
+func (svr *server) startExternals() {
+	svr.externalMu.Lock()
+	var pending []*externalFD
+	for _, f := range svr.externals {
+		svr.assignExternal(f)
+		pending = append(pending, f)
+	}
+	svr.externalsReady = true
+	svr.externalMu.Unlock()
+	for _, f := range pending {
+		if err := f.watch(); err != nil {
+			sniffError(err)
+			svr.unregisterFD(f.fd)
+		}
+	}
+}
+
+// watch registers the file-descriptor to the poller of its loop.
+func (f *externalFD) watch() error {
+	switch f.events & (FDReadable | FDWritable) {
+	case FDReadable:
+		return f.lp.poller.AddRead(f.fd)
+	case FDWritable:
+		return f.lp.poller.AddWrite(f.fd)
+	default:
+		return f.lp.poller.AddReadWrite(f.fd)
+	}
+}
+
+// external returns the file-descriptor registered by RegisterFD and watched by this loop.
+func (lp *loop) external(fd int) *externalFD {
+	lp.svr.externalMu.RLock()
+	f := lp.svr.externals[fd]
+	lp.svr.externalMu.RUnlock()
+	if f == nil || f.lp != lp {
+		return nil
+	}
+	return f
+}
+
+// loopExternal runs the handler of file-descriptor with the events occurred.
+func (lp *loop) loopExternal(f *externalFD, events FDEvent) error {
+	if events &= f.events; events == 0 {
+		return nil
+	}
+	switch f.handler(f.fd, events) {
+	case Close:
+		lp.svr.unregisterFD(f.fd)
+	case Shutdown:
+		return ErrServerShutdown
+	}
+	return nil
+}
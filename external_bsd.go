@@ -0,0 +1,28 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build darwin netbsd freebsd openbsd dragonfly
+
+package gnet
+
+import "github.com/panjf2000/gnet/netpoll"
+
+// unwatch deletes the filters explicitly, since they're only dropped by kqueue once the file-descriptor is closed.
+func (f *externalFD) unwatch() {
+	_ = f.lp.poller.ModNone(f.fd)
+}
+
+// fdEvents converts the kqueue filter into FDEvent.
+func fdEvents(filter int16) FDEvent {
+	switch filter {
+	case netpoll.EVFilterRead:
+		return FDReadable
+	case netpoll.EVFilterWrite:
+		return FDWritable
+	case netpoll.EVFilterSock:
+		return FDReadable | FDWritable
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,24 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux
+
+package gnet
+
+import "github.com/panjf2000/gnet/netpoll"
+
+func (f *externalFD) unwatch() {
+	_ = f.lp.poller.Delete(f.fd)
+}
+
+// fdEvents converts the epoll events into FDEvent.
+func fdEvents(ev uint32) (events FDEvent) {
+	if ev&netpoll.InEvents != 0 {
+		events |= FDReadable
+	}
+	if ev&netpoll.OutEvents != 0 {
+		events |= FDWritable
+	}
+	return
+}
@@ -0,0 +1,58 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import "net"
+
+// NewCIDRFilter makes a filter of connections for WithConnectionFilter from the lists of CIDRs, which rejects
+// the remote addresses in deny, and the ones out of allow unless allow is empty. Connections without IP
+// addresses, e.g. from Unix domain sockets, are only admitted when allow is empty.
+func NewCIDRFilter(allow, deny []string) (func(remote net.Addr) bool, error) {
+	allowNets, err := parseCIDRs(allow)
+	if err != nil {
+		return nil, err
+	}
+	denyNets, err := parseCIDRs(deny)
+	if err != nil {
+		return nil, err
+	}
+	return func(remote net.Addr) bool {
+		var ip net.IP
+		switch addr := remote.(type) {
+		case *net.TCPAddr:
+			ip = addr.IP
+		case *net.UDPAddr:
+			ip = addr.IP
+		default:
+			return len(allowNets) == 0
+		}
+		for _, n := range denyNets {
+			if n.Contains(ip) {
+				return false
+			}
+		}
+		if len(allowNets) == 0 {
+			return true
+		}
+		for _, n := range allowNets {
+			if n.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
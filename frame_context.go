@@ -0,0 +1,80 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build darwin netbsd freebsd openbsd dragonfly linux
+
+package gnet
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// FrameStats records the outcomes of frames processed with the contexts from Conn.FrameContext.
+type FrameStats struct {
+	// Completed is the number of frames finished before being cancelled.
+	Completed int64
+
+	// Cancelled is the number of frames cancelled by reason of the connection being closed.
+	Cancelled int64
+
+	// TimedOut is the number of frames cancelled by reason of the frame deadline passing.
+	TimedOut int64
+}
+
+// FrameStats returns the outcomes of frames processed with the contexts from Conn.FrameContext.
+func (s Server) FrameStats() FrameStats {
+	return FrameStats{
+		Completed: atomic.LoadInt64(&s.svr.frameStats.Completed),
+		Cancelled: atomic.LoadInt64(&s.svr.frameStats.Cancelled),
+		TimedOut:  atomic.LoadInt64(&s.svr.frameStats.TimedOut),
+	}
+}
+
+// closeContext returns the context cancelled when the connection is closed or the server shuts down, it's nil
+// for the connections of datagrams which don't belong to any loop.
+func (c *conn) closeContext() context.Context {
+	if c.loop == nil {
+		return nil
+	}
+	if c.closeCtx == nil {
+		c.closeCtx, c.closeCancel = context.WithCancel(c.loop.svr.ctx)
+	}
+	return c.closeCtx
+}
+
+func (c *conn) FrameContext() (context.Context, context.CancelFunc) {
+	// The context of datagram is only cancelled by the shutdown of server or the deadline.
+	svr, parent := c.svr, context.Context(nil)
+	if c.loop != nil {
+		svr, parent = c.loop.svr, c.closeContext()
+	} else {
+		parent = svr.ctx
+	}
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+	)
+	if deadline := svr.tunables().frameDeadline; deadline > 0 {
+		ctx, cancel = context.WithTimeout(parent, deadline)
+	} else {
+		ctx, cancel = context.WithCancel(parent)
+	}
+	stats := &svr.frameStats
+	var once sync.Once
+	return ctx, func() {
+		once.Do(func() {
+			switch ctx.Err() {
+			case nil:
+				atomic.AddInt64(&stats.Completed, 1)
+			case context.DeadlineExceeded:
+				atomic.AddInt64(&stats.TimedOut, 1)
+			default:
+				atomic.AddInt64(&stats.Cancelled, 1)
+			}
+			cancel()
+		})
+	}
+}
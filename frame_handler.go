@@ -0,0 +1,115 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build darwin netbsd freebsd openbsd dragonfly linux
+
+package gnet
+
+import (
+	"net"
+	"time"
+)
+
+// FrameEventHandler is the variant of EventHandler whose React receives the frame decoded by the codec, so that
+// handlers needn't invoke Conn.ReadFrame themselves. Embed EventServer for the default implementation of the
+// other events, the React defined by the embedding type shadows the one of EventServer. Use AdaptFrames for
+// serving it.
+type FrameEventHandler interface {
+	OnInitComplete(server Server) (action Action)
+	OnAccept(remote net.Addr) (action Action, reason []byte)
+	OnOpened(c Conn) (out []byte, action Action)
+	OnReadClosed(c Conn) (out []byte, action Action)
+	OnClosed(c Conn, err error) (action Action)
+	PreWrite()
+	Tick() (delay time.Duration, action Action)
+	OnMemoryPressure(usage, limit int64)
+	OnHeartbeatTimeout(c Conn)
+	OnAcceptError(err error) (action Action)
+
+	// React fires with every complete frame decoded from the inbound data of connection, empty frames are
+	// skipped. Every datagram is a frame for UDP and unixgram.
+	React(frame []byte, c Conn) (out []byte, action Action)
+}
+
+// AdaptFrames adapts the FrameEventHandler to EventHandler, so that it can be served by Serve and wrapped by
+// middlewares.
+func AdaptFrames(handler FrameEventHandler) EventHandler {
+	return &frameAdapter{FrameEventHandler: handler}
+}
+
+type frameAdapter struct {
+	FrameEventHandler
+}
+
+// React passes the complete frames to the React of FrameEventHandler until one of them yields output or an action,
+// the event-loop invokes it again after writing the output, hence all the complete frames are delivered in order.
+func (a *frameAdapter) React(c Conn) (out []byte, action Action) {
+	for {
+		n := c.BufferLength()
+		frame := c.ReadFrame()
+		if len(frame) == 0 {
+			if frame == nil || c.BufferLength() == n {
+				return
+			}
+			continue // skip the empty frames, e.g. empty lines.
+		}
+		if out, action = a.FrameEventHandler.React(frame, c); len(out) != 0 || action != None {
+			return
+		}
+		if cc, ok := c.(*conn); ok && !cc.opened {
+			return // detached by React.
+		}
+	}
+}
+
+// BatchFrameEventHandler is the variant of EventHandler which receives all the complete frames decoded after a
+// read at once, reducing the overhead per frame of the protocols with high message rates, the output for the whole
+// batch is written at once as well. Embed EventServer for the default implementation of the other events. Use
+// AdaptBatchFrames for serving it.
+type BatchFrameEventHandler interface {
+	OnInitComplete(server Server) (action Action)
+	OnAccept(remote net.Addr) (action Action, reason []byte)
+	OnOpened(c Conn) (out []byte, action Action)
+	OnReadClosed(c Conn) (out []byte, action Action)
+	OnClosed(c Conn, err error) (action Action)
+	PreWrite()
+	Tick() (delay time.Duration, action Action)
+	OnMemoryPressure(usage, limit int64)
+	OnHeartbeatTimeout(c Conn)
+	OnAcceptError(err error) (action Action)
+
+	// OnFrames fires with the complete frames decoded from the inbound data of connection, empty frames are
+	// skipped. The frames may refer to the inbound buffer of connection and are only valid until it returns.
+	OnFrames(c Conn, frames [][]byte) (out []byte, action Action)
+}
+
+// AdaptBatchFrames adapts the BatchFrameEventHandler to EventHandler, so that it can be served by Serve and wrapped
+// by middlewares.
+func AdaptBatchFrames(handler BatchFrameEventHandler) EventHandler {
+	return &batchFrameAdapter{BatchFrameEventHandler: handler}
+}
+
+type batchFrameAdapter struct {
+	BatchFrameEventHandler
+}
+
+// React decodes all the complete frames and passes them to OnFrames at once.
+func (a *batchFrameAdapter) React(c Conn) (out []byte, action Action) {
+	var frames [][]byte
+	for {
+		n := c.BufferLength()
+		frame := c.ReadFrame()
+		if len(frame) == 0 {
+			if frame == nil || c.BufferLength() == n {
+				break
+			}
+			continue // skip the empty frames, e.g. empty lines.
+		}
+		frames = append(frames, frame)
+	}
+	if len(frames) != 0 {
+		out, action = a.BatchFrameEventHandler.OnFrames(c, frames)
+	}
+	return
+}
@@ -6,14 +6,19 @@
 package gnet
 
 import (
+	"context"
+	"io"
+	"io/ioutil"
 	"log"
 	"net"
 	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/panjf2000/gnet/netpoll"
 	"github.com/panjf2000/gnet/ringbuffer"
+	"golang.org/x/sys/unix"
 )
 
 // socketRingBufferSize represents the initial size of connection ring-buffer.
@@ -54,8 +59,50 @@ type Server struct {
 
 	// TCPKeepAlive (SO_KEEPALIVE) socket option.
 	TCPKeepAlive time.Duration
+
+	svr *server
+}
+
+// PeerCredentials holds the credentials of the process on the other end of a Unix domain socket.
+type PeerCredentials struct {
+	// PID is the process ID of the peer.
+	PID int32
+
+	// UID is the user ID of the peer.
+	UID uint32
+
+	// GID is the group ID of the peer.
+	GID uint32
+}
+
+// PacketInfo holds the information of a datagram carried by control messages, i.e. IP_PKTINFO and IP_TOS or their
+// IPv6 counterparts, which QUIC stacks rely on.
+type PacketInfo struct {
+	// Dst is the destination address of the received datagram, or the source address to send the datagram from,
+	// nil leaves it to the kernel when sending.
+	Dst net.IP
+
+	// IfIndex is the index of the interface receiving the datagram, or the interface to send the datagram through,
+	// 0 leaves it to the kernel when sending.
+	IfIndex int
+
+	// TrafficClass is the TOS or the traffic class of the datagram, whose lowest 2 bits are the ECN codepoint,
+	// negative means it's unknown when receiving or left to the socket when sending.
+	TrafficClass int
+}
+
+// ECN returns the ECN codepoint in the traffic class.
+func (info *PacketInfo) ECN() int {
+	if info.TrafficClass < 0 {
+		return 0
+	}
+	return info.TrafficClass & 0x3
 }
 
+// ReactTask is a piece of React logic offloaded by Conn.AsyncReact, its output and action are applied to
+// the connection in the event-loop just like the ones returned by React.
+type ReactTask func() (out []byte, action Action)
+
 // Conn is a interface of gnet connection.
 type Conn interface {
 	// Context returns a user-defined context.
@@ -64,16 +111,57 @@ type Conn interface {
 	// SetContext sets a user-defined context.
 	SetContext(ctx interface{})
 
+	// Set attaches the value to the connection with the key, which must be comparable, or removes the value attached
+	// with the key if the value is nil. Unlike the single user-defined context, it lets middlewares and handlers keep
+	// their own data apart, define unexported key types like context.WithValue to avoid collisions. The values are
+	// confined to the event-loop and dropped once the connection is closed, hence Set and Get mustn't be invoked
+	// out of the event-loop.
+	Set(key, value interface{})
+
+	// Get returns the value attached to the connection with the key, or nil if there is none.
+	Get(key interface{}) (value interface{})
+
 	// LocalAddr is the connection's local socket address.
 	LocalAddr() (addr net.Addr)
 
 	// RemoteAddr is the connection's remote peer address.
 	RemoteAddr() (addr net.Addr)
 
+	// RemoteSockaddr is the connection's remote peer address as the raw socket address, which is the source
+	// address of the datagram being handled for UDP and unixgram servers.
+	RemoteSockaddr() (sa unix.Sockaddr)
+
+	// SendTo sends the datagram to addr rather than to the source of the datagram being handled, which lets UDP and
+	// unixgram servers redirect the replies or answer on behalf of peers. It's only available in React of those
+	// servers, ErrNotDatagram is returned otherwise.
+	SendTo(buf []byte, addr net.Addr) error
+
+	// SendSegments sends the buffer as datagrams of segmentSize bytes, the last one may be shorter, to addr or to
+	// the source of the datagram being handled if addr is nil. The buffer is split by the kernel with UDP_SEGMENT
+	// on Linux, and by gnet elsewhere or if the kernel refuses it. It's only available in React of UDP and unixgram
+	// servers, ErrNotDatagram is returned otherwise.
+	SendSegments(buf []byte, segmentSize int, addr net.Addr) error
+
+	// PacketInfo returns the information of the datagram being handled, which is only received by UDP servers
+	// with WithPacketInfo, nil is returned otherwise.
+	PacketInfo() *PacketInfo
+
+	// SendPacket sends the datagram with the information set by control messages, e.g. the source address and
+	// the ECN codepoint, to addr or to the source of the datagram being handled if addr is nil. It's only available
+	// in React of UDP servers, ErrNotDatagram is returned otherwise.
+	SendPacket(buf []byte, addr net.Addr, info *PacketInfo) error
+
+	// PeerCredentials returns the credentials of the peer process retrieved with SO_PEERCRED when
+	// the connection was accepted, or the credentials of the sender received with the datagram of unixgram
+	// servers, it returns nil if the connection is not a Unix domain socket or the credentials are unavailable
+	// on the current platform.
+	PeerCredentials() (cred *PeerCredentials)
+
 	// Wake triggers a React event for this connection.
 	//Wake()
 
-	// ReadFrame returns either a frame from TCP stream based on codec or nil when there isn't a complete frame yet.
+	// ReadFrame returns either a frame from TCP stream based on codec or nil when there isn't a complete frame yet,
+	// the whole datagram is a frame for UDP and unixgram.
 	ReadFrame() (buf []byte)
 
 	// Read reads all data from inbound ring-buffer without moving "read" pointer, which means
@@ -92,15 +180,40 @@ type Conn interface {
 	// Content-Length attribute in an HTTP request which indicates you how much data you should read from inbound ring-buffer.
 	ReadN(n int) (size int, buf []byte)
 
-	// ShiftN shifts "read" pointer in ring buffer with the given length.
-	//ShiftN(n int)
+	// ReadUpTo reads the available bytes up to the given length from inbound ring-buffer and event-loop-buffer like
+	// ReadN, but it doesn't require all the n bytes to be available, so that the protocols streaming bodies in
+	// arbitrary chunk sizes can consume whatever has arrived. It returns zero size when no data is available.
+	ReadUpTo(n int) (size int, buf []byte)
+
+	// Peek returns the next n bytes of the inbound data without moving "read" pointer, the returned slice refers
+	// to the buffer of connection rather than a copy when the bytes are contiguous, so it's only valid until the next
+	// read or discard and must not be modified. ErrUnexpectedEOF is returned when less than n bytes are available.
+	Peek(n int) (buf []byte, err error)
+
+	// Discard shifts "read" pointer with the given length, which evicts up to n bytes of the inbound data without
+	// copying them, it returns the number of bytes discarded.
+	Discard(n int) (discarded int)
+
+	// Reader returns an io.Reader over the inbound data, which evicts the data it reads from the buffer and returns
+	// io.EOF once the available data runs out, so the parsers buffering the data themselves, e.g. bufio.Reader,
+	// must not be reused across events. It must be invoked within the event-loop, e.g. in React.
+	Reader() io.Reader
+
+	// Writer returns an io.Writer which writes to the connection, the data that can't be written right away is
+	// queued to the outbound buffer. The data isn't encoded by the codec. It must be invoked within the event-loop,
+	// e.g. in React, use AsyncWrite in other goroutines instead.
+	Writer() io.Writer
 
 	// BufferLength returns the length of available data in the inbound ring-buffer.
 	BufferLength() (size int)
 
-	// OutboundBuffer returns the outbound ring-buffer.
+	// OutboundBuffer returns the outbound ring-buffer, which is nil for datagrams and with OutboundLinkedBuffer.
 	OutboundBuffer() *ringbuffer.RingBuffer
 
+	// OutboundBuffered returns the length of data pending to be written to the connection, regardless of the
+	// kind of outbound buffer. It must be invoked within the event-loop, e.g. in React or a task of Execute.
+	OutboundBuffered() (size int)
+
 	// InboundBuffer returns the inbound ring-buffer.
 	InboundBuffer() *ringbuffer.RingBuffer
 
@@ -108,8 +221,84 @@ type Conn interface {
 	// the event-loop goroutine.
 	AsyncWrite(buf []byte)
 
+	// AsyncWriteFrom writes n bytes pulled from the reader to client/connection asynchronously, or until EOF if n is
+	// negative. The data is pulled chunk by chunk in the event-loop as the socket becomes writable rather than being
+	// held in memory at once, and the data written after it is sent once the stream is done. The reader is closed
+	// once the stream is done or the connection is closed if it's an io.Closer, and the connection is closed with
+	// the error of reading. Reading mustn't block the event-loop for long, e.g. reading a file or an in-memory buffer.
+	AsyncWriteFrom(r io.Reader, n int64)
+
 	// Wake triggers a React event for this connection.
 	Wake()
+
+	// WakeWith triggers a React event for this connection like Wake, with the payload which is retrievable
+	// by WakeContext inside that React.
+	WakeWith(data interface{})
+
+	// WakeContext returns the payload of WakeWith inside the React triggered by it, or nil otherwise.
+	WakeContext() (data interface{})
+
+	// Execute runs the task in the event-loop of connection, which allows other goroutines to mutate the state of
+	// connection without locks. The task is dropped if the connection has been closed, and the connection is
+	// closed with the error returned by the task.
+	Execute(task func(c Conn) error)
+
+	// LoopTime returns the coarse clock of the event-loop, which is updated once every time the event-loop wakes up
+	// for events, so it's cheaper than time.Now but lags behind it by the time spent handling events. It must be
+	// invoked within the event-loop.
+	LoopTime() time.Time
+
+	// SetTimer runs f in the event-loop of connection after d elapses, the connection is closed with the error
+	// returned by f. The timer is cancelled when the connection is closed, or by Timer.Stop. It fails with
+	// ErrNotStream for the datagrams of UDP and unixgram servers.
+	SetTimer(d time.Duration, f func(c Conn) error) (*Timer, error)
+
+	// AsyncReact runs the task on the worker pool set up by WithWorkerPool and then applies its output and action
+	// to the connection in the event-loop, as if they were returned by React. Tasks of a connection are run one
+	// at a time in order, and they're run in the event-loop when the worker pool is disabled or overloaded.
+	// The task must not access the buffers of connection, copy the data it needs before offloading.
+	AsyncReact(task ReactTask)
+
+	// FrameContext returns a context for processing the current frame outside the event-loop, e.g. in a
+	// worker pool, which is cancelled when the connection is closed or the deadline set by WithFrameDeadline
+	// passes, the context of datagram is cancelled when the server shuts down instead. The cancel function must be invoked when the processing is done, which records the outcome
+	// into Server.FrameStats. FrameContext itself must be invoked within the event-loop, e.g. in React.
+	FrameContext() (ctx context.Context, cancel context.CancelFunc)
+
+	// NetConn returns the adapter of connection to net.Conn for the libraries operating on net.Conn, whose blocking
+	// methods must be invoked outside the event-loop. Once it's returned, the inbound data is read through it instead
+	// of React, and writes through it are queued to the event-loop without waiting for them to be written. Reading
+	// the socket is paused while the inbound data piles up in the adapter, and writes block while the outbound data
+	// piles up in the connection.
+	// NetConn itself must be invoked within the event-loop, e.g. in OnOpened.
+	NetConn() net.Conn
+
+	// CloseWrite shuts down the writing side of connection by sending FIN to the peer once the pending data has been
+	// written, while the connection is kept open for reading. The connection is closed once both sides are closed.
+	CloseWrite()
+
+	// Dup duplicates the file-descriptor of connection, the caller owns the returned file-descriptor and must close it,
+	// while the connection stays in the event-loop.
+	Dup() (fd int, err error)
+
+	// Detach hands off the connection to the code requiring the blocking semantics, it removes the connection from
+	// the event-loop without firing OnClosed and returns a net.Conn on the duplicated file-descriptor, which yields
+	// the inbound data left in the buffer first and writes the outbound data left in the buffer before anything else.
+	// The output and action returned by the event invoking Detach are ignored, and the connection must not be used
+	// afterwards. Detach itself must be invoked within the event-loop, e.g. in React.
+	Detach() (net.Conn, error)
+
+	// CloseAbort closes the connection abortively by setting SO_LINGER with zero timeout, which discards the
+	// unsent data and sends RST to the peer instead of FIN, so that the connection doesn't linger in TIME_WAIT.
+	CloseAbort()
+
+	// PauseRead stops reading from the connection until ResumeRead, the data is left in the socket so that the
+	// peer is held back by the flow control of TCP, e.g. while the data read can't be consumed as fast as it
+	// arrives. The connection is still closed on errors. It can be invoked from any goroutine.
+	PauseRead()
+
+	// ResumeRead resumes reading from the connection paused by PauseRead, it can be invoked from any goroutine.
+	ResumeRead()
 }
 
 // EventHandler represents the server events' callbacks for the Serve call.
@@ -120,14 +309,26 @@ type EventHandler interface {
 	// The server parameter has information and various utilities.
 	OnInitComplete(server Server) (action Action)
 
+	// OnAccept fires when a connection has been accepted, before any resources are allocated for it and
+	// OnOpened. The connection is rejected if the action is Close or Shutdown, in which case the reason is
+	// written to it before it's closed. It may be invoked by any event-loop accepting connections.
+	OnAccept(remote net.Addr) (action Action, reason []byte)
+
 	// OnOpened fires when a new connection has been opened.
 	// The info parameter has information about the connection such as
 	// it's local and remote address.
 	// Use the out return value to write data to the connection.
 	OnOpened(c Conn) (out []byte, action Action)
 
+	// OnReadClosed fires when the peer has closed its writing side by sending FIN, i.e. the connection is
+	// half-closed. Use the out return value to write data to the connection. The connection is closed with
+	// ErrConnectionClosed unless the action is None, in which case it's kept open for writing until CloseWrite.
+	OnReadClosed(c Conn) (out []byte, action Action)
+
 	// OnClosed fires when a connection has been closed.
-	// The err parameter is the last known connection error.
+	// The err parameter is the last known connection error, which is ErrConnectionClosed if the peer closed the
+	// connection, ErrServerShutdown if the server is shutting down, an *os.SyscallError wrapping the errno if
+	// reading or writing failed, or nil if the server closed the connection by the Close action.
 	OnClosed(c Conn, err error) (action Action)
 
 	// PreWrite fires just before any data is written to any client socket.
@@ -141,6 +342,19 @@ type EventHandler interface {
 	// Tick fires immediately after the server starts and will fire again
 	// following the duration specified by the delay return value.
 	Tick() (delay time.Duration, action Action)
+
+	// OnMemoryPressure fires when the memory usage of connection buffers exceeds the limit set by
+	// WithMemoryLimit and the server enters the shedding mode, it may be invoked by any event-loop.
+	OnMemoryPressure(usage, limit int64)
+
+	// OnHeartbeatTimeout fires when the connection has been idle for the heartbeat timeout set by WithHeartbeat,
+	// right before it's closed with ErrHeartbeatTimeout.
+	OnHeartbeatTimeout(c Conn)
+
+	// OnAcceptError fires when accepting fails by reason of running out of file descriptors or memory, after which
+	// accepting pauses for a while, or with ErrTooManyConnections when a connection is closed by the limit set by
+	// WithMaxConnsPerHost. It may be invoked by any event-loop accepting connections.
+	OnAcceptError(err error) (action Action)
 }
 
 // EventServer is a built-in implementation of EventHandler which sets up each method with a default implementation,
@@ -154,6 +368,13 @@ func (es *EventServer) OnInitComplete(svr Server) (action Action) {
 	return
 }
 
+// OnAccept fires when a connection has been accepted, before any resources are allocated for it and
+// OnOpened. The connection is rejected if the action is Close or Shutdown, in which case the reason is
+// written to it before it's closed. It may be invoked by any event-loop accepting connections.
+func (es *EventServer) OnAccept(remote net.Addr) (action Action, reason []byte) {
+	return
+}
+
 // OnOpened fires when a new connection has been opened.
 // The info parameter has information about the connection such as
 // it's local and remote address.
@@ -162,8 +383,18 @@ func (es *EventServer) OnOpened(c Conn) (out []byte, action Action) {
 	return
 }
 
+// OnReadClosed fires when the peer has closed its writing side by sending FIN, i.e. the connection is
+// half-closed. Use the out return value to write data to the connection. The connection is closed with
+// ErrConnectionClosed unless the action is None, in which case it's kept open for writing until CloseWrite.
+func (es *EventServer) OnReadClosed(c Conn) (out []byte, action Action) {
+	action = Close
+	return
+}
+
 // OnClosed fires when a connection has been closed.
-// The err parameter is the last known connection error.
+// The err parameter is the last known connection error, which is ErrConnectionClosed if the peer closed the
+// connection, ErrServerShutdown if the server is shutting down, an *os.SyscallError wrapping the errno if reading
+// or writing failed, or nil if the server closed the connection by the Close action.
 func (es *EventServer) OnClosed(c Conn, err error) (action Action) {
 	return
 }
@@ -185,18 +416,37 @@ func (es *EventServer) Tick() (delay time.Duration, action Action) {
 	return
 }
 
+// OnMemoryPressure fires when the memory usage of connection buffers exceeds the limit set by
+// WithMemoryLimit and the server enters the shedding mode, it may be invoked by any event-loop.
+func (es *EventServer) OnMemoryPressure(usage, limit int64) {
+}
+
+// OnHeartbeatTimeout fires when the connection has been idle for the heartbeat timeout set by WithHeartbeat,
+// right before it's closed with ErrHeartbeatTimeout.
+func (es *EventServer) OnHeartbeatTimeout(c Conn) {
+}
+
+// OnAcceptError fires when accepting fails by reason of running out of file descriptors or memory, after which
+// accepting pauses for a while, or with ErrTooManyConnections when a connection is closed by the limit set by
+// WithMaxConnsPerHost. It may be invoked by any event-loop accepting connections.
+func (es *EventServer) OnAcceptError(err error) (action Action) {
+	return
+}
+
 // Serve starts handling events for the specified addresses.
 //
 // Addresses should use a scheme prefix and be formatted
 // like `tcp://192.168.0.10:9851` or `unix://socket`.
 // Valid network schemes:
-//  tcp   - bind to both IPv4 and IPv6
-//  tcp4  - IPv4
-//  tcp6  - IPv6
-//  udp   - bind to both IPv4 and IPv6
-//  udp4  - IPv4
-//  udp6  - IPv6
-//  unix  - Unix Domain Socket
+//
+//	tcp   - bind to both IPv4 and IPv6
+//	tcp4  - IPv4
+//	tcp6  - IPv6
+//	udp   - bind to both IPv4 and IPv6
+//	udp4  - IPv4
+//	udp6  - IPv6
+//	unix  - Unix Domain Socket
+//	unixgram - Unix Domain Socket in datagram mode
 //
 // The "tcp" network scheme is assumed when one is not specified.
 func Serve(eventHandler EventHandler, addr string, opts ...Option) error {
@@ -204,39 +454,135 @@ func Serve(eventHandler EventHandler, addr string, opts ...Option) error {
 	defer ln.close()
 
 	options := initOptions(opts...)
+	if options.Poller == nil && !netpoll.EdgeTriggeredSupported {
+		// The default poller is level-triggered only, e.g. poll(2) with the poll tag.
+		options.EdgeTriggered = false
+	}
 
 	ln.network, ln.addr = parseAddr(addr)
-	if ln.network == "unix" {
+	switch ln.network {
+	case "tcp", "tcp4", "tcp6", "udp", "udp4", "udp6", "unix", "unixgram":
+	default:
+		return ErrUnsupportedProtocol
+	}
+	if ln.isUnix() {
 		sniffError(os.RemoveAll(ln.addr))
 	}
 	var err error
-	if ln.network == "udp" {
+	bindAddr := ln.addr
+	if ln.isUnix() && (options.UnixSocketMode != 0 || options.UnixSocketOwner != nil) {
+		// The socket file is bound in a private directory and renamed into place once its mode and owner
+		// have been applied, so that no peer can connect to it before.
+		dir, err := ioutil.TempDir(filepath.Dir(ln.addr), ".gnet")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+		bindAddr = filepath.Join(dir, "s")
+	}
+	switch {
+	case !ln.isUnix() && (options.BindToDevice != "" || options.FreeBind || options.Mark != 0 || options.TCPFastOpen > 0):
+		lc := netpoll.ListenConfig(options.ReusePort, func(network string, fd int) error {
+			return setupBeforeBind(network, fd, options)
+		})
+		if strings.HasPrefix(ln.network, "udp") {
+			ln.pconn, err = lc.ListenPacket(context.Background(), ln.network, bindAddr)
+		} else {
+			ln.ln, err = lc.Listen(context.Background(), ln.network, bindAddr)
+		}
+	case ln.network == "udp":
 		if options.ReusePort {
-			ln.pconn, err = netpoll.ReusePortListenPacket(ln.network, ln.addr)
+			ln.pconn, err = netpoll.ReusePortListenPacket(ln.network, bindAddr)
 		} else {
-			ln.pconn, err = net.ListenPacket(ln.network, ln.addr)
+			ln.pconn, err = net.ListenPacket(ln.network, bindAddr)
 		}
-	} else {
+	case ln.network == "unixgram":
+		ln.pconn, err = net.ListenPacket(ln.network, bindAddr)
+	default:
 		if options.ReusePort {
-			ln.ln, err = netpoll.ReusePortListen(ln.network, ln.addr)
+			ln.ln, err = netpoll.ReusePortListen(ln.network, bindAddr)
 		} else {
-			ln.ln, err = net.Listen(ln.network, ln.addr)
+			ln.ln, err = net.Listen(ln.network, bindAddr)
 		}
 	}
 	if err != nil {
 		return err
 	}
-	if ln.pconn != nil {
+	if ln.isUnix() {
+		if err = ln.setUnixSocket(bindAddr, options); err != nil {
+			return err
+		}
+	}
+	switch {
+	case ln.isUnix():
+		// The socket file may have been bound elsewhere.
+		ln.lnaddr = &net.UnixAddr{Name: ln.addr, Net: ln.network}
+	case ln.pconn != nil:
 		ln.lnaddr = ln.pconn.LocalAddr()
-	} else {
+	default:
 		ln.lnaddr = ln.ln.Addr()
 	}
 	if err := ln.system(); err != nil {
 		return err
 	}
+	if ln.network == "unixgram" {
+		sniffError(netpoll.SetPassCred(ln.fd))
+	}
+	if options.UDPGRO && ln.pconn != nil && strings.HasPrefix(ln.network, "udp") {
+		if err := netpoll.SetUDPGRO(ln.fd); err != nil {
+			return err
+		}
+	}
+	if options.PacketInfo && ln.pconn != nil && strings.HasPrefix(ln.network, "udp") {
+		sa, err := unix.Getsockname(ln.fd)
+		if err != nil {
+			return os.NewSyscallError("getsockname", err)
+		}
+		_, ipv6 := sa.(*unix.SockaddrInet6)
+		if err := netpoll.SetRecvPacketInfo(ln.fd, ipv6); err != nil {
+			return err
+		}
+	}
+	if options.DeferAccept > 0 && ln.ln != nil && strings.HasPrefix(ln.network, "tcp") {
+		// Round up so that a sub-second duration doesn't turn deferring accept off.
+		secs := int((options.DeferAccept + time.Second - 1) / time.Second)
+		if err := netpoll.SetDeferAccept(ln.fd, secs); err != nil {
+			return err
+		}
+	}
+	if options.SocketOptionHook != nil {
+		if err := options.SocketOptionHook(ln.fd, ln.network); err != nil {
+			return err
+		}
+	}
 	return serve(eventHandler, &ln, options)
 }
 
+// setupBeforeBind sets up the socket options of the listener which must be applied before binding.
+func setupBeforeBind(network string, fd int, options *Options) error {
+	if options.BindToDevice != "" {
+		if err := netpoll.SetBindToDevice(fd, options.BindToDevice); err != nil {
+			return err
+		}
+	}
+	if options.Mark != 0 {
+		if err := netpoll.SetMark(fd, options.Mark); err != nil {
+			return err
+		}
+	}
+	if options.FreeBind {
+		if err := netpoll.SetFreeBind(fd, strings.HasSuffix(network, "6")); err != nil {
+			return err
+		}
+	}
+	if options.TCPFastOpen > 0 && strings.HasPrefix(network, "tcp") {
+		if err := netpoll.SetFastOpen(fd, options.TCPFastOpen); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func parseAddr(addr string) (network, address string) {
 	network = "tcp"
 	address = addr
@@ -248,13 +594,47 @@ func parseAddr(addr string) (network, address string) {
 }
 
 type listener struct {
-	ln      net.Listener
-	lnaddr  net.Addr
-	pconn   net.PacketConn
-	f       *os.File
-	fd      int
-	network string
-	addr    string
+	ln         net.Listener
+	lnaddr     net.Addr
+	pconn      net.PacketConn
+	f          *os.File
+	fd         int
+	network    string
+	addr       string
+	keepSocket bool
+}
+
+// isUnix reports whether the listener is bound to a Unix domain socket file.
+func (ln *listener) isUnix() bool {
+	return ln.network == "unix" || ln.network == "unixgram"
+}
+
+// setUnixSocket applies the file mode and ownership to the Unix domain socket file bound at path, which is
+// renamed into place if it's bound elsewhere, and decides whether the file survives the shutdown of server.
+func (ln *listener) setUnixSocket(path string, options *Options) error {
+	if options.UnixSocketMode != 0 {
+		if err := os.Chmod(path, options.UnixSocketMode); err != nil {
+			return err
+		}
+	}
+	if owner := options.UnixSocketOwner; owner != nil {
+		if err := os.Chown(path, owner.UID, owner.GID); err != nil {
+			return err
+		}
+	}
+	if path != ln.addr {
+		if err := os.Rename(path, ln.addr); err != nil {
+			return err
+		}
+		sniffError(os.Remove(filepath.Dir(path)))
+	}
+	if options.KeepUnixSocket {
+		ln.keepSocket = true
+		if unixLn, ok := ln.ln.(*net.UnixListener); ok {
+			unixLn.SetUnlinkOnClose(false)
+		}
+	}
+	return nil
 }
 
 func sniffError(err error) {
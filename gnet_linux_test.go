@@ -0,0 +1,142 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestAcceptSpareFd(t *testing.T) {
+	svr := &testAcceptSpareFdServer{network: "tcp", addr: ":9991", closed: make(chan struct{}, 1)}
+	must(Serve(svr, "tcp://:9991", WithAcceptSpareFd(true), WithTicker(true)))
+	if svr.err != nil {
+		t.Fatal(svr.err)
+	}
+	if errs := atomic.LoadInt32(&svr.acceptErrors); errs == 0 {
+		t.Fatal("OnAcceptError was not fired")
+	}
+	if stats := svr.server.AcceptStats(); stats.Failed == 0 {
+		t.Fatalf("unexpected stats of accepting: %+v", stats)
+	}
+}
+
+type testAcceptSpareFdServer struct {
+	*EventServer
+	network      string
+	addr         string
+	server       Server
+	acceptErrors int32
+	err          error
+	done         int32
+	closed       chan struct{}
+}
+
+func (t *testAcceptSpareFdServer) OnInitComplete(srv Server) (action Action) {
+	t.server = srv
+	go func() {
+		defer atomic.StoreInt32(&t.done, 1)
+		t.err = t.exhaustFds()
+	}()
+	return
+}
+
+// exhaustFds leaves a single file descriptor for the client, so that accepting its connection fails with EMFILE.
+func (t *testAcceptSpareFdServer) exhaustFds() error {
+	if err := t.echo(); err != nil {
+		return err
+	}
+	<-t.closed // wait for the file descriptor of the connection to be released.
+	var rlimit, limited unix.Rlimit
+	if err := unix.Getrlimit(unix.RLIMIT_NOFILE, &rlimit); err != nil {
+		return err
+	}
+	entries, err := ioutil.ReadDir("/proc/self/fd")
+	if err != nil {
+		return err
+	}
+	limited = rlimit
+	limited.Cur = uint64(len(entries)) + 64
+	if err = unix.Setrlimit(unix.RLIMIT_NOFILE, &limited); err != nil {
+		return err
+	}
+	var fds []int
+	defer func() {
+		for _, fd := range fds {
+			_ = unix.Close(fd)
+		}
+		_ = unix.Setrlimit(unix.RLIMIT_NOFILE, &rlimit)
+	}()
+	for {
+		fd, err := unix.Open(os.DevNull, unix.O_RDONLY|unix.O_CLOEXEC, 0)
+		if err != nil {
+			break
+		}
+		fds = append(fds, fd)
+	}
+	_ = unix.Close(fds[len(fds)-1])
+	fds = fds[:len(fds)-1]
+
+	conn, err := net.Dial(t.network, t.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	// The connection is accepted with the spare file descriptor and closed right away.
+	if _, err = conn.Read(make([]byte, 1)); err == nil {
+		return fmt.Errorf("connection should be refused")
+	}
+	if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+		return fmt.Errorf("connection hangs in the backlog")
+	}
+	return nil
+}
+
+// echo verifies that the server is serving connections.
+func (t *testAcceptSpareFdServer) echo() error {
+	conn, err := net.Dial(t.network, t.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err = conn.Write([]byte("hello")); err != nil {
+		return err
+	}
+	_, err = io.ReadFull(conn, make([]byte, 5))
+	return err
+}
+
+func (t *testAcceptSpareFdServer) React(c Conn) (out []byte, action Action) {
+	out = c.Read()
+	c.ResetBuffer()
+	return
+}
+
+func (t *testAcceptSpareFdServer) OnClosed(c Conn, err error) (action Action) {
+	t.closed <- struct{}{}
+	return
+}
+
+func (t *testAcceptSpareFdServer) OnAcceptError(err error) (action Action) {
+	atomic.AddInt32(&t.acceptErrors, 1)
+	return
+}
+
+func (t *testAcceptSpareFdServer) Tick() (delay time.Duration, action Action) {
+	if atomic.LoadInt32(&t.done) == 1 {
+		action = Shutdown
+	}
+	delay = 10 * time.Millisecond
+	return
+}
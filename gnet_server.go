@@ -8,28 +8,57 @@
 package gnet
 
 import (
+	"context"
 	"log"
 	"runtime"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/panjf2000/gnet/internal"
 	"github.com/panjf2000/gnet/netpoll"
-	"github.com/panjf2000/gnet/ringbuffer"
+	"github.com/panjf2000/gnet/pool"
 )
 
 type server struct {
-	ln               *listener          // all the listeners
-	wg               sync.WaitGroup     // loop close WaitGroup
-	tch              chan time.Duration // ticker channel
-	opts             *Options           // options with server
-	once             sync.Once          // make sure only signalShutdown once
-	cond             *sync.Cond         // shutdown signaler
-	codec            ICodec             // codec for TCP stream
-	mainLoop         *loop              // main loop for accepting connections
-	bytesPool        sync.Pool          // pool for storing bytes
-	eventHandler     EventHandler       // user eventHandler
-	subLoopGroup     IEventLoopGroup    // loops for handling events
-	subLoopGroupSize int                // number of loops
+	memoryUsage      int64                                // bytes of buffers held by connections, accessed atomically
+	frameStats       FrameStats                           // outcomes of frames, accessed atomically
+	acceptStats      AcceptStats                          // counters of accepting, accessed atomically
+	shedding         int32                                // whether the memory limit is exceeded, accessed atomically
+	ln               *listener                            // all the listeners
+	wg               sync.WaitGroup                       // loop close WaitGroup
+	tch              chan time.Duration                   // ticker channel
+	opts             *Options                             // options with server
+	once             sync.Once                            // make sure only signalShutdown once
+	cond             *sync.Cond                           // shutdown signaler
+	codec            ICodec                               // codec for TCP stream
+	mainLoop         *loop                                // main loop for accepting connections
+	inboundPool      sync.Pool                            // pool for storing inbound ring-buffers
+	outboundPool     sync.Pool                            // pool for storing outbound ring-buffers
+	decodePool       *pool.WorkerPool                     // worker pool for decoding frames
+	reactPool        *pool.WorkerPool                     // worker pool for running tasks of AsyncReact
+	eventHandler     EventHandler                         // user eventHandler
+	subLoopGroup     IEventLoopGroup                      // loops for handling events
+	subLoopGroupSize int                                  // number of loops
+	timerSeq         uint32                               // sequence for distributing timers to loops, accessed atomically
+	timerMu          sync.Mutex                           // protects the fields of timers below
+	timersReady      bool                                 // whether the loops are ready for timers
+	pendingTimers    []pendingTimer                       // timers scheduled before the loops are ready
+	spareMu          sync.Mutex                           // protects spareFd
+	spareFd          int                                  // file descriptor reserved for rejecting connections
+	bindListener     func(p netpoll.Poller, fd int) error // registers the listener to a poller
+	tickers          map[string]*ticker                   // named tickers registered by Server.Schedule
+	externalMu       sync.RWMutex                         // protects the fields of external file-descriptors below
+	externals        map[int]*externalFD                  // file-descriptors registered by Server.RegisterFD
+	externalsReady   bool                                 // whether the loops are ready for external file-descriptors
+	externalSeq      uint32                               // sequence for distributing external file-descriptors to loops, accessed atomically
+	ctx              context.Context                      // context cancelled when the server shuts down
+	cancel           context.CancelFunc                   // cancel function of ctx
+	mirrorQueue      chan *MirrorRecord                   // records queued for the sink of Mirror
+	reloadMu         sync.Mutex                           // serializes Server.ApplyOptions
+	reloaded         Options                              // options with the ones applied by Server.ApplyOptions
+	tuned            atomic.Value                         // current *tunables
+	hostConns        hostConns                            // connections of every source host for MaxConnsPerHost
 }
 
 // waitForShutdown waits for a signal to shutdown
@@ -61,7 +90,9 @@ func (svr *server) startLoops() {
 
 func (svr *server) closeLoops() {
 	svr.subLoopGroup.iterate(func(i int, lp *loop) bool {
+		lp.closeTicker()
 		_ = lp.poller.Close()
+		svr.allocator().Put(lp.packet)
 		return true
 	})
 }
@@ -77,55 +108,100 @@ func (svr *server) startReactors() {
 	})
 }
 
-func (svr *server) activateLoops(numLoops int) error {
-	// Create loops locally and bind the listeners.
+// encode encodes the output of connection with the codec, EncodeConn is preferred if the codec implements it.
+func (svr *server) encode(c *conn, buf []byte) ([]byte, error) {
+	if enc, ok := svr.codec.(ConnEncoder); ok {
+		return enc.EncodeConn(c, buf)
+	}
+	return svr.codec.Encode(buf)
+}
+
+// openPoller opens a poller tuned by the options.
+func (svr *server) openPoller() (netpoll.Poller, error) {
+	open := svr.opts.Poller
+	if open == nil {
+		open = netpoll.OpenPoller
+	}
+	p, err := open()
+	if err != nil {
+		return nil, err
+	}
+	p.SetPollConfig(netpoll.PollConfig{
+		BatchSize: svr.opts.PollBatchSize,
+		Timeout:   svr.opts.PollTimeout,
+		Adaptive:  svr.opts.AdaptivePolling,
+	})
+	return p, nil
+}
+
+// openLoops creates the given number of event-loops and registers them into the sub loop group,
+// the listener is bound to every loop with bind unless it's nil.
+func (svr *server) openLoops(numLoops int, bind func(p netpoll.Poller, fd int) error) error {
 	for i := 0; i < numLoops; i++ {
-		if p, err := netpoll.OpenPoller(); err == nil {
-			lp := &loop{
-				idx:         i,
-				poller:      p,
-				packet:      make([]byte, 0xFFFF),
-				connections: make(map[int]*conn),
-				svr:         svr,
-			}
-			_ = lp.poller.AddRead(svr.ln.fd)
-			svr.subLoopGroup.register(lp)
-		} else {
+		p, err := svr.openPoller()
+		if err != nil {
 			return err
 		}
+		lp := &loop{
+			idx:    i,
+			poller: p,
+			packet: svr.allocator().Get(0xFFFF),
+			svr:    svr,
+			now:    time.Now(),
+			timers: internal.NewTimingWheel(timerResolution, time.Now()),
+		}
+		p.SetTimerHook(lp)
+		p.SetWakeupHook(lp.updateClock)
+		lp.startShrink()
+		lp.startLeakDetector()
+		svr.subLoopGroup.register(lp)
+		if bind != nil {
+			if err = bind(lp.poller, svr.ln.fd); err != nil {
+				return err
+			}
+		}
 	}
 	svr.subLoopGroupSize = svr.subLoopGroup.len()
+	return nil
+}
+
+func (svr *server) activateLoops(numLoops int) error {
+	// Create loops locally and bind the listeners.
+	svr.bindListener = netpoll.Poller.AddRead
+	if err := svr.openLoops(numLoops, svr.bindListener); err != nil {
+		return err
+	}
 	// Start loops in background
 	svr.startLoops()
 	return nil
 }
 
+// activateExclusiveLoops shares the single listener among loops with EPOLLEXCLUSIVE, so that every loop
+// accepts connections on its own without the thundering herd.
+func (svr *server) activateExclusiveLoops(numLoops int) error {
+	svr.bindListener = netpoll.Poller.AddReadExclusive
+	if err := svr.openLoops(numLoops, svr.bindListener); err != nil {
+		return err
+	}
+	svr.startLoops()
+	return nil
+}
+
 func (svr *server) activateReactors(numLoops int) error {
-	for i := 0; i < numLoops; i++ {
-		if p, err := netpoll.OpenPoller(); err == nil {
-			lp := &loop{
-				idx:         i,
-				poller:      p,
-				packet:      make([]byte, 0xFFFF),
-				connections: make(map[int]*conn),
-				svr:         svr,
-			}
-			svr.subLoopGroup.register(lp)
-		} else {
-			return err
-		}
+	svr.bindListener = netpoll.Poller.AddRead
+	if err := svr.openLoops(numLoops, nil); err != nil {
+		return err
 	}
-	svr.subLoopGroupSize = svr.subLoopGroup.len()
 	// Start sub reactors.
 	svr.startReactors()
 
-	if p, err := netpoll.OpenPoller(); err == nil {
+	if p, err := svr.openPoller(); err == nil {
 		lp := &loop{
 			idx:    -1,
 			poller: p,
 			svr:    svr,
 		}
-		_ = lp.poller.AddRead(svr.ln.fd)
+		_ = svr.bindListener(lp.poller, svr.ln.fd)
 		svr.mainLoop = lp
 		// Start main reactor.
 		svr.wg.Add(1)
@@ -143,24 +219,28 @@ func (svr *server) start(numCPU int) error {
 	if svr.opts.ReusePort || svr.ln.pconn != nil {
 		return svr.activateLoops(numCPU)
 	}
+	if svr.opts.ExclusiveAccept && netpoll.ExclusiveReadSupported {
+		return svr.activateExclusiveLoops(numCPU)
+	}
 	return svr.activateReactors(numCPU)
 }
 
 func (svr *server) stop() {
 	// Wait on a signal for shutdown
 	svr.waitForShutdown()
+	svr.cancel()
 
 	// Notify all loops to close by closing all listeners
 	svr.subLoopGroup.iterate(func(i int, lp *loop) bool {
 		sniffError(lp.poller.Trigger(func() error {
-			return errShutdown
+			return ErrServerShutdown
 		}))
 		return true
 	})
 
 	if svr.mainLoop != nil {
 		sniffError(svr.mainLoop.poller.Trigger(func() error {
-			return errShutdown
+			return ErrServerShutdown
 		}))
 	}
 
@@ -169,9 +249,10 @@ func (svr *server) stop() {
 
 	// Close loops and all outstanding connections
 	svr.subLoopGroup.iterate(func(i int, lp *loop) bool {
-		for _, c := range lp.connections {
-			sniffError(lp.loopCloseConn(c, nil))
-		}
+		lp.connections.iterate(func(c *conn) bool {
+			sniffError(lp.loopCloseConn(c, ErrServerShutdown))
+			return true
+		})
 		return true
 	})
 	svr.closeLoops()
@@ -179,6 +260,19 @@ func (svr *server) stop() {
 	if svr.mainLoop != nil {
 		sniffError(svr.mainLoop.poller.Close())
 	}
+
+	svr.releasePools()
+	svr.closeSpareFd()
+}
+
+// releasePools releases the worker pools of server.
+func (svr *server) releasePools() {
+	if svr.decodePool != nil {
+		svr.decodePool.Release()
+	}
+	if svr.reactPool != nil {
+		svr.reactPool.Release()
+	}
 }
 
 func serve(eventHandler EventHandler, listener *listener, options *Options) error {
@@ -192,13 +286,21 @@ func serve(eventHandler EventHandler, listener *listener, options *Options) erro
 
 	svr := new(server)
 	svr.eventHandler = eventHandler
+	if options.Tracer != nil {
+		svr.eventHandler = &tracedHandler{EventHandler: eventHandler, svr: svr}
+	}
 	svr.ln = listener
 	svr.subLoopGroup = new(eventLoopGroup)
 	svr.cond = sync.NewCond(&sync.Mutex{})
 	svr.tch = make(chan time.Duration)
 	svr.opts = options
-	svr.bytesPool.New = func() interface{} {
-		return ringbuffer.New(socketRingBufferSize)
+	svr.ctx, svr.cancel = context.WithCancel(context.Background())
+	svr.initTunables()
+	svr.inboundPool.New = func() interface{} {
+		return svr.newInboundBuffer()
+	}
+	svr.outboundPool.New = func() interface{} {
+		return svr.newOutboundBuffer()
 	}
 	svr.codec = func() ICodec {
 		if options.Codec == nil {
@@ -213,18 +315,39 @@ func serve(eventHandler EventHandler, listener *listener, options *Options) erro
 		NumLoops:     numCPU,
 		ReUsePort:    options.ReusePort,
 		TCPKeepAlive: options.TCPKeepAlive,
+		svr:          svr,
 	}
 	switch svr.eventHandler.OnInitComplete(server) {
 	case None:
 	case Shutdown:
+		svr.cancel()
 		return nil
 	}
 
+	svr.spareFd = -1
+	if options.AcceptSpareFd {
+		svr.openSpareFd()
+	}
+	// The codecs encoding depending on the connection keep states in it, which the workers can't access.
+	if _, stateful := svr.codec.(ConnEncoder); options.DecodeOffload && !stateful {
+		svr.decodePool = pool.NewWorkerPool()
+	}
+	if options.WorkerPoolSize > 0 {
+		svr.reactPool = pool.NewWorkerPoolWithSize(options.WorkerPoolSize)
+	}
+
 	if err := svr.start(numCPU); err != nil {
+		svr.cancel()
 		svr.closeLoops()
+		svr.releasePools()
+		svr.closeSpareFd()
 		log.Printf("gnet server is stoping with error: %v\n", err)
 		return err
 	}
+	svr.startTimers()
+	svr.startExternals()
+	svr.startMirror()
+	svr.startWatchdog()
 	defer svr.stop()
 
 	return nil
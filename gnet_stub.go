@@ -20,7 +20,7 @@ func (ln *listener) close() {
 	if ln.pconn != nil {
 		ln.pconn.Close()
 	}
-	if ln.network == "unix" {
+	if ln.isUnix() && !ln.keepSocket {
 		os.RemoveAll(ln.addr)
 	}
 }
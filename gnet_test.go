@@ -7,18 +7,28 @@ package gnet
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"encoding/binary"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"math/rand"
 	"net"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/panjf2000/gnet/accesslog"
+	"github.com/panjf2000/gnet/netpoll"
 	"github.com/panjf2000/gnet/pool"
+	"github.com/panjf2000/gnet/ringbuffer"
+	"golang.org/x/sys/unix"
 )
 
 func TestCodecServe(t *testing.T) {
@@ -433,20 +443,20 @@ func (s *testServer) Tick() (delay time.Duration, action Action) {
 	return
 }
 
-func testServe(network, addr string, reuseport, multicore, async bool, nclients int) {
+func testServe(network, addr string, reuseport, multicore, async bool, nclients int, opts ...Option) {
 	var err error
 	ts := &testServer{network: network, addr: addr, multicore: multicore, async: async, nclients: nclients,
 		bytesPool: pool.NewBytesPool(), workerPool: pool.NewWorkerPool()}
+	opts = append([]Option{WithMulticore(multicore), WithTicker(true), WithTCPKeepAlive(time.Minute * 5)}, opts...)
 	if network == "unix" {
 		_ = os.RemoveAll(addr)
 		defer os.RemoveAll(addr)
-		err = Serve(ts, network+"://"+addr, WithMulticore(multicore), WithTicker(true), WithTCPKeepAlive(time.Minute*5))
+		err = Serve(ts, network+"://"+addr, opts...)
 	} else {
 		if reuseport {
-			err = Serve(ts, network+"://"+addr, WithMulticore(multicore), WithReusePort(true), WithTicker(true), WithTCPKeepAlive(time.Minute*5))
-		} else {
-			err = Serve(ts, network+"://"+addr, WithMulticore(multicore), WithTicker(true), WithTCPKeepAlive(time.Minute*5))
+			opts = append(opts, WithReusePort(true))
 		}
+		err = Serve(ts, network+"://"+addr, opts...)
 	}
 	if err != nil {
 		panic(err)
@@ -680,3 +690,3951 @@ func TestBadAddresses(t *testing.T) {
 		t.Fatalf("expected nil, got '%v'", err)
 	}
 }
+
+func TestOpenedBeforeReact(t *testing.T) {
+	t.Run("reactor", func(t *testing.T) {
+		testOpenedBeforeReact("tcp", ":9991", false)
+	})
+	t.Run("reuseport", func(t *testing.T) {
+		testOpenedBeforeReact("tcp", ":9992", true)
+	})
+}
+
+type testOpenedBeforeReactServer struct {
+	*EventServer
+	network      string
+	addr         string
+	nclients     int
+	started      int32
+	disconnected int32
+}
+
+func (t *testOpenedBeforeReactServer) OnOpened(c Conn) (out []byte, action Action) {
+	c.SetContext(true)
+	out = []byte("opened\r\n")
+	return
+}
+
+func (t *testOpenedBeforeReactServer) OnClosed(c Conn, err error) (action Action) {
+	if atomic.AddInt32(&t.disconnected, 1) == int32(t.nclients) {
+		action = Shutdown
+	}
+	return
+}
+
+func (t *testOpenedBeforeReactServer) React(c Conn) (out []byte, action Action) {
+	if opened, _ := c.Context().(bool); !opened {
+		panic("React fired before OnOpened")
+	}
+	out = c.Read()
+	c.ResetBuffer()
+	return
+}
+
+func (t *testOpenedBeforeReactServer) Tick() (delay time.Duration, action Action) {
+	if atomic.CompareAndSwapInt32(&t.started, 0, 1) {
+		for i := 0; i < t.nclients; i++ {
+			go func() {
+				conn, err := net.Dial(t.network, t.addr)
+				must(err)
+				defer conn.Close()
+				// Write before reading anything so the data races with the accept.
+				_, err = conn.Write([]byte("early\r\n"))
+				must(err)
+				rd := bufio.NewReader(conn)
+				msg, err := rd.ReadBytes('\n')
+				must(err)
+				if string(msg) != "opened\r\n" {
+					panic("bad header: " + string(msg))
+				}
+				msg, err = rd.ReadBytes('\n')
+				must(err)
+				if string(msg) != "early\r\n" {
+					panic("bad echo: " + string(msg))
+				}
+			}()
+		}
+	}
+	delay = time.Second / 20
+	return
+}
+
+func testOpenedBeforeReact(network, addr string, reuseport bool) {
+	svr := &testOpenedBeforeReactServer{network: network, addr: addr, nclients: 10}
+	must(Serve(svr, network+"://"+addr, WithTicker(true), WithReusePort(reuseport)))
+}
+
+func TestServeUnixgram(t *testing.T) {
+	testServeUnixgram("socket9991", "socket9991-client")
+}
+
+type testUnixgramServer struct {
+	*EventServer
+	addr       string
+	clientAddr string
+	started    int32
+	done       int32
+}
+
+func (t *testUnixgramServer) React(c Conn) (out []byte, action Action) {
+	if c.RemoteAddr().String() != t.clientAddr {
+		panic("bad remote addr: " + c.RemoteAddr().String())
+	}
+	if cred := c.PeerCredentials(); runtime.GOOS == "linux" && (cred == nil || cred.PID != int32(os.Getpid())) {
+		panic(fmt.Sprintf("bad credentials of sender: %+v", cred))
+	}
+	out = c.Read()
+	c.ResetBuffer()
+	return
+}
+
+func (t *testUnixgramServer) Tick() (delay time.Duration, action Action) {
+	if atomic.CompareAndSwapInt32(&t.started, 0, 1) {
+		go func() {
+			defer atomic.StoreInt32(&t.done, 1)
+			_ = os.RemoveAll(t.clientAddr)
+			defer os.RemoveAll(t.clientAddr)
+			conn, err := net.DialUnix("unixgram",
+				&net.UnixAddr{Name: t.clientAddr, Net: "unixgram"}, &net.UnixAddr{Name: t.addr, Net: "unixgram"})
+			must(err)
+			defer conn.Close()
+			for i := 0; i < 10; i++ {
+				data := []byte(fmt.Sprintf("datagram-%d", i))
+				_, err = conn.Write(data)
+				must(err)
+				data2 := make([]byte, 64)
+				n, err := conn.Read(data2)
+				must(err)
+				if string(data) != string(data2[:n]) {
+					panic(fmt.Sprintf("mismatch unixgram: %s vs %s", data, data2[:n]))
+				}
+			}
+		}()
+	}
+	if atomic.LoadInt32(&t.done) == 1 {
+		action = Shutdown
+		return
+	}
+	delay = time.Second / 20
+	return
+}
+
+func testServeUnixgram(addr, clientAddr string) {
+	svr := &testUnixgramServer{addr: addr, clientAddr: clientAddr}
+	must(Serve(svr, "unixgram://"+addr, WithTicker(true)))
+	if _, err := os.Stat(addr); !os.IsNotExist(err) {
+		panic("socket file was not removed")
+	}
+}
+
+func TestPeerCredentials(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("SO_PEERCRED is only supported on linux")
+	}
+	testPeerCredentials("unix", "socket9991")
+}
+
+type testPeerCredServer struct {
+	*EventServer
+	network string
+	addr    string
+	started int32
+}
+
+func (t *testPeerCredServer) OnOpened(c Conn) (out []byte, action Action) {
+	cred := c.PeerCredentials()
+	if cred == nil {
+		panic("nil peer credentials")
+	}
+	if int(cred.PID) != os.Getpid() || int(cred.UID) != os.Getuid() || int(cred.GID) != os.Getgid() {
+		panic(fmt.Sprintf("bad peer credentials: %+v", *cred))
+	}
+	action = Shutdown
+	return
+}
+
+func (t *testPeerCredServer) Tick() (delay time.Duration, action Action) {
+	if atomic.CompareAndSwapInt32(&t.started, 0, 1) {
+		go func() {
+			conn, err := net.Dial(t.network, t.addr)
+			must(err)
+			_ = conn.Close()
+		}()
+	}
+	delay = time.Second / 20
+	return
+}
+
+func testPeerCredentials(network, addr string) {
+	svr := &testPeerCredServer{network: network, addr: addr}
+	must(Serve(svr, network+"://"+addr, WithTicker(true)))
+}
+
+func TestUnixSocketOptions(t *testing.T) {
+	addr := "socket9991"
+	svr := &testUnixSocketServer{addr: addr, mode: 0600}
+	must(Serve(svr, "unix://"+addr, WithUnixSocketMode(svr.mode),
+		WithUnixSocketOwner(os.Getuid(), os.Getgid()), WithKeepUnixSocket(true)))
+	defer os.RemoveAll(addr)
+	if _, err := os.Stat(addr); err != nil {
+		t.Fatalf("expected socket file to be kept, got '%v'", err)
+	}
+
+	umask := unix.Umask(0022)
+	defer unix.Umask(umask)
+	svr = &testUnixSocketServer{addr: addr, mode: 0755}
+	must(Serve(svr, "unix://"+addr, WithUnixSocketOwner(os.Getuid(), os.Getgid())))
+	if dirs, _ := filepath.Glob(".gnet*"); len(dirs) != 0 {
+		t.Fatalf("expected the private directories of binding to be removed, got %v", dirs)
+	}
+}
+
+type testUnixSocketServer struct {
+	*EventServer
+	addr string
+	mode os.FileMode
+}
+
+func (t *testUnixSocketServer) OnInitComplete(srv Server) (action Action) {
+	if srv.Addr.String() != t.addr {
+		panic(fmt.Sprintf("bad address of listener: %v", srv.Addr))
+	}
+	conn, err := net.Dial("unix", t.addr)
+	must(err)
+	_ = conn.Close()
+	fi, err := os.Stat(t.addr)
+	must(err)
+	if fi.Mode().Perm() != t.mode {
+		panic(fmt.Sprintf("bad socket file mode: %v", fi.Mode().Perm()))
+	}
+	return Shutdown
+}
+
+func TestDecodeOffload(t *testing.T) {
+	t.Run("1-loop-LineBasedFrameCodec", func(t *testing.T) {
+		testDecodeOffload("tcp", ":9991", false, new(LineBasedFrameCodec))
+	})
+	t.Run("N-loop-FixedLengthFrameCodec", func(t *testing.T) {
+		testDecodeOffload("tcp", ":9992", true, NewFixedLengthFrameCodec(12))
+	})
+}
+
+func testDecodeOffload(network, addr string, multicore bool, codec ICodec) {
+	ts := &testCodecServer{network: network, addr: addr, multicore: multicore, nclients: 10, codec: codec}
+	must(Serve(ts, network+"://"+addr, WithMulticore(multicore), WithTicker(true),
+		WithCodec(codec), WithDecodeOffload(true)))
+}
+
+func TestDecodeOffloadConnEncoder(t *testing.T) {
+	svr := &testDecodeOffloadSyslogServer{network: "tcp", addr: ":9960"}
+	must(Serve(svr, "tcp://:9960", WithTicker(true), WithCodec(new(SyslogCodec)), WithDecodeOffload(true)))
+}
+
+type testDecodeOffloadSyslogServer struct {
+	*EventServer
+	network string
+	addr    string
+	started int32
+	done    int32
+}
+
+func (t *testDecodeOffloadSyslogServer) React(c Conn) (out []byte, action Action) {
+	if frame := c.ReadFrame(); frame != nil {
+		out = []byte("ack")
+	}
+	return
+}
+
+func (t *testDecodeOffloadSyslogServer) Tick() (delay time.Duration, action Action) {
+	if atomic.CompareAndSwapInt32(&t.started, 0, 1) {
+		go func() {
+			defer atomic.StoreInt32(&t.done, 1)
+			conn, err := net.Dial(t.network, t.addr)
+			must(err)
+			defer conn.Close()
+			// The reply is framed the same way as the non-transparent message detected by the codec.
+			_, err = conn.Write([]byte("<34>1 - host app - - - hello\n"))
+			must(err)
+			line, err := bufio.NewReader(conn).ReadString('\n')
+			must(err)
+			if line != "ack\n" {
+				panic(fmt.Sprintf("unexpected reply: %q", line))
+			}
+		}()
+	}
+	if atomic.LoadInt32(&t.done) == 1 {
+		action = Shutdown
+	}
+	delay = time.Second / 20
+	return
+}
+
+func TestCloseAbort(t *testing.T) {
+	testCloseAbort("tcp", ":9991")
+}
+
+type testCloseAbortServer struct {
+	*EventServer
+	network string
+	addr    string
+	started int32
+	done    int32
+}
+
+func (t *testCloseAbortServer) React(c Conn) (out []byte, action Action) {
+	c.CloseAbort()
+	return
+}
+
+func (t *testCloseAbortServer) Tick() (delay time.Duration, action Action) {
+	if atomic.CompareAndSwapInt32(&t.started, 0, 1) {
+		go func() {
+			defer atomic.StoreInt32(&t.done, 1)
+			conn, err := net.Dial(t.network, t.addr)
+			must(err)
+			defer conn.Close()
+			_, err = conn.Write([]byte("abort me"))
+			must(err)
+			_, err = conn.Read(make([]byte, 1))
+			if err == nil || err == io.EOF {
+				panic(fmt.Sprintf("expected connection reset, got '%v'", err))
+			}
+		}()
+	}
+	if atomic.LoadInt32(&t.done) == 1 {
+		action = Shutdown
+	}
+	delay = time.Second / 20
+	return
+}
+
+func testCloseAbort(network, addr string) {
+	svr := &testCloseAbortServer{network: network, addr: addr}
+	must(Serve(svr, network+"://"+addr, WithTicker(true), WithLinger(5)))
+}
+
+func TestMemoryLimit(t *testing.T) {
+	testMemoryLimit("tcp", ":9991")
+}
+
+type testMemoryLimitServer struct {
+	*EventServer
+	network  string
+	addr     string
+	started  int32
+	pressure int32
+	done     int32
+}
+
+func (t *testMemoryLimitServer) OnMemoryPressure(usage, limit int64) {
+	if usage <= limit {
+		panic(fmt.Sprintf("unexpected memory pressure, usage: %d, limit: %d", usage, limit))
+	}
+	atomic.StoreInt32(&t.pressure, 1)
+}
+
+func (t *testMemoryLimitServer) React(c Conn) (out []byte, action Action) {
+	out = c.Read()
+	c.ResetBuffer()
+	return
+}
+
+func (t *testMemoryLimitServer) Tick() (delay time.Duration, action Action) {
+	if atomic.CompareAndSwapInt32(&t.started, 0, 1) {
+		go func() {
+			defer atomic.StoreInt32(&t.done, 1)
+			conn, err := net.Dial(t.network, t.addr)
+			must(err)
+			defer conn.Close()
+			_, err = conn.Write([]byte("ping"))
+			must(err)
+			_, err = io.ReadFull(conn, make([]byte, 4))
+			must(err)
+			// The echo is written before the buffers are accounted, so the pressure may be reported right after it.
+			for deadline := time.Now().Add(time.Second); atomic.LoadInt32(&t.pressure) != 1; {
+				if time.Now().After(deadline) {
+					panic("OnMemoryPressure was not fired")
+				}
+				time.Sleep(time.Millisecond)
+			}
+			// New connections are refused in the shedding mode.
+			conn2, err := net.Dial(t.network, t.addr)
+			must(err)
+			defer conn2.Close()
+			if _, err = conn2.Read(make([]byte, 1)); err == nil {
+				panic("expected the connection to be refused")
+			}
+		}()
+	}
+	if atomic.LoadInt32(&t.done) == 1 {
+		action = Shutdown
+	}
+	delay = time.Second / 20
+	return
+}
+
+func testMemoryLimit(network, addr string) {
+	svr := &testMemoryLimitServer{network: network, addr: addr}
+	must(Serve(svr, network+"://"+addr, WithTicker(true), WithMemoryLimit(1)))
+}
+
+func TestSocketOptionHook(t *testing.T) {
+	testSocketOptionHook("tcp", ":9991")
+}
+
+type testSocketOptionHookServer struct {
+	*EventServer
+	network string
+	addr    string
+	calls   int32
+}
+
+func (t *testSocketOptionHookServer) OnInitComplete(srv Server) (action Action) {
+	if atomic.LoadInt32(&t.calls) != 1 {
+		panic("socket option hook was not invoked for the listener")
+	}
+	go func() {
+		conn, err := net.Dial(t.network, t.addr)
+		must(err)
+		defer conn.Close()
+		_, _ = conn.Read(make([]byte, 1))
+	}()
+	return
+}
+
+func (t *testSocketOptionHookServer) OnOpened(c Conn) (out []byte, action Action) {
+	if atomic.LoadInt32(&t.calls) != 2 {
+		panic("socket option hook was not invoked for the accepted socket")
+	}
+	action = Shutdown
+	return
+}
+
+func testSocketOptionHook(network, addr string) {
+	svr := &testSocketOptionHookServer{network: network, addr: addr}
+	must(Serve(svr, network+"://"+addr, WithSocketOptionHook(func(fd int, network string) error {
+		if network != svr.network {
+			panic("bad network: " + network)
+		}
+		atomic.AddInt32(&svr.calls, 1)
+		return nil
+	})))
+}
+
+func TestFrameContext(t *testing.T) {
+	testFrameContext("tcp", ":9991")
+}
+
+func TestDatagramFrameContext(t *testing.T) {
+	svr := &testDatagramFrameContextServer{network: "udp", addr: "127.0.0.1:9956"}
+	must(Serve(svr, "udp://127.0.0.1:9956", WithTicker(true), WithFrameDeadline(time.Second)))
+	if stats := svr.server.FrameStats(); stats.Completed != 1 {
+		t.Fatalf("unexpected stats of frames: %+v", stats)
+	}
+}
+
+type testDatagramFrameContextServer struct {
+	*EventServer
+	network string
+	addr    string
+	server  Server
+	done    int32
+}
+
+func (t *testDatagramFrameContextServer) OnInitComplete(srv Server) (action Action) {
+	t.server = srv
+	go func() {
+		defer atomic.StoreInt32(&t.done, 1)
+		conn, err := net.Dial(t.network, t.addr)
+		must(err)
+		defer conn.Close()
+		_, err = conn.Write([]byte("ping"))
+		must(err)
+		_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+		_, err = conn.Read(make([]byte, 4))
+		must(err)
+	}()
+	return
+}
+
+func (t *testDatagramFrameContextServer) React(c Conn) (out []byte, action Action) {
+	ctx, cancel := c.FrameContext()
+	if _, ok := ctx.Deadline(); !ok || ctx.Err() != nil {
+		panic("unexpected context of datagram")
+	}
+	cancel()
+	return []byte("pong"), None
+}
+
+func (t *testDatagramFrameContextServer) Tick() (delay time.Duration, action Action) {
+	if atomic.LoadInt32(&t.done) == 1 {
+		action = Shutdown
+	}
+	delay = 10 * time.Millisecond
+	return
+}
+
+type testFrameContextServer struct {
+	*EventServer
+	network string
+	addr    string
+	server  Server
+	started int32
+	frames  sync.WaitGroup
+	done    int32
+}
+
+func (t *testFrameContextServer) OnInitComplete(srv Server) (action Action) {
+	t.server = srv
+	return
+}
+
+func (t *testFrameContextServer) React(c Conn) (out []byte, action Action) {
+	frame := string(c.Read())
+	c.ResetBuffer()
+	ctx, cancel := c.FrameContext()
+	go func() {
+		defer t.frames.Done()
+		defer cancel()
+		switch frame {
+		case "fast":
+			c.AsyncWrite([]byte("done"))
+		case "slow", "hang":
+			<-ctx.Done()
+		}
+	}()
+	return
+}
+
+func (t *testFrameContextServer) Tick() (delay time.Duration, action Action) {
+	if atomic.CompareAndSwapInt32(&t.started, 0, 1) {
+		t.frames.Add(3)
+		go func() {
+			defer atomic.StoreInt32(&t.done, 1)
+			conn, err := net.Dial(t.network, t.addr)
+			must(err)
+			_, err = conn.Write([]byte("fast"))
+			must(err)
+			_, err = io.ReadFull(conn, make([]byte, 4))
+			must(err)
+			_, err = conn.Write([]byte("slow"))
+			must(err)
+			time.Sleep(time.Millisecond * 200)
+			_, err = conn.Write([]byte("hang"))
+			must(err)
+			time.Sleep(time.Millisecond * 20)
+			must(conn.Close())
+			t.frames.Wait()
+			stats := t.server.FrameStats()
+			if stats.Completed != 1 || stats.TimedOut != 1 || stats.Cancelled != 1 {
+				panic(fmt.Sprintf("bad frame stats: %+v", stats))
+			}
+		}()
+	}
+	if atomic.LoadInt32(&t.done) == 1 {
+		action = Shutdown
+	}
+	delay = time.Second / 20
+	return
+}
+
+func testFrameContext(network, addr string) {
+	svr := &testFrameContextServer{network: network, addr: addr}
+	must(Serve(svr, network+"://"+addr, WithTicker(true), WithFrameDeadline(time.Millisecond*100)))
+}
+
+func TestAccessLog(t *testing.T) {
+	testAccessLog(t, "tcp", ":9991")
+}
+
+type testAccessLogServer struct {
+	*EventServer
+	network string
+	addr    string
+}
+
+func (t *testAccessLogServer) OnInitComplete(srv Server) (action Action) {
+	go func() {
+		conn, err := net.Dial(t.network, t.addr)
+		must(err)
+		_ = conn.Close()
+	}()
+	return
+}
+
+func (t *testAccessLogServer) OnClosed(c Conn, err error) (action Action) {
+	action = Shutdown
+	return
+}
+
+func testAccessLog(t *testing.T, network, addr string) {
+	var buf bytes.Buffer
+	logger := accesslog.New(&buf, new(accesslog.JSONFormatter))
+	svr := &testAccessLogServer{network: network, addr: addr}
+	must(Serve(svr, network+"://"+addr, WithAccessLog(logger)))
+	must(logger.Close())
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 records, got %d: %q", len(lines), buf.String())
+	}
+	if !strings.Contains(lines[0], `"event":"open"`) || !strings.Contains(lines[1], `"event":"close"`) {
+		t.Fatalf("unexpected records: %q", buf.String())
+	}
+}
+
+func TestListenerSocketOptions(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the socket options of listener are only supported on linux")
+	}
+	testListenerSocketOptions(t, "tcp://127.0.0.1:9991", WithBindToDevice("lo"))
+	testListenerSocketOptions(t, "udp://127.0.0.1:9991", WithBindToDevice("lo"), WithReusePort(true))
+	// 192.0.2.0/24 is reserved for documentation, so the address is never assigned locally.
+	testListenerSocketOptions(t, "tcp://192.0.2.1:9991", WithFreeBind(true))
+	testListenerSocketOptions(t, "tcp://127.0.0.1:9991", WithTCPFastOpen(16))
+	testListenerSocketOptions(t, "tcp://127.0.0.1:9991", WithDeferAccept(time.Second))
+	if os.Geteuid() == 0 {
+		// SO_MARK requires CAP_NET_ADMIN.
+		testListenerSocketOptions(t, "tcp://127.0.0.1:9991", WithMark(0x1))
+	}
+}
+
+type testListenerSocketOptionsServer struct {
+	*EventServer
+	addr net.Addr
+}
+
+func (t *testListenerSocketOptionsServer) OnInitComplete(srv Server) (action Action) {
+	t.addr = srv.Addr
+	action = Shutdown
+	return
+}
+
+func testListenerSocketOptions(t *testing.T, addr string, opts ...Option) {
+	svr := new(testListenerSocketOptionsServer)
+	if err := Serve(svr, addr, opts...); err != nil {
+		t.Fatalf("failed to serve %s: %v", addr, err)
+	}
+	if network, address := parseAddr(addr); svr.addr.String() != address {
+		t.Fatalf("bad %s address: %s", network, svr.addr)
+	}
+}
+
+func TestExclusiveAccept(t *testing.T) {
+	testExclusiveAccept("tcp", ":9991", 16)
+}
+
+type testExclusiveAcceptServer struct {
+	*EventServer
+	network  string
+	addr     string
+	nclients int
+	done     int32
+	server   Server
+}
+
+func (t *testExclusiveAcceptServer) OnInitComplete(srv Server) (action Action) {
+	t.server = srv
+	go func() {
+		var wg sync.WaitGroup
+		for i := 0; i < t.nclients; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				conn, err := net.Dial(t.network, t.addr)
+				must(err)
+				defer conn.Close()
+				_, err = conn.Write([]byte("ping\n"))
+				must(err)
+				msg, err := bufio.NewReader(conn).ReadString('\n')
+				must(err)
+				if msg != "ping\n" {
+					panic("bad echo: " + msg)
+				}
+			}()
+		}
+		wg.Wait()
+		atomic.StoreInt32(&t.done, 1)
+	}()
+	return
+}
+
+func (t *testExclusiveAcceptServer) React(c Conn) (out []byte, action Action) {
+	out = append([]byte(nil), c.Read()...)
+	c.ResetBuffer()
+	return
+}
+
+func (t *testExclusiveAcceptServer) Tick() (delay time.Duration, action Action) {
+	if atomic.LoadInt32(&t.done) == 1 {
+		action = Shutdown
+	}
+	delay = 10 * time.Millisecond
+	return
+}
+
+func testExclusiveAccept(network, addr string, nclients int) {
+	svr := &testExclusiveAcceptServer{network: network, addr: addr, nclients: nclients}
+	must(Serve(svr, network+"://"+addr, WithMulticore(true), WithExclusiveAccept(true), WithTicker(true)))
+}
+
+func TestAcceptTimeSlice(t *testing.T) {
+	svr := &testExclusiveAcceptServer{network: "tcp", addr: ":9991", nclients: 16}
+	must(Serve(svr, "tcp://:9991", WithReusePort(true), WithAcceptTimeSlice(time.Nanosecond), WithTicker(true)))
+	stats := svr.server.AcceptStats()
+	if stats.Accepted != int64(svr.nclients) {
+		t.Fatalf("expected %d connections accepted, got %d", svr.nclients, stats.Accepted)
+	}
+	if stats.Deferred == 0 {
+		t.Fatal("expected accepting to be deferred by the time slice")
+	}
+}
+
+func TestEdgeTriggered(t *testing.T) {
+	t.Run("reactor", func(t *testing.T) {
+		testEdgeTriggered("tcp", ":9991", 8, WithEdgeTriggered(true))
+	})
+	t.Run("reuseport", func(t *testing.T) {
+		testEdgeTriggered("tcp", ":9992", 8, WithEdgeTriggered(true), WithReusePort(true))
+	})
+}
+
+func TestPollConfig(t *testing.T) {
+	testEdgeTriggered("tcp", ":9991", 8, WithPollBatchSize(1), WithPollTimeout(time.Millisecond),
+		WithAdaptivePolling(true))
+}
+
+type testEdgeTriggeredServer struct {
+	*EventServer
+	network  string
+	addr     string
+	nclients int
+	done     int32
+}
+
+func (t *testEdgeTriggeredServer) OnInitComplete(srv Server) (action Action) {
+	go func() {
+		var wg sync.WaitGroup
+		for i := 0; i < t.nclients; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				conn, err := net.Dial(t.network, t.addr)
+				must(err)
+				defer conn.Close()
+				// Large enough to fill the socket buffers and exercise the partial reads/writes.
+				data := make([]byte, 4<<20)
+				rand.Read(data)
+				go func() {
+					_, err := conn.Write(data)
+					must(err)
+				}()
+				echo := make([]byte, len(data))
+				_, err = io.ReadFull(conn, echo)
+				must(err)
+				if string(echo) != string(data) {
+					panic("mismatched echo")
+				}
+			}()
+		}
+		wg.Wait()
+		atomic.StoreInt32(&t.done, 1)
+	}()
+	return
+}
+
+func (t *testEdgeTriggeredServer) React(c Conn) (out []byte, action Action) {
+	out = append([]byte(nil), c.Read()...)
+	c.ResetBuffer()
+	return
+}
+
+func (t *testEdgeTriggeredServer) Tick() (delay time.Duration, action Action) {
+	if atomic.LoadInt32(&t.done) == 1 {
+		action = Shutdown
+	}
+	delay = 10 * time.Millisecond
+	return
+}
+
+func testEdgeTriggered(network, addr string, nclients int, opts ...Option) {
+	svr := &testEdgeTriggeredServer{network: network, addr: addr, nclients: nclients}
+	must(Serve(svr, network+"://"+addr, append(opts, WithMulticore(true), WithTicker(true))...))
+}
+
+func TestAsyncReact(t *testing.T) {
+	testAsyncReact("tcp", ":9991", 8)
+}
+
+type testAsyncReactServer struct {
+	*EventServer
+	network  string
+	addr     string
+	nclients int
+	done     int32
+}
+
+func (t *testAsyncReactServer) OnInitComplete(srv Server) (action Action) {
+	go func() {
+		var wg sync.WaitGroup
+		for i := 0; i < t.nclients; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				conn, err := net.Dial(t.network, t.addr)
+				must(err)
+				defer conn.Close()
+				rd := bufio.NewReader(conn)
+				var expected string
+				for j := 0; j < 10; j++ {
+					msg := fmt.Sprintf("%d\n", j)
+					expected += msg
+					_, err = conn.Write([]byte(msg))
+					must(err)
+				}
+				var got string
+				for len(got) < len(expected) {
+					line, err := rd.ReadString('\n')
+					must(err)
+					got += line
+				}
+				if got != expected {
+					panic("outputs out of order: " + got)
+				}
+			}()
+		}
+		wg.Wait()
+		atomic.StoreInt32(&t.done, 1)
+	}()
+	return
+}
+
+func (t *testAsyncReactServer) React(c Conn) (out []byte, action Action) {
+	data := append([]byte(nil), c.Read()...)
+	c.ResetBuffer()
+	c.AsyncReact(func() ([]byte, Action) {
+		// Take longer for the earlier data, which would reorder the outputs without queueing.
+		time.Sleep(time.Duration(rand.Intn(5)) * time.Millisecond)
+		return data, None
+	})
+	return
+}
+
+func (t *testAsyncReactServer) Tick() (delay time.Duration, action Action) {
+	if atomic.LoadInt32(&t.done) == 1 {
+		action = Shutdown
+	}
+	delay = 10 * time.Millisecond
+	return
+}
+
+func testAsyncReact(network, addr string, nclients int) {
+	svr := &testAsyncReactServer{network: network, addr: addr, nclients: nclients}
+	must(Serve(svr, network+"://"+addr, WithMulticore(true), WithWorkerPool(4), WithTicker(true)))
+}
+
+func TestExecute(t *testing.T) {
+	testExecute("tcp", ":9991", 100)
+}
+
+type testExecuteServer struct {
+	*EventServer
+	network string
+	addr    string
+	ntasks  int
+	closed  error
+}
+
+func (t *testExecuteServer) OnInitComplete(srv Server) (action Action) {
+	go func() {
+		conn, err := net.Dial(t.network, t.addr)
+		must(err)
+		defer conn.Close()
+		_, _ = conn.Read(make([]byte, 1))
+	}()
+	return
+}
+
+func (t *testExecuteServer) OnOpened(c Conn) (out []byte, action Action) {
+	c.SetContext(0)
+	// Mutate the state of connection from other goroutines without locks.
+	var wg sync.WaitGroup
+	for i := 0; i < t.ntasks; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.Execute(func(c Conn) error {
+				c.SetContext(c.Context().(int) + 1)
+				return nil
+			})
+		}()
+	}
+	go func() {
+		wg.Wait()
+		c.Execute(func(c Conn) error {
+			return fmt.Errorf("executed %d tasks", c.Context().(int))
+		})
+	}()
+	return
+}
+
+func (t *testExecuteServer) OnClosed(c Conn, err error) (action Action) {
+	t.closed = err
+	action = Shutdown
+	return
+}
+
+func testExecute(network, addr string, ntasks int) {
+	svr := &testExecuteServer{network: network, addr: addr, ntasks: ntasks}
+	must(Serve(svr, network+"://"+addr))
+	if svr.closed == nil || svr.closed.Error() != fmt.Sprintf("executed %d tasks", ntasks) {
+		panic(fmt.Sprintf("unexpected error of closing: %v", svr.closed))
+	}
+}
+
+func TestWakeWith(t *testing.T) {
+	testWakeWith("tcp", ":9991")
+}
+
+type testWakeWithServer struct {
+	*EventServer
+	network string
+	addr    string
+	wakes   []interface{}
+}
+
+func (t *testWakeWithServer) OnInitComplete(srv Server) (action Action) {
+	go func() {
+		conn, err := net.Dial(t.network, t.addr)
+		must(err)
+		defer conn.Close()
+		_, _ = conn.Read(make([]byte, 1))
+	}()
+	return
+}
+
+func (t *testWakeWithServer) OnOpened(c Conn) (out []byte, action Action) {
+	go func() {
+		c.WakeWith("payload")
+		c.Wake()
+	}()
+	return
+}
+
+func (t *testWakeWithServer) React(c Conn) (out []byte, action Action) {
+	t.wakes = append(t.wakes, c.WakeContext())
+	if len(t.wakes) == 2 {
+		action = Shutdown
+	}
+	return
+}
+
+func testWakeWith(network, addr string) {
+	svr := &testWakeWithServer{network: network, addr: addr}
+	must(Serve(svr, network+"://"+addr))
+	if svr.wakes[0] != "payload" || svr.wakes[1] != nil {
+		panic(fmt.Sprintf("unexpected payloads of wakes: %v", svr.wakes))
+	}
+}
+
+func TestTimers(t *testing.T) {
+	testTimers("tcp", ":9991")
+	// Datagrams aren't bound to loops.
+	if _, err := new(conn).SetTimer(time.Millisecond, nil); err != ErrNotStream {
+		t.Fatalf("expected ErrNotStream, got %v", err)
+	}
+}
+
+type testTimersServer struct {
+	*EventServer
+	network string
+	addr    string
+	fired   int32
+	stopped int32
+	closed  error
+}
+
+func (t *testTimersServer) OnInitComplete(srv Server) (action Action) {
+	// Timers scheduled before the loops are started are kept until then.
+	srv.AfterFunc(10*time.Millisecond, func() {
+		atomic.AddInt32(&t.fired, 1)
+	})
+	srv.AfterFunc(10*time.Millisecond, func() {
+		atomic.AddInt32(&t.stopped, 1)
+	}).Stop()
+	go func() {
+		conn, err := net.Dial(t.network, t.addr)
+		must(err)
+		defer conn.Close()
+		_, _ = conn.Read(make([]byte, 1))
+	}()
+	return
+}
+
+func (t *testTimersServer) OnOpened(c Conn) (out []byte, action Action) {
+	timer, err := c.SetTimer(10*time.Millisecond, func(c Conn) error {
+		atomic.AddInt32(&t.stopped, 1)
+		return fmt.Errorf("stopped timer fired")
+	})
+	must(err)
+	timer.Stop()
+	_, err = c.SetTimer(50*time.Millisecond, func(c Conn) error {
+		return fmt.Errorf("timed out")
+	})
+	must(err)
+	return
+}
+
+func (t *testTimersServer) OnClosed(c Conn, err error) (action Action) {
+	t.closed = err
+	action = Shutdown
+	return
+}
+
+func testTimers(network, addr string) {
+	svr := &testTimersServer{network: network, addr: addr}
+	must(Serve(svr, network+"://"+addr))
+	if svr.closed == nil || svr.closed.Error() != "timed out" {
+		panic(fmt.Sprintf("unexpected error of closing: %v", svr.closed))
+	}
+	if fired := atomic.LoadInt32(&svr.fired); fired != 1 {
+		panic(fmt.Sprintf("timer of server fired %d times", fired))
+	}
+	if stopped := atomic.LoadInt32(&svr.stopped); stopped != 0 {
+		panic(fmt.Sprintf("stopped timers fired %d times", stopped))
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	testSchedule("tcp", ":9991")
+}
+
+type testScheduleServer struct {
+	*EventServer
+	fast    int32
+	removed int32
+	err     error
+}
+
+func (t *testScheduleServer) OnInitComplete(srv Server) (action Action) {
+	must(srv.Schedule("fast", 5*time.Millisecond, func() Action {
+		atomic.AddInt32(&t.fast, 1)
+		return None
+	}))
+	t.err = srv.Schedule("fast", time.Millisecond, func() Action { return None })
+	must(srv.Schedule("remove", 50*time.Millisecond, func() Action {
+		if !srv.Unschedule("fast") || !srv.Unschedule("remove") {
+			panic("tickers should be removed")
+		}
+		atomic.StoreInt32(&t.removed, atomic.LoadInt32(&t.fast))
+		must(srv.Schedule("shutdown", 50*time.Millisecond, func() Action {
+			return Shutdown
+		}))
+		return None
+	}))
+	return
+}
+
+func testSchedule(network, addr string) {
+	svr := new(testScheduleServer)
+	must(Serve(svr, network+"://"+addr, WithMulticore(true)))
+	if svr.err != ErrTickerExists {
+		panic(fmt.Sprintf("unexpected error of duplicate ticker: %v", svr.err))
+	}
+	fast, removed := atomic.LoadInt32(&svr.fast), atomic.LoadInt32(&svr.removed)
+	if removed == 0 || fast != removed {
+		panic(fmt.Sprintf("ticker fired %d times, %d times before being removed", fast, removed))
+	}
+}
+
+func TestLoopTime(t *testing.T) {
+	testLoopTime("tcp", ":9991")
+}
+
+type testLoopTimeServer struct {
+	*EventServer
+	network string
+	addr    string
+	lag     time.Duration
+}
+
+func (t *testLoopTimeServer) OnInitComplete(srv Server) (action Action) {
+	go func() {
+		conn, err := net.Dial(t.network, t.addr)
+		must(err)
+		defer conn.Close()
+		time.Sleep(50 * time.Millisecond)
+		_, err = conn.Write([]byte("ping"))
+		must(err)
+		_, _ = conn.Read(make([]byte, 1))
+	}()
+	return
+}
+
+func (t *testLoopTimeServer) React(c Conn) (out []byte, action Action) {
+	t.lag = time.Since(c.LoopTime())
+	action = Shutdown
+	return
+}
+
+func testLoopTime(network, addr string) {
+	svr := &testLoopTimeServer{network: network, addr: addr}
+	must(Serve(svr, network+"://"+addr))
+	// The clock is updated when the loop wakes up for the data, not when the connection was opened.
+	if svr.lag < 0 || svr.lag > 40*time.Millisecond {
+		panic(fmt.Sprintf("loop time lags behind by %v", svr.lag))
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	testHeartbeat("tcp", ":9991")
+}
+
+type testHeartbeatServer struct {
+	*EventServer
+	network  string
+	addr     string
+	timeouts int32
+	pings    int32
+	lifetime chan time.Duration
+	closed   error
+}
+
+func (t *testHeartbeatServer) OnInitComplete(srv Server) (action Action) {
+	go func() {
+		conn, err := net.Dial(t.network, t.addr)
+		must(err)
+		defer conn.Close()
+		start := time.Now()
+		buf := make([]byte, 5)
+		for {
+			if _, err = io.ReadFull(conn, buf); err != nil {
+				break
+			}
+			atomic.AddInt32(&t.pings, 1)
+			// Answer the pings for a while, then keep silent until the connection is closed.
+			if time.Since(start) < 200*time.Millisecond {
+				_, _ = conn.Write([]byte("pong\n"))
+			}
+		}
+		t.lifetime <- time.Since(start)
+	}()
+	return
+}
+
+func (t *testHeartbeatServer) React(c Conn) (out []byte, action Action) {
+	c.ResetBuffer()
+	return
+}
+
+func (t *testHeartbeatServer) OnHeartbeatTimeout(c Conn) {
+	atomic.AddInt32(&t.timeouts, 1)
+}
+
+func (t *testHeartbeatServer) OnClosed(c Conn, err error) (action Action) {
+	t.closed = err
+	action = Shutdown
+	return
+}
+
+func testHeartbeat(network, addr string) {
+	svr := &testHeartbeatServer{network: network, addr: addr, lifetime: make(chan time.Duration, 1)}
+	ping := func(c Conn) []byte { return []byte("ping\n") }
+	must(Serve(svr, network+"://"+addr, WithHeartbeat(20*time.Millisecond, 100*time.Millisecond, ping)))
+	lifetime := <-svr.lifetime
+	if svr.closed != ErrHeartbeatTimeout || atomic.LoadInt32(&svr.timeouts) != 1 {
+		panic(fmt.Sprintf("unexpected error of closing: %v", svr.closed))
+	}
+	if pings := atomic.LoadInt32(&svr.pings); pings < 5 {
+		panic(fmt.Sprintf("only %d pings received", pings))
+	}
+	if lifetime < 250*time.Millisecond {
+		panic(fmt.Sprintf("connection with pongs was closed after %v", lifetime))
+	}
+}
+
+func TestConnectionFilter(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("binding to 127.0.0.2 requires the loopback of Linux")
+	}
+	filter, err := NewCIDRFilter([]string{"127.0.0.0/8"}, []string{"127.0.0.2/32"})
+	must(err)
+	t.Run("reactor", func(t *testing.T) {
+		testConnectionFilter(t, "tcp", "127.0.0.1:9991", WithConnectionFilter(filter))
+	})
+	t.Run("reuseport", func(t *testing.T) {
+		testConnectionFilter(t, "tcp", "127.0.0.1:9992", WithConnectionFilter(filter), WithReusePort(true))
+	})
+}
+
+type testConnectionFilterServer struct {
+	*EventServer
+	network string
+	addr    string
+	server  Server
+	done    int32
+}
+
+func (t *testConnectionFilterServer) OnInitComplete(srv Server) (action Action) {
+	t.server = srv
+	go func() {
+		defer atomic.StoreInt32(&t.done, 1)
+		// The connection from the allowed address is served.
+		conn, err := net.Dial(t.network, t.addr)
+		must(err)
+		defer conn.Close()
+		_, err = conn.Write([]byte("hello"))
+		must(err)
+		_, err = io.ReadFull(conn, make([]byte, 5))
+		must(err)
+
+		// The connection from the denied address is closed right away.
+		dialer := net.Dialer{LocalAddr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 2)}}
+		denied, err := dialer.Dial(t.network, t.addr)
+		must(err)
+		defer denied.Close()
+		_ = denied.SetReadDeadline(time.Now().Add(time.Second))
+		if _, err = denied.Read(make([]byte, 1)); err == nil {
+			panic("denied connection should be closed")
+		}
+	}()
+	return
+}
+
+func (t *testConnectionFilterServer) OnOpened(c Conn) (out []byte, action Action) {
+	if c.RemoteAddr().(*net.TCPAddr).IP.Equal(net.IPv4(127, 0, 0, 2)) {
+		panic("denied connection should not be opened")
+	}
+	return
+}
+
+func (t *testConnectionFilterServer) React(c Conn) (out []byte, action Action) {
+	out = c.Read()
+	c.ResetBuffer()
+	return
+}
+
+func (t *testConnectionFilterServer) Tick() (delay time.Duration, action Action) {
+	if atomic.LoadInt32(&t.done) == 1 {
+		action = Shutdown
+	}
+	delay = 10 * time.Millisecond
+	return
+}
+
+func testConnectionFilter(t *testing.T, network, addr string, opts ...Option) {
+	svr := &testConnectionFilterServer{network: network, addr: addr}
+	must(Serve(svr, network+"://"+addr, append(opts, WithTicker(true))...))
+	stats := svr.server.AcceptStats()
+	if stats.Accepted != 2 || stats.Filtered != 1 {
+		t.Fatalf("expected 2 connections accepted and 1 filtered, got %+v", stats)
+	}
+}
+
+func TestAcceptRateLimit(t *testing.T) {
+	t.Run("reactor", func(t *testing.T) {
+		testAcceptRateLimit(t, "tcp", ":9991")
+	})
+	t.Run("reuseport", func(t *testing.T) {
+		testAcceptRateLimit(t, "tcp", ":9992", WithReusePort(true))
+	})
+}
+
+type testAcceptRateLimitServer struct {
+	*EventServer
+	network  string
+	addr     string
+	nclients int
+	server   Server
+	elapsed  time.Duration
+	done     int32
+}
+
+func (t *testAcceptRateLimitServer) OnInitComplete(srv Server) (action Action) {
+	t.server = srv
+	go func() {
+		start := time.Now()
+		var wg sync.WaitGroup
+		for i := 0; i < t.nclients; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				conn, err := net.Dial(t.network, t.addr)
+				must(err)
+				defer conn.Close()
+				_, err = conn.Write([]byte("hello"))
+				must(err)
+				_, err = io.ReadFull(conn, make([]byte, 5))
+				must(err)
+			}()
+		}
+		wg.Wait()
+		t.elapsed = time.Since(start)
+		atomic.StoreInt32(&t.done, 1)
+	}()
+	return
+}
+
+func (t *testAcceptRateLimitServer) React(c Conn) (out []byte, action Action) {
+	out = c.Read()
+	c.ResetBuffer()
+	return
+}
+
+func (t *testAcceptRateLimitServer) Tick() (delay time.Duration, action Action) {
+	if atomic.LoadInt32(&t.done) == 1 {
+		action = Shutdown
+	}
+	delay = 10 * time.Millisecond
+	return
+}
+
+func testAcceptRateLimit(t *testing.T, network, addr string, opts ...Option) {
+	svr := &testAcceptRateLimitServer{network: network, addr: addr, nclients: 6}
+	opts = append(opts, WithAcceptRateLimit(20, 2), WithTicker(true))
+	must(Serve(svr, network+"://"+addr, opts...))
+	// The burst is accepted at once, the rest is accepted at the rate of 20 connections per second.
+	if svr.elapsed < 150*time.Millisecond {
+		t.Fatalf("%d connections were accepted in %v", svr.nclients, svr.elapsed)
+	}
+	stats := svr.server.AcceptStats()
+	if stats.Accepted != int64(svr.nclients) || stats.Throttled == 0 {
+		t.Fatalf("unexpected stats of accepting: %+v", stats)
+	}
+}
+
+func TestClosedErrors(t *testing.T) {
+	if err := Serve(new(EventServer), "sctp://:9991"); err != ErrUnsupportedProtocol {
+		t.Fatalf("unexpected error of unsupported protocol: %v", err)
+	}
+	svr := &testClosedErrorsServer{network: "tcp", addr: ":9991", errs: make(chan error, 2)}
+	must(Serve(svr, "tcp://:9991"))
+	if err := <-svr.errs; err != ErrConnectionClosed {
+		t.Fatalf("unexpected error of connection closed by the peer: %v", err)
+	}
+	if err := <-svr.errs; err != ErrServerShutdown {
+		t.Fatalf("unexpected error of connection closed by the shutdown: %v", err)
+	}
+}
+
+type testClosedErrorsServer struct {
+	*EventServer
+	network string
+	addr    string
+	errs    chan error
+}
+
+func (t *testClosedErrorsServer) OnInitComplete(srv Server) (action Action) {
+	go func() {
+		// The first connection is kept open until the server shuts down.
+		kept, err := net.Dial(t.network, t.addr)
+		must(err)
+		defer kept.Close()
+		conn, err := net.Dial(t.network, t.addr)
+		must(err)
+		_ = conn.Close()
+		_, _ = kept.Read(make([]byte, 1))
+	}()
+	return
+}
+
+func (t *testClosedErrorsServer) OnClosed(c Conn, err error) (action Action) {
+	t.errs <- err
+	if err == ErrConnectionClosed {
+		action = Shutdown
+	}
+	return
+}
+
+func TestOnAccept(t *testing.T) {
+	svr := &testOnAcceptServer{network: "tcp", addr: ":9991"}
+	must(Serve(svr, "tcp://:9991", WithTicker(true)))
+	if svr.err != nil {
+		t.Fatal(svr.err)
+	}
+	if opened := atomic.LoadInt32(&svr.opened); opened != 1 {
+		t.Fatalf("expected 1 connection opened, got %d", opened)
+	}
+	if stats := svr.server.AcceptStats(); stats.Rejected != 1 {
+		t.Fatalf("unexpected stats of accepting: %+v", stats)
+	}
+}
+
+type testOnAcceptServer struct {
+	*EventServer
+	network  string
+	addr     string
+	server   Server
+	accepted int32
+	opened   int32
+	err      error
+	done     int32
+}
+
+func (t *testOnAcceptServer) OnInitComplete(srv Server) (action Action) {
+	t.server = srv
+	go func() {
+		defer atomic.StoreInt32(&t.done, 1)
+		t.err = t.dial()
+	}()
+	return
+}
+
+func (t *testOnAcceptServer) dial() error {
+	// The first connection is rejected with the reason.
+	conn, err := net.Dial(t.network, t.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	reason, err := ioutil.ReadAll(conn)
+	if err != nil {
+		return err
+	}
+	if string(reason) != "busy\n" {
+		return fmt.Errorf("unexpected reason of rejection: %q", reason)
+	}
+
+	// The second connection is served.
+	conn, err = net.Dial(t.network, t.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err = conn.Write([]byte("hello")); err != nil {
+		return err
+	}
+	_, err = io.ReadFull(conn, make([]byte, 5))
+	return err
+}
+
+func (t *testOnAcceptServer) OnAccept(remote net.Addr) (action Action, reason []byte) {
+	if remote.(*net.TCPAddr).IP == nil {
+		panic("nil remote addr")
+	}
+	if atomic.AddInt32(&t.accepted, 1) == 1 {
+		return Close, []byte("busy\n")
+	}
+	return
+}
+
+func (t *testOnAcceptServer) OnOpened(c Conn) (out []byte, action Action) {
+	atomic.AddInt32(&t.opened, 1)
+	return
+}
+
+func (t *testOnAcceptServer) React(c Conn) (out []byte, action Action) {
+	out = c.Read()
+	c.ResetBuffer()
+	return
+}
+
+func (t *testOnAcceptServer) Tick() (delay time.Duration, action Action) {
+	if atomic.LoadInt32(&t.done) == 1 {
+		action = Shutdown
+	}
+	delay = 10 * time.Millisecond
+	return
+}
+
+func TestHalfClose(t *testing.T) {
+	t.Run("level-triggered", func(t *testing.T) {
+		testHalfClose("tcp", ":9991")
+	})
+	t.Run("edge-triggered", func(t *testing.T) {
+		testHalfClose("tcp", ":9992", WithEdgeTriggered(true))
+	})
+}
+
+type testHalfCloseServer struct {
+	*EventServer
+	network string
+	addr    string
+	data    chan []byte
+	closed  error
+}
+
+func (t *testHalfCloseServer) OnInitComplete(srv Server) (action Action) {
+	go func() {
+		conn, err := net.Dial(t.network, t.addr)
+		must(err)
+		defer conn.Close()
+		_, err = conn.Write([]byte("hello"))
+		must(err)
+		// Keep reading after sending FIN.
+		must(conn.(*net.TCPConn).CloseWrite())
+		data, err := ioutil.ReadAll(conn)
+		must(err)
+		t.data <- data
+	}()
+	return
+}
+
+func (t *testHalfCloseServer) React(c Conn) (out []byte, action Action) {
+	out = c.Read()
+	c.ResetBuffer()
+	return
+}
+
+func (t *testHalfCloseServer) OnReadClosed(c Conn) (out []byte, action Action) {
+	c.CloseWrite()
+	return []byte(" bye"), None
+}
+
+func (t *testHalfCloseServer) OnClosed(c Conn, err error) (action Action) {
+	t.closed = err
+	action = Shutdown
+	return
+}
+
+func testHalfClose(network, addr string, opts ...Option) {
+	svr := &testHalfCloseServer{network: network, addr: addr, data: make(chan []byte, 1)}
+	must(Serve(svr, network+"://"+addr, opts...))
+	if svr.closed != nil {
+		panic(fmt.Sprintf("unexpected error of closing: %v", svr.closed))
+	}
+	if data := <-svr.data; string(data) != "hello bye" {
+		panic(fmt.Sprintf("unexpected data after half-close: %q", data))
+	}
+}
+
+func TestHalfCloseReset(t *testing.T) {
+	t.Run("level-triggered", func(t *testing.T) {
+		testHalfCloseReset("tcp", ":9962")
+	})
+	t.Run("edge-triggered", func(t *testing.T) {
+		testHalfCloseReset("tcp", ":9961", WithEdgeTriggered(true))
+	})
+}
+
+type testHalfCloseResetServer struct {
+	*EventServer
+	network string
+	addr    string
+}
+
+func (t *testHalfCloseResetServer) OnInitComplete(srv Server) (action Action) {
+	go func() {
+		conn, err := net.Dial(t.network, t.addr)
+		must(err)
+		must(conn.(*net.TCPConn).CloseWrite())
+		data := make([]byte, 3)
+		_, err = io.ReadFull(conn, data)
+		must(err)
+		// Reset the half-closed connection.
+		must(conn.(*net.TCPConn).SetLinger(0))
+		must(conn.Close())
+	}()
+	return
+}
+
+func (t *testHalfCloseResetServer) OnReadClosed(c Conn) (out []byte, action Action) {
+	return []byte("bye"), None
+}
+
+func (t *testHalfCloseResetServer) OnClosed(c Conn, err error) (action Action) {
+	action = Shutdown
+	return
+}
+
+func testHalfCloseReset(network, addr string, opts ...Option) {
+	svr := &testHalfCloseResetServer{network: network, addr: addr}
+	must(Serve(svr, network+"://"+addr, opts...))
+}
+
+func TestPauseRead(t *testing.T) {
+	t.Run("level-triggered", func(t *testing.T) {
+		testPauseRead("tcp", ":9958")
+	})
+	// The readable events keep being watched in the edge-triggered mode, both epoll and kqueue must ignore them.
+	t.Run("edge-triggered", func(t *testing.T) {
+		testPauseRead("tcp", ":9957", WithEdgeTriggered(true))
+	})
+}
+
+type testPauseReadServer struct {
+	*EventServer
+	network string
+	addr    string
+	paused  int32
+	reads   int32
+	done    int32
+}
+
+func (t *testPauseReadServer) OnInitComplete(srv Server) (action Action) {
+	go func() {
+		defer atomic.StoreInt32(&t.done, 1)
+		conn, err := net.Dial(t.network, t.addr)
+		must(err)
+		defer conn.Close()
+		_, err = conn.Write([]byte("a"))
+		must(err)
+		time.Sleep(20 * time.Millisecond)
+		_, err = conn.Write([]byte("b"))
+		must(err)
+		data := make([]byte, 2)
+		_, err = io.ReadFull(conn, data)
+		must(err)
+		if string(data) != "ab" {
+			panic(fmt.Sprintf("unexpected echo: %q", data))
+		}
+	}()
+	return
+}
+
+func (t *testPauseReadServer) React(c Conn) (out []byte, action Action) {
+	data := c.Read()
+	if len(data) == 0 {
+		return
+	}
+	if atomic.LoadInt32(&t.paused) == 1 {
+		panic("data was read while reading was paused")
+	}
+	out = append([]byte(nil), data...)
+	c.ResetBuffer()
+	if atomic.AddInt32(&t.reads, 1) == 1 {
+		atomic.StoreInt32(&t.paused, 1)
+		c.PauseRead()
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			atomic.StoreInt32(&t.paused, 0)
+			c.ResumeRead()
+		}()
+	}
+	return
+}
+
+func (t *testPauseReadServer) Tick() (delay time.Duration, action Action) {
+	if atomic.LoadInt32(&t.done) == 1 {
+		action = Shutdown
+	}
+	delay = 10 * time.Millisecond
+	return
+}
+
+func testPauseRead(network, addr string, opts ...Option) {
+	svr := &testPauseReadServer{network: network, addr: addr}
+	must(Serve(svr, network+"://"+addr, append(opts, WithTicker(true))...))
+}
+
+func TestNetConn(t *testing.T) {
+	svr := &testNetConnServer{network: "tcp", addr: ":9991", errs: make(chan error, 1)}
+	must(Serve(svr, "tcp://:9991"))
+	if err := <-svr.errs; err != nil {
+		t.Fatal(err)
+	}
+}
+
+type testNetConnServer struct {
+	*EventServer
+	network string
+	addr    string
+	errs    chan error
+}
+
+func (t *testNetConnServer) OnInitComplete(srv Server) (action Action) {
+	go func() {
+		conn, err := net.Dial(t.network, t.addr)
+		must(err)
+		defer conn.Close()
+		_, err = conn.Write([]byte("hello"))
+		must(err)
+		// The echo is followed by the close of server.
+		data, err := ioutil.ReadAll(conn)
+		must(err)
+		if string(data) != "hello" {
+			panic(fmt.Sprintf("unexpected echo: %q", data))
+		}
+	}()
+	return
+}
+
+func (t *testNetConnServer) OnOpened(c Conn) (out []byte, action Action) {
+	nc := c.NetConn()
+	go func() {
+		t.errs <- serveNetConn(nc)
+	}()
+	return
+}
+
+// serveNetConn echoes a message with the blocking methods of net.Conn.
+func serveNetConn(nc net.Conn) error {
+	defer nc.Close()
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(nc, buf); err != nil {
+		return err
+	}
+	if _, err := nc.Write(buf); err != nil {
+		return err
+	}
+	_ = nc.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	if _, err := nc.Read(buf); err == nil {
+		return fmt.Errorf("read should time out")
+	} else if nerr, ok := err.(net.Error); !ok || !nerr.Timeout() {
+		return fmt.Errorf("unexpected error of read: %v", err)
+	}
+	return nil
+}
+
+func (t *testNetConnServer) OnClosed(c Conn, err error) (action Action) {
+	action = Shutdown
+	return
+}
+
+func TestNetConnBackpressure(t *testing.T) {
+	svr := &testNetConnBackpressureServer{network: "tcp", addr: ":9953", errs: make(chan error, 1)}
+	must(Serve(svr, "tcp://:9953"))
+	if err := <-svr.errs; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// netConnStreamSize is much larger than what the socket buffers hold.
+const netConnStreamSize = 32 << 20
+
+type testNetConnBackpressureServer struct {
+	*EventServer
+	network string
+	addr    string
+	errs    chan error
+}
+
+func (t *testNetConnBackpressureServer) OnInitComplete(srv Server) (action Action) {
+	go func() {
+		conn, err := net.Dial(t.network, t.addr)
+		must(err)
+		defer conn.Close()
+		_, err = io.CopyN(conn, &patternReader{}, netConnStreamSize)
+		must(err)
+		// Let the writes of server pile up before reading.
+		time.Sleep(300 * time.Millisecond)
+		must(checkPattern(conn))
+	}()
+	return
+}
+
+func (t *testNetConnBackpressureServer) OnOpened(c Conn) (out []byte, action Action) {
+	nc := c.NetConn().(*netConn)
+	go func() {
+		t.errs <- serveNetConnBackpressure(nc)
+	}()
+	return
+}
+
+// serveNetConnBackpressure lets the inbound and the outbound data pile up, which must be bounded.
+func serveNetConnBackpressure(nc *netConn) error {
+	defer nc.Close()
+	time.Sleep(100 * time.Millisecond)
+	nc.mu.Lock()
+	inbound := len(nc.inbound)
+	nc.mu.Unlock()
+	if inbound > 2*netConnMaxInbound {
+		return fmt.Errorf("expected the inbound data to be bounded, got %d bytes", inbound)
+	}
+	if err := checkPattern(nc); err != nil {
+		return err
+	}
+
+	var written int64
+	done := make(chan error, 1)
+	go func() {
+		_, err := io.CopyN(writerFunc(func(p []byte) (int, error) {
+			n, err := nc.Write(p)
+			atomic.AddInt64(&written, int64(n))
+			return n, err
+		}), &patternReader{}, netConnStreamSize)
+		done <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+	if atomic.LoadInt64(&written) == netConnStreamSize {
+		return fmt.Errorf("expected the writes to block")
+	}
+	return <-done
+}
+
+// checkPattern reads netConnStreamSize bytes of the pattern of patternReader.
+func checkPattern(r io.Reader) error {
+	buf := make([]byte, 1<<16)
+	for off := 0; off < netConnStreamSize; {
+		n := len(buf)
+		if n > netConnStreamSize-off {
+			n = netConnStreamSize - off
+		}
+		if _, err := io.ReadFull(r, buf[:n]); err != nil {
+			return err
+		}
+		for i, b := range buf[:n] {
+			if b != byte((off+i)%251) {
+				return fmt.Errorf("unexpected byte at %d", off+i)
+			}
+		}
+		off += n
+	}
+	return nil
+}
+
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) { return f(p) }
+
+func (t *testNetConnBackpressureServer) OnClosed(c Conn, err error) (action Action) {
+	action = Shutdown
+	return
+}
+
+func TestDetach(t *testing.T) {
+	svr := &testDetachServer{network: "tcp", addr: ":9992", errs: make(chan error, 1)}
+	must(Serve(svr, "tcp://:9992"))
+	if err := <-svr.errs; err != nil {
+		t.Fatal(err)
+	}
+}
+
+type testDetachServer struct {
+	*EventServer
+	network string
+	addr    string
+	errs    chan error
+	done    int32
+}
+
+func (t *testDetachServer) OnInitComplete(srv Server) (action Action) {
+	must(srv.Schedule("detach", 10*time.Millisecond, func() Action {
+		if atomic.LoadInt32(&t.done) == 1 {
+			return Shutdown
+		}
+		return None
+	}))
+	go func() {
+		conn, err := net.Dial(t.network, t.addr)
+		must(err)
+		defer conn.Close()
+		_, err = conn.Write([]byte("hello"))
+		must(err)
+		// The echo comes from the detached connection, which is closed afterwards.
+		data, err := ioutil.ReadAll(conn)
+		must(err)
+		if string(data) != "hello" {
+			panic(fmt.Sprintf("unexpected echo: %q", data))
+		}
+	}()
+	return
+}
+
+func (t *testDetachServer) React(c Conn) (out []byte, action Action) {
+	fd, err := c.Dup()
+	if err != nil {
+		t.errs <- err
+		return nil, Shutdown
+	}
+	_ = unix.Close(fd)
+	nc, err := c.Detach()
+	if err != nil {
+		t.errs <- err
+		return nil, Shutdown
+	}
+	go func() {
+		defer atomic.StoreInt32(&t.done, 1)
+		defer nc.Close()
+		buf := make([]byte, 5)
+		if _, err := io.ReadFull(nc, buf); err != nil {
+			t.errs <- err
+			return
+		}
+		_, err := nc.Write(buf)
+		t.errs <- err
+	}()
+	// The output is ignored since the connection has been detached.
+	return []byte("ignored"), None
+}
+
+func (t *testDetachServer) OnClosed(c Conn, err error) (action Action) {
+	panic("OnClosed fired for the detached connection")
+}
+
+func TestPeekDiscard(t *testing.T) {
+	svr := &testPeekServer{network: "tcp", addr: ":9993"}
+	must(Serve(svr, "tcp://:9993"))
+}
+
+type testPeekServer struct {
+	*EventServer
+	network string
+	addr    string
+}
+
+func (t *testPeekServer) OnInitComplete(srv Server) (action Action) {
+	go func() {
+		conn, err := net.Dial(t.network, t.addr)
+		must(err)
+		defer conn.Close()
+		// Frames are prefixed by a 2-byte length and split across writes, so that the header and payload
+		// are left in the inbound ring-buffer partially.
+		for _, part := range []string{"\x00", "\x05hel", "lo\x00\x05wor", "ld"} {
+			_, err = conn.Write([]byte(part))
+			must(err)
+			time.Sleep(20 * time.Millisecond)
+		}
+		data := make([]byte, 10)
+		_, err = io.ReadFull(conn, data)
+		must(err)
+		if string(data) != "helloworld" {
+			panic(fmt.Sprintf("unexpected payloads: %q", data))
+		}
+	}()
+	return
+}
+
+func (t *testPeekServer) React(c Conn) (out []byte, action Action) {
+	for {
+		header, err := c.Peek(2)
+		if err != nil {
+			return
+		}
+		length := int(binary.BigEndian.Uint16(header))
+		if c.BufferLength() < 2+length {
+			return
+		}
+		if n := c.Discard(2); n != 2 {
+			panic(fmt.Sprintf("unexpected discarded bytes: %d", n))
+		}
+		payload, err := c.Peek(length)
+		if err != nil {
+			panic(err)
+		}
+		out = append(out, payload...)
+		c.Discard(length)
+	}
+}
+
+func (t *testPeekServer) OnClosed(c Conn, err error) (action Action) {
+	action = Shutdown
+	return
+}
+
+func TestReaderWriter(t *testing.T) {
+	svr := &testReaderWriterServer{network: "tcp", addr: ":9994"}
+	must(Serve(svr, "tcp://:9994"))
+}
+
+type testReaderWriterServer struct {
+	*EventServer
+	network string
+	addr    string
+}
+
+func (t *testReaderWriterServer) OnInitComplete(srv Server) (action Action) {
+	go func() {
+		conn, err := net.Dial(t.network, t.addr)
+		must(err)
+		defer conn.Close()
+		_, err = conn.Write([]byte("\x00\x2aping\n"))
+		must(err)
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		must(err)
+		if line != "42 ping\n" {
+			panic(fmt.Sprintf("unexpected reply: %q", line))
+		}
+	}()
+	return
+}
+
+func (t *testReaderWriterServer) React(c Conn) (out []byte, action Action) {
+	if c.BufferLength() == 0 {
+		return
+	}
+	r := c.Reader()
+	var id uint16
+	if err := binary.Read(r, binary.BigEndian, &id); err != nil {
+		panic(err)
+	}
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil {
+		panic(err)
+	}
+	if _, err = fmt.Fprintf(c.Writer(), "%d %s", id, line); err != nil {
+		panic(err)
+	}
+	if _, err = r.Read(make([]byte, 1)); err != io.EOF {
+		panic(fmt.Sprintf("unexpected error of reading the drained buffer: %v", err))
+	}
+	return
+}
+
+func (t *testReaderWriterServer) OnClosed(c Conn, err error) (action Action) {
+	action = Shutdown
+	return
+}
+
+func TestAsyncWriteFrom(t *testing.T) {
+	for _, et := range []bool{false, true} {
+		svr := &testWriteFromServer{network: "tcp", addr: ":9995", closed: make(chan struct{})}
+		must(Serve(svr, "tcp://:9995", WithEdgeTriggered(et)))
+		select {
+		case <-svr.closed:
+		default:
+			t.Fatal("reader of the stream isn't closed")
+		}
+	}
+}
+
+const streamSize = 8 << 20
+
+// patternReader yields the bytes of a repeated pattern without holding them in memory.
+type patternReader struct {
+	off    int64
+	closed chan struct{}
+}
+
+func (r *patternReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = byte(r.off % 251)
+		r.off++
+	}
+	return len(p), nil
+}
+
+func (r *patternReader) Close() error {
+	close(r.closed)
+	return nil
+}
+
+type testWriteFromServer struct {
+	*EventServer
+	network string
+	addr    string
+	closed  chan struct{}
+}
+
+func (t *testWriteFromServer) OnInitComplete(srv Server) (action Action) {
+	go func() {
+		conn, err := net.Dial(t.network, t.addr)
+		must(err)
+		defer conn.Close()
+		_, err = conn.Write([]byte("go"))
+		must(err)
+		data, err := ioutil.ReadAll(io.LimitReader(conn, streamSize+3))
+		must(err)
+		if len(data) != streamSize+3 || string(data[streamSize:]) != "end" {
+			panic(fmt.Sprintf("unexpected length of stream: %d", len(data)))
+		}
+		for i, b := range data[:streamSize] {
+			if b != byte(i%251) {
+				panic(fmt.Sprintf("unexpected byte at %d", i))
+			}
+		}
+	}()
+	return
+}
+
+func (t *testWriteFromServer) React(c Conn) (out []byte, action Action) {
+	c.ResetBuffer()
+	c.AsyncWriteFrom(&patternReader{closed: t.closed}, streamSize)
+	c.AsyncWrite([]byte("end"))
+	return
+}
+
+func (t *testWriteFromServer) OnClosed(c Conn, err error) (action Action) {
+	action = Shutdown
+	return
+}
+
+func TestReadUpTo(t *testing.T) {
+	svr := &testReadUpToServer{network: "tcp", addr: ":9996"}
+	must(Serve(svr, "tcp://:9996"))
+}
+
+type testReadUpToServer struct {
+	*EventServer
+	network string
+	addr    string
+}
+
+func (t *testReadUpToServer) OnInitComplete(srv Server) (action Action) {
+	go func() {
+		conn, err := net.Dial(t.network, t.addr)
+		must(err)
+		defer conn.Close()
+		for _, part := range []string{"012", "3456789"} {
+			_, err = conn.Write([]byte(part))
+			must(err)
+			time.Sleep(20 * time.Millisecond)
+		}
+		data := make([]byte, 10)
+		_, err = io.ReadFull(conn, data)
+		must(err)
+		if string(data) != "0123456789" {
+			panic(fmt.Sprintf("unexpected echo: %q", data))
+		}
+	}()
+	return
+}
+
+func (t *testReadUpToServer) React(c Conn) (out []byte, action Action) {
+	for {
+		size, buf := c.ReadUpTo(4)
+		if size == 0 {
+			return
+		}
+		if size > 4 || size != len(buf) {
+			panic(fmt.Sprintf("unexpected size of chunk: %d", size))
+		}
+		out = append(out, buf...)
+	}
+}
+
+func (t *testReadUpToServer) OnClosed(c Conn, err error) (action Action) {
+	action = Shutdown
+	return
+}
+
+func TestBufferGrowth(t *testing.T) {
+	svr := &testBufferGrowthServer{network: "tcp", addr: ":9997", errs: make(chan error, 4)}
+	must(Serve(svr, "tcp://:9997", WithReadBufferCap(16), WithWriteBufferCap(32),
+		WithBufferGrowth(ringbuffer.GrowthPolicy{Increment: 16, MaxCap: 64})))
+	if err := <-svr.errs; err != nil {
+		t.Fatal(err)
+	}
+}
+
+type testBufferGrowthServer struct {
+	*EventServer
+	network string
+	addr    string
+	reacts  int
+	errs    chan error
+}
+
+func (t *testBufferGrowthServer) OnInitComplete(srv Server) (action Action) {
+	go func() {
+		conn, err := net.Dial(t.network, t.addr)
+		must(err)
+		defer conn.Close()
+		// The data is left in the inbound buffer, which grows by the increment until the maximum capacity.
+		for _, size := range []int{20, 1, 100} {
+			_, err = conn.Write(make([]byte, size))
+			must(err)
+			time.Sleep(20 * time.Millisecond)
+		}
+	}()
+	return
+}
+
+func (t *testBufferGrowthServer) OnOpened(c Conn) (out []byte, action Action) {
+	if size := c.OutboundBuffer().Capacity(); size != 32 {
+		t.errs <- fmt.Errorf("unexpected capacity of outbound buffer: %d", size)
+	}
+	return
+}
+
+func (t *testBufferGrowthServer) React(c Conn) (out []byte, action Action) {
+	t.reacts++
+	if size := c.InboundBuffer().Capacity(); t.reacts == 2 && size != 32 {
+		t.errs <- fmt.Errorf("unexpected capacity of inbound buffer: %d", size)
+	}
+	return
+}
+
+func (t *testBufferGrowthServer) OnClosed(c Conn, err error) (action Action) {
+	if err != ErrBufferFull {
+		t.errs <- fmt.Errorf("unexpected error of closing: %v", err)
+	}
+	t.errs <- nil
+	action = Shutdown
+	return
+}
+
+func TestBufferShrink(t *testing.T) {
+	svr := &testBufferShrinkServer{network: "tcp", addr: ":9998", errs: make(chan error, 1)}
+	must(Serve(svr, "tcp://:9998", WithBufferShrink(20*time.Millisecond)))
+	if err := <-svr.errs; err != nil {
+		t.Fatal(err)
+	}
+}
+
+type testBufferShrinkServer struct {
+	*EventServer
+	network string
+	addr    string
+	reacts  int
+	errs    chan error
+}
+
+func (t *testBufferShrinkServer) OnInitComplete(srv Server) (action Action) {
+	go func() {
+		conn, err := net.Dial(t.network, t.addr)
+		must(err)
+		defer conn.Close()
+		for _, size := range []int{5000, 1} {
+			_, err = conn.Write(make([]byte, size))
+			must(err)
+			time.Sleep(20 * time.Millisecond)
+		}
+		// The inbound buffer emptied by the second React is shrunk after two sweeps.
+		time.Sleep(100 * time.Millisecond)
+		_, err = conn.Write([]byte{0})
+		must(err)
+		_, err = conn.Read(make([]byte, 1))
+		must(err)
+	}()
+	return
+}
+
+func (t *testBufferShrinkServer) React(c Conn) (out []byte, action Action) {
+	t.reacts++
+	switch t.reacts {
+	case 1:
+		// Leave the data in the inbound buffer to grow it.
+	case 2:
+		if size := c.InboundBuffer().Capacity(); size <= socketRingBufferSize {
+			t.errs <- fmt.Errorf("inbound buffer should have grown: %d", size)
+		}
+		c.ResetBuffer()
+	case 3:
+		var err error
+		if size := c.InboundBuffer().Capacity(); size != socketRingBufferSize {
+			err = fmt.Errorf("inbound buffer should have been shrunk: %d", size)
+		}
+		t.errs <- err
+		return []byte{0}, None
+	}
+	return
+}
+
+func (t *testBufferShrinkServer) OnClosed(c Conn, err error) (action Action) {
+	action = Shutdown
+	return
+}
+
+func TestMirroredBuffers(t *testing.T) {
+	svr := &testMirroredServer{network: "tcp", addr: ":9999", errs: make(chan error, 1)}
+	must(Serve(svr, "tcp://:9999", WithMirroredBuffers(true)))
+	if err := <-svr.errs; err != nil {
+		t.Fatal(err)
+	}
+}
+
+type testMirroredServer struct {
+	*EventServer
+	network string
+	addr    string
+	reacts  int
+	errs    chan error
+}
+
+func (t *testMirroredServer) OnInitComplete(srv Server) (action Action) {
+	go func() {
+		conn, err := net.Dial(t.network, t.addr)
+		must(err)
+		defer conn.Close()
+		data := make([]byte, 5001)
+		for i := range data {
+			data[i] = byte(i % 251)
+		}
+		for _, part := range [][]byte{data[:3000], data[3000:5000], data[5000:]} {
+			_, err = conn.Write(part)
+			must(err)
+			time.Sleep(20 * time.Millisecond)
+		}
+		_, err = conn.Read(make([]byte, 1))
+		must(err)
+	}()
+	return
+}
+
+func (t *testMirroredServer) React(c Conn) (out []byte, action Action) {
+	t.reacts++
+	switch t.reacts {
+	case 1:
+		// Leave the data in the inbound buffer.
+	case 2:
+		// Consume all but the last byte in the buffer, so that the data of this React wraps around the end of buffer.
+		c.ReadN(2999)
+	case 3:
+		var err error
+		rb := c.InboundBuffer()
+		head, tail := rb.LazyReadAll()
+		switch {
+		case rb.Capacity()%os.Getpagesize() != 0:
+			err = fmt.Errorf("unexpected capacity of inbound buffer: %d", rb.Capacity())
+		case runtime.GOOS == "linux" && (len(head) != 2001 || tail != nil):
+			err = fmt.Errorf("wrapped data should be contiguous: %d, %d", len(head), len(tail))
+		}
+		for i, b := range append(head, tail...) {
+			if b != byte((2999+i)%251) {
+				err = fmt.Errorf("unexpected byte at %d", i)
+				break
+			}
+		}
+		t.errs <- err
+		return []byte{0}, None
+	}
+	return
+}
+
+func (t *testMirroredServer) OnClosed(c Conn, err error) (action Action) {
+	action = Shutdown
+	return
+}
+
+func TestAllocator(t *testing.T) {
+	alloc := &countingAllocator{Allocator: pool.NewAllocator()}
+	svr := &testAllocatorServer{network: "tcp", addr: ":9991"}
+	must(Serve(svr, "tcp://:9991", WithAllocator(alloc)))
+	// The read buffer of loop is put back when the server is closed, so is the inbound buffer outgrown.
+	if gets, puts := atomic.LoadInt32(&alloc.gets), atomic.LoadInt32(&alloc.puts); gets == 0 || puts < 2 {
+		t.Fatalf("unexpected number of allocations: %d gets, %d puts", gets, puts)
+	}
+}
+
+type countingAllocator struct {
+	pool.Allocator
+	gets, puts int32
+}
+
+func (a *countingAllocator) Get(size int) []byte {
+	atomic.AddInt32(&a.gets, 1)
+	return a.Allocator.Get(size)
+}
+
+func (a *countingAllocator) Put(buf []byte) {
+	atomic.AddInt32(&a.puts, 1)
+	a.Allocator.Put(buf)
+}
+
+type testAllocatorServer struct {
+	*EventServer
+	network string
+	addr    string
+}
+
+func (t *testAllocatorServer) OnInitComplete(srv Server) (action Action) {
+	go func() {
+		conn, err := net.Dial(t.network, t.addr)
+		must(err)
+		defer conn.Close()
+		_, err = conn.Write(make([]byte, 4096))
+		must(err)
+		time.Sleep(20 * time.Millisecond)
+	}()
+	return
+}
+
+func (t *testAllocatorServer) React(c Conn) (out []byte, action Action) {
+	// Leave the data in the inbound buffer to grow it.
+	return
+}
+
+func (t *testAllocatorServer) OnClosed(c Conn, err error) (action Action) {
+	action = Shutdown
+	return
+}
+
+func TestLinkedOutboundBuffer(t *testing.T) {
+	for _, et := range []bool{false, true} {
+		svr := &testLinkedOutboundServer{network: "tcp", addr: ":9990"}
+		must(Serve(svr, "tcp://:9990", WithEdgeTriggered(et), WithOutboundBufferKind(OutboundLinkedBuffer)))
+	}
+}
+
+type testLinkedOutboundServer struct {
+	*EventServer
+	network string
+	addr    string
+}
+
+func (t *testLinkedOutboundServer) OnInitComplete(srv Server) (action Action) {
+	go func() {
+		conn, err := net.Dial(t.network, t.addr)
+		must(err)
+		defer conn.Close()
+		_, err = conn.Write([]byte("go"))
+		must(err)
+		// Let the outbound buffer pile up before reading.
+		time.Sleep(50 * time.Millisecond)
+		data, err := ioutil.ReadAll(io.LimitReader(conn, streamSize+5))
+		must(err)
+		if len(data) != streamSize+5 || string(data[:2]) != "go" || string(data[streamSize+2:]) != "end" {
+			panic(fmt.Sprintf("unexpected length of stream: %d", len(data)))
+		}
+		for i, b := range data[2 : streamSize+2] {
+			if b != byte(i%251) {
+				panic(fmt.Sprintf("unexpected byte at %d", i))
+			}
+		}
+	}()
+	return
+}
+
+func (t *testLinkedOutboundServer) React(c Conn) (out []byte, action Action) {
+	if c.OutboundBuffer() != nil {
+		panic("outbound ring-buffer is held with the linked buffer")
+	}
+	out = append([]byte(nil), c.Read()...)
+	c.ResetBuffer()
+	data := make([]byte, streamSize)
+	_, _ = (&patternReader{}).Read(data)
+	// Write the large stream in chunks to queue a number of nodes.
+	for len(data) > 0 {
+		n := 1 << 16
+		if n > len(data) {
+			n = len(data)
+		}
+		c.AsyncWrite(data[:n])
+		data = data[n:]
+	}
+	c.AsyncWrite([]byte("end"))
+	return
+}
+
+func (t *testLinkedOutboundServer) OnClosed(c Conn, err error) (action Action) {
+	action = Shutdown
+	return
+}
+
+func TestConcurrentAsyncWrite(t *testing.T) {
+	svr := &testConcurrentWriteServer{network: "tcp", addr: ":9989"}
+	must(Serve(svr, "tcp://:9989"))
+	// The writes triggered in a burst share wakeups.
+	stats := svr.srv.TriggerStats()
+	if stats.Triggered < concurrentWriters*writesPerWriter || stats.Wakeups == 0 || stats.Wakeups >= stats.Triggered {
+		t.Fatalf("unexpected stats of triggers: %+v", stats)
+	}
+}
+
+const (
+	concurrentWriters = 16
+	writesPerWriter   = 10000
+)
+
+type testConcurrentWriteServer struct {
+	*EventServer
+	network string
+	addr    string
+	srv     Server
+}
+
+func (t *testConcurrentWriteServer) OnInitComplete(srv Server) (action Action) {
+	t.srv = srv
+	go func() {
+		conn, err := net.Dial(t.network, t.addr)
+		must(err)
+		defer conn.Close()
+		_, err = conn.Write([]byte("go"))
+		must(err)
+		// Every writer sends its sequence numbers in order, which mustn't be torn or reordered.
+		var next [concurrentWriters]uint32
+		r := bufio.NewReader(conn)
+		msg := make([]byte, 8)
+		for i := 0; i < concurrentWriters*writesPerWriter; i++ {
+			_, err = io.ReadFull(r, msg)
+			must(err)
+			id, seq := binary.BigEndian.Uint32(msg), binary.BigEndian.Uint32(msg[4:])
+			if id >= concurrentWriters || seq != next[id] {
+				panic(fmt.Sprintf("unexpected message %d of writer %d", seq, id))
+			}
+			next[id]++
+		}
+	}()
+	return
+}
+
+func (t *testConcurrentWriteServer) React(c Conn) (out []byte, action Action) {
+	c.ResetBuffer()
+	for i := 0; i < concurrentWriters; i++ {
+		go func(id uint32) {
+			for seq := uint32(0); seq < writesPerWriter; seq++ {
+				msg := make([]byte, 8)
+				binary.BigEndian.PutUint32(msg, id)
+				binary.BigEndian.PutUint32(msg[4:], seq)
+				c.AsyncWrite(msg)
+			}
+		}(uint32(i))
+	}
+	return
+}
+
+func (t *testConcurrentWriteServer) OnClosed(c Conn, err error) (action Action) {
+	action = Shutdown
+	return
+}
+
+func TestRegisterFD(t *testing.T) {
+	var p [2]int
+	must(unix.Pipe(p[:]))
+	must(unix.SetNonblock(p[0], true))
+	must(unix.SetNonblock(p[1], true))
+	defer unix.Close(p[0])
+	defer unix.Close(p[1])
+	svr := &testRegisterFDServer{t: t, r: p[0], w: p[1]}
+	must(Serve(svr, "tcp://:9988"))
+	if svr.got != "pingpong" {
+		t.Fatalf("unexpected data from pipe: %q", svr.got)
+	}
+	if svr.srv.UnregisterFD(p[1]) {
+		t.Fatal("writing side of pipe isn't unregistered by Close")
+	}
+}
+
+type testRegisterFDServer struct {
+	*EventServer
+	t    *testing.T
+	r, w int
+	srv  Server
+	got  string
+}
+
+func (t *testRegisterFDServer) OnInitComplete(srv Server) (action Action) {
+	t.srv = srv
+	// The reading side is watched once the event-loops are started.
+	must(srv.RegisterFD(t.r, FDReadable, t.onReadable))
+	if err := srv.RegisterFD(t.r, FDReadable, t.onReadable); err != ErrFDRegistered {
+		t.t.Fatalf("unexpected error of registering twice: %v", err)
+	}
+	if err := srv.RegisterFD(-1, FDReadable, t.onReadable); err != ErrInvalidFD {
+		t.t.Fatalf("unexpected error of registering invalid fd: %v", err)
+	}
+	_, err := unix.Write(t.w, []byte("ping"))
+	must(err)
+	return
+}
+
+func (t *testRegisterFDServer) onReadable(fd int, events FDEvent) Action {
+	buf := make([]byte, 16)
+	n, err := unix.Read(fd, buf)
+	must(err)
+	if t.got += string(buf[:n]); t.got == "ping" {
+		must(t.srv.RegisterFD(t.w, FDWritable, func(fd int, events FDEvent) Action {
+			_, err := unix.Write(fd, []byte("pong"))
+			must(err)
+			return Close
+		}))
+		return None
+	}
+	return Shutdown
+}
+
+func TestWithPoller(t *testing.T) {
+	var opened, triggered int32
+	factory := func() (netpoll.Poller, error) {
+		p, err := netpoll.OpenPoller()
+		if err != nil {
+			return nil, err
+		}
+		atomic.AddInt32(&opened, 1)
+		return &countingPoller{Poller: p, triggered: &triggered}, nil
+	}
+	svr := &testWakeWithServer{network: "tcp", addr: ":9987"}
+	must(Serve(svr, "tcp://:9987", WithPoller(factory)))
+	if atomic.LoadInt32(&opened) == 0 || atomic.LoadInt32(&triggered) == 0 {
+		t.Fatalf("custom poller isn't used: %d opened, %d triggered", opened, triggered)
+	}
+}
+
+// countingPoller wraps the default poller, counting the jobs triggered.
+type countingPoller struct {
+	netpoll.Poller
+	triggered *int32
+}
+
+func (p *countingPoller) Trigger(job netpoll.Job) error {
+	atomic.AddInt32(p.triggered, 1)
+	return p.Poller.Trigger(job)
+}
+
+func TestPollPoller(t *testing.T) {
+	t.Run("tcp", func(t *testing.T) {
+		testServe("tcp", ":9986", false, true, true, 10, WithPoller(netpoll.OpenPollPoller))
+	})
+	t.Run("tcp-reuseport", func(t *testing.T) {
+		testServe("tcp", ":9986", true, true, false, 10, WithPoller(netpoll.OpenPollPoller))
+	})
+	t.Run("udp", func(t *testing.T) {
+		testServe("udp", ":9986", false, true, false, 10, WithPoller(netpoll.OpenPollPoller))
+	})
+}
+
+func TestUDPSendTo(t *testing.T) {
+	svr := &testSendToServer{t: t, addr: "127.0.0.1:9985"}
+	must(Serve(svr, "udp://127.0.0.1:9985"))
+}
+
+type testSendToServer struct {
+	*EventServer
+	t    *testing.T
+	addr string
+}
+
+func (t *testSendToServer) OnInitComplete(srv Server) (action Action) {
+	go func() {
+		a, err := net.Dial("udp", t.addr)
+		must(err)
+		defer a.Close()
+		b, err := net.ListenPacket("udp", "127.0.0.1:0")
+		must(err)
+		defer b.Close()
+		// A asks the server to answer B on its behalf.
+		_, err = a.Write([]byte(b.LocalAddr().String()))
+		must(err)
+		must(b.SetReadDeadline(time.Now().Add(time.Second)))
+		buf := make([]byte, 64)
+		n, from, err := b.ReadFrom(buf)
+		must(err)
+		if string(buf[:n]) != "from "+a.LocalAddr().String() || from.String() != t.addr {
+			panic(fmt.Sprintf("unexpected datagram %q from %s", buf[:n], from))
+		}
+		_, err = a.Write([]byte("shutdown"))
+		must(err)
+	}()
+	return
+}
+
+func (t *testSendToServer) React(c Conn) (out []byte, action Action) {
+	data := string(c.Read())
+	if data == "shutdown" {
+		action = Shutdown
+		return
+	}
+	if sa, ok := c.RemoteSockaddr().(*unix.SockaddrInet4); !ok || sa.Port != c.RemoteAddr().(*net.UDPAddr).Port {
+		t.t.Fatalf("unexpected source address of datagram: %v", c.RemoteSockaddr())
+	}
+	to, err := net.ResolveUDPAddr("udp", data)
+	must(err)
+	must(c.SendTo([]byte("from "+c.RemoteAddr().String()), to))
+	if err := c.SendTo(nil, &net.UnixAddr{Name: "invalid"}); err != ErrInvalidAddr {
+		t.t.Fatalf("unexpected error of sending to invalid address: %v", err)
+	}
+	return
+}
+
+func TestUDPSegments(t *testing.T) {
+	svr := &testSegmentsServer{addr: "127.0.0.1:9984"}
+	opts := []Option{WithUDPGRO(runtime.GOOS == "linux")}
+	must(Serve(svr, "udp://127.0.0.1:9984", opts...))
+}
+
+type testSegmentsServer struct {
+	*EventServer
+	addr string
+}
+
+func (t *testSegmentsServer) OnInitComplete(srv Server) (action Action) {
+	go func() {
+		conn, err := net.Dial("udp", t.addr)
+		must(err)
+		defer conn.Close()
+		// The datagrams are passed to React one by one even if they're coalesced by GRO.
+		for i := 0; i < 3; i++ {
+			_, err = conn.Write([]byte("seg"))
+			must(err)
+		}
+		must(conn.SetReadDeadline(time.Now().Add(time.Second)))
+		buf := make([]byte, 4096)
+		for i := 0; i < 9; i++ {
+			n, err := conn.Read(buf)
+			must(err)
+			if n != 1000 && !(i%3 == 2 && n == 500) {
+				panic(fmt.Sprintf("unexpected size of segment %d: %d", i, n))
+			}
+		}
+		_, err = conn.Write([]byte("shutdown"))
+		must(err)
+	}()
+	return
+}
+
+func (t *testSegmentsServer) React(c Conn) (out []byte, action Action) {
+	switch string(c.Read()) {
+	case "seg":
+		must(c.SendSegments(make([]byte, 2500), 1000, nil))
+	case "shutdown":
+		action = Shutdown
+	default:
+		panic(fmt.Sprintf("unexpected datagram: %q", c.Read()))
+	}
+	return
+}
+
+func TestUDPPacketInfo(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("IP_PKTINFO is only supported on Linux")
+	}
+	svr := &testPacketInfoServer{t: t, addr: "127.0.0.1:9983"}
+	must(Serve(svr, "udp://127.0.0.1:9983", WithPacketInfo(true)))
+}
+
+type testPacketInfoServer struct {
+	*EventServer
+	t    *testing.T
+	addr string
+}
+
+func (t *testPacketInfoServer) OnInitComplete(srv Server) (action Action) {
+	go func() {
+		raddr, err := net.ResolveUDPAddr("udp", t.addr)
+		must(err)
+		conn, err := net.DialUDP("udp", nil, raddr)
+		must(err)
+		defer conn.Close()
+		f, err := conn.File()
+		must(err)
+		// Mark the datagrams with ECT(0), and receive the traffic class of the reply.
+		must(unix.SetsockoptInt(int(f.Fd()), unix.IPPROTO_IP, unix.IP_TOS, 0x2))
+		must(netpoll.SetRecvPacketInfo(int(f.Fd()), false))
+		_ = f.Close()
+		_, err = conn.Write([]byte("info"))
+		must(err)
+		must(conn.SetReadDeadline(time.Now().Add(time.Second)))
+		buf, oob := make([]byte, 64), make([]byte, 256)
+		n, oobn, _, _, err := conn.ReadMsgUDP(buf, oob)
+		must(err)
+		if _, _, tclass := netpoll.ParsePacketInfo(oob[:oobn]); string(buf[:n]) != "info" || tclass&0x3 != 0x1 {
+			panic(fmt.Sprintf("unexpected reply %q with traffic class %d", buf[:n], tclass))
+		}
+		_, err = conn.Write([]byte("shutdown"))
+		must(err)
+	}()
+	return
+}
+
+func (t *testPacketInfoServer) React(c Conn) (out []byte, action Action) {
+	if string(c.Read()) == "shutdown" {
+		action = Shutdown
+		return
+	}
+	info := c.PacketInfo()
+	if info == nil || !info.Dst.Equal(net.IPv4(127, 0, 0, 1)) || info.IfIndex == 0 || info.ECN() != 0x2 {
+		t.t.Fatalf("unexpected information of datagram: %+v", info)
+	}
+	// Answer from the destination address of the request with ECT(1).
+	must(c.SendPacket(c.Read(), nil, &PacketInfo{Dst: info.Dst, TrafficClass: 0x1}))
+	return
+}
+
+func TestMiddleware(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		trace []string
+	)
+	record := func(event string) {
+		mu.Lock()
+		trace = append(trace, event)
+		mu.Unlock()
+	}
+	tracing := func(name string) Middleware {
+		return WithHooks(Hooks{
+			BeforeOpened: func(c Conn) ([]byte, Action) {
+				record(name + ":opened")
+				return nil, None
+			},
+			AfterClosed: func(c Conn, err error, action Action) Action {
+				record(name + ":closed")
+				return action
+			},
+		})
+	}
+	auth := WithHooks(Hooks{
+		BeforeReact: func(c Conn) ([]byte, Action) {
+			if bytes.HasPrefix(c.Read(), []byte("deny")) {
+				c.ResetBuffer()
+				return []byte("denied"), Close
+			}
+			return nil, None
+		},
+		AfterReact: func(c Conn, out []byte, action Action) ([]byte, Action) {
+			if len(out) != 0 {
+				out = append([]byte("> "), out...)
+			}
+			return out, action
+		},
+	})
+	svr := &testMiddlewareServer{addr: "127.0.0.1:9982"}
+	handler := Chain(tracing("outer"), tracing("inner"), auth)(svr)
+	must(Serve(handler, "tcp://"+svr.addr, WithTicker(true)))
+	expected := []string{"outer:opened", "inner:opened", "inner:closed", "outer:closed"}
+	if len(trace) != 2*len(expected) {
+		t.Fatalf("unexpected trace: %v", trace)
+	}
+	for i, event := range trace {
+		if event != expected[i%len(expected)] {
+			t.Fatalf("unexpected trace: %v", trace)
+		}
+	}
+	if svr.reacted != 1 {
+		t.Fatalf("expected 1 reaction of the handler, got %d", svr.reacted)
+	}
+}
+
+type testMiddlewareServer struct {
+	*EventServer
+	addr    string
+	reacted int
+	done    int32
+}
+
+func (t *testMiddlewareServer) OnInitComplete(srv Server) (action Action) {
+	go func() {
+		defer atomic.StoreInt32(&t.done, 1)
+		for _, req := range []string{"hello", "deny"} {
+			conn, err := net.Dial("tcp", t.addr)
+			must(err)
+			_, err = conn.Write([]byte(req))
+			must(err)
+			_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+			resp, err := ioutil.ReadAll(conn)
+			must(err)
+			if req == "hello" && string(resp) != "> hello" {
+				panic(fmt.Sprintf("unexpected response: %q", resp))
+			}
+			if req == "deny" && string(resp) != "denied" {
+				panic(fmt.Sprintf("unexpected response: %q", resp))
+			}
+			_ = conn.Close()
+		}
+		time.Sleep(50 * time.Millisecond)
+	}()
+	return
+}
+
+func (t *testMiddlewareServer) React(c Conn) (out []byte, action Action) {
+	if out = c.Read(); len(out) != 0 {
+		t.reacted++
+	}
+	c.ResetBuffer()
+	action = Close
+	return
+}
+
+func (t *testMiddlewareServer) Tick() (delay time.Duration, action Action) {
+	if atomic.LoadInt32(&t.done) == 1 {
+		action = Shutdown
+	}
+	delay = 10 * time.Millisecond
+	return
+}
+
+func TestEventHandlerV2(t *testing.T) {
+	svr := &testEventHandlerV2Server{addr: "127.0.0.1:9981", connDone: make(chan struct{})}
+	must(Serve(AdaptV2(svr), "tcp://"+svr.addr, WithTicker(true)))
+	select {
+	case <-svr.ctx.Done():
+	default:
+		t.Fatal("context of server should be cancelled once the server shuts down")
+	}
+	if atomic.LoadInt32(&svr.reacted) != 1 {
+		t.Fatalf("expected 1 reaction, got %d", svr.reacted)
+	}
+}
+
+type testEventHandlerV2Server struct {
+	*EventServerV2
+	addr     string
+	ctx      context.Context
+	reacted  int32
+	connDone chan struct{}
+	done     int32
+}
+
+func (t *testEventHandlerV2Server) OnInitComplete(ctx context.Context, srv Server) (action Action) {
+	t.ctx = ctx
+	go func() {
+		defer atomic.StoreInt32(&t.done, 1)
+		conn, err := net.Dial("tcp", t.addr)
+		must(err)
+		_, err = conn.Write([]byte("hello"))
+		must(err)
+		_, err = io.ReadFull(conn, make([]byte, 5))
+		must(err)
+		_ = conn.Close()
+		select {
+		case <-t.connDone:
+		case <-time.After(time.Second):
+			panic("context of connection should be cancelled once the connection is closed")
+		}
+	}()
+	return
+}
+
+func (t *testEventHandlerV2Server) OnOpened(ctx context.Context, c Conn) (out []byte, action Action) {
+	if ctx == t.ctx || ctx.Err() != nil {
+		panic("expected a live context of connection")
+	}
+	go func() {
+		<-ctx.Done()
+		close(t.connDone)
+	}()
+	return
+}
+
+func (t *testEventHandlerV2Server) React(ctx context.Context, c Conn) (out []byte, action Action) {
+	if out = c.Read(); len(out) != 0 {
+		atomic.AddInt32(&t.reacted, 1)
+	}
+	c.ResetBuffer()
+	return
+}
+
+func (t *testEventHandlerV2Server) OnClosed(ctx context.Context, c Conn, err error) (action Action) {
+	if ctx.Err() != nil {
+		panic("context of connection should be cancelled after OnClosed")
+	}
+	return
+}
+
+func (t *testEventHandlerV2Server) Tick(ctx context.Context) (delay time.Duration, action Action) {
+	if atomic.LoadInt32(&t.done) == 1 {
+		action = Shutdown
+	}
+	delay = 10 * time.Millisecond
+	return
+}
+
+func TestFrameEventHandler(t *testing.T) {
+	svr := &testFrameEventServer{addr: "127.0.0.1:9980"}
+	must(Serve(AdaptFrames(svr), "tcp://"+svr.addr, WithCodec(new(LineBasedFrameCodec)), WithTicker(true)))
+	if got := strings.Join(svr.frames, ","); got != "a,b,c,d" {
+		t.Fatalf("unexpected frames: %s", got)
+	}
+}
+
+type testFrameEventServer struct {
+	*EventServer
+	addr   string
+	frames []string
+	done   int32
+}
+
+func (t *testFrameEventServer) OnInitComplete(srv Server) (action Action) {
+	go func() {
+		defer atomic.StoreInt32(&t.done, 1)
+		conn, err := net.Dial("tcp", t.addr)
+		must(err)
+		defer conn.Close()
+		// Only the last frame of every batch is answered, the others are delivered without output.
+		_, err = conn.Write([]byte("a\nb\n\nc\nd\n"))
+		must(err)
+		_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		must(err)
+		if line != "ok\n" {
+			panic(fmt.Sprintf("unexpected response: %q", line))
+		}
+	}()
+	return
+}
+
+func (t *testFrameEventServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	t.frames = append(t.frames, string(frame))
+	if string(frame) == "d" {
+		out = []byte("ok")
+	}
+	return
+}
+
+func (t *testFrameEventServer) Tick() (delay time.Duration, action Action) {
+	if atomic.LoadInt32(&t.done) == 1 {
+		action = Shutdown
+	}
+	delay = 10 * time.Millisecond
+	return
+}
+
+func TestBatchFrameEventHandler(t *testing.T) {
+	svr := &testBatchFrameEventServer{addr: "127.0.0.1:9979"}
+	must(Serve(AdaptBatchFrames(svr), "tcp://"+svr.addr, WithCodec(new(LineBasedFrameCodec)), WithTicker(true)))
+	if len(svr.batches) != 1 || svr.batches[0] != "a,b,c" {
+		t.Fatalf("unexpected batches: %q", svr.batches)
+	}
+}
+
+type testBatchFrameEventServer struct {
+	*EventServer
+	addr    string
+	batches []string
+	done    int32
+}
+
+func (t *testBatchFrameEventServer) OnInitComplete(srv Server) (action Action) {
+	go func() {
+		defer atomic.StoreInt32(&t.done, 1)
+		conn, err := net.Dial("tcp", t.addr)
+		must(err)
+		defer conn.Close()
+		_, err = conn.Write([]byte("a\nb\n\nc\n"))
+		must(err)
+		_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		must(err)
+		if line != "3\n" {
+			panic(fmt.Sprintf("unexpected response: %q", line))
+		}
+	}()
+	return
+}
+
+func (t *testBatchFrameEventServer) OnFrames(c Conn, frames [][]byte) (out []byte, action Action) {
+	batch := make([]string, len(frames))
+	for i, frame := range frames {
+		batch[i] = string(frame)
+	}
+	t.batches = append(t.batches, strings.Join(batch, ","))
+	out = []byte(fmt.Sprint(len(frames)))
+	return
+}
+
+func (t *testBatchFrameEventServer) Tick() (delay time.Duration, action Action) {
+	if atomic.LoadInt32(&t.done) == 1 {
+		action = Shutdown
+	}
+	delay = 10 * time.Millisecond
+	return
+}
+
+func TestAttachments(t *testing.T) {
+	type authKey struct{}
+	type metricsKey struct{}
+	auth := WithHooks(Hooks{
+		BeforeOpened: func(c Conn) ([]byte, Action) {
+			c.Set(authKey{}, "alice")
+			return nil, None
+		},
+	})
+	metrics := WithHooks(Hooks{
+		BeforeReact: func(c Conn) ([]byte, Action) {
+			n, _ := c.Get(metricsKey{}).(int)
+			c.Set(metricsKey{}, n+1)
+			return nil, None
+		},
+		BeforeClosed: func(c Conn, err error) {
+			c.Set(authKey{}, nil)
+			if c.Get(authKey{}) != nil {
+				panic("attachment should be removed")
+			}
+		},
+	})
+	svr := &testAttachmentsServer{addr: "127.0.0.1:9978"}
+	svr.react = func(c Conn) []byte {
+		return []byte(fmt.Sprintf("%v:%v", c.Get(authKey{}), c.Get(metricsKey{})))
+	}
+	must(Serve(Chain(auth, metrics)(svr), "tcp://"+svr.addr, WithTicker(true)))
+}
+
+type testAttachmentsServer struct {
+	*EventServer
+	addr  string
+	react func(c Conn) []byte
+	done  int32
+}
+
+func (t *testAttachmentsServer) OnInitComplete(srv Server) (action Action) {
+	go func() {
+		defer atomic.StoreInt32(&t.done, 1)
+		conn, err := net.Dial("tcp", t.addr)
+		must(err)
+		defer conn.Close()
+		_, err = conn.Write([]byte("hello"))
+		must(err)
+		buf := make([]byte, 7)
+		_, err = io.ReadFull(conn, buf)
+		must(err)
+		if string(buf) != "alice:1" {
+			panic(fmt.Sprintf("unexpected response: %q", buf))
+		}
+	}()
+	return
+}
+
+func (t *testAttachmentsServer) React(c Conn) (out []byte, action Action) {
+	if len(c.Read()) != 0 {
+		out = t.react(c)
+	}
+	c.ResetBuffer()
+	return
+}
+
+func (t *testAttachmentsServer) Tick() (delay time.Duration, action Action) {
+	if atomic.LoadInt32(&t.done) == 1 {
+		action = Shutdown
+	}
+	delay = 10 * time.Millisecond
+	return
+}
+
+func TestTooLongFrame(t *testing.T) {
+	t.Run("event-loop", func(t *testing.T) {
+		testTooLongFrame(t, "127.0.0.1:9977")
+	})
+	t.Run("decode-offload", func(t *testing.T) {
+		testTooLongFrame(t, "127.0.0.1:9976", WithDecodeOffload(true))
+	})
+}
+
+type testTooLongFrameServer struct {
+	*EventServer
+	addr   string
+	frames []string
+	err    error
+	done   int32
+}
+
+func (t *testTooLongFrameServer) OnInitComplete(srv Server) (action Action) {
+	go func() {
+		defer atomic.StoreInt32(&t.done, 1)
+		conn, err := net.Dial("tcp", t.addr)
+		must(err)
+		defer conn.Close()
+		_, err = conn.Write([]byte("ok\n" + strings.Repeat("x", 64)))
+		must(err)
+		_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+		if _, err = conn.Read(make([]byte, 1)); err != io.EOF {
+			panic(fmt.Sprintf("expected the connection to be closed, got %v", err))
+		}
+	}()
+	return
+}
+
+func (t *testTooLongFrameServer) React(c Conn) (out []byte, action Action) {
+	for frame := c.ReadFrame(); frame != nil; frame = c.ReadFrame() {
+		t.frames = append(t.frames, string(frame))
+	}
+	return
+}
+
+func (t *testTooLongFrameServer) OnClosed(c Conn, err error) (action Action) {
+	t.err = err
+	return
+}
+
+func (t *testTooLongFrameServer) Tick() (delay time.Duration, action Action) {
+	if atomic.LoadInt32(&t.done) == 1 {
+		action = Shutdown
+	}
+	delay = 10 * time.Millisecond
+	return
+}
+
+func testTooLongFrame(t *testing.T, addr string, opts ...Option) {
+	svr := &testTooLongFrameServer{addr: addr}
+	codec := &LineBasedFrameCodec{MaxFrameLength: 16}
+	must(Serve(svr, "tcp://"+addr, append(opts, WithCodec(codec), WithTicker(true))...))
+	if len(svr.frames) != 1 || svr.frames[0] != "ok" {
+		t.Fatalf("unexpected frames: %q", svr.frames)
+	}
+	if svr.err != ErrTooLongFrame {
+		t.Fatalf("expected the connection to be closed with ErrTooLongFrame, got %v", svr.err)
+	}
+}
+
+func TestCompressedCodecChain(t *testing.T) {
+	framer := NewLengthFieldBasedFrameCodec(
+		EncoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 4},
+		DecoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 4, InitialBytesToStrip: 4},
+	)
+	stage := NewCompressionStage(CompressionConfig{
+		Negotiate: func(c Conn) Compression { return CompressionGzip },
+	})
+	codec := NewCodecChain(framer, stage)
+	svr := &testCompressedServer{addr: "127.0.0.1:9975", codec: codec}
+	must(Serve(svr, "tcp://"+svr.addr, WithCodec(codec), WithTicker(true)))
+}
+
+type testCompressedServer struct {
+	*EventServer
+	addr  string
+	codec *CodecChain
+	done  int32
+}
+
+func (t *testCompressedServer) OnInitComplete(srv Server) (action Action) {
+	go func() {
+		defer atomic.StoreInt32(&t.done, 1)
+		nc, err := net.Dial("tcp", t.addr)
+		must(err)
+		defer nc.Close()
+		req := bytes.Repeat([]byte("hello "), 100)
+		frame, err := t.codec.Encode(req)
+		must(err)
+		_, err = nc.Write(frame)
+		must(err)
+		header := make([]byte, 4)
+		_, err = io.ReadFull(nc, header)
+		must(err)
+		body := make([]byte, binary.BigEndian.Uint32(header))
+		_, err = io.ReadFull(nc, body)
+		must(err)
+		if Compression(body[0]) != CompressionGzip {
+			panic(fmt.Sprintf("expected the response compressed with gzip, got %d", body[0]))
+		}
+		resp, err := t.codec.Decode(&conn{inboundBuffer: ringbuffer.New(64), cache: append(header, body...)})
+		must(err)
+		if !bytes.Equal(resp, req) {
+			panic("unexpected response")
+		}
+	}()
+	return
+}
+
+func (t *testCompressedServer) React(c Conn) (out []byte, action Action) {
+	out = c.ReadFrame()
+	return
+}
+
+func (t *testCompressedServer) Tick() (delay time.Duration, action Action) {
+	if atomic.LoadInt32(&t.done) == 1 {
+		action = Shutdown
+	}
+	delay = 10 * time.Millisecond
+	return
+}
+
+func TestModbus(t *testing.T) {
+	router := NewModbusRouter()
+	router.Handle(0x03, func(c Conn, req *ModbusADU) *ModbusADU {
+		// Reads the holding registers, whose values are their addresses.
+		addr, count := binary.BigEndian.Uint16(req.Data), binary.BigEndian.Uint16(req.Data[2:])
+		data := []byte{byte(2 * count)}
+		for i := uint16(0); i < count; i++ {
+			data = append(data, byte((addr+i)>>8), byte(addr+i))
+		}
+		return req.Reply(data)
+	})
+	svr := &testModbusServer{addr: "127.0.0.1:9974", router: router}
+	must(Serve(AdaptFrames(svr), "tcp://"+svr.addr, WithCodec(new(ModbusCodec)), WithTicker(true)))
+}
+
+type testModbusServer struct {
+	*EventServer
+	addr   string
+	router *ModbusRouter
+	done   int32
+}
+
+func (t *testModbusServer) OnInitComplete(srv Server) (action Action) {
+	go func() {
+		defer atomic.StoreInt32(&t.done, 1)
+		conn, err := net.Dial("tcp", t.addr)
+		must(err)
+		defer conn.Close()
+		read := &ModbusADU{TransactionID: 1, UnitID: 1, FunctionCode: 0x03, Data: []byte{0, 10, 0, 2}}
+		write := &ModbusADU{TransactionID: 2, UnitID: 1, FunctionCode: 0x10, Data: []byte{0, 10, 0, 1, 2, 0, 1}}
+		_, err = conn.Write(append(read.Marshal(), write.Marshal()...))
+		must(err)
+		readResp := read.Reply([]byte{4, 0, 10, 0, 11}).Marshal()
+		writeResp := write.Exception(ModbusIllegalFunction).Marshal()
+		resp := make([]byte, len(readResp)+len(writeResp))
+		_, err = io.ReadFull(conn, resp)
+		must(err)
+		if !bytes.Equal(resp, append(readResp, writeResp...)) {
+			panic(fmt.Sprintf("unexpected response: %x", resp))
+		}
+	}()
+	return
+}
+
+func (t *testModbusServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	out, err := t.router.Route(c, frame)
+	if err != nil {
+		action = Close
+	}
+	return
+}
+
+func (t *testModbusServer) Tick() (delay time.Duration, action Action) {
+	if atomic.LoadInt32(&t.done) == 1 {
+		action = Shutdown
+	}
+	delay = 10 * time.Millisecond
+	return
+}
+
+func TestDNS(t *testing.T) {
+	t.Run("tcp", func(t *testing.T) {
+		testDNS(t, "tcp", "127.0.0.1:9973")
+	})
+	t.Run("udp", func(t *testing.T) {
+		testDNS(t, "udp", "127.0.0.1:9972")
+	})
+}
+
+// testDNSQuery makes a query of A records, with the OPT record advertising the payload size if it's positive.
+func testDNSQuery(id uint16, payloadSize int) []byte {
+	msg := []byte{byte(id >> 8), byte(id), 1, 0, 0, 1, 0, 0, 0, 0, 0, 0}
+	msg = append(msg, "\x01a\x07example\x00\x00\x01\x00\x01"...)
+	if payloadSize > 0 {
+		msg[11] = 1
+		msg = append(msg, 0, 0, 41, byte(payloadSize>>8), byte(payloadSize), 0, 0, 0, 0, 0, 0)
+	}
+	return msg
+}
+
+type testDNSServer struct {
+	*EventServer
+	network string
+	addr    string
+	done    int32
+}
+
+func (t *testDNSServer) OnInitComplete(srv Server) (action Action) {
+	go func() {
+		defer atomic.StoreInt32(&t.done, 1)
+		conn, err := net.Dial(t.network, t.addr)
+		must(err)
+		defer conn.Close()
+		exchange := func(req []byte) []byte {
+			if t.network == "tcp" {
+				req, _ = new(DNSCodec).Encode(req)
+			}
+			_, err := conn.Write(req)
+			must(err)
+			_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+			if t.network == "tcp" {
+				prefix := make([]byte, 2)
+				_, err = io.ReadFull(conn, prefix)
+				must(err)
+				resp := make([]byte, binary.BigEndian.Uint16(prefix))
+				_, err = io.ReadFull(conn, resp)
+				must(err)
+				return resp
+			}
+			resp := make([]byte, 0xFFFF)
+			n, err := conn.Read(resp)
+			must(err)
+			return resp[:n]
+		}
+		resp := exchange(testDNSQuery(1, 0))
+		if truncated := t.network == "udp"; DNSTruncated(resp) != truncated {
+			panic(fmt.Sprintf("expected the truncated flag to be %t over %s", truncated, t.network))
+		}
+		if t.network == "udp" && (len(resp) != len(testDNSQuery(1, 0)) || resp[7] != 0) {
+			panic(fmt.Sprintf("unexpected truncated response: %x", resp))
+		}
+		if resp = exchange(testDNSQuery(2, 4096)); DNSTruncated(resp) || resp[1] != 2 {
+			panic("expected the response fitting in the advertised payload size")
+		}
+	}()
+	return
+}
+
+func (t *testDNSServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	// Answer with a record of 600 bytes, which doesn't fit in a datagram of 512 bytes.
+	resp := append([]byte(nil), frame...)
+	resp[2] |= 0x80
+	resp[7] = 1
+	resp = append(resp[:len(testDNSQuery(0, 0))], 0xC0, 12, 0, 1, 0, 1, 0, 0, 0, 60, 600>>8, 600&0xFF)
+	resp = append(resp, make([]byte, 600)...)
+	out = DNSFitResponse(c, frame, resp)
+	return
+}
+
+func (t *testDNSServer) Tick() (delay time.Duration, action Action) {
+	if atomic.LoadInt32(&t.done) == 1 {
+		action = Shutdown
+	}
+	delay = 10 * time.Millisecond
+	return
+}
+
+func testDNS(t *testing.T, network, addr string) {
+	svr := &testDNSServer{network: network, addr: addr}
+	must(Serve(AdaptFrames(svr), network+"://"+addr, WithCodec(new(DNSCodec)), WithTicker(true)))
+}
+
+func TestMirror(t *testing.T) {
+	records := make(chan *MirrorRecord, 8)
+	svr := &testMirrorServer{addr: "127.0.0.1:9971", records: records}
+	mirror := &Mirror{
+		Select: func(c Conn) MirrorDirection {
+			return MirrorOutbound
+		},
+		Sink: func(rec *MirrorRecord) {
+			records <- rec
+		},
+	}
+	must(Serve(svr, "tcp://"+svr.addr, WithTicker(true), WithMirror(mirror)))
+	if mirror.Dropped() != 0 {
+		t.Fatalf("expected no records dropped, got %d", mirror.Dropped())
+	}
+}
+
+type testMirrorServer struct {
+	*EventServer
+	addr    string
+	records chan *MirrorRecord
+	done    int32
+}
+
+func (t *testMirrorServer) OnInitComplete(srv Server) (action Action) {
+	go func() {
+		defer atomic.StoreInt32(&t.done, 1)
+		conn, err := net.Dial("tcp", t.addr)
+		must(err)
+		defer conn.Close()
+		_, err = conn.Write([]byte("hello"))
+		must(err)
+		buf := make([]byte, 11)
+		_, err = io.ReadFull(conn, buf)
+		must(err)
+		// Only the outbound data is mirrored as selected.
+		select {
+		case rec := <-t.records:
+			if rec.Direction != MirrorOutbound || string(rec.Data) != "echo: hello" ||
+				rec.RemoteAddr.String() != conn.LocalAddr().String() {
+				panic(fmt.Sprintf("unexpected record: %+v", rec))
+			}
+		case <-time.After(time.Second):
+			panic("timeout waiting for the mirrored record")
+		}
+	}()
+	return
+}
+
+func (t *testMirrorServer) React(c Conn) (out []byte, action Action) {
+	if data := c.Read(); len(data) != 0 {
+		out = append([]byte("echo: "), data...)
+	}
+	c.ResetBuffer()
+	return
+}
+
+func (t *testMirrorServer) Tick() (delay time.Duration, action Action) {
+	if atomic.LoadInt32(&t.done) == 1 {
+		if len(t.records) != 0 {
+			panic("unexpected records of the inbound data")
+		}
+		action = Shutdown
+	}
+	delay = 10 * time.Millisecond
+	return
+}
+
+func TestApplyOptions(t *testing.T) {
+	svr := &testApplyOptionsServer{addr: "127.0.0.1:9970"}
+	must(Serve(svr, "tcp://"+svr.addr, WithTicker(true)))
+}
+
+type testApplyOptionsServer struct {
+	*EventServer
+	addr string
+	done int32
+}
+
+func (t *testApplyOptionsServer) OnInitComplete(srv Server) (action Action) {
+	go func() {
+		defer atomic.StoreInt32(&t.done, 1)
+		echo := func() error {
+			conn, err := net.Dial("tcp", t.addr)
+			must(err)
+			defer conn.Close()
+			_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+			_, err = conn.Write([]byte("hello"))
+			must(err)
+			_, err = io.ReadFull(conn, make([]byte, 5))
+			return err
+		}
+		must(echo())
+		must(srv.ApplyOptions(WithConnectionFilter(func(remote net.Addr) bool {
+			return false
+		})))
+		if err := echo(); err == nil {
+			panic("expected the connection rejected by the filter applied")
+		}
+		if filtered := srv.AcceptStats().Filtered; filtered != 1 {
+			panic(fmt.Sprintf("expected a connection filtered, got %d", filtered))
+		}
+		must(srv.ApplyOptions(WithConnectionFilter(nil)))
+		must(echo())
+
+		if err := srv.ApplyOptions(WithMaxConnsPerHost(1), WithMulticore(true)); err != ErrUnreloadableOption {
+			panic(fmt.Sprintf("expected ErrUnreloadableOption, got '%v'", err))
+		}
+		must(srv.ApplyOptions(WithMaxConnsPerHost(1)))
+		conn, err := net.Dial("tcp", t.addr)
+		must(err)
+		defer conn.Close()
+		_, err = conn.Write([]byte("hello"))
+		must(err)
+		_, err = io.ReadFull(conn, make([]byte, 5))
+		must(err)
+		if err := echo(); err == nil {
+			panic("expected the connection rejected by the limit applied")
+		}
+		must(srv.ApplyOptions(WithMaxConnsPerHost(0)))
+		must(echo())
+	}()
+	return
+}
+
+func (t *testApplyOptionsServer) React(c Conn) (out []byte, action Action) {
+	out = c.Read()
+	c.ResetBuffer()
+	return
+}
+
+func (t *testApplyOptionsServer) Tick() (delay time.Duration, action Action) {
+	if atomic.LoadInt32(&t.done) == 1 {
+		action = Shutdown
+	}
+	delay = 10 * time.Millisecond
+	return
+}
+
+func TestServerStats(t *testing.T) {
+	svr := &testServerStatsServer{addr: "127.0.0.1:9969"}
+	must(Serve(svr, "tcp://"+svr.addr, WithTicker(true), WithMulticore(true)))
+}
+
+type testServerStatsServer struct {
+	*EventServer
+	addr string
+	done int32
+}
+
+func (t *testServerStatsServer) OnInitComplete(srv Server) (action Action) {
+	go func() {
+		defer atomic.StoreInt32(&t.done, 1)
+		conn, err := net.Dial("tcp", t.addr)
+		must(err)
+		defer conn.Close()
+		_, err = conn.Write([]byte("hello"))
+		must(err)
+		_, err = io.ReadFull(conn, make([]byte, 5))
+		must(err)
+
+		var total LoopStats
+		for _, stats := range srv.Stats() {
+			total.Connections += stats.Connections
+			total.PollIterations += stats.PollIterations
+			total.BytesRead += stats.BytesRead
+			total.BytesWritten += stats.BytesWritten
+		}
+		if total.Connections != 1 || total.PollIterations == 0 || total.BytesRead != 5 || total.BytesWritten != 5 {
+			panic(fmt.Sprintf("unexpected stats: %+v", total))
+		}
+		var dump bytes.Buffer
+		must(srv.DumpConns(&dump))
+		if lines := strings.Count(dump.String(), "\n"); lines != 1 ||
+			!strings.Contains(dump.String(), "remote="+conn.LocalAddr().String()) {
+			panic(fmt.Sprintf("unexpected dump: %q", dump.String()))
+		}
+	}()
+	return
+}
+
+func (t *testServerStatsServer) React(c Conn) (out []byte, action Action) {
+	out = c.Read()
+	c.ResetBuffer()
+	return
+}
+
+func (t *testServerStatsServer) Tick() (delay time.Duration, action Action) {
+	if atomic.LoadInt32(&t.done) == 1 {
+		action = Shutdown
+	}
+	delay = 10 * time.Millisecond
+	return
+}
+
+func TestTracer(t *testing.T) {
+	tracer := new(testTracer)
+	svr := &testTracerServer{addr: "127.0.0.1:9968", tracer: tracer}
+	must(Serve(svr, "tcp://"+svr.addr, WithTicker(true), WithTracer(tracer)))
+}
+
+type testTracer struct {
+	mu    sync.Mutex
+	spans []*testSpan
+}
+
+type testSpan struct {
+	name  string
+	attrs map[string]interface{}
+	ended bool
+}
+
+type testSpanKey struct{}
+
+func (t *testTracer) Start(ctx context.Context, name string, attrs ...TraceAttribute) (context.Context, Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	span := &testSpan{name: name, attrs: make(map[string]interface{})}
+	t.spans = append(t.spans, span)
+	t.set(span, attrs)
+	return context.WithValue(ctx, testSpanKey{}, span), &testSpanHandle{t, span}
+}
+
+func (t *testTracer) set(span *testSpan, attrs []TraceAttribute) {
+	for _, attr := range attrs {
+		span.attrs[attr.Key] = attr.Value
+	}
+}
+
+// sum returns the number of ended spans named name and the sum of their attribute key.
+func (t *testTracer) sum(name, key string) (spans, sum int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, span := range t.spans {
+		if span.name == name && span.ended {
+			spans++
+			n, _ := span.attrs[key].(int)
+			sum += n
+		}
+	}
+	return
+}
+
+type testSpanHandle struct {
+	tracer *testTracer
+	span   *testSpan
+}
+
+func (h *testSpanHandle) SetAttributes(attrs ...TraceAttribute) {
+	h.tracer.mu.Lock()
+	defer h.tracer.mu.Unlock()
+	h.tracer.set(h.span, attrs)
+}
+
+func (h *testSpanHandle) End(err error) {
+	h.tracer.mu.Lock()
+	defer h.tracer.mu.Unlock()
+	h.span.ended = true
+}
+
+type testTracerServer struct {
+	*EventServer
+	addr   string
+	tracer *testTracer
+	done   int32
+}
+
+func (t *testTracerServer) OnInitComplete(srv Server) (action Action) {
+	go func() {
+		defer atomic.StoreInt32(&t.done, 1)
+		conn, err := net.Dial("tcp", t.addr)
+		must(err)
+		defer conn.Close()
+		_, err = conn.Write([]byte("hello"))
+		must(err)
+		// The output is too large to be written at once, the rest is flushed later.
+		_, err = io.ReadFull(conn, make([]byte, 16<<20))
+		must(err)
+		if spans, _ := t.tracer.sum(SpanAccept, AttrOutboundBytes); spans != 1 {
+			panic(fmt.Sprintf("expected a span of accept, got %d", spans))
+		}
+		// React fires again after the output, with the buffer consumed.
+		if spans, inbound := t.tracer.sum(SpanReact, AttrInboundBytes); spans != 2 || inbound != 5 {
+			panic(fmt.Sprintf("unexpected spans of React: %d, %d bytes", spans, inbound))
+		}
+		for {
+			// The last span of flush ends after the data has been written.
+			spans, flushed := t.tracer.sum(SpanFlush, AttrOutboundBytes)
+			if spans > 0 && flushed > 0 && flushed < 16<<20 {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	return
+}
+
+func (t *testTracerServer) React(c Conn) (out []byte, action Action) {
+	if TraceContext(c).Value(testSpanKey{}).(*testSpan).name != SpanReact {
+		panic("expected the span of React in the trace context")
+	}
+	if c.BufferLength() != 0 {
+		c.ResetBuffer()
+		out = make([]byte, 16<<20)
+	}
+	return
+}
+
+func (t *testTracerServer) Tick() (delay time.Duration, action Action) {
+	if atomic.LoadInt32(&t.done) == 1 {
+		action = Shutdown
+	}
+	delay = 10 * time.Millisecond
+	return
+}
+
+func TestLoopWatchdog(t *testing.T) {
+	stalls := make(chan []byte, 8)
+	svr := &testWatchdogServer{addr: "127.0.0.1:9967", stalls: stalls}
+	must(Serve(svr, "tcp://"+svr.addr, WithTicker(true),
+		WithLoopWatchdog(20*time.Millisecond, func(loopIdx int, d time.Duration, stack []byte) {
+			if loopIdx != 0 || d <= 20*time.Millisecond {
+				panic(fmt.Sprintf("unexpected stall of loop %d for %s", loopIdx, d))
+			}
+			stalls <- stack
+		})))
+}
+
+type testWatchdogServer struct {
+	*EventServer
+	addr   string
+	stalls chan []byte
+	done   int32
+}
+
+func (t *testWatchdogServer) OnInitComplete(srv Server) (action Action) {
+	go func() {
+		defer atomic.StoreInt32(&t.done, 1)
+		conn, err := net.Dial("tcp", t.addr)
+		must(err)
+		defer conn.Close()
+		_, err = conn.Write([]byte("block"))
+		must(err)
+		_, err = io.ReadFull(conn, make([]byte, 5))
+		must(err)
+		select {
+		case stack := <-t.stalls:
+			if !bytes.Contains(stack, []byte("testWatchdogServer).React")) {
+				panic(fmt.Sprintf("expected the stack blocked in React, got %s", stack))
+			}
+		case <-time.After(time.Second):
+			panic("timeout waiting for the stall")
+		}
+		// The stall is reported once for the blocked iteration.
+		time.Sleep(50 * time.Millisecond)
+		if len(t.stalls) != 0 {
+			panic("expected a single stall")
+		}
+	}()
+	return
+}
+
+func (t *testWatchdogServer) React(c Conn) (out []byte, action Action) {
+	if out = c.Read(); len(out) != 0 {
+		time.Sleep(100 * time.Millisecond)
+	}
+	c.ResetBuffer()
+	return
+}
+
+func (t *testWatchdogServer) Tick() (delay time.Duration, action Action) {
+	if atomic.LoadInt32(&t.done) == 1 {
+		action = Shutdown
+	}
+	delay = 10 * time.Millisecond
+	return
+}
+
+func TestLeakDetector(t *testing.T) {
+	leaks := make(chan LeakInfo, 1)
+	svr := &testLeakServer{addr: "127.0.0.1:9966", closed: make(chan error, 1)}
+	must(Serve(svr, "tcp://"+svr.addr, WithTicker(true),
+		WithLeakDetector(50*time.Millisecond, func(c Conn, info LeakInfo) Action {
+			leaks <- info
+			return Close
+		})))
+	info := <-leaks
+	if info.LastEvent != LeakEventWrite || info.OpenedAt.IsZero() || info.LastEventAt.Before(info.OpenedAt) {
+		t.Fatalf("unexpected diagnostics: %+v", info)
+	}
+}
+
+type testLeakServer struct {
+	*EventServer
+	addr   string
+	closed chan error
+	done   int32
+}
+
+func (t *testLeakServer) OnInitComplete(srv Server) (action Action) {
+	go func() {
+		defer atomic.StoreInt32(&t.done, 1)
+		conn, err := net.Dial("tcp", t.addr)
+		must(err)
+		defer conn.Close()
+		_, err = conn.Write([]byte("hello"))
+		must(err)
+		_, err = io.ReadFull(conn, make([]byte, 5))
+		must(err)
+		// The connection idle since the echo is closed as leaked.
+		_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+		if _, err = conn.Read(make([]byte, 1)); err != io.EOF {
+			panic(fmt.Sprintf("expected the connection closed, got %v", err))
+		}
+		if err = <-t.closed; err != ErrConnectionLeaked {
+			panic(fmt.Sprintf("expected ErrConnectionLeaked, got %v", err))
+		}
+	}()
+	return
+}
+
+func (t *testLeakServer) OnClosed(c Conn, err error) (action Action) {
+	t.closed <- err
+	return
+}
+
+func (t *testLeakServer) React(c Conn) (out []byte, action Action) {
+	out = c.Read()
+	c.ResetBuffer()
+	return
+}
+
+func (t *testLeakServer) Tick() (delay time.Duration, action Action) {
+	if atomic.LoadInt32(&t.done) == 1 {
+		action = Shutdown
+	}
+	delay = 10 * time.Millisecond
+	return
+}
+
+func TestHandshakeTimeout(t *testing.T) {
+	svr := &testHandshakeServer{addr: "127.0.0.1:9965", closed: make(chan error, 2)}
+	must(Serve(AdaptFrames(svr), "tcp://"+svr.addr, WithTicker(true), WithCodec(new(LineBasedFrameCodec)),
+		WithHandshakeTimeout(50*time.Millisecond)))
+}
+
+type testHandshakeServer struct {
+	*EventServer
+	addr   string
+	closed chan error
+	done   int32
+}
+
+func (t *testHandshakeServer) OnInitComplete(srv Server) (action Action) {
+	go func() {
+		defer atomic.StoreInt32(&t.done, 1)
+		slow, err := net.Dial("tcp", t.addr)
+		must(err)
+		defer slow.Close()
+		conn, err := net.Dial("tcp", t.addr)
+		must(err)
+		defer conn.Close()
+		_, err = slow.Write([]byte("hel"))
+		must(err)
+		_, err = conn.Write([]byte("hello\n"))
+		must(err)
+		r := bufio.NewReader(conn)
+		_, err = r.ReadString('\n')
+		must(err)
+
+		// The connection trickling a partial frame is closed, while the other one outlives the timeout.
+		_ = slow.SetReadDeadline(time.Now().Add(time.Second))
+		if _, err = slow.Read(make([]byte, 1)); err != io.EOF {
+			panic(fmt.Sprintf("expected the connection closed, got %v", err))
+		}
+		if err = <-t.closed; err != ErrHandshakeTimeout {
+			panic(fmt.Sprintf("expected ErrHandshakeTimeout, got %v", err))
+		}
+		time.Sleep(50 * time.Millisecond)
+		_, err = conn.Write([]byte("again\n"))
+		must(err)
+		_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+		if line, err := r.ReadString('\n'); err != nil || line != "again\n" {
+			panic(fmt.Sprintf("unexpected echo: %q, %v", line, err))
+		}
+	}()
+	return
+}
+
+func (t *testHandshakeServer) OnClosed(c Conn, err error) (action Action) {
+	t.closed <- err
+	return
+}
+
+func (t *testHandshakeServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	out = frame
+	return
+}
+
+func (t *testHandshakeServer) Tick() (delay time.Duration, action Action) {
+	if atomic.LoadInt32(&t.done) == 1 {
+		action = Shutdown
+	}
+	delay = 10 * time.Millisecond
+	return
+}
+
+func TestMaxConnsPerHost(t *testing.T) {
+	svr := &testMaxConnsPerHostServer{addr: "127.0.0.1:9964"}
+	must(Serve(svr, "tcp://"+svr.addr, WithTicker(true), WithMaxConnsPerHost(1)))
+}
+
+func TestHostKey(t *testing.T) {
+	svr := &server{opts: &Options{MaxConnsPerHost: 1, AggregateIPv6Hosts: true}}
+	a := &unix.SockaddrInet6{Addr: [16]byte{0x20, 0x01, 0x0d, 0xb8, 15: 1}}
+	b := &unix.SockaddrInet6{Addr: [16]byte{0x20, 0x01, 0x0d, 0xb8, 8: 0xff, 15: 2}}
+	c := &unix.SockaddrInet6{Addr: [16]byte{0x20, 0x01, 0x0d, 0xb8, 7: 1, 15: 1}}
+	if svr.hostKey(a) != svr.hostKey(b) || svr.hostKey(a) == svr.hostKey(c) {
+		t.Fatal("expected the IPv6 hosts aggregated by /64")
+	}
+	mapped := &unix.SockaddrInet6{Addr: [16]byte{10: 0xff, 11: 0xff, 12: 127, 15: 1}}
+	if svr.hostKey(mapped) != svr.hostKey(&unix.SockaddrInet4{Addr: [4]byte{127, 0, 0, 1}}) {
+		t.Fatal("expected the IPv4-mapped address counted as IPv4")
+	}
+	svr.opts.AggregateIPv6Hosts = false
+	if svr.hostKey(a) == svr.hostKey(b) {
+		t.Fatal("expected the IPv6 hosts counted separately")
+	}
+}
+
+type testMaxConnsPerHostServer struct {
+	*EventServer
+	addr    string
+	limited int32
+	done    int32
+}
+
+func (t *testMaxConnsPerHostServer) OnAcceptError(err error) (action Action) {
+	if err == ErrTooManyConnections {
+		atomic.AddInt32(&t.limited, 1)
+	}
+	return
+}
+
+func (t *testMaxConnsPerHostServer) OnInitComplete(srv Server) (action Action) {
+	go func() {
+		defer atomic.StoreInt32(&t.done, 1)
+		echo := func(conn net.Conn) error {
+			_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+			if _, err := conn.Write([]byte("hello")); err != nil {
+				return err
+			}
+			_, err := io.ReadFull(conn, make([]byte, 5))
+			return err
+		}
+		first, err := net.Dial("tcp", t.addr)
+		must(err)
+		must(echo(first))
+		second, err := net.Dial("tcp", t.addr)
+		must(err)
+		defer second.Close()
+		if err = echo(second); err == nil {
+			panic("expected the second connection from the host rejected")
+		}
+		if limited := srv.AcceptStats().Limited; limited != 1 || atomic.LoadInt32(&t.limited) != 1 {
+			panic(fmt.Sprintf("expected a connection limited, got %d", limited))
+		}
+		// The host is counted out once its connection is closed.
+		must(first.Close())
+		for i := 0; ; i++ {
+			third, err := net.Dial("tcp", t.addr)
+			must(err)
+			err = echo(third)
+			_ = third.Close()
+			if err == nil {
+				break
+			}
+			if i == 100 {
+				panic("expected the connection accepted after the first one is closed")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+	return
+}
+
+func (t *testMaxConnsPerHostServer) React(c Conn) (out []byte, action Action) {
+	out = c.Read()
+	c.ResetBuffer()
+	return
+}
+
+func (t *testMaxConnsPerHostServer) Tick() (delay time.Duration, action Action) {
+	if atomic.LoadInt32(&t.done) == 1 {
+		action = Shutdown
+	}
+	delay = 10 * time.Millisecond
+	return
+}
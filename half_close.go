@@ -0,0 +1,77 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build darwin netbsd freebsd openbsd dragonfly linux
+
+package gnet
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+func (c *conn) CloseWrite() {
+	if c.loop != nil {
+		sniffError(c.loop.poller.Trigger(func() error {
+			return c.loop.loopCloseWrite(c)
+		}))
+	}
+}
+
+// loopReadClosed fires OnReadClosed when the peer has closed its writing side, the connection is kept open for
+// writing if the action is None, otherwise it's closed with ErrConnectionClosed.
+func (lp *loop) loopReadClosed(c *conn) error {
+	out, action := lp.svr.eventHandler.OnReadClosed(c)
+	if !c.opened {
+		return nil // detached by OnReadClosed.
+	}
+	if len(out) != 0 {
+		if frame, err := lp.svr.encode(c, out); err == nil {
+			c.write(frame)
+		}
+		if !c.opened {
+			return nil // closed by the failure of writing.
+		}
+	}
+	switch action {
+	case None:
+	case Shutdown:
+		return ErrServerShutdown
+	default:
+		return lp.loopCloseConn(c, ErrConnectionClosed)
+	}
+	c.readClosed = true
+	if c.outboundEmpty() {
+		lp.unwatchWrite(c)
+		if c.writeClosed && len(c.sources) == 0 {
+			return lp.loopShutdownWrite(c)
+		}
+		return nil
+	}
+	lp.watchWrite(c)
+	return nil
+}
+
+func (lp *loop) loopCloseWrite(c *conn) error {
+	if lp.connections.get(c.fd) != c || c.writeClosed {
+		return nil // ignore stale closes.
+	}
+	c.writeClosed = true
+	if c.outboundEmpty() && len(c.sources) == 0 {
+		return lp.loopShutdownWrite(c)
+	}
+	return nil // shut down by loopOut once the outbound buffer is drained.
+}
+
+// loopShutdownWrite sends FIN to the peer, the connection is closed if its reading side has been closed as well.
+func (lp *loop) loopShutdownWrite(c *conn) error {
+	if err := unix.Shutdown(c.fd, unix.SHUT_WR); err != nil {
+		return lp.loopCloseConn(c, os.NewSyscallError("shutdown", err))
+	}
+	if c.readClosed {
+		return lp.loopCloseConn(c, nil)
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build darwin netbsd freebsd openbsd dragonfly linux
+
+package gnet
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// EventHandlerV2 is the variant of EventHandler whose callbacks receive a context.Context, which is the context
+// of server for the server events, and the context of connection for the connection events. The context of server
+// is cancelled when the server shuts down, the context of connection is derived from it and cancelled as well once
+// the connection is closed, i.e. after OnClosed returns, or detached. The connections of datagrams receive the
+// context of server. Use AdaptV2 for serving it.
+type EventHandlerV2 interface {
+	// OnInitComplete fires when the server is ready for accepting connections.
+	OnInitComplete(ctx context.Context, server Server) (action Action)
+
+	// OnAccept fires when a connection has been accepted, before any resources are allocated for it and OnOpened.
+	OnAccept(ctx context.Context, remote net.Addr) (action Action, reason []byte)
+
+	// OnOpened fires when a new connection has been opened.
+	OnOpened(ctx context.Context, c Conn) (out []byte, action Action)
+
+	// OnReadClosed fires when the peer has closed its writing side by sending FIN.
+	OnReadClosed(ctx context.Context, c Conn) (out []byte, action Action)
+
+	// OnClosed fires when a connection has been closed, the context of connection is cancelled after it returns.
+	OnClosed(ctx context.Context, c Conn, err error) (action Action)
+
+	// PreWrite fires just before any data is written to any client socket.
+	PreWrite(ctx context.Context)
+
+	// React fires when a connection sends the server data.
+	React(ctx context.Context, c Conn) (out []byte, action Action)
+
+	// Tick fires immediately after the server starts and will fire again following the returned delay.
+	Tick(ctx context.Context) (delay time.Duration, action Action)
+
+	// OnMemoryPressure fires when the memory usage of connection buffers exceeds the limit set by WithMemoryLimit.
+	OnMemoryPressure(ctx context.Context, usage, limit int64)
+
+	// OnHeartbeatTimeout fires when the connection has been idle for the heartbeat timeout set by WithHeartbeat.
+	OnHeartbeatTimeout(ctx context.Context, c Conn)
+
+	// OnAcceptError fires when accepting fails by reason of running out of file descriptors or memory, or with
+	// ErrTooManyConnections when a connection is closed by the limit set by WithMaxConnsPerHost.
+	OnAcceptError(ctx context.Context, err error) (action Action)
+}
+
+// EventServerV2 is a built-in implementation of EventHandlerV2 which sets up each method with the same default
+// implementation as EventServer, compose it with your own implementation of EventHandlerV2 when you don't want to
+// implement all methods.
+type EventServerV2 struct {
+}
+
+// OnInitComplete fires when the server is ready for accepting connections.
+func (es *EventServerV2) OnInitComplete(ctx context.Context, svr Server) (action Action) {
+	return
+}
+
+// OnAccept fires when a connection has been accepted, before any resources are allocated for it and OnOpened.
+func (es *EventServerV2) OnAccept(ctx context.Context, remote net.Addr) (action Action, reason []byte) {
+	return
+}
+
+// OnOpened fires when a new connection has been opened.
+func (es *EventServerV2) OnOpened(ctx context.Context, c Conn) (out []byte, action Action) {
+	return
+}
+
+// OnReadClosed fires when the peer has closed its writing side by sending FIN, the connection is closed by default.
+func (es *EventServerV2) OnReadClosed(ctx context.Context, c Conn) (out []byte, action Action) {
+	action = Close
+	return
+}
+
+// OnClosed fires when a connection has been closed.
+func (es *EventServerV2) OnClosed(ctx context.Context, c Conn, err error) (action Action) {
+	return
+}
+
+// PreWrite fires just before any data is written to any client socket.
+func (es *EventServerV2) PreWrite(ctx context.Context) {
+}
+
+// React fires when a connection sends the server data.
+func (es *EventServerV2) React(ctx context.Context, c Conn) (out []byte, action Action) {
+	return
+}
+
+// Tick fires immediately after the server starts and will fire again following the returned delay.
+func (es *EventServerV2) Tick(ctx context.Context) (delay time.Duration, action Action) {
+	return
+}
+
+// OnMemoryPressure fires when the memory usage of connection buffers exceeds the limit set by WithMemoryLimit.
+func (es *EventServerV2) OnMemoryPressure(ctx context.Context, usage, limit int64) {
+}
+
+// OnHeartbeatTimeout fires when the connection has been idle for the heartbeat timeout set by WithHeartbeat.
+func (es *EventServerV2) OnHeartbeatTimeout(ctx context.Context, c Conn) {
+}
+
+// OnAcceptError fires when accepting fails by reason of running out of file descriptors or memory, or with
+// ErrTooManyConnections when a connection is closed by the limit set by WithMaxConnsPerHost.
+func (es *EventServerV2) OnAcceptError(ctx context.Context, err error) (action Action) {
+	return
+}
+
+// AdaptV2 adapts the EventHandlerV2 to EventHandler, so that it can be served by Serve and wrapped by middlewares.
+func AdaptV2(handler EventHandlerV2) EventHandler {
+	return &v2Adapter{handler: handler, ctx: context.Background()}
+}
+
+type v2Adapter struct {
+	handler EventHandlerV2
+	ctx     context.Context // context of server, set by OnInitComplete before any other events
+}
+
+// connContext returns the context of the given connection.
+func (a *v2Adapter) connContext(c Conn) context.Context {
+	if cc, ok := c.(*conn); ok {
+		if ctx := cc.closeContext(); ctx != nil {
+			return ctx
+		}
+	}
+	return a.ctx
+}
+
+func (a *v2Adapter) OnInitComplete(server Server) (action Action) {
+	if server.svr != nil {
+		a.ctx = server.svr.ctx
+	}
+	return a.handler.OnInitComplete(a.ctx, server)
+}
+
+func (a *v2Adapter) OnAccept(remote net.Addr) (action Action, reason []byte) {
+	return a.handler.OnAccept(a.ctx, remote)
+}
+
+func (a *v2Adapter) OnOpened(c Conn) (out []byte, action Action) {
+	return a.handler.OnOpened(a.connContext(c), c)
+}
+
+func (a *v2Adapter) OnReadClosed(c Conn) (out []byte, action Action) {
+	return a.handler.OnReadClosed(a.connContext(c), c)
+}
+
+func (a *v2Adapter) OnClosed(c Conn, err error) (action Action) {
+	return a.handler.OnClosed(a.connContext(c), c, err)
+}
+
+func (a *v2Adapter) PreWrite() {
+	a.handler.PreWrite(a.ctx)
+}
+
+func (a *v2Adapter) React(c Conn) (out []byte, action Action) {
+	return a.handler.React(a.connContext(c), c)
+}
+
+func (a *v2Adapter) Tick() (delay time.Duration, action Action) {
+	return a.handler.Tick(a.ctx)
+}
+
+func (a *v2Adapter) OnMemoryPressure(usage, limit int64) {
+	a.handler.OnMemoryPressure(a.ctx, usage, limit)
+}
+
+func (a *v2Adapter) OnHeartbeatTimeout(c Conn) {
+	a.handler.OnHeartbeatTimeout(a.connContext(c), c)
+}
+
+func (a *v2Adapter) OnAcceptError(err error) (action Action) {
+	return a.handler.OnAcceptError(a.ctx, err)
+}
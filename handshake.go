@@ -0,0 +1,30 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build darwin netbsd freebsd openbsd dragonfly linux
+
+package gnet
+
+// CompleteHandshake marks the handshake of connection as completed, so that it isn't closed by the timeout set by
+// WithHandshakeTimeout. It's only needed by the handlers parsing the inbound data without ReadFrame, e.g. a PROXY
+// protocol header or a greeting of their own, since the first frame returned by ReadFrame completes it.
+func CompleteHandshake(c Conn) {
+	if cc, ok := c.(*conn); ok {
+		cc.handshaken = true
+	}
+}
+
+// startHandshakeTimer starts the timer closing the connection whose handshake isn't completed in time, if the
+// handshake timeout is enabled.
+func (lp *loop) startHandshakeTimer(c *conn) {
+	if lp.svr.opts.HandshakeTimeout <= 0 {
+		return
+	}
+	lp.addConnTimer(&Timer{lp: lp, c: c}, lp.svr.opts.HandshakeTimeout, func(c Conn) error {
+		if c.(*conn).handshaken {
+			return nil
+		}
+		return ErrHandshakeTimeout
+	})
+}
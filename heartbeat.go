@@ -0,0 +1,48 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build darwin netbsd freebsd openbsd dragonfly linux
+
+package gnet
+
+// startHeartbeat starts checking the idleness of connection if the heartbeat is enabled.
+func (lp *loop) startHeartbeat(c *conn) {
+	if lp.svr.opts.HeartbeatInterval <= 0 {
+		return
+	}
+	c.lastActive = lp.now
+	lp.addConnTimer(&Timer{lp: lp, c: c}, lp.svr.opts.HeartbeatInterval, lp.heartbeat)
+}
+
+// heartbeat pings the connection idle for the interval and closes the connection idle for the timeout,
+// then it checks the connection again once the connection could become idle for the interval.
+func (lp *loop) heartbeat(c Conn) error {
+	cc := c.(*conn)
+	interval, timeout := lp.svr.opts.HeartbeatInterval, lp.svr.opts.HeartbeatTimeout
+	if timeout <= 0 {
+		timeout = 3 * interval
+	}
+	idle := lp.now.Sub(cc.lastActive)
+	if idle >= timeout {
+		lp.svr.eventHandler.OnHeartbeatTimeout(c)
+		return ErrHeartbeatTimeout
+	}
+	next := interval - idle
+	if idle >= interval {
+		if ping := lp.svr.opts.HeartbeatPing; ping != nil {
+			if frame, err := lp.svr.encode(cc, ping(c)); err == nil {
+				cc.write(frame)
+				if !cc.opened {
+					return nil // closed by the failure of writing.
+				}
+			}
+		}
+		next = interval
+		if rest := timeout - idle; rest < next {
+			next = rest
+		}
+	}
+	lp.addConnTimer(&Timer{lp: lp, c: cc}, next, lp.heartbeat)
+	return nil
+}
@@ -0,0 +1,81 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build darwin netbsd freebsd openbsd dragonfly linux
+
+package gnet
+
+import (
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// ipv6HostPrefix is the length in bytes of the prefix of IPv6 addresses aggregated into a host, i.e. a /64.
+const ipv6HostPrefix = 8
+
+// hostConns counts the connections of every source host for MaxConnsPerHost.
+type hostConns struct {
+	mu    sync.Mutex
+	conns map[string]int
+}
+
+// hostKey returns the key of the source host of remote address, which is empty if the connections of host can't
+// be limited, e.g. for Unix domain sockets.
+func (svr *server) hostKey(sa unix.Sockaddr) string {
+	switch sa := sa.(type) {
+	case *unix.SockaddrInet4:
+		return string(sa.Addr[:])
+	case *unix.SockaddrInet6:
+		ip := sa.Addr[:]
+		if isIPv4Mapped(ip) {
+			return string(ip[12:])
+		}
+		if svr.opts.AggregateIPv6Hosts {
+			ip = ip[:ipv6HostPrefix]
+		}
+		return string(ip)
+	}
+	return ""
+}
+
+func isIPv4Mapped(ip []byte) bool {
+	for _, b := range ip[:10] {
+		if b != 0 {
+			return false
+		}
+	}
+	return ip[10] == 0xff && ip[11] == 0xff
+}
+
+// acquireHost counts a connection of the host in, it reports false if the host has reached the limit.
+func (svr *server) acquireHost(key string, limit int) bool {
+	if key == "" {
+		return true
+	}
+	h := &svr.hostConns
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.conns[key] >= limit {
+		return false
+	}
+	if h.conns == nil {
+		h.conns = make(map[string]int)
+	}
+	h.conns[key]++
+	return true
+}
+
+// releaseHost counts a connection of the host out.
+func (svr *server) releaseHost(key string) {
+	if key == "" {
+		return
+	}
+	h := &svr.hostConns
+	h.mu.Lock()
+	if h.conns[key]--; h.conns[key] <= 0 {
+		delete(h.conns, key)
+	}
+	h.mu.Unlock()
+}
@@ -6,40 +6,76 @@
 package internal
 
 import (
-	"sync"
+	"sync/atomic"
+	"unsafe"
 )
 
 // Job is a asynchronous function.
 type Job func() error
 
+type jobNode struct {
+	job  Job
+	next unsafe.Pointer // *jobNode
+}
+
 // NewAsyncJobQueue creates a note-queue.
-func NewAsyncJobQueue() AsyncJobQueue {
-	return AsyncJobQueue{mu: SpinLock()}
+func NewAsyncJobQueue() *AsyncJobQueue {
+	stub := new(jobNode)
+	return &AsyncJobQueue{head: unsafe.Pointer(stub), tail: stub}
 }
 
-// AsyncJobQueue queues pending tasks.
+// AsyncJobQueue queues pending tasks, it's a lock-free queue of multiple producers and a single consumer,
+// in which Push may be called from any goroutines while ForEach must be called from one goroutine only.
 type AsyncJobQueue struct {
-	mu   sync.Locker
-	jobs []func() error
+	head unsafe.Pointer // *jobNode, the latest node pushed by producers
+	tail *jobNode       // stub node preceding the oldest job, owned by the consumer
+	size int64          // number of pending jobs, accessed atomically
 }
 
 // Push pushes a item into queue.
 func (q *AsyncJobQueue) Push(job Job) {
-	q.mu.Lock()
-	q.jobs = append(q.jobs, job)
-	q.mu.Unlock()
+	atomic.AddInt64(&q.size, 1)
+	node := &jobNode{job: job}
+	prev := (*jobNode)(atomic.SwapPointer(&q.head, unsafe.Pointer(node)))
+	// The queue is cut off at prev until it's linked to node, ForEach stops there and the remaining jobs
+	// are left to the next round.
+	atomic.StorePointer(&prev.next, unsafe.Pointer(node))
+}
+
+// pop removes the oldest job from queue, it returns nil if the queue is empty.
+func (q *AsyncJobQueue) pop() (*jobNode, Job) {
+	next := (*jobNode)(atomic.LoadPointer(&q.tail.next))
+	if next == nil {
+		return nil, nil
+	}
+	q.tail = next
+	job := next.job
+	next.job = nil
+	return next, job
 }
 
-// ForEach iterates this queue and executes each note with a given func.
+// ForEach iterates this queue and executes each note with a given func, the jobs pushed while iterating
+// are left to the next round.
 func (q *AsyncJobQueue) ForEach() (err error) {
-	q.mu.Lock()
-	jobs := q.jobs
-	q.jobs = nil
-	q.mu.Unlock()
-	for i := range jobs {
-		if err = jobs[i](); err != nil {
-			return err
+	last := (*jobNode)(atomic.LoadPointer(&q.head))
+	var n int64
+	defer func() { atomic.AddInt64(&q.size, -n) }()
+	for {
+		node, job := q.pop()
+		if node == nil {
+			return
+		}
+		n++
+		if err = job(); err != nil {
+			return
+		}
+		if node == last {
+			return
 		}
 	}
-	return
+}
+
+// Len returns the number of pending jobs.
+func (q *AsyncJobQueue) Len() int {
+	return int(atomic.LoadInt64(&q.size))
 }
@@ -0,0 +1,164 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import "time"
+
+const (
+	wheelBits   = 6
+	wheelSize   = 1 << wheelBits
+	wheelMask   = wheelSize - 1
+	wheelLevels = 4
+
+	// maxTicks is the farthest expiration that fits in the wheel, farther timers are parked at the last level
+	// and re-inserted until they fit.
+	maxTicks = 1<<(wheelBits*wheelLevels) - 1
+)
+
+// Timer is a timer in TimingWheel.
+type Timer struct {
+	expire     int64
+	f          func()
+	prev, next *Timer
+	slot       **Timer
+	wheel      *TimingWheel
+}
+
+// Stop prevents the timer from firing, it reports false if the timer has already fired or been stopped.
+func (t *Timer) Stop() bool {
+	if t.wheel == nil {
+		return false
+	}
+	t.wheel.unlink(t)
+	return true
+}
+
+// TimingWheel is a hierarchical timing wheel, which is not safe for concurrent use.
+type TimingWheel struct {
+	tick    time.Duration
+	start   time.Time
+	current int64
+	count   int
+	slots   [wheelLevels][wheelSize]*Timer
+}
+
+// NewTimingWheel instantiates a TimingWheel with the given resolution starting from now.
+func NewTimingWheel(tick time.Duration, now time.Time) *TimingWheel {
+	return &TimingWheel{tick: tick, start: now}
+}
+
+// Len returns the number of pending timers.
+func (w *TimingWheel) Len() int {
+	return w.count
+}
+
+// AfterFunc schedules f to be invoked by Advance after d elapses from now.
+func (w *TimingWheel) AfterFunc(now time.Time, d time.Duration, f func()) *Timer {
+	if w.count == 0 {
+		// Catch up with now in one step, the wheel may not have been advanced while it was empty.
+		w.current = w.ticks(now)
+	}
+	expire := w.ticks(now.Add(d + w.tick - 1))
+	if expire <= w.current {
+		expire = w.current + 1
+	}
+	t := &Timer{expire: expire, f: f}
+	w.link(t)
+	return t
+}
+
+// Next returns the time from now until the wheel needs to be advanced, or a negative duration if there are
+// no pending timers.
+func (w *TimingWheel) Next(now time.Time) time.Duration {
+	if w.count == 0 {
+		return -1
+	}
+	next := (w.current | wheelMask) + 1 // the next cascade of the upper levels
+	for tick := w.current + 1; tick < next; tick++ {
+		if w.slots[0][tick&wheelMask] != nil {
+			next = tick
+			break
+		}
+	}
+	if d := w.start.Add(time.Duration(next) * w.tick).Sub(now); d > 0 {
+		return d
+	}
+	return 0
+}
+
+// Advance moves the wheel to now and invokes the expired timers.
+func (w *TimingWheel) Advance(now time.Time) {
+	target := w.ticks(now)
+	for w.current < target {
+		if w.count == 0 {
+			w.current = target
+			return
+		}
+		w.current++
+		w.cascade()
+		slot := &w.slots[0][w.current&wheelMask]
+		for *slot != nil {
+			t := *slot
+			w.unlink(t)
+			t.f()
+		}
+	}
+}
+
+func (w *TimingWheel) ticks(t time.Time) int64 {
+	return int64(t.Sub(w.start) / w.tick)
+}
+
+// cascade re-inserts the timers of the upper levels whose slots are reached by the current tick.
+func (w *TimingWheel) cascade() {
+	for level := 1; level < wheelLevels; level++ {
+		if w.current&(1<<(wheelBits*uint(level))-1) != 0 {
+			return
+		}
+		slot := &w.slots[level][(w.current>>(wheelBits*uint(level)))&wheelMask]
+		t := *slot
+		*slot = nil
+		for t != nil {
+			next := t.next
+			w.count--
+			t.prev, t.next, t.slot, t.wheel = nil, nil, nil, nil
+			w.link(t)
+			t = next
+		}
+	}
+}
+
+func (w *TimingWheel) link(t *Timer) {
+	expire := t.expire
+	if expire-w.current > maxTicks {
+		expire = w.current + maxTicks
+	}
+	level := 0
+	for delta := expire - w.current; delta >= wheelSize; delta >>= wheelBits {
+		level++
+	}
+	slot := &w.slots[level][(expire>>(wheelBits*uint(level)))&wheelMask]
+	t.next = *slot
+	if t.next != nil {
+		t.next.prev = t
+	}
+	*slot = t
+	t.slot = slot
+	t.wheel = w
+	w.count++
+}
+
+func (w *TimingWheel) unlink(t *Timer) {
+	if t.prev != nil {
+		t.prev.next = t.next
+	} else {
+		*t.slot = t.next
+	}
+	if t.next != nil {
+		t.next.prev = t.prev
+	}
+	t.prev, t.next, t.slot, t.wheel = nil, nil, nil, nil
+	w.count--
+}
@@ -0,0 +1,42 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"sync"
+	"time"
+)
+
+// TokenBucket is a token bucket for rate limiting, which is safe for concurrent use.
+type TokenBucket struct {
+	mu     sync.Mutex
+	rate   float64 // tokens refilled per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket instantiates a full TokenBucket refilled with rate tokens per second up to burst tokens.
+func NewTokenBucket(rate, burst int, now time.Time) *TokenBucket {
+	return &TokenBucket{rate: float64(rate), burst: float64(burst), tokens: float64(burst), last: now}
+}
+
+// Take takes a token from the bucket, it returns the time until a token is available if the bucket is empty.
+func (b *TokenBucket) Take(now time.Time) (ok bool, wait time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+	}
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
@@ -0,0 +1,71 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build darwin netbsd freebsd openbsd dragonfly linux
+
+package gnet
+
+import (
+	"log"
+	"time"
+)
+
+// The events of connections recorded for the leak detector.
+const (
+	LeakEventOpen  = "open"
+	LeakEventRead  = "read"
+	LeakEventWrite = "write"
+	LeakEventTimer = "timer"
+	LeakEventWake  = "wake"
+)
+
+// LeakInfo is the diagnostics of a connection suspected to be leaked.
+type LeakInfo struct {
+	// OpenedAt is the time when the connection was opened.
+	OpenedAt time.Time
+
+	// LastEvent is the last event of the connection, one of the LeakEvent constants.
+	LastEvent string
+
+	// LastEventAt is the time of LastEvent.
+	LastEventAt time.Time
+}
+
+// touch records the event of connection for the leak detector.
+func (c *conn) touch(event string) {
+	c.lastEvent = event
+	c.lastEventAt = c.loop.now
+	c.leakReported = false
+}
+
+// startLeakDetector starts sweeping the connections of loop for leaks if the leak detector is enabled.
+func (lp *loop) startLeakDetector() {
+	if timeout := lp.svr.opts.LeakTimeout; timeout > 0 {
+		lp.timers.AfterFunc(time.Now(), timeout/2, lp.detectLeaks)
+	}
+}
+
+// detectLeaks reports the connections which have had no reads, writes, wakes or pending timers for LeakTimeout,
+// a connection is reported once until its next event. Without OnLeak, the diagnostics are logged.
+func (lp *loop) detectLeaks() {
+	timeout := lp.svr.opts.LeakTimeout
+	lp.connections.iterate(func(c *conn) bool {
+		if !c.opened || c.leakReported || len(c.timers) != 0 || lp.now.Sub(c.lastEventAt) < timeout {
+			return true
+		}
+		c.leakReported = true
+		info := LeakInfo{OpenedAt: c.openedAt, LastEvent: c.lastEvent, LastEventAt: c.lastEventAt}
+		onLeak := lp.svr.opts.OnLeak
+		if onLeak == nil {
+			log.Printf("gnet: connection %s is idle since %s at %s\n", addrString(c.remoteAddr), info.LastEvent,
+				info.LastEventAt.Format(time.RFC3339))
+			return true
+		}
+		if onLeak(c, info) == Close && c.opened {
+			lp.setTimerErr(lp.loopCloseConn(c, ErrConnectionLeaked))
+		}
+		return true
+	})
+	lp.timers.AfterFunc(time.Now(), timeout/2, lp.detectLeaks)
+}
@@ -12,28 +12,32 @@ import (
 )
 
 func (lp *loop) handleEvent(fd int, filter int16, job internal.Job) error {
-	if c, ok := lp.connections[fd]; ok {
-		switch c.opened {
-		case false:
-			return lp.loopOpen(c)
-		case true:
-			switch filter {
-			// Don't change the ordering of processing EVFILT_WRITE | EVFILT_READ | EV_ERROR/EV_EOF unless you're 100%
-			// sure what you're doing!
-			// Re-ordering can easily introduce bugs and bad side-effects, as I found out painfully in the past.
-			case netpoll.EVFilterWrite:
-				if !c.outboundBuffer.IsEmpty() {
-					return lp.loopOut(c)
-				}
-				return nil
-			case netpoll.EVFilterRead:
-				return lp.loopIn(c)
-			case netpoll.EVFilterSock:
-				return lp.loopCloseConn(c, nil)
-			default:
+	if c := lp.connections.get(fd); c != nil {
+		switch filter {
+		// Don't change the ordering of processing EVFILT_WRITE | EVFILT_READ | EV_ERROR/EV_EOF unless you're 100%
+		// sure what you're doing!
+		// Re-ordering can easily introduce bugs and bad side-effects, as I found out painfully in the past.
+		case netpoll.EVFilterWrite:
+			if !c.outboundEmpty() {
+				return lp.loopOut(c)
+			}
+			return nil
+		case netpoll.EVFilterRead:
+			// The read filter of paused connection is only left in the edge-triggered mode, where the data
+			// is left in the socket and read once resumed.
+			if c.readPaused {
 				return nil
 			}
+			return lp.loopIn(c)
+		case netpoll.EVFilterSock:
+			// Read the rest of data, then the half-close or the failure of connection is surfaced by loopIn.
+			return lp.loopIn(c)
+		default:
+			return nil
 		}
 	}
+	if f := lp.external(fd); f != nil {
+		return lp.loopExternal(f, fdEvents(filter))
+	}
 	return lp.loopAccept(fd)
 }
@@ -9,17 +9,18 @@ package gnet
 import (
 	"github.com/panjf2000/gnet/internal"
 	"github.com/panjf2000/gnet/netpoll"
+	"golang.org/x/sys/unix"
 )
 
 func (lp *loop) handleEvent(fd int, ev uint32, job internal.Job) error {
-	if c, ok := lp.connections[fd]; ok {
+	if c := lp.connections.get(fd); c != nil {
 		switch {
 		// Don't change the ordering of processing EPOLLOUT | EPOLLRDHUP / EPOLLIN unless you're 100%
 		// sure what you're doing!
 		// Re-ordering can easily introduce bugs and bad side-effects, as I found out painfully in the past.
-		case !c.opened:
-			return lp.loopOpen(c)
-		case !c.outboundBuffer.IsEmpty():
+		case lp.svr.opts.EdgeTriggered:
+			return lp.loopEdgeTriggered(c, ev)
+		case !c.outboundEmpty():
 			if ev&netpoll.OutEvents != 0 {
 				return lp.loopOut(c)
 			}
@@ -30,5 +31,30 @@ func (lp *loop) handleEvent(fd int, ev uint32, job internal.Job) error {
 			return nil
 		}
 	}
+	if lp.tickerFd > 0 && fd == lp.tickerFd {
+		return lp.loopTick()
+	}
+	if f := lp.external(fd); f != nil {
+		return lp.loopExternal(f, fdEvents(ev))
+	}
 	return lp.loopAccept(fd)
 }
+
+// loopEdgeTriggered handles the writable and readable events of connection at once in the edge-triggered mode,
+// since neither of them will be reported again until the state of socket changes.
+func (lp *loop) loopEdgeTriggered(c *conn, ev uint32) error {
+	if c.readClosed && ev&(unix.EPOLLERR|unix.EPOLLHUP) != 0 {
+		// The half-closed connection has been reset by peer or shut down in both directions.
+		return lp.loopCloseConn(c, ErrConnectionClosed)
+	}
+	if ev&netpoll.OutEvents != 0 && !c.outboundEmpty() {
+		if err := lp.loopOut(c); err != nil || !c.opened {
+			return err
+		}
+	}
+	// The data of paused connection is left in the socket unless it has failed, which is read once resumed.
+	if ev&netpoll.InEvents != 0 && (!c.readPaused || ev&(unix.EPOLLERR|unix.EPOLLHUP) != 0) {
+		return lp.loopIn(c)
+	}
+	return nil
+}
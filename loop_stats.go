@@ -0,0 +1,108 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build darwin netbsd freebsd openbsd dragonfly linux
+
+package gnet
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+)
+
+// loopCounters are the counters of an event-loop, which are accessed atomically.
+type loopCounters struct {
+	pollIterations int64
+	bytesRead      int64
+	bytesWritten   int64
+	busySince      int64 // unix nanoseconds when the current iteration started, zero while polling
+	goroutine      int64 // id of the goroutine running the event-loop, only set with OnLoopStall
+}
+
+// LoopStats is the snapshot of metrics of an event-loop serving connections.
+type LoopStats struct {
+	// Index is the index of event-loop.
+	Index int
+
+	// Connections is the number of connections served by the event-loop.
+	Connections int64
+
+	// PendingJobs is the number of jobs waiting to be run by the event-loop, e.g. triggered by AsyncWrite and Wake.
+	PendingJobs int64
+
+	// PollIterations is the number of times the event-loop has returned from polling.
+	PollIterations int64
+
+	// BytesRead is the number of bytes read from the connections, including the datagrams received.
+	BytesRead int64
+
+	// BytesWritten is the number of bytes written to the connections, including the datagrams sent by React.
+	BytesWritten int64
+}
+
+// Stats returns the metrics of every event-loop serving connections, it can be invoked from any goroutine.
+func (s Server) Stats() (stats []LoopStats) {
+	s.svr.subLoopGroup.iterate(func(i int, lp *loop) bool {
+		stats = append(stats, LoopStats{
+			Index:          lp.idx,
+			Connections:    int64(lp.connections.len()),
+			PendingJobs:    lp.poller.TriggerStats().Pending,
+			PollIterations: atomic.LoadInt64(&lp.counters.pollIterations),
+			BytesRead:      atomic.LoadInt64(&lp.counters.bytesRead),
+			BytesWritten:   atomic.LoadInt64(&lp.counters.bytesWritten),
+		})
+		return true
+	})
+	return
+}
+
+// DumpConns writes the states of all connections to w for debugging, a line per connection. The states are
+// collected within the event-loops, so it blocks until every event-loop has run the job, and it fails with
+// ErrServerShutdown if the server shuts down in the meantime.
+func (s Server) DumpConns(w io.Writer) error {
+	return s.svr.dumpConns(w)
+}
+
+func (svr *server) dumpConns(w io.Writer) error {
+	var loops []*loop
+	svr.subLoopGroup.iterate(func(i int, lp *loop) bool {
+		loops = append(loops, lp)
+		return true
+	})
+	for _, lp := range loops {
+		lp := lp
+		lines := make(chan []string, 1)
+		if err := lp.poller.Trigger(func() error {
+			var states []string
+			lp.connections.iterate(func(c *conn) bool {
+				states = append(states, c.dumpState(lp.idx))
+				return true
+			})
+			lines <- states
+			return nil
+		}); err != nil {
+			return err
+		}
+		select {
+		case states := <-lines:
+			for _, line := range states {
+				if _, err := io.WriteString(w, line); err != nil {
+					return err
+				}
+			}
+		case <-svr.ctx.Done():
+			return ErrServerShutdown
+		}
+	}
+	return nil
+}
+
+// dumpState formats the state of connection into a line.
+func (c *conn) dumpState(idx int) string {
+	return fmt.Sprintf("loop=%d fd=%d local=%s remote=%s opened=%t inbound=%d outbound=%d timers=%d "+
+		"react-tasks=%d read-closed=%t write-closed=%t\n",
+		idx, c.fd, addrString(c.localAddr), addrString(c.remoteAddr), c.opened, c.inboundBuffer.Length(),
+		c.outboundLength(), len(c.timers), len(c.reactTasks), c.readClosed, c.writeClosed)
+}
@@ -0,0 +1,87 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build darwin netbsd freebsd openbsd dragonfly linux
+
+package gnet
+
+import "sync/atomic"
+
+// isShedding reports whether the server is in the shedding mode caused by exceeding the memory limit.
+func (svr *server) isShedding() bool {
+	return atomic.LoadInt32(&svr.shedding) == 1
+}
+
+// trackMemory accounts the buffers of the given connection into the memory usage of server, it shrinks
+// the idle buffers of the connection in the shedding mode, and switches the shedding mode on/off by
+// comparing the memory usage with the memory limit.
+func (svr *server) trackMemory(c *conn) {
+	if svr.opts.MemoryLimit <= 0 || !c.opened {
+		return
+	}
+	if svr.isShedding() {
+		if c.inboundBuffer.IsEmpty() && c.inboundBuffer.Capacity() > svr.readBufferCap() {
+			c.inboundBuffer.Release()
+			c.inboundBuffer = svr.newInboundBuffer()
+		}
+		if c.outboundBuffer != nil && c.outboundBuffer.IsEmpty() && c.outboundBuffer.Capacity() > svr.writeBufferCap() {
+			c.outboundBuffer.Release()
+			c.outboundBuffer = svr.newOutboundBuffer()
+		}
+	}
+	size := int64(c.inboundBuffer.Capacity())
+	if c.outboundList != nil {
+		size += int64(c.outboundList.length())
+	} else {
+		size += int64(c.outboundBuffer.Capacity())
+	}
+	if size == c.memory {
+		return
+	}
+	usage := atomic.AddInt64(&svr.memoryUsage, size-c.memory)
+	c.memory = size
+	svr.checkMemory(usage)
+}
+
+// untrackMemory removes the buffers of the given connection from the memory usage of server.
+func (svr *server) untrackMemory(c *conn) {
+	if c.memory == 0 {
+		return
+	}
+	usage := atomic.AddInt64(&svr.memoryUsage, -c.memory)
+	c.memory = 0
+	svr.checkMemory(usage)
+}
+
+func (svr *server) checkMemory(usage int64) {
+	limit := svr.opts.MemoryLimit
+	switch {
+	case usage > limit && atomic.CompareAndSwapInt32(&svr.shedding, 0, 1):
+		svr.eventHandler.OnMemoryPressure(usage, limit)
+		if svr.opts.ShedSlowConsumers {
+			svr.subLoopGroup.iterate(func(i int, lp *loop) bool {
+				sniffError(lp.poller.Trigger(lp.loopShedSlowest))
+				return true
+			})
+		}
+	case usage <= limit*9/10:
+		// Leave the shedding mode only after the memory usage falls back with a margin to avoid flapping.
+		atomic.CompareAndSwapInt32(&svr.shedding, 1, 0)
+	}
+}
+
+// loopShedSlowest closes the connection with the most outbound data pending in this loop.
+func (lp *loop) loopShedSlowest() error {
+	var slowest *conn
+	lp.connections.iterate(func(c *conn) bool {
+		if slowest == nil || c.outboundLength() > slowest.outboundLength() {
+			slowest = c
+		}
+		return true
+	})
+	if slowest == nil || slowest.outboundEmpty() {
+		return nil
+	}
+	return lp.loopCloseConn(slowest, ErrMemoryLimitExceeded)
+}
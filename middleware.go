@@ -0,0 +1,83 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+// Middleware wraps an EventHandler into another one, which usually embeds the wrapped handler and overrides
+// the events it's interested in, invoking the wrapped handler before or after its own work.
+type Middleware func(next EventHandler) EventHandler
+
+// Chain composes the middlewares into a single one, the first middleware is the outermost, i.e.
+// Chain(a, b, c)(h) is equivalent to a(b(c(h))), so the events reach a first and h last.
+func Chain(middlewares ...Middleware) Middleware {
+	return func(next EventHandler) EventHandler {
+		for i := len(middlewares) - 1; i >= 0; i-- {
+			next = middlewares[i](next)
+		}
+		return next
+	}
+}
+
+// Hooks is a set of functions invoked before and after OnOpened, React and OnClosed of the wrapped handler, any of
+// them can be nil. The event is answered by the Before hook without invoking the wrapped handler if the hook returns
+// an action other than None, e.g. Close for rejecting unauthorized or rate limited connections. The After hooks
+// receive the results of the wrapped handler and return the ones passed on to the server. BeforeReact follows the
+// same contract as React, i.e. it must consume the data it answers, since React fires again while there is output.
+type Hooks struct {
+	BeforeOpened func(c Conn) (out []byte, action Action)
+	AfterOpened  func(c Conn, out []byte, action Action) ([]byte, Action)
+	BeforeReact  func(c Conn) (out []byte, action Action)
+	AfterReact   func(c Conn, out []byte, action Action) ([]byte, Action)
+	BeforeClosed func(c Conn, err error)
+	AfterClosed  func(c Conn, err error, action Action) Action
+}
+
+// WithHooks returns a middleware which invokes the given hooks around the events of the wrapped handler.
+func WithHooks(hooks Hooks) Middleware {
+	return func(next EventHandler) EventHandler {
+		return &hookedHandler{EventHandler: next, hooks: hooks}
+	}
+}
+
+type hookedHandler struct {
+	EventHandler
+	hooks Hooks
+}
+
+func (h *hookedHandler) OnOpened(c Conn) (out []byte, action Action) {
+	if h.hooks.BeforeOpened != nil {
+		if out, action = h.hooks.BeforeOpened(c); action != None {
+			return
+		}
+	}
+	out, action = h.EventHandler.OnOpened(c)
+	if h.hooks.AfterOpened != nil {
+		out, action = h.hooks.AfterOpened(c, out, action)
+	}
+	return
+}
+
+func (h *hookedHandler) React(c Conn) (out []byte, action Action) {
+	if h.hooks.BeforeReact != nil {
+		if out, action = h.hooks.BeforeReact(c); action != None {
+			return
+		}
+	}
+	out, action = h.EventHandler.React(c)
+	if h.hooks.AfterReact != nil {
+		out, action = h.hooks.AfterReact(c, out, action)
+	}
+	return
+}
+
+func (h *hookedHandler) OnClosed(c Conn, err error) (action Action) {
+	if h.hooks.BeforeClosed != nil {
+		h.hooks.BeforeClosed(c, err)
+	}
+	action = h.EventHandler.OnClosed(c, err)
+	if h.hooks.AfterClosed != nil {
+		action = h.hooks.AfterClosed(c, err, action)
+	}
+	return
+}
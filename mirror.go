@@ -0,0 +1,128 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build darwin netbsd freebsd openbsd dragonfly linux
+
+package gnet
+
+import (
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultMirrorQueueSize is the default number of records queued for the sink of Mirror.
+const DefaultMirrorQueueSize = 1024
+
+// MirrorDirection is the set of directions of traffic mirrored for a connection.
+type MirrorDirection int
+
+const (
+	// MirrorInbound mirrors the data received from the peer.
+	MirrorInbound MirrorDirection = 1 << iota
+
+	// MirrorOutbound mirrors the data sent to the peer.
+	MirrorOutbound
+
+	// MirrorBoth mirrors the data in both directions.
+	MirrorBoth = MirrorInbound | MirrorOutbound
+)
+
+// MirrorRecord is a chunk of traffic of a connection mirrored to the sink.
+type MirrorRecord struct {
+	Time       time.Time
+	Direction  MirrorDirection
+	LocalAddr  net.Addr
+	RemoteAddr net.Addr
+	Data       []byte
+}
+
+// Mirror copies the traffic of the selected connections to a sink for capturing and debugging, the copies are
+// handed over to the sink in a separate goroutine, so that a slow sink never blocks the event-loops: the records
+// are dropped once the queue is full, and the ones pending when the server shuts down are dropped as well.
+// The inbound data is mirrored as read from the sockets, and the outbound data as written by the event-loops,
+// i.e. the output encoded by the codec.
+type Mirror struct {
+	// Select returns the directions mirrored for the connection, it's invoked once when a connection is opened
+	// or for every datagram, in the event-loop. Nil mirrors all connections in both directions.
+	Select func(c Conn) MirrorDirection
+
+	// Sink receives the records in order, it mustn't retain Data after returning.
+	Sink func(rec *MirrorRecord)
+
+	// QueueSize is the number of records queued for Sink, DefaultMirrorQueueSize is used if it's zero.
+	QueueSize int
+
+	dropped uint64
+}
+
+// Dropped returns the number of records dropped as the queue was full.
+func (m *Mirror) Dropped() uint64 {
+	return atomic.LoadUint64(&m.dropped)
+}
+
+// MirrorTo returns the sink writing the data of records to w, e.g. a connection to a secondary server or a file,
+// the errors of writing are ignored.
+func MirrorTo(w io.Writer) func(rec *MirrorRecord) {
+	return func(rec *MirrorRecord) {
+		_, _ = w.Write(rec.Data)
+	}
+}
+
+// startMirror starts the goroutine handing over the mirrored records to the sink until the server shuts down.
+func (svr *server) startMirror() {
+	m := svr.opts.Mirror
+	if m == nil {
+		return
+	}
+	size := m.QueueSize
+	if size <= 0 {
+		size = DefaultMirrorQueueSize
+	}
+	svr.mirrorQueue = make(chan *MirrorRecord, size)
+	svr.wg.Add(1)
+	go func() {
+		defer svr.wg.Done()
+		for {
+			select {
+			case rec := <-svr.mirrorQueue:
+				m.Sink(rec)
+			case <-svr.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// selectMirror sets up the directions mirrored for the connection.
+func (svr *server) selectMirror(c *conn) {
+	if svr.mirrorQueue == nil {
+		return
+	}
+	if svr.opts.Mirror.Select == nil {
+		c.mirrored = MirrorBoth
+		return
+	}
+	c.mirrored = svr.opts.Mirror.Select(c)
+}
+
+// mirror queues the copy of data in the direction of connection, if it's selected.
+func (svr *server) mirror(c *conn, dir MirrorDirection, data []byte) {
+	if c.mirrored&dir == 0 || len(data) == 0 {
+		return
+	}
+	rec := &MirrorRecord{
+		Time:       time.Now(),
+		Direction:  dir,
+		LocalAddr:  c.localAddr,
+		RemoteAddr: c.remoteAddr,
+		Data:       append([]byte(nil), data...),
+	}
+	select {
+	case svr.mirrorQueue <- rec:
+	default:
+		atomic.AddUint64(&svr.opts.Mirror.dropped, 1)
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import "encoding/binary"
+
+const (
+	// modbusHeaderLength is the length of MBAP header, including the unit identifier.
+	modbusHeaderLength = 7
+
+	// modbusMaxLength is the maximum value of the length field of MBAP header, i.e. the unit identifier and
+	// the PDU of 253 bytes at most.
+	modbusMaxLength = 254
+)
+
+// The exception codes of Modbus.
+const (
+	ModbusIllegalFunction    byte = 0x01
+	ModbusIllegalDataAddress byte = 0x02
+	ModbusIllegalDataValue   byte = 0x03
+	ModbusServerDeviceFailed byte = 0x04
+)
+
+// ModbusCodec encodes/decodes the ADUs of Modbus TCP into/from TCP stream, every decoded frame is a whole ADU with
+// the MBAP header, which can be parsed by ParseModbusADU. Outbound frames are written as is, e.g. the ones made by
+// ModbusADU.Marshal. The connection is closed with ErrMalformedFrame if the MBAP header is invalid.
+type ModbusCodec struct {
+}
+
+// Encode ...
+func (cc *ModbusCodec) Encode(buf []byte) ([]byte, error) {
+	return buf, nil
+}
+
+// Decode decodes an ADU from TCP stream.
+func (cc *ModbusCodec) Decode(c Conn) ([]byte, error) {
+	header, err := c.Peek(modbusHeaderLength - 1)
+	if err != nil {
+		return nil, ErrUnexpectedEOF
+	}
+	length := int(binary.BigEndian.Uint16(header[4:]))
+	if binary.BigEndian.Uint16(header[2:]) != 0 || length < 2 || length > modbusMaxLength {
+		return nil, ErrMalformedFrame
+	}
+	size, frame := c.ReadN(modbusHeaderLength - 1 + length)
+	if size == 0 {
+		return nil, ErrUnexpectedEOF
+	}
+	return frame, nil
+}
+
+// ModbusADU is an application data unit of Modbus TCP.
+type ModbusADU struct {
+	// TransactionID pairs the request and response.
+	TransactionID uint16
+
+	// UnitID identifies the remote server behind gateways.
+	UnitID byte
+
+	// FunctionCode is the function code of PDU, the exception responses have the highest bit set.
+	FunctionCode byte
+
+	// Data is the data of PDU following the function code.
+	Data []byte
+}
+
+// ParseModbusADU parses the ADU decoded by ModbusCodec, the error is ErrMalformedFrame if it's invalid.
+func ParseModbusADU(frame []byte) (*ModbusADU, error) {
+	if len(frame) < modbusHeaderLength+1 || int(binary.BigEndian.Uint16(frame[4:]))+modbusHeaderLength-1 != len(frame) {
+		return nil, ErrMalformedFrame
+	}
+	return &ModbusADU{
+		TransactionID: binary.BigEndian.Uint16(frame),
+		UnitID:        frame[6],
+		FunctionCode:  frame[7],
+		Data:          frame[8:],
+	}, nil
+}
+
+// Marshal makes the ADU for ModbusCodec.
+func (adu *ModbusADU) Marshal() []byte {
+	buf := make([]byte, modbusHeaderLength+1+len(adu.Data))
+	binary.BigEndian.PutUint16(buf, adu.TransactionID)
+	binary.BigEndian.PutUint16(buf[4:], uint16(2+len(adu.Data)))
+	buf[6] = adu.UnitID
+	buf[7] = adu.FunctionCode
+	copy(buf[8:], adu.Data)
+	return buf
+}
+
+// Reply returns the response to the request ADU with the data.
+func (adu *ModbusADU) Reply(data []byte) *ModbusADU {
+	return &ModbusADU{TransactionID: adu.TransactionID, UnitID: adu.UnitID, FunctionCode: adu.FunctionCode, Data: data}
+}
+
+// Exception returns the exception response to the request ADU with the exception code.
+func (adu *ModbusADU) Exception(code byte) *ModbusADU {
+	return &ModbusADU{
+		TransactionID: adu.TransactionID,
+		UnitID:        adu.UnitID,
+		FunctionCode:  adu.FunctionCode | 0x80,
+		Data:          []byte{code},
+	}
+}
+
+// ModbusHandler handles the request ADU of connection and returns the response, nil means no response, e.g. for
+// the requests broadcast to unit 0.
+type ModbusHandler func(c Conn, req *ModbusADU) (resp *ModbusADU)
+
+// ModbusRouter dispatches the request ADUs to the handlers by function code, it's meant to be invoked from
+// the React of FrameEventHandler with the frames of ModbusCodec.
+type ModbusRouter struct {
+	handlers map[byte]ModbusHandler
+}
+
+// NewModbusRouter instantiates and returns a router without any handlers.
+func NewModbusRouter() *ModbusRouter {
+	return &ModbusRouter{handlers: make(map[byte]ModbusHandler)}
+}
+
+// Handle registers the handler for the function code, it must be invoked before serving.
+func (r *ModbusRouter) Handle(functionCode byte, handler ModbusHandler) {
+	r.handlers[functionCode] = handler
+}
+
+// Route parses the request ADU of frame and dispatches it to the handler of its function code, it answers the
+// function codes without handlers with the exception of ModbusIllegalFunction. The output is the marshaled response
+// or nil, the error is ErrMalformedFrame if the frame is invalid.
+func (r *ModbusRouter) Route(c Conn, frame []byte) ([]byte, error) {
+	req, err := ParseModbusADU(frame)
+	if err != nil {
+		return nil, err
+	}
+	handler, ok := r.handlers[req.FunctionCode]
+	if !ok {
+		return req.Exception(ModbusIllegalFunction).Marshal(), nil
+	}
+	if resp := handler(c, req); resp != nil {
+		return resp.Marshal(), nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,178 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"reflect"
+	"sync"
+)
+
+// NDJSONCodec encodes/decodes newline-delimited JSON frames into/from TCP stream, every line is a JSON value and
+// is decoded as a raw frame without the line ending. The frames can be further decoded by Unmarshal, or decoded
+// into the types registered by Register with DecodeValue.
+type NDJSONCodec struct {
+	// MaxFrameLength is the maximum length of lines, the connection is closed with ErrTooLongFrame once it's
+	// exceeded. Zero means no limit.
+	MaxFrameLength int
+
+	// UseNumber makes Unmarshal and DecodeValue decode numbers into json.Number instead of float64.
+	UseNumber bool
+
+	// DisallowUnknownFields makes Unmarshal and DecodeValue fail on the fields of objects unknown to the destination.
+	DisallowUnknownFields bool
+
+	// TypeField is the field of JSON objects naming the types registered by Register, "type" if empty.
+	TypeField string
+
+	types    map[string]reflect.Type
+	decoders sync.Pool
+}
+
+// errTrailingJSON is the error of a frame with data following its JSON value.
+var errTrailingJSON = errors.New("invalid data after the JSON value of frame")
+
+// ndjsonDecoder is a json.Decoder reused for the frames read from r.
+type ndjsonDecoder struct {
+	r   bytes.Reader
+	dec *json.Decoder
+}
+
+// Register registers the type of prototype, e.g. Event{} or &Event{}, for the JSON objects whose TypeField is
+// name. It must be invoked before the codec is used.
+func (cc *NDJSONCodec) Register(name string, prototype interface{}) {
+	if cc.types == nil {
+		cc.types = make(map[string]reflect.Type)
+	}
+	t := reflect.TypeOf(prototype)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	cc.types[name] = t
+}
+
+// Encode appends the line ending to the JSON value, which is compacted first if it spans multiple lines.
+func (cc *NDJSONCodec) Encode(buf []byte) ([]byte, error) {
+	if bytes.IndexByte(buf, '\n') >= 0 {
+		var compacted bytes.Buffer
+		if err := json.Compact(&compacted, buf); err != nil {
+			return nil, err
+		}
+		buf = compacted.Bytes()
+	}
+	return append(buf, '\n'), nil
+}
+
+// Decode decodes a line from TCP stream, the line ending of either LF or CRLF is stripped.
+func (cc *NDJSONCodec) Decode(c Conn) ([]byte, error) {
+	buf := c.Read()
+	idx := bytes.IndexByte(buf, '\n')
+	if idx == -1 {
+		if exceedsFrameLength(cc.MaxFrameLength, len(buf)) {
+			return nil, ErrTooLongFrame
+		}
+		return nil, ErrCRLFNotFound
+	}
+	if exceedsFrameLength(cc.MaxFrameLength, idx) {
+		return nil, ErrTooLongFrame
+	}
+	_, buf = c.ReadN(idx + 1)
+	return bytes.TrimSuffix(buf[:idx], []byte{'\r'}), nil
+}
+
+// Unmarshal decodes the JSON value of frame into v with a pooled json.Decoder.
+func (cc *NDJSONCodec) Unmarshal(frame []byte, v interface{}) error {
+	d, ok := cc.decoders.Get().(*ndjsonDecoder)
+	if !ok {
+		d = new(ndjsonDecoder)
+		d.dec = json.NewDecoder(&d.r)
+		if cc.UseNumber {
+			d.dec.UseNumber()
+		}
+		if cc.DisallowUnknownFields {
+			d.dec.DisallowUnknownFields()
+		}
+	}
+	d.r.Reset(frame)
+	if err := d.dec.Decode(v); err != nil {
+		// The error sticks to the decoder, so it's dropped rather than reused.
+		return err
+	}
+	if hasTrailingData(d.dec.Buffered()) || hasTrailingData(&d.r) {
+		// The data following the value would be decoded with the next frame otherwise.
+		return errTrailingJSON
+	}
+	d.r.Reset(nil)
+	cc.decoders.Put(d)
+	return nil
+}
+
+// hasTrailingData reports whether there is anything but white spaces left in r.
+func hasTrailingData(r io.Reader) bool {
+	var buf [64]byte
+	for {
+		n, err := r.Read(buf[:])
+		if len(bytes.TrimSpace(buf[:n])) != 0 {
+			return true
+		}
+		if err != nil {
+			return false
+		}
+	}
+}
+
+// typeName scans the members of the JSON object of frame up to field rather than decoding all of them, and returns
+// the string value of it, the error is ErrUnregisteredType if there is no such string member.
+func typeName(frame []byte, field string) (string, error) {
+	dec := json.NewDecoder(bytes.NewReader(frame))
+	if tok, err := dec.Token(); err != nil {
+		return "", err
+	} else if tok != json.Delim('{') {
+		return "", ErrUnregisteredType
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return "", err
+		}
+		if key, _ := tok.(string); key == field {
+			var name string
+			if dec.Decode(&name) != nil {
+				return "", ErrUnregisteredType
+			}
+			return name, nil
+		}
+		var skipped json.RawMessage
+		if err = dec.Decode(&skipped); err != nil {
+			return "", err
+		}
+	}
+	return "", ErrUnregisteredType
+}
+
+// DecodeValue decodes the JSON object of frame into a new value of the type registered for its TypeField, and
+// returns the pointer to it, the error is ErrUnregisteredType if no type is registered for it.
+func (cc *NDJSONCodec) DecodeValue(frame []byte) (interface{}, error) {
+	field := cc.TypeField
+	if field == "" {
+		field = "type"
+	}
+	name, err := typeName(frame, field)
+	if err != nil {
+		return nil, err
+	}
+	t, ok := cc.types[name]
+	if !ok {
+		return nil, ErrUnregisteredType
+	}
+	v := reflect.New(t).Interface()
+	if err := cc.Unmarshal(frame, v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
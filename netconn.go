@@ -0,0 +1,228 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build darwin netbsd freebsd openbsd dragonfly linux
+
+package gnet
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// netConnMaxInbound is the size of inbound data buffered by netConn at which reading the socket is paused,
+	// it's resumed once the blocking reads have taken half of it.
+	netConnMaxInbound = 256 << 10
+
+	// netConnMaxOutbound is the size of outbound data pending to be written at which the writes of netConn block,
+	// until the outbound buffer of connection is flushed.
+	netConnMaxOutbound = 256 << 10
+)
+
+// timeoutError is returned by netConn when the deadline passes.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// netConn adapts a connection to net.Conn, inbound data is fed by the event-loop instead of React and
+// blocking reads wait for it on a condition variable, so do the writes waiting for the outbound data to drain.
+type netConn struct {
+	c            *conn
+	mu           sync.Mutex
+	cond         *sync.Cond
+	inbound      []byte
+	paused       bool // whether reading has been paused for the inbound data piling up
+	queued       int  // size of data written but not queued to the outbound buffer by the event-loop yet
+	outbound     int  // size of data in the outbound buffer of connection since the last flush
+	closed       bool
+	closeErr     error
+	readDeadline time.Time
+	readTimer    *time.Timer
+	writeDead    bool
+	writeTimer   *time.Timer
+}
+
+func (c *conn) NetConn() net.Conn {
+	if c.netConn == nil {
+		nc := &netConn{c: c}
+		nc.cond = sync.NewCond(&nc.mu)
+		// Take over the data which hasn't been consumed by React.
+		nc.inbound = append(nc.inbound, c.Read()...)
+		c.ResetBuffer()
+		c.netConn = nc
+	}
+	return c.netConn
+}
+
+// feed appends the inbound data from the event-loop and wakes up the blocking reads, reading the socket is paused
+// once the inbound data reaches netConnMaxInbound.
+func (nc *netConn) feed(data []byte) {
+	nc.mu.Lock()
+	nc.inbound = append(nc.inbound, data...)
+	pause := !nc.paused && len(nc.inbound) >= netConnMaxInbound
+	if pause {
+		nc.paused = true
+	}
+	nc.mu.Unlock()
+	nc.cond.Broadcast()
+	if pause {
+		_ = nc.c.loop.loopPauseRead(nc.c)
+	}
+}
+
+// flushed records the outbound buffer flushed by the event-loop and wakes up the blocking writes.
+func (nc *netConn) flushed() {
+	nc.mu.Lock()
+	nc.outbound = 0
+	nc.mu.Unlock()
+	nc.cond.Broadcast()
+}
+
+// closeWith marks the adapter closed by the event-loop and wakes up the blocking reads.
+func (nc *netConn) closeWith(err error) {
+	nc.mu.Lock()
+	if !nc.closed {
+		nc.closed = true
+		nc.closeErr = err
+	}
+	nc.mu.Unlock()
+	nc.cond.Broadcast()
+}
+
+// Read reads the inbound data, it blocks until data arrives, the connection is closed or the read deadline passes.
+func (nc *netConn) Read(b []byte) (int, error) {
+	nc.mu.Lock()
+	for len(nc.inbound) == 0 {
+		switch {
+		case nc.closed:
+			err := nc.closeErr
+			nc.mu.Unlock()
+			if err == nil || err == ErrConnectionClosed {
+				return 0, io.EOF
+			}
+			return 0, err
+		case !nc.readDeadline.IsZero() && !time.Now().Before(nc.readDeadline):
+			nc.mu.Unlock()
+			return 0, timeoutError{}
+		}
+		nc.cond.Wait()
+	}
+	n := copy(b, nc.inbound)
+	nc.inbound = nc.inbound[n:]
+	resume := nc.paused && len(nc.inbound) < netConnMaxInbound/2
+	if resume {
+		nc.paused = false
+	}
+	nc.mu.Unlock()
+	if resume {
+		nc.c.ResumeRead()
+	}
+	return n, nil
+}
+
+// Write queues the data to the event-loop for writing without waiting for it to be written, unless the data
+// pending to be written has reached netConnMaxOutbound, in which case it blocks until the outbound buffer is
+// flushed, the connection is closed or the write deadline passes.
+func (nc *netConn) Write(b []byte) (int, error) {
+	nc.mu.Lock()
+	for {
+		switch {
+		case nc.closed:
+			nc.mu.Unlock()
+			return 0, io.ErrClosedPipe
+		case nc.writeDead:
+			nc.mu.Unlock()
+			return 0, timeoutError{}
+		}
+		if nc.queued+nc.outbound < netConnMaxOutbound {
+			break
+		}
+		nc.cond.Wait()
+	}
+	nc.queued += len(b)
+	nc.mu.Unlock()
+	buf := append([]byte{}, b...)
+	c := nc.c
+	if err := c.loop.poller.Trigger(func() error {
+		outbound := 0
+		if c.loop.connections.get(c.fd) == c {
+			if c.write(buf); c.opened {
+				outbound = c.outboundLength()
+			}
+		}
+		nc.mu.Lock()
+		nc.queued -= len(buf)
+		nc.outbound = outbound
+		nc.mu.Unlock()
+		nc.cond.Broadcast()
+		return nil
+	}); err != nil {
+		nc.mu.Lock()
+		nc.queued -= len(buf)
+		nc.mu.Unlock()
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Close closes the connection in the event-loop, which fires OnClosed. The data queued by Write is written
+// before, but the part of it left in the outbound buffer is discarded, use CloseWrite of Conn for a graceful close.
+func (nc *netConn) Close() error {
+	nc.closeWith(nil)
+	c := nc.c
+	return c.loop.poller.Trigger(func() error {
+		if c.loop.connections.get(c.fd) != c {
+			return nil // ignore stale closes.
+		}
+		return c.loop.loopCloseConn(c, nil)
+	})
+}
+
+func (nc *netConn) LocalAddr() net.Addr  { return nc.c.localAddr }
+func (nc *netConn) RemoteAddr() net.Addr { return nc.c.remoteAddr }
+
+func (nc *netConn) SetDeadline(t time.Time) error {
+	_ = nc.SetReadDeadline(t)
+	return nc.SetWriteDeadline(t)
+}
+
+func (nc *netConn) SetReadDeadline(t time.Time) error {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	nc.readDeadline = t
+	if nc.readTimer != nil {
+		nc.readTimer.Stop()
+		nc.readTimer = nil
+	}
+	if !t.IsZero() {
+		// Wake up the blocking reads once the deadline passes.
+		nc.readTimer = time.AfterFunc(time.Until(t), nc.cond.Broadcast)
+	}
+	return nil
+}
+
+// SetWriteDeadline sets the deadline of writing, which fails the writes after it and the ones blocking on it.
+func (nc *netConn) SetWriteDeadline(t time.Time) error {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	if nc.writeTimer != nil {
+		nc.writeTimer.Stop()
+		nc.writeTimer = nil
+	}
+	nc.writeDead = !t.IsZero() && !time.Now().Before(t)
+	if !t.IsZero() && !nc.writeDead {
+		nc.writeTimer = time.AfterFunc(time.Until(t), func() {
+			nc.mu.Lock()
+			nc.writeDead = true
+			nc.mu.Unlock()
+			nc.cond.Broadcast()
+		})
+	}
+	return nil
+}
@@ -0,0 +1,21 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build freebsd
+
+package netpoll
+
+import "golang.org/x/sys/unix"
+
+// acceptFilterArgSize is the size of struct accept_filter_arg.
+const acceptFilterArgSize = 256
+
+// SetDeferAccept sets up the "dataready" accept filter via SO_ACCEPTFILTER on a listening socket, so that
+// a connection is only surfaced to accept once data arrives on it, secs is ignored since the filter
+// has no timeout. The accf_data kernel module must be loaded.
+func SetDeferAccept(fd, secs int) error {
+	arg := make([]byte, acceptFilterArgSize)
+	copy(arg, "dataready")
+	return unix.SetsockoptString(fd, unix.SOL_SOCKET, unix.SO_ACCEPTFILTER, string(arg))
+}
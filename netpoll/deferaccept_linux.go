@@ -0,0 +1,15 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux
+
+package netpoll
+
+import "golang.org/x/sys/unix"
+
+// SetDeferAccept sets up the TCP_DEFER_ACCEPT socket option on a listening socket, so that a connection
+// is only surfaced to accept once data arrives on it, or it's dropped after secs seconds.
+func SetDeferAccept(fd, secs int) error {
+	return unix.SetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_DEFER_ACCEPT, secs)
+}
@@ -0,0 +1,17 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build !linux,!freebsd
+
+package netpoll
+
+import "errors"
+
+// ErrDeferAcceptUnsupported occurs when deferring accept on a platform without TCP_DEFER_ACCEPT or SO_ACCEPTFILTER.
+var ErrDeferAcceptUnsupported = errors.New("deferring accept is not supported on this platform")
+
+// SetDeferAccept always fails on platforms without TCP_DEFER_ACCEPT or SO_ACCEPTFILTER.
+func SetDeferAccept(fd, secs int) error {
+	return ErrDeferAcceptUnsupported
+}
@@ -9,43 +9,47 @@ package netpoll
 
 import (
 	"log"
+	"time"
 
-	"github.com/panjf2000/gnet/internal"
 	"golang.org/x/sys/unix"
 )
 
-// Poller represents a poller which is in charge of monitoring file-descriptors.
-type Poller struct {
-	fd            int    // epoll fd
-	wfd           int    // wake fd
-	wfdBuf        []byte // wfd buffer to read packet
-	asyncJobQueue internal.AsyncJobQueue
+// poller is the Poller based on epoll, which is in charge of monitoring file-descriptors.
+type poller struct {
+	fd         int    // epoll fd
+	wfd        int    // wake fd
+	wfdBuf     []byte // wfd buffer to read packet
+	config     PollConfig
+	timerHook  TimerHook
+	wakeupHook func()
+	jobQueue
 }
 
-// OpenPoller instantiates a poller.
-func OpenPoller() (*Poller, error) {
-	poller := new(Poller)
+// openPoller instantiates the poller based on epoll.
+func openPoller() (Poller, error) {
+	p := new(poller)
 	epollFD, err := unix.EpollCreate1(0)
 	if err != nil {
 		return nil, err
 	}
-	poller.fd = epollFD
+	p.fd = epollFD
 	r0, _, errno := unix.Syscall(unix.SYS_EVENTFD2, 0, 0, 0)
 	if errno != 0 {
 		_ = unix.Close(epollFD)
 		return nil, errno
 	}
-	poller.wfd = int(r0)
-	poller.wfdBuf = make([]byte, 8)
-	if err = poller.AddRead(poller.wfd); err != nil {
+	p.wfd = int(r0)
+	p.wfdBuf = make([]byte, 8)
+	if err = p.AddRead(p.wfd); err != nil {
 		return nil, err
 	}
-	poller.asyncJobQueue = internal.NewAsyncJobQueue()
-	return poller, nil
+	p.initJobs()
+	return p, nil
 }
 
 // Close closes the poller.
-func (p *Poller) Close() error {
+func (p *poller) Close() error {
+	p.closeJobs()
 	if err := unix.Close(p.wfd); err != nil {
 		return err
 	}
@@ -55,22 +59,50 @@ func (p *Poller) Close() error {
 var wakeSignal = []byte{0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
 
 // Trigger wakes up the poller blocked in waiting for network-events and runs jobs in asyncJobQueue.
-func (p *Poller) Trigger(job internal.Job) error {
-	p.asyncJobQueue.Push(job)
+func (p *poller) Trigger(job Job) error {
+	return p.trigger(job, p)
+}
+
+func (p *poller) wake() error {
 	_, err := unix.Write(p.wfd, wakeSignal)
 	return err
 }
 
+// SetPollConfig tunes the way in which the poller waits for network-events, it must be called before Polling.
+func (p *poller) SetPollConfig(config PollConfig) {
+	p.config = config
+}
+
+// SetTimerHook installs the hook driving timers, it must be called before Polling.
+func (p *poller) SetTimerHook(hook TimerHook) {
+	p.timerHook = hook
+}
+
+// SetWakeupHook installs the hook invoked every time the poller returns from waiting, before any events are
+// handled, it must be called before Polling.
+func (p *poller) SetWakeupHook(hook func()) {
+	p.wakeupHook = hook
+}
+
 // Polling blocks the current goroutine, waiting for network-events.
-func (p *Poller) Polling(callback func(fd int, ev uint32, job internal.Job) error) (err error) {
-	el := newEventList(initEvents)
-	var wakenUp bool
+func (p *poller) Polling(callback func(fd int, ev Event, job Job) error) (err error) {
+	size, growable := p.config.batchSize()
+	el := newEventList(size)
+	var wakenUp, busy bool
 	for {
-		n, err0 := unix.EpollWait(p.fd, el.events, -1)
+		msec := -1
+		if timeout := pollTimeout(&p.config, p.timerHook, busy); timeout >= 0 {
+			msec = int((timeout + time.Millisecond - 1) / time.Millisecond)
+		}
+		n, err0 := unix.EpollWait(p.fd, el.events, msec)
+		if p.wakeupHook != nil {
+			p.wakeupHook()
+		}
 		if err0 != nil && err0 != unix.EINTR {
 			log.Println(err0)
 			continue
 		}
+		busy = n > 0
 		for i := 0; i < n; i++ {
 			if fd := int(el.events[i].Fd); fd != p.wfd {
 				if err = callback(fd, el.events[i].Events, nil); err != nil {
@@ -81,13 +113,20 @@ func (p *Poller) Polling(callback func(fd int, ev uint32, job internal.Job) erro
 				_, _ = unix.Read(p.wfd, p.wfdBuf)
 			}
 		}
-		if wakenUp {
+		// Run the pending jobs in every iteration rather than only after reading the wakeup, so that the jobs
+		// triggered while handling events are run in a batch without waiting for another poll.
+		if wakenUp || p.jobsNotified() {
 			wakenUp = false
-			if err = p.asyncJobQueue.ForEach(); err != nil {
+			if err = p.runJobs(); err != nil {
+				return
+			}
+		}
+		if p.timerHook != nil {
+			if err = p.timerHook.Expire(); err != nil {
 				return
 			}
 		}
-		if n == el.size {
+		if n == el.size && growable {
 			el.increase()
 		}
 	}
@@ -100,31 +139,62 @@ const (
 )
 
 // AddReadWrite registers the given file-descriptor with readable and writable events to the poller.
-func (p *Poller) AddReadWrite(fd int) error {
+func (p *poller) AddReadWrite(fd int) error {
 	return unix.EpollCtl(p.fd, unix.EPOLL_CTL_ADD, fd, &unix.EpollEvent{Fd: int32(fd), Events: readWriteEvents})
 }
 
 // AddRead registers the given file-descriptor with readable event to the poller.
-func (p *Poller) AddRead(fd int) error {
+func (p *poller) AddRead(fd int) error {
 	return unix.EpollCtl(p.fd, unix.EPOLL_CTL_ADD, fd, &unix.EpollEvent{Fd: int32(fd), Events: readEvents})
 }
 
+// AddReadExclusive registers the given file-descriptor with readable event and EPOLLEXCLUSIVE to the poller,
+// so that only one of the pollers sharing the file-descriptor is woken up by an event on it.
+// EPOLLPRI is left out since it can't be combined with EPOLLEXCLUSIVE.
+func (p *poller) AddReadExclusive(fd int) error {
+	return unix.EpollCtl(p.fd, unix.EPOLL_CTL_ADD, fd,
+		&unix.EpollEvent{Fd: int32(fd), Events: unix.EPOLLIN | unix.EPOLLEXCLUSIVE})
+}
+
+// AddReadWriteEdge registers the given file-descriptor with readable and writable events in the edge-triggered
+// mode to the poller, the events are only reported when the file-descriptor changes its state.
+func (p *poller) AddReadWriteEdge(fd int) error {
+	return unix.EpollCtl(p.fd, unix.EPOLL_CTL_ADD, fd,
+		&unix.EpollEvent{Fd: int32(fd), Events: readWriteEvents | unix.EPOLLET})
+}
+
 // AddWrite registers the given file-descriptor with writable event to the poller.
-func (p *Poller) AddWrite(fd int) error {
+func (p *poller) AddWrite(fd int) error {
 	return unix.EpollCtl(p.fd, unix.EPOLL_CTL_ADD, fd, &unix.EpollEvent{Fd: int32(fd), Events: writeEvents})
 }
 
 // ModRead renews the given file-descriptor with readable event in the poller.
-func (p *Poller) ModRead(fd int) error {
+func (p *poller) ModRead(fd int) error {
 	return unix.EpollCtl(p.fd, unix.EPOLL_CTL_MOD, fd, &unix.EpollEvent{Fd: int32(fd), Events: readEvents})
 }
 
 // ModReadWrite renews the given file-descriptor with readable and writable events in the poller.
-func (p *Poller) ModReadWrite(fd int) error {
+func (p *poller) ModReadWrite(fd int) error {
 	return unix.EpollCtl(p.fd, unix.EPOLL_CTL_MOD, fd, &unix.EpollEvent{Fd: int32(fd), Events: readWriteEvents})
 }
 
+// ModWrite renews the given file-descriptor with writable event only in the poller.
+func (p *poller) ModWrite(fd int) error {
+	return unix.EpollCtl(p.fd, unix.EPOLL_CTL_MOD, fd, &unix.EpollEvent{Fd: int32(fd), Events: writeEvents})
+}
+
+// ModNone renews the given file-descriptor with no events in the poller, only the exceptional events
+// are reported for it.
+func (p *poller) ModNone(fd int) error {
+	return unix.EpollCtl(p.fd, unix.EPOLL_CTL_MOD, fd, &unix.EpollEvent{Fd: int32(fd)})
+}
+
+// DeleteRead stops watching the readable event of the given file-descriptor registered by AddRead.
+func (p *poller) DeleteRead(fd int) error {
+	return unix.EpollCtl(p.fd, unix.EPOLL_CTL_DEL, fd, nil)
+}
+
 // Delete removes the given file-descriptor from the poller.
-func (p *Poller) Delete(fd int) error {
+func (p *poller) Delete(fd int) error {
 	return unix.EpollCtl(p.fd, unix.EPOLL_CTL_DEL, fd, nil)
 }
@@ -16,8 +16,14 @@ const (
 	OutEvents = ErrEvents | unix.EPOLLOUT
 	// InEvents combines EPOLLIN/EPOLLPRI events and some exceptional events.
 	InEvents = ErrEvents | unix.EPOLLIN | unix.EPOLLPRI
+
+	// ExclusiveReadSupported indicates whether Poller.AddReadExclusive is available.
+	ExclusiveReadSupported = true
 )
 
+// Event is the set of epoll events of a file-descriptor reported by Poller.Polling.
+type Event = uint32
+
 type eventList struct {
 	size   int
 	events []unix.EpollEvent
@@ -4,4 +4,70 @@
 
 package netpoll
 
+import (
+	"errors"
+	"time"
+)
+
+// ErrPollerClosed is returned when triggering a poller which has been closed.
+var ErrPollerClosed = errors.New("poller has been closed")
+
+// ErrEdgeTriggeredUnsupported is returned when registering a file-descriptor in the edge-triggered mode
+// to a poller which is level-triggered only.
+var ErrEdgeTriggeredUnsupported = errors.New("edge-triggered mode is not supported by poller")
+
 const initEvents = 512
+
+// PollConfig tunes the way in which a poller waits for network-events.
+type PollConfig struct {
+	// BatchSize is the maximum number of events returned by one epoll_wait/kevent, the batch starts with
+	// 512 events and grows on demand if it's zero.
+	BatchSize int
+
+	// Timeout is the maximum time of waiting for events in one epoll_wait/kevent, zero blocks until events arrive.
+	Timeout time.Duration
+
+	// Adaptive indicates whether to poll without waiting as long as the previous poll returned events,
+	// which trades CPU for latency under high load.
+	Adaptive bool
+}
+
+// TimerHook drives timers from a poller, so that timers are run in the goroutine of poller without extra wakeups.
+type TimerHook interface {
+	// Next returns the time until the next timer expires, or a negative duration if there are no timers.
+	Next() time.Duration
+
+	// Expire runs the expired timers, Polling returns the error returned by it.
+	Expire() error
+}
+
+// pollTimeout returns the timeout of the next poll, negative means blocking until events arrive.
+func pollTimeout(cfg *PollConfig, hook TimerHook, busy bool) time.Duration {
+	timeout := cfg.timeout(busy)
+	if hook != nil {
+		if next := hook.Next(); next >= 0 && (timeout < 0 || next < timeout) {
+			timeout = next
+		}
+	}
+	return timeout
+}
+
+// batchSize returns the initial size of event list and whether it grows on demand.
+func (cfg *PollConfig) batchSize() (size int, growable bool) {
+	if cfg.BatchSize > 0 {
+		return cfg.BatchSize, false
+	}
+	return initEvents, true
+}
+
+// timeout returns the timeout of the next poll, negative means blocking until events arrive.
+func (cfg *PollConfig) timeout(busy bool) time.Duration {
+	switch {
+	case busy && cfg.Adaptive:
+		return 0
+	case cfg.Timeout > 0:
+		return cfg.Timeout
+	default:
+		return -1
+	}
+}
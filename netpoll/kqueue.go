@@ -10,25 +10,27 @@ package netpoll
 import (
 	"log"
 
-	"github.com/panjf2000/gnet/internal"
 	"golang.org/x/sys/unix"
 )
 
-// Poller represents a poller which is in charge of monitoring file-descriptors.
-type Poller struct {
-	fd            int
-	asyncJobQueue internal.AsyncJobQueue
+// poller is the Poller based on kqueue, which is in charge of monitoring file-descriptors.
+type poller struct {
+	fd         int
+	config     PollConfig
+	timerHook  TimerHook
+	wakeupHook func()
+	jobQueue
 }
 
-// OpenPoller instantiates a poller.
-func OpenPoller() (*Poller, error) {
-	poller := new(Poller)
+// openPoller instantiates the poller based on kqueue.
+func openPoller() (Poller, error) {
+	p := new(poller)
 	kfd, err := unix.Kqueue()
 	if err != nil {
 		return nil, err
 	}
-	poller.fd = kfd
-	_, err = unix.Kevent(poller.fd, []unix.Kevent_t{{
+	p.fd = kfd
+	_, err = unix.Kevent(p.fd, []unix.Kevent_t{{
 		Ident:  0,
 		Filter: unix.EVFILT_USER,
 		Flags:  unix.EV_ADD | unix.EV_CLEAR,
@@ -36,12 +38,13 @@ func OpenPoller() (*Poller, error) {
 	if err != nil {
 		return nil, err
 	}
-	poller.asyncJobQueue = internal.NewAsyncJobQueue()
-	return poller, nil
+	p.initJobs()
+	return p, nil
 }
 
 // Close closes the poller.
-func (p *Poller) Close() error {
+func (p *poller) Close() error {
+	p.closeJobs()
 	return unix.Close(p.fd)
 }
 
@@ -52,22 +55,51 @@ var wakeChanges = []unix.Kevent_t{{
 }}
 
 // Trigger wakes up the poller blocked in waiting for network-events and runs jobs in asyncJobQueue.
-func (p *Poller) Trigger(job internal.Job) error {
-	p.asyncJobQueue.Push(job)
+func (p *poller) Trigger(job Job) error {
+	return p.trigger(job, p)
+}
+
+func (p *poller) wake() error {
 	_, err := unix.Kevent(p.fd, wakeChanges, nil, nil)
 	return err
 }
 
+// SetPollConfig tunes the way in which the poller waits for network-events, it must be called before Polling.
+func (p *poller) SetPollConfig(config PollConfig) {
+	p.config = config
+}
+
+// SetTimerHook installs the hook driving timers, it must be called before Polling.
+func (p *poller) SetTimerHook(hook TimerHook) {
+	p.timerHook = hook
+}
+
+// SetWakeupHook installs the hook invoked every time the poller returns from waiting, before any events are
+// handled, it must be called before Polling.
+func (p *poller) SetWakeupHook(hook func()) {
+	p.wakeupHook = hook
+}
+
 // Polling blocks the current goroutine, waiting for network-events.
-func (p *Poller) Polling(callback func(fd int, filter int16, job internal.Job) error) (err error) {
-	el := newEventList(initEvents)
-	var wakenUp bool
+func (p *poller) Polling(callback func(fd int, filter Event, job Job) error) (err error) {
+	size, growable := p.config.batchSize()
+	el := newEventList(size)
+	var wakenUp, busy bool
 	for {
-		n, err0 := unix.Kevent(p.fd, nil, el.events, nil)
+		var ts *unix.Timespec
+		if timeout := pollTimeout(&p.config, p.timerHook, busy); timeout >= 0 {
+			spec := unix.NsecToTimespec(int64(timeout))
+			ts = &spec
+		}
+		n, err0 := unix.Kevent(p.fd, nil, el.events, ts)
+		if p.wakeupHook != nil {
+			p.wakeupHook()
+		}
 		if err0 != nil && err0 != unix.EINTR {
 			log.Println(err0)
 			continue
 		}
+		busy = n > 0
 		var evFilter int16
 		for i := 0; i < n; i++ {
 			if fd := int(el.events[i].Ident); fd != 0 {
@@ -82,20 +114,27 @@ func (p *Poller) Polling(callback func(fd int, filter int16, job internal.Job) e
 				wakenUp = true
 			}
 		}
-		if wakenUp {
+		// Run the pending jobs in every iteration rather than only after reading the wakeup, so that the jobs
+		// triggered while handling events are run in a batch without waiting for another poll.
+		if wakenUp || p.jobsNotified() {
 			wakenUp = false
-			if err = p.asyncJobQueue.ForEach(); err != nil {
+			if err = p.runJobs(); err != nil {
 				return
 			}
 		}
-		if n == el.size {
+		if p.timerHook != nil {
+			if err = p.timerHook.Expire(); err != nil {
+				return
+			}
+		}
+		if n == el.size && growable {
 			el.increase()
 		}
 	}
 }
 
 // AddReadWrite registers the given file-descriptor with readable and writable events to the poller.
-func (p *Poller) AddReadWrite(fd int) error {
+func (p *poller) AddReadWrite(fd int) error {
 	if _, err := unix.Kevent(p.fd, []unix.Kevent_t{
 		{Ident: uint64(fd), Flags: unix.EV_ADD, Filter: unix.EVFILT_READ},
 		{Ident: uint64(fd), Flags: unix.EV_ADD, Filter: unix.EVFILT_WRITE},
@@ -106,7 +145,7 @@ func (p *Poller) AddReadWrite(fd int) error {
 }
 
 // AddRead registers the given file-descriptor with readable event to the poller.
-func (p *Poller) AddRead(fd int) error {
+func (p *poller) AddRead(fd int) error {
 	if _, err := unix.Kevent(p.fd, []unix.Kevent_t{
 		{Ident: uint64(fd), Flags: unix.EV_ADD, Filter: unix.EVFILT_READ}}, nil, nil); err != nil {
 		return err
@@ -114,8 +153,26 @@ func (p *Poller) AddRead(fd int) error {
 	return nil
 }
 
+// AddReadExclusive registers the given file-descriptor with readable event to the poller, kqueue has no
+// counterpart of EPOLLEXCLUSIVE so every poller sharing the file-descriptor is woken up by an event on it.
+func (p *poller) AddReadExclusive(fd int) error {
+	return p.AddRead(fd)
+}
+
+// AddReadWriteEdge registers the given file-descriptor with readable and writable events in the edge-triggered
+// mode (EV_CLEAR) to the poller, the events are only reported when the file-descriptor changes its state.
+func (p *poller) AddReadWriteEdge(fd int) error {
+	if _, err := unix.Kevent(p.fd, []unix.Kevent_t{
+		{Ident: uint64(fd), Flags: unix.EV_ADD | unix.EV_CLEAR, Filter: unix.EVFILT_READ},
+		{Ident: uint64(fd), Flags: unix.EV_ADD | unix.EV_CLEAR, Filter: unix.EVFILT_WRITE},
+	}, nil, nil); err != nil {
+		return err
+	}
+	return nil
+}
+
 // AddWrite registers the given file-descriptor with writable event to the poller.
-func (p *Poller) AddWrite(fd int) error {
+func (p *poller) AddWrite(fd int) error {
 	if _, err := unix.Kevent(p.fd, []unix.Kevent_t{
 		{Ident: uint64(fd), Flags: unix.EV_ADD, Filter: unix.EVFILT_WRITE}}, nil, nil); err != nil {
 		return err
@@ -123,8 +180,35 @@ func (p *Poller) AddWrite(fd int) error {
 	return nil
 }
 
-// ModRead renews the given file-descriptor with readable event in the poller.
-func (p *Poller) ModRead(fd int) error {
+// ModRead renews the given file-descriptor with readable event in the poller, the readable filter is added back
+// if it has been deleted by ModWrite or ModNone.
+func (p *poller) ModRead(fd int) error {
+	if err := p.AddRead(fd); err != nil {
+		return err
+	}
+	return p.deleteWrite(fd)
+}
+
+// ModReadWrite renews the given file-descriptor with readable and writable events in the poller.
+func (p *poller) ModReadWrite(fd int) error {
+	return p.AddReadWrite(fd)
+}
+
+// ModWrite renews the given file-descriptor with writable event only in the poller.
+func (p *poller) ModWrite(fd int) error {
+	_ = p.DeleteRead(fd)
+	return p.AddWrite(fd)
+}
+
+// ModNone renews the given file-descriptor with no events in the poller.
+func (p *poller) ModNone(fd int) error {
+	_ = p.DeleteRead(fd)
+	_ = p.deleteWrite(fd)
+	return nil
+}
+
+// deleteWrite stops watching the writable event of the given file-descriptor.
+func (p *poller) deleteWrite(fd int) error {
 	if _, err := unix.Kevent(p.fd, []unix.Kevent_t{
 		{Ident: uint64(fd), Flags: unix.EV_DELETE, Filter: unix.EVFILT_WRITE}}, nil, nil); err != nil {
 		return err
@@ -132,16 +216,16 @@ func (p *Poller) ModRead(fd int) error {
 	return nil
 }
 
-// ModReadWrite renews the given file-descriptor with readable and writable events in the poller.
-func (p *Poller) ModReadWrite(fd int) error {
+// DeleteRead stops watching the readable event of the given file-descriptor registered by AddRead.
+func (p *poller) DeleteRead(fd int) error {
 	if _, err := unix.Kevent(p.fd, []unix.Kevent_t{
-		{Ident: uint64(fd), Flags: unix.EV_ADD, Filter: unix.EVFILT_WRITE}}, nil, nil); err != nil {
+		{Ident: uint64(fd), Flags: unix.EV_DELETE, Filter: unix.EVFILT_READ}}, nil, nil); err != nil {
 		return err
 	}
 	return nil
 }
 
 // Delete removes the given file-descriptor from the poller.
-func (p *Poller) Delete(fd int) error {
+func (p *poller) Delete(fd int) error {
 	return nil
 }
@@ -15,8 +15,14 @@ const (
 	EVFilterRead = unix.EVFILT_READ
 	// EVFilterSock ...
 	EVFilterSock = -0xd
+
+	// ExclusiveReadSupported indicates whether Poller.AddReadExclusive is available.
+	ExclusiveReadSupported = false
 )
 
+// Event is the kqueue filter of a file-descriptor reported by Poller.Polling, or EVFilterSock on EOF and errors.
+type Event = int16
+
 type eventList struct {
 	size   int
 	events []unix.Kevent_t
@@ -0,0 +1,15 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build darwin netbsd freebsd openbsd dragonfly linux
+
+package netpoll
+
+import "golang.org/x/sys/unix"
+
+// SetLinger sets up the SO_LINGER socket option, closing the socket blocks for at most the given seconds
+// to send the unsent data, and a zero value discards the unsent data and resets the connection with RST.
+func SetLinger(fd, secs int) error {
+	return unix.SetsockoptLinger(fd, unix.SOL_SOCKET, unix.SO_LINGER, &unix.Linger{Onoff: 1, Linger: int32(secs)})
+}
@@ -0,0 +1,17 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build darwin netbsd freebsd openbsd dragonfly linux
+// +build !poll
+
+package netpoll
+
+// EdgeTriggeredSupported indicates whether the pollers opened by OpenPoller support Poller.AddReadWriteEdge.
+const EdgeTriggeredSupported = true
+
+// OpenPoller instantiates a poller based on epoll or kqueue, the one based on poll(2) is used instead
+// if the package is built with the poll tag.
+func OpenPoller() (Poller, error) {
+	return openPoller()
+}
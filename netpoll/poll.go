@@ -0,0 +1,281 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build darwin netbsd freebsd openbsd dragonfly linux
+
+package netpoll
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	pollReadEvents      = unix.POLLIN | unix.POLLPRI
+	pollWriteEvents     = unix.POLLOUT
+	pollReadWriteEvents = pollReadEvents | pollWriteEvents
+)
+
+// pollPoller is the Poller based on poll(2), which is portable to the platforms without epoll or kqueue at the cost
+// of scanning all the file-descriptors in every poll. The events are level-triggered only, BatchSize is ignored.
+type pollPoller struct {
+	rfd, wfd   int    // pipe waking up the poller
+	wakeBuf    []byte // buffer to drain the pipe
+	config     PollConfig
+	timerHook  TimerHook
+	wakeupHook func()
+	jobQueue
+	mu      sync.Mutex    // protects events and dirty
+	events  map[int]int16 // events watched of file-descriptors
+	dirty   bool          // whether events has been changed since fds was built
+	polling int32         // whether the poller is blocked in poll, accessed atomically
+	fds     []unix.PollFd // only accessed by Polling
+}
+
+// OpenPollPoller instantiates a poller based on poll(2), it's the one returned by OpenPoller if the package is
+// built with the poll tag. The poller doesn't support AddReadWriteEdge.
+func OpenPollPoller() (Poller, error) {
+	var fds [2]int
+	if err := unix.Pipe(fds[:]); err != nil {
+		return nil, err
+	}
+	for _, fd := range fds {
+		unix.CloseOnExec(fd)
+		if err := unix.SetNonblock(fd, true); err != nil {
+			_ = unix.Close(fds[0])
+			_ = unix.Close(fds[1])
+			return nil, err
+		}
+	}
+	p := &pollPoller{
+		rfd:     fds[0],
+		wfd:     fds[1],
+		wakeBuf: make([]byte, 64),
+		events:  make(map[int]int16),
+	}
+	p.initJobs()
+	_ = p.AddRead(p.rfd)
+	return p, nil
+}
+
+// Close closes the poller.
+func (p *pollPoller) Close() error {
+	p.closeJobs()
+	if err := unix.Close(p.wfd); err != nil {
+		return err
+	}
+	return unix.Close(p.rfd)
+}
+
+// Trigger wakes up the poller blocked in waiting for network-events and runs jobs in asyncJobQueue.
+func (p *pollPoller) Trigger(job Job) error {
+	return p.trigger(job, p)
+}
+
+func (p *pollPoller) wake() error {
+	if _, err := unix.Write(p.wfd, []byte{0}); err != nil && err != unix.EAGAIN {
+		return err
+	}
+	return nil // the pipe is full of pending wakeups on EAGAIN.
+}
+
+// SetPollConfig tunes the way in which the poller waits for network-events, it must be called before Polling.
+func (p *pollPoller) SetPollConfig(config PollConfig) {
+	p.config = config
+}
+
+// SetTimerHook installs the hook driving timers, it must be called before Polling.
+func (p *pollPoller) SetTimerHook(hook TimerHook) {
+	p.timerHook = hook
+}
+
+// SetWakeupHook installs the hook invoked every time the poller returns from waiting, before any events are
+// handled, it must be called before Polling.
+func (p *pollPoller) SetWakeupHook(hook func()) {
+	p.wakeupHook = hook
+}
+
+// Polling blocks the current goroutine, waiting for network-events.
+func (p *pollPoller) Polling(callback func(fd int, ev Event, job Job) error) (err error) {
+	var wakenUp, busy bool
+	for {
+		msec := -1
+		if timeout := pollTimeout(&p.config, p.timerHook, busy); timeout >= 0 {
+			msec = int((timeout + time.Millisecond - 1) / time.Millisecond)
+		}
+		// Mark polling before picking up the changes of events, the changes made afterwards wake up the poll.
+		atomic.StoreInt32(&p.polling, 1)
+		p.rebuild()
+		n, err0 := unix.Poll(p.fds, msec)
+		atomic.StoreInt32(&p.polling, 0)
+		if p.wakeupHook != nil {
+			p.wakeupHook()
+		}
+		if err0 != nil && err0 != unix.EINTR {
+			log.Println(err0)
+			continue
+		}
+		busy = n > 0
+		for i := 0; i < len(p.fds) && n > 0; i++ {
+			revents := p.fds[i].Revents
+			if revents == 0 {
+				continue
+			}
+			n--
+			switch fd := int(p.fds[i].Fd); {
+			case fd == p.rfd:
+				wakenUp = true
+				for {
+					if _, err0 = unix.Read(p.rfd, p.wakeBuf); err0 != nil {
+						break
+					}
+				}
+			case revents&unix.POLLNVAL != 0:
+				// The file-descriptor has been closed without being deleted.
+			default:
+				if err = pollDispatch(fd, revents, callback); err != nil {
+					return
+				}
+			}
+		}
+		if wakenUp || p.jobsNotified() {
+			wakenUp = false
+			if err = p.runJobs(); err != nil {
+				return
+			}
+		}
+		if p.timerHook != nil {
+			if err = p.timerHook.Expire(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// rebuild builds the file-descriptors to poll from the events watched if they have been changed.
+func (p *pollPoller) rebuild() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.dirty {
+		return
+	}
+	p.dirty = false
+	p.fds = p.fds[:0]
+	for fd, events := range p.events {
+		p.fds = append(p.fds, unix.PollFd{Fd: int32(fd), Events: events})
+	}
+}
+
+// set changes the events watched of the file-descriptor, which must or mustn't have been watched according to add.
+func (p *pollPoller) set(fd int, events int16, add bool) error {
+	p.mu.Lock()
+	if _, ok := p.events[fd]; ok == add {
+		p.mu.Unlock()
+		if add {
+			return unix.EEXIST
+		}
+		return unix.ENOENT
+	}
+	p.events[fd] = events
+	p.dirty = true
+	p.mu.Unlock()
+	p.interrupt()
+	return nil
+}
+
+// interrupt wakes up the poll to pick up the changes of events made by other goroutines.
+func (p *pollPoller) interrupt() {
+	if atomic.LoadInt32(&p.polling) == 1 {
+		p.closeMu.RLock()
+		if atomic.LoadInt32(&p.closed) == 0 {
+			_ = p.wake()
+		}
+		p.closeMu.RUnlock()
+	}
+}
+
+// AddReadWrite registers the given file-descriptor with readable and writable events to the poller.
+func (p *pollPoller) AddReadWrite(fd int) error {
+	return p.set(fd, pollReadWriteEvents, true)
+}
+
+// AddRead registers the given file-descriptor with readable event to the poller.
+func (p *pollPoller) AddRead(fd int) error {
+	return p.set(fd, pollReadEvents, true)
+}
+
+// AddReadExclusive registers the given file-descriptor with readable event to the poller, the exclusive wakeup
+// isn't supported by poll(2).
+func (p *pollPoller) AddReadExclusive(fd int) error {
+	return p.AddRead(fd)
+}
+
+// AddReadWriteEdge returns ErrEdgeTriggeredUnsupported since poll(2) is level-triggered only.
+func (p *pollPoller) AddReadWriteEdge(fd int) error {
+	return ErrEdgeTriggeredUnsupported
+}
+
+// AddWrite registers the given file-descriptor with writable event to the poller.
+func (p *pollPoller) AddWrite(fd int) error {
+	return p.set(fd, pollWriteEvents, true)
+}
+
+// ModRead renews the given file-descriptor with readable event in the poller.
+func (p *pollPoller) ModRead(fd int) error {
+	return p.set(fd, pollReadEvents, false)
+}
+
+// ModReadWrite renews the given file-descriptor with readable and writable events in the poller.
+func (p *pollPoller) ModReadWrite(fd int) error {
+	return p.set(fd, pollReadWriteEvents, false)
+}
+
+// ModWrite renews the given file-descriptor with writable event only in the poller.
+func (p *pollPoller) ModWrite(fd int) error {
+	return p.set(fd, pollWriteEvents, false)
+}
+
+// ModNone renews the given file-descriptor with no events in the poller, only the exceptional events
+// are reported for it.
+func (p *pollPoller) ModNone(fd int) error {
+	return p.set(fd, 0, false)
+}
+
+// DeleteRead stops watching the readable event of the given file-descriptor, it's removed from the poller
+// if no events are left.
+func (p *pollPoller) DeleteRead(fd int) error {
+	p.mu.Lock()
+	events, ok := p.events[fd]
+	if !ok {
+		p.mu.Unlock()
+		return unix.ENOENT
+	}
+	if events &^= pollReadEvents; events == 0 {
+		delete(p.events, fd)
+	} else {
+		p.events[fd] = events
+	}
+	p.dirty = true
+	p.mu.Unlock()
+	p.interrupt()
+	return nil
+}
+
+// Delete removes the given file-descriptor from the poller.
+func (p *pollPoller) Delete(fd int) error {
+	p.mu.Lock()
+	if _, ok := p.events[fd]; !ok {
+		p.mu.Unlock()
+		return unix.ENOENT
+	}
+	delete(p.events, fd)
+	p.dirty = true
+	p.mu.Unlock()
+	p.interrupt()
+	return nil
+}
@@ -0,0 +1,26 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build darwin netbsd freebsd openbsd dragonfly
+
+package netpoll
+
+import "golang.org/x/sys/unix"
+
+// pollDispatch passes the events returned by poll(2) to callback as kqueue filters, in the order of
+// writable and readable events as they're reported by kqueue.
+func pollDispatch(fd int, revents int16, callback func(fd int, ev Event, job Job) error) error {
+	if revents&(unix.POLLHUP|unix.POLLERR) != 0 {
+		return callback(fd, EVFilterSock, nil)
+	}
+	if revents&pollWriteEvents != 0 {
+		if err := callback(fd, EVFilterWrite, nil); err != nil {
+			return err
+		}
+	}
+	if revents&pollReadEvents != 0 {
+		return callback(fd, EVFilterRead, nil)
+	}
+	return nil
+}
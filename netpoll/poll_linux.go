@@ -0,0 +1,12 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux
+
+package netpoll
+
+// pollDispatch passes the events returned by poll(2) to callback, the poll events share the values of epoll events.
+func pollDispatch(fd int, revents int16, callback func(fd int, ev Event, job Job) error) error {
+	return callback(fd, Event(uint16(revents)), nil)
+}
@@ -0,0 +1,75 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build darwin netbsd freebsd openbsd dragonfly linux
+
+package netpoll
+
+import "github.com/panjf2000/gnet/internal"
+
+// Job is a function run by the goroutine of poller after being triggered.
+type Job = internal.Job
+
+// Poller is in charge of monitoring file-descriptors, OpenPoller returns the one based on epoll or kqueue,
+// alternative backends can be supplied to gnet by implementing it.
+type Poller interface {
+	// Close closes the poller, it makes Polling return once it's woken up.
+	Close() error
+
+	// Trigger runs the job in the goroutine of Polling, waking it up if it's blocked in waiting for events.
+	// It's safe to call from any goroutines, ErrPollerClosed is returned after the poller is closed.
+	Trigger(job Job) error
+
+	// SetPollConfig tunes the way in which the poller waits for events, it's called before Polling.
+	SetPollConfig(config PollConfig)
+
+	// SetTimerHook installs the hook driving timers, it's called before Polling.
+	SetTimerHook(hook TimerHook)
+
+	// SetWakeupHook installs the hook invoked every time the poller returns from waiting, before any events
+	// are handled, it's called before Polling.
+	SetWakeupHook(hook func())
+
+	// Polling blocks the current goroutine, passing the events of file-descriptors to callback, until
+	// callback, a triggered job or the timer hook returns an error.
+	Polling(callback func(fd int, ev Event, job Job) error) error
+
+	// TriggerStats returns the counters of jobs triggered to the poller.
+	TriggerStats() TriggerStats
+
+	// AddReadWrite registers the given file-descriptor with readable and writable events to the poller.
+	AddReadWrite(fd int) error
+
+	// AddRead registers the given file-descriptor with readable event to the poller.
+	AddRead(fd int) error
+
+	// AddReadExclusive registers the given file-descriptor with readable event to the poller, waking up only
+	// one of the pollers sharing the file-descriptor, if ExclusiveReadSupported.
+	AddReadExclusive(fd int) error
+
+	// AddReadWriteEdge registers the given file-descriptor with readable and writable events in the
+	// edge-triggered mode to the poller.
+	AddReadWriteEdge(fd int) error
+
+	// AddWrite registers the given file-descriptor with writable event to the poller.
+	AddWrite(fd int) error
+
+	// ModRead renews the given file-descriptor with readable event in the poller.
+	ModRead(fd int) error
+
+	// ModReadWrite renews the given file-descriptor with readable and writable events in the poller.
+	ModReadWrite(fd int) error
+
+	// ModWrite renews the given file-descriptor with writable event only in the poller.
+	ModWrite(fd int) error
+
+	// ModNone renews the given file-descriptor with no events in the poller.
+	ModNone(fd int) error
+
+	// DeleteRead stops watching the readable event of the given file-descriptor.
+	DeleteRead(fd int) error
+
+	// Delete removes the given file-descriptor from the poller.
+	Delete(fd int) error
+}
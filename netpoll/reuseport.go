@@ -4,6 +4,7 @@ package netpoll
 
 import (
 	"net"
+	"syscall"
 
 	"github.com/libp2p/go-reuseport"
 )
@@ -17,3 +18,23 @@ func ReusePortListenPacket(proto, addr string) (net.PacketConn, error) {
 func ReusePortListen(proto, addr string) (net.Listener, error) {
 	return reuseport.Listen(proto, addr)
 }
+
+// ListenConfig returns a net.ListenConfig which sets up SO_REUSEPORT if reusePort is true and then
+// invokes setup with the network (e.g. tcp4, udp6) and the socket, before the socket is bound.
+func ListenConfig(reusePort bool, setup func(network string, fd int) error) *net.ListenConfig {
+	return &net.ListenConfig{
+		Control: func(network, address string, c syscall.RawConn) (err error) {
+			if reusePort {
+				if err = reuseport.Control(network, address, c); err != nil {
+					return
+				}
+			}
+			if cerr := c.Control(func(fd uintptr) {
+				err = setup(network, int(fd))
+			}); cerr != nil {
+				return cerr
+			}
+			return
+		},
+	}
+}
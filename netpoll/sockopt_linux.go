@@ -0,0 +1,170 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux
+
+package netpoll
+
+import (
+	"net"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// SetUserTimeout sets up the TCP_USER_TIMEOUT socket option, which is the maximum amount of time in milliseconds
+// that transmitted data may remain unacknowledged before the kernel forcefully closes the connection.
+func SetUserTimeout(fd, msecs int) error {
+	return unix.SetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_USER_TIMEOUT, msecs)
+}
+
+// SetBindToDevice sets up the SO_BINDTODEVICE socket option, which binds the socket to the given network interface.
+func SetBindToDevice(fd int, ifname string) error {
+	return unix.BindToDevice(fd, ifname)
+}
+
+// SetFreeBind sets up the IP_FREEBIND socket option, which allows binding to an IP address that is nonlocal
+// or does not (yet) exist.
+func SetFreeBind(fd int, ipv6 bool) error {
+	if ipv6 {
+		return unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_FREEBIND, 1)
+	}
+	return unix.SetsockoptInt(fd, unix.IPPROTO_IP, unix.IP_FREEBIND, 1)
+}
+
+// SetMark sets up the SO_MARK socket option, which marks the packets sent through the socket for policy routing.
+func SetMark(fd, mark int) error {
+	return unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_MARK, mark)
+}
+
+// SetFastOpen sets up the TCP_FASTOPEN socket option on a listening socket, qlen is the maximum length
+// of the queue of pending TCP Fast Open requests.
+func SetFastOpen(fd, qlen int) error {
+	return unix.SetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_FASTOPEN, qlen)
+}
+
+const (
+	udpSegment = 103 // UDP_SEGMENT
+	udpGRO     = 104 // UDP_GRO
+)
+
+// SetUDPGRO sets up the UDP_GRO socket option, which lets the kernel coalesce the datagrams received from the same
+// flow into one buffer, the size of the segments is reported by UDPGROSegmentSize.
+func SetUDPGRO(fd int) error {
+	return unix.SetsockoptInt(fd, unix.IPPROTO_UDP, udpGRO, 1)
+}
+
+// UDPGROSegmentSize returns the size of the segments of coalesced datagrams from the control messages received
+// by recvmsg, or 0 if the datagram isn't coalesced.
+func UDPGROSegmentSize(oob []byte) int {
+	msgs, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return 0
+	}
+	for _, msg := range msgs {
+		if msg.Header.Level == unix.IPPROTO_UDP && msg.Header.Type == udpGRO && len(msg.Data) >= 4 {
+			return int(*(*int32)(unsafe.Pointer(&msg.Data[0])))
+		}
+	}
+	return 0
+}
+
+// UDPSegmentControl returns the control message of UDP_SEGMENT for sendmsg, which lets the kernel split the buffer
+// into datagrams of the given size.
+func UDPSegmentControl(size int) []byte {
+	v := uint16(size)
+	return appendControl(nil, unix.IPPROTO_UDP, udpSegment, (*[2]byte)(unsafe.Pointer(&v))[:])
+}
+
+// SetRecvPacketInfo sets up IP_PKTINFO and IP_RECVTOS, or their IPv6 counterparts as well on IPv6 sockets, which make
+// the kernel report the destination address, the interface and the traffic class of the datagrams received by recvmsg.
+func SetRecvPacketInfo(fd int, ipv6 bool) error {
+	if ipv6 {
+		if err := unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_RECVPKTINFO, 1); err != nil {
+			return err
+		}
+		if err := unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_RECVTCLASS, 1); err != nil {
+			return err
+		}
+		// The IPv4 datagrams received by dual-stack sockets are reported with the IPv4 options, which fail on
+		// IPv6-only sockets.
+		_ = unix.SetsockoptInt(fd, unix.IPPROTO_IP, unix.IP_PKTINFO, 1)
+		_ = unix.SetsockoptInt(fd, unix.IPPROTO_IP, unix.IP_RECVTOS, 1)
+		return nil
+	}
+	if err := unix.SetsockoptInt(fd, unix.IPPROTO_IP, unix.IP_PKTINFO, 1); err != nil {
+		return err
+	}
+	return unix.SetsockoptInt(fd, unix.IPPROTO_IP, unix.IP_RECVTOS, 1)
+}
+
+// ParsePacketInfo returns the destination address, the interface index and the traffic class of the datagram from
+// the control messages received by recvmsg, dst is nil if they're absent and tclass is -1 if it's absent.
+func ParsePacketInfo(oob []byte) (dst net.IP, ifIndex, tclass int) {
+	tclass = -1
+	msgs, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return
+	}
+	for _, msg := range msgs {
+		switch {
+		case msg.Header.Level == unix.IPPROTO_IP && msg.Header.Type == unix.IP_PKTINFO &&
+			len(msg.Data) >= unix.SizeofInet4Pktinfo:
+			info := (*unix.Inet4Pktinfo)(unsafe.Pointer(&msg.Data[0]))
+			dst, ifIndex = net.IPv4(info.Addr[0], info.Addr[1], info.Addr[2], info.Addr[3]), int(info.Ifindex)
+		case msg.Header.Level == unix.IPPROTO_IPV6 && msg.Header.Type == unix.IPV6_PKTINFO &&
+			len(msg.Data) >= unix.SizeofInet6Pktinfo:
+			info := (*unix.Inet6Pktinfo)(unsafe.Pointer(&msg.Data[0]))
+			dst, ifIndex = append(net.IP(nil), info.Addr[:]...), int(info.Ifindex)
+		case msg.Header.Level == unix.IPPROTO_IP && msg.Header.Type == unix.IP_TOS && len(msg.Data) >= 1:
+			tclass = int(msg.Data[0])
+		case msg.Header.Level == unix.IPPROTO_IPV6 && msg.Header.Type == unix.IPV6_TCLASS && len(msg.Data) >= 4:
+			tclass = int(*(*int32)(unsafe.Pointer(&msg.Data[0])))
+		}
+	}
+	return
+}
+
+// PacketInfoControl returns the control messages for sendmsg which send the datagram from the source address
+// through the interface with the traffic class, src is left to the kernel if it's nil, so is ifIndex if it's 0
+// and tclass if it's negative. The IPv4 control messages are returned unless ipv6 is true.
+func PacketInfoControl(src net.IP, ifIndex, tclass int, ipv6 bool) []byte {
+	var b []byte
+	if src != nil || ifIndex != 0 {
+		if ipv6 {
+			info := unix.Inet6Pktinfo{Ifindex: uint32(ifIndex)}
+			copy(info.Addr[:], src.To16())
+			b = appendControl(b, unix.IPPROTO_IPV6, unix.IPV6_PKTINFO,
+				(*[unix.SizeofInet6Pktinfo]byte)(unsafe.Pointer(&info))[:])
+		} else {
+			info := unix.Inet4Pktinfo{Ifindex: int32(ifIndex)}
+			if ip := src.To4(); ip != nil {
+				copy(info.Spec_dst[:], ip)
+			}
+			b = appendControl(b, unix.IPPROTO_IP, unix.IP_PKTINFO,
+				(*[unix.SizeofInet4Pktinfo]byte)(unsafe.Pointer(&info))[:])
+		}
+	}
+	if tclass >= 0 {
+		v := int32(tclass)
+		if ipv6 {
+			b = appendControl(b, unix.IPPROTO_IPV6, unix.IPV6_TCLASS, (*[4]byte)(unsafe.Pointer(&v))[:])
+		} else {
+			b = appendControl(b, unix.IPPROTO_IP, unix.IP_TOS, (*[4]byte)(unsafe.Pointer(&v))[:])
+		}
+	}
+	return b
+}
+
+// appendControl appends a control message of the given level and type to b.
+func appendControl(b []byte, level, typ int, data []byte) []byte {
+	off := len(b)
+	b = append(b, make([]byte, unix.CmsgSpace(len(data)))...)
+	h := (*unix.Cmsghdr)(unsafe.Pointer(&b[off]))
+	h.Level = int32(level)
+	h.Type = int32(typ)
+	h.SetLen(unix.CmsgLen(len(data)))
+	copy(b[off+unix.CmsgLen(0):], data)
+	return b
+}
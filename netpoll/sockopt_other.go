@@ -0,0 +1,88 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build !linux
+
+package netpoll
+
+import (
+	"errors"
+	"net"
+)
+
+var (
+	// ErrBindToDeviceUnsupported occurs when binding a socket to a network interface on a platform without SO_BINDTODEVICE.
+	ErrBindToDeviceUnsupported = errors.New("SO_BINDTODEVICE is not supported on this platform")
+
+	// ErrFreeBindUnsupported occurs when setting up IP_FREEBIND on a platform without it.
+	ErrFreeBindUnsupported = errors.New("IP_FREEBIND is not supported on this platform")
+
+	// ErrMarkUnsupported occurs when setting up SO_MARK on a platform without it.
+	ErrMarkUnsupported = errors.New("SO_MARK is not supported on this platform")
+
+	// ErrFastOpenUnsupported occurs when setting up TCP_FASTOPEN on a platform without it.
+	ErrFastOpenUnsupported = errors.New("TCP_FASTOPEN is not supported on this platform")
+
+	// ErrUDPGROUnsupported occurs when setting up UDP_GRO on a platform without it.
+	ErrUDPGROUnsupported = errors.New("UDP_GRO is not supported on this platform")
+
+	// ErrPacketInfoUnsupported occurs when setting up IP_PKTINFO or sending with it on a platform without it.
+	ErrPacketInfoUnsupported = errors.New("IP_PKTINFO is not supported on this platform")
+)
+
+// SetUserTimeout is a no-op on platforms without the TCP_USER_TIMEOUT socket option.
+func SetUserTimeout(fd, msecs int) error {
+	return nil
+}
+
+// SetBindToDevice always fails on platforms without the SO_BINDTODEVICE socket option.
+func SetBindToDevice(fd int, ifname string) error {
+	return ErrBindToDeviceUnsupported
+}
+
+// SetFreeBind always fails on platforms without the IP_FREEBIND socket option.
+func SetFreeBind(fd int, ipv6 bool) error {
+	return ErrFreeBindUnsupported
+}
+
+// SetMark always fails on platforms without the SO_MARK socket option.
+func SetMark(fd, mark int) error {
+	return ErrMarkUnsupported
+}
+
+// SetFastOpen always fails on platforms without the TCP_FASTOPEN socket option.
+func SetFastOpen(fd, qlen int) error {
+	return ErrFastOpenUnsupported
+}
+
+// SetUDPGRO always fails on platforms without the UDP_GRO socket option.
+func SetUDPGRO(fd int) error {
+	return ErrUDPGROUnsupported
+}
+
+// UDPGROSegmentSize always returns 0 on platforms without the UDP_GRO socket option.
+func UDPGROSegmentSize(oob []byte) int {
+	return 0
+}
+
+// UDPSegmentControl returns nil on platforms without the UDP_SEGMENT control message, the buffer has to be split
+// into datagrams by the caller.
+func UDPSegmentControl(size int) []byte {
+	return nil
+}
+
+// SetRecvPacketInfo always fails on platforms without the IP_PKTINFO socket option.
+func SetRecvPacketInfo(fd int, ipv6 bool) error {
+	return ErrPacketInfoUnsupported
+}
+
+// ParsePacketInfo always returns nothing on platforms without the IP_PKTINFO socket option.
+func ParsePacketInfo(oob []byte) (dst net.IP, ifIndex, tclass int) {
+	return nil, 0, -1
+}
+
+// PacketInfoControl returns nil on platforms without the IP_PKTINFO control message.
+func PacketInfoControl(src net.IP, ifIndex, tclass int, ipv6 bool) []byte {
+	return nil
+}
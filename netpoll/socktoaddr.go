@@ -40,6 +40,15 @@ func SockaddrToUDPAddr(sa unix.Sockaddr) *net.UDPAddr {
 	return nil
 }
 
+// SockaddrToUnixgramAddr converts a Sockaddr to a net.UnixAddr of the "unixgram" network.
+// Returns nil if conversion fails.
+func SockaddrToUnixgramAddr(sa unix.Sockaddr) net.Addr {
+	if sa, ok := sa.(*unix.SockaddrUnix); ok {
+		return &net.UnixAddr{Name: sa.Name, Net: "unixgram"}
+	}
+	return nil
+}
+
 // sockaddrInet4ToIPAndZone converts a SockaddrInet4 to a net.IP.
 // It returns nil if conversion fails.
 func sockaddrInet4ToIP(sa *unix.SockaddrInet4) net.IP {
@@ -86,3 +95,48 @@ func int2decimal(i uint) string {
 	}
 	return string(b[bp:])
 }
+
+// AddrToSockaddr converts a net.UDPAddr or net.UnixAddr to a Sockaddr of the same family as like, IPv4 addresses
+// are mapped into IPv6 if like is an IPv6 socket address. Returns nil if conversion fails.
+func AddrToSockaddr(addr net.Addr, like unix.Sockaddr) unix.Sockaddr {
+	switch addr := addr.(type) {
+	case *net.UDPAddr:
+		if _, ok := like.(*unix.SockaddrInet6); ok {
+			sa := &unix.SockaddrInet6{Port: addr.Port, ZoneId: ip6ZoneToIndex(addr.Zone)}
+			if copy(sa.Addr[:], addr.IP.To16()) == 0 {
+				return nil
+			}
+			return sa
+		}
+		ip := addr.IP.To4()
+		if ip == nil {
+			return nil
+		}
+		sa := &unix.SockaddrInet4{Port: addr.Port}
+		copy(sa.Addr[:], ip)
+		return sa
+	case *net.UnixAddr:
+		if _, ok := like.(*unix.SockaddrUnix); ok {
+			return &unix.SockaddrUnix{Name: addr.Name}
+		}
+	}
+	return nil
+}
+
+// ip6ZoneToIndex converts a net string of IP6 Zone to the unix int, returns 0 if zone is "".
+func ip6ZoneToIndex(zone string) uint32 {
+	if zone == "" {
+		return 0
+	}
+	if ifi, err := net.InterfaceByName(zone); err == nil {
+		return uint32(ifi.Index)
+	}
+	n := 0
+	for _, ch := range zone {
+		if ch < '0' || ch > '9' {
+			return 0
+		}
+		n = n*10 + int(ch-'0')
+	}
+	return uint32(n)
+}
@@ -0,0 +1,52 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux
+
+package netpoll
+
+import (
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// itimerspec mirrors struct itimerspec of timerfd_settime(2).
+type itimerspec struct {
+	interval unix.Timespec
+	value    unix.Timespec
+}
+
+// OpenTimerfd creates a non-blocking timerfd on the monotonic clock, which becomes readable when it expires.
+func OpenTimerfd() (int, error) {
+	// TFD_NONBLOCK and TFD_CLOEXEC share the values of O_NONBLOCK and O_CLOEXEC.
+	r0, _, errno := unix.Syscall(unix.SYS_TIMERFD_CREATE, unix.CLOCK_MONOTONIC, unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+	if errno != 0 {
+		return -1, errno
+	}
+	return int(r0), nil
+}
+
+// ArmTimerfd arms the timerfd to expire once after d, it expires right away if d isn't positive.
+func ArmTimerfd(fd int, d time.Duration) error {
+	if d <= 0 {
+		d = 1 // a zero value disarms the timerfd
+	}
+	spec := itimerspec{value: unix.NsecToTimespec(int64(d))}
+	_, _, errno := unix.Syscall6(unix.SYS_TIMERFD_SETTIME, uintptr(fd), 0, uintptr(unsafe.Pointer(&spec)), 0, 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// ReadTimerfd consumes the expirations of timerfd, so that it won't be reported as readable again until re-armed.
+func ReadTimerfd(fd int) error {
+	var buf [8]byte
+	if _, err := unix.Read(fd, buf[:]); err != nil && err != unix.EAGAIN {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build darwin netbsd freebsd openbsd dragonfly linux
+
+package netpoll
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/panjf2000/gnet/internal"
+)
+
+// TriggerStats records the counters of jobs triggered to a poller.
+type TriggerStats struct {
+	// Triggered is the number of jobs triggered.
+	Triggered int64
+
+	// Wakeups is the number of times the poller was woken up for the triggered jobs, the jobs triggered
+	// before the poller runs them share one wakeup.
+	Wakeups int64
+
+	// Pending is the number of jobs waiting to be run, namely the depth of the job queue.
+	Pending int64
+}
+
+// jobQueue queues the jobs triggered to a poller, which is embedded by the pollers to share the way of waking up.
+type jobQueue struct {
+	asyncJobQueue *internal.AsyncJobQueue
+	notified      int32        // whether the poller has been woken up for the pending jobs, accessed atomically
+	triggered     int64        // accessed atomically
+	wakeups       int64        // accessed atomically
+	closeMu       sync.RWMutex // guards the file-descriptors of waking up against being used after closed
+	closed        int32        // accessed atomically
+}
+
+// waker wakes up the poller blocked in waiting for events.
+type waker interface {
+	wake() error
+}
+
+func (q *jobQueue) initJobs() {
+	q.asyncJobQueue = internal.NewAsyncJobQueue()
+}
+
+// trigger queues the job and wakes up the poller with w. The file-descriptors of closed poller may have been
+// reused, so ErrPollerClosed is returned instead of using them.
+//
+// The poller is woken up only once until it runs the pending jobs, so that the jobs triggered in a burst
+// share a single wakeup rather than making a syscall each.
+func (q *jobQueue) trigger(job Job, w waker) error {
+	if atomic.LoadInt32(&q.closed) == 1 {
+		return ErrPollerClosed
+	}
+	atomic.AddInt64(&q.triggered, 1)
+	q.asyncJobQueue.Push(job)
+	if !atomic.CompareAndSwapInt32(&q.notified, 0, 1) {
+		return nil
+	}
+	atomic.AddInt64(&q.wakeups, 1)
+	q.closeMu.RLock()
+	defer q.closeMu.RUnlock()
+	if atomic.LoadInt32(&q.closed) == 1 {
+		return ErrPollerClosed
+	}
+	return w.wake()
+}
+
+// closeJobs makes the poller refuse jobs, it must be called before closing the file-descriptors of waking up.
+func (q *jobQueue) closeJobs() {
+	q.closeMu.Lock()
+	atomic.StoreInt32(&q.closed, 1)
+	q.closeMu.Unlock()
+}
+
+// TriggerStats returns the counters of jobs triggered to the poller.
+func (q *jobQueue) TriggerStats() TriggerStats {
+	return TriggerStats{
+		Triggered: atomic.LoadInt64(&q.triggered),
+		Wakeups:   atomic.LoadInt64(&q.wakeups),
+		Pending:   int64(q.asyncJobQueue.Len()),
+	}
+}
+
+// jobsNotified reports whether the poller has been woken up for the pending jobs.
+func (q *jobQueue) jobsNotified() bool {
+	return atomic.LoadInt32(&q.notified) == 1
+}
+
+// runJobs runs the jobs pending in the queue, the flag of wakeup is reset beforehand so that the jobs
+// triggered from now on wake up the poller again.
+func (q *jobQueue) runJobs() error {
+	atomic.StoreInt32(&q.notified, 0)
+	return q.asyncJobQueue.ForEach()
+}
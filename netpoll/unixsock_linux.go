@@ -0,0 +1,43 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux
+
+package netpoll
+
+import "golang.org/x/sys/unix"
+
+// SetPassCred enables the SO_PASSCRED socket option on the given Unix domain socket,
+// so that the credentials of the sending process can be received with each message.
+func SetPassCred(fd int) error {
+	return unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_PASSCRED, 1)
+}
+
+// ParseCredentials returns the credentials of the sending process from the control messages received on the
+// Unix domain socket with SO_PASSCRED enabled, ok is false if they're absent.
+func ParseCredentials(oob []byte) (pid int32, uid, gid uint32, ok bool) {
+	msgs, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return
+	}
+	for i := range msgs {
+		if msgs[i].Header.Level != unix.SOL_SOCKET || msgs[i].Header.Type != unix.SCM_CREDENTIALS {
+			continue
+		}
+		if ucred, err := unix.ParseUnixCredentials(&msgs[i]); err == nil {
+			return ucred.Pid, ucred.Uid, ucred.Gid, true
+		}
+	}
+	return
+}
+
+// GetPeerCred retrieves the credentials of the process connected to the other end
+// of the given Unix domain socket via the SO_PEERCRED socket option.
+func GetPeerCred(fd int) (pid int32, uid, gid uint32, err error) {
+	ucred, err := unix.GetsockoptUcred(fd, unix.SOL_SOCKET, unix.SO_PEERCRED)
+	if err != nil {
+		return
+	}
+	return ucred.Pid, ucred.Uid, ucred.Gid, nil
+}
@@ -0,0 +1,27 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build !linux
+
+package netpoll
+
+import "errors"
+
+// ErrPeerCredUnsupported occurs when retrieving the peer credentials on a platform without SO_PEERCRED.
+var ErrPeerCredUnsupported = errors.New("SO_PEERCRED is not supported on this platform")
+
+// SetPassCred is a no-op on platforms without the SO_PASSCRED socket option.
+func SetPassCred(fd int) error {
+	return nil
+}
+
+// ParseCredentials never finds the credentials on platforms without the SO_PASSCRED socket option.
+func ParseCredentials(oob []byte) (pid int32, uid, gid uint32, ok bool) {
+	return
+}
+
+// GetPeerCred always fails on platforms without the SO_PEERCRED socket option.
+func GetPeerCred(fd int) (pid int32, uid, gid uint32, err error) {
+	return 0, 0, 0, ErrPeerCredUnsupported
+}
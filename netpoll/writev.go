@@ -0,0 +1,40 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build darwin netbsd freebsd openbsd dragonfly linux
+
+package netpoll
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// MaxIovecs is the maximum number of buffers written by one Writev, which doesn't exceed IOV_MAX on any platform.
+const MaxIovecs = 1024
+
+// Writev writes the given buffers to the file-descriptor with one writev, at most MaxIovecs of them are written.
+func Writev(fd int, bufs [][]byte) (int, error) {
+	if len(bufs) > MaxIovecs {
+		bufs = bufs[:MaxIovecs]
+	}
+	iovs := make([]unix.Iovec, 0, len(bufs))
+	for _, buf := range bufs {
+		if len(buf) == 0 {
+			continue
+		}
+		iov := unix.Iovec{Base: &buf[0]}
+		iov.SetLen(len(buf))
+		iovs = append(iovs, iov)
+	}
+	if len(iovs) == 0 {
+		return 0, nil
+	}
+	n, _, errno := unix.Syscall(unix.SYS_WRITEV, uintptr(fd), uintptr(unsafe.Pointer(&iovs[0])), uintptr(len(iovs)))
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(n), nil
+}
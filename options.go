@@ -5,7 +5,28 @@
 package gnet
 
 import (
+	"net"
+	"os"
 	"time"
+
+	"github.com/panjf2000/gnet/accesslog"
+	"github.com/panjf2000/gnet/netpoll"
+	"github.com/panjf2000/gnet/pool"
+	"github.com/panjf2000/gnet/ringbuffer"
+)
+
+// OutboundBufferKind is the kind of outbound buffers of connections.
+type OutboundBufferKind int
+
+const (
+	// OutboundRingBuffer copies the data pending to be written into a ring-buffer, which grows to hold all of it.
+	OutboundRingBuffer OutboundBufferKind = iota
+
+	// OutboundLinkedBuffer queues the data pending to be written in a linked list of slices, which are written with
+	// writev. The slices passed to AsyncWrite are queued by reference rather than being copied, so they mustn't be
+	// modified after AsyncWrite. Connections don't hold outbound ring-buffers, so Conn.OutboundBuffer returns nil,
+	// use Conn.OutboundBuffered to get the length of data pending to be written.
+	OutboundLinkedBuffer
 )
 
 // Option is a function that will set up option.
@@ -36,8 +57,220 @@ type Options struct {
 	// TCPKeepAlive (SO_KEEPALIVE) socket option.
 	TCPKeepAlive time.Duration
 
+	// Linger (SO_LINGER) socket option in seconds, closing a connection blocks until the unsent data
+	// has been sent or the timeout expires, it's left to the system default when zero. The sockets are closed
+	// on other goroutines then, so that the event-loops aren't blocked while lingering.
+	Linger int
+
+	// TCPUserTimeout (TCP_USER_TIMEOUT) socket option, the connection will be closed by kernel when the
+	// transmitted data remains unacknowledged for the given duration, it only works on linux.
+	TCPUserTimeout time.Duration
+
 	// ICodec encodes and decodes TCP stream.
 	Codec ICodec
+
+	// FrameDeadline is the maximum duration for processing a frame with the context from Conn.FrameContext,
+	// the context is cancelled when it passes, zero means no deadline.
+	FrameDeadline time.Duration
+
+	// DecodeOffload indicates whether to decode frames on a worker pool instead of the event-loops, which keeps
+	// event-loops IO-bound with CPU-heavy codecs. Frames of a connection are still delivered to React in order
+	// and one at a time, invoke c.ReadFrame() within React to get the decoded frame. The workers decode with a view
+	// of the stream instead of the connection, hence the codecs implementing ConnEncoder, which keep states in their
+	// connections, are never offloaded, nor should be the custom codecs using Conn.Set or the addresses of Conn.
+	DecodeOffload bool
+
+	// MemoryLimit is the soft limit in bytes of the buffers held by all connections, the server enters the shedding
+	// mode when it's exceeded: new connections are refused and idle buffers are shrunk until the usage falls back.
+	MemoryLimit int64
+
+	// ShedSlowConsumers indicates whether to close the connection with the most pending outbound data in each
+	// event-loop when the server enters the shedding mode.
+	ShedSlowConsumers bool
+
+	// SocketOptionHook is invoked with the file descriptor of the listener and every accepted socket
+	// as well as the network, for setting up socket options which are not covered by gnet.
+	// An accepted socket will be closed if the hook returns an error on it.
+	SocketOptionHook func(fd int, network string) error
+
+	// UnixSocketMode is the file mode applied to the Unix domain socket file after binding, zero leaves it untouched.
+	// While it or UnixSocketOwner is set, the file is bound in a private directory next to it and renamed into place
+	// once they are applied, so that no peer can connect to it before. The path bound must fit in a socket address
+	// with the name of that directory, and getsockname(2) keeps reporting it on the listener.
+	UnixSocketMode os.FileMode
+
+	// UnixSocketOwner is the owner applied to the Unix domain socket file after binding, nil leaves it untouched.
+	UnixSocketOwner *UnixSocketOwner
+
+	// KeepUnixSocket indicates whether to keep the Unix domain socket file when the server shuts down.
+	KeepUnixSocket bool
+
+	// BindToDevice is the name of the network interface which the TCP/UDP listener is bound to via SO_BINDTODEVICE,
+	// empty leaves the listener unbound from interfaces.
+	BindToDevice string
+
+	// FreeBind indicates whether to set up IP_FREEBIND on the TCP/UDP listener, which allows binding to
+	// an IP address that is nonlocal or not yet assigned, e.g. a floating IP of anycast or VRRP failover.
+	FreeBind bool
+
+	// Mark is the firewall mark set on the TCP/UDP listener via SO_MARK and inherited by the accepted sockets,
+	// so that the traffic can be steered by policy routing or nftables, zero leaves the sockets unmarked.
+	Mark int
+
+	// TCPFastOpen is the queue length of pending TCP Fast Open requests on the TCP listener, which lets clients send
+	// data within the SYN and saves a round trip on fresh connections, zero disables TCP Fast Open.
+	TCPFastOpen int
+
+	// DeferAccept is the time for which connections are held back from the acceptor until data arrives on them,
+	// via TCP_DEFER_ACCEPT on Linux or the "dataready" accept filter on FreeBSD, which ignores the duration.
+	// Zero disables deferring accept.
+	DeferAccept time.Duration
+
+	// ExclusiveAccept indicates whether to share the listener among all event-loops with EPOLLEXCLUSIVE when
+	// ReusePort is off, instead of accepting all connections on the main reactor. It's ignored on the platforms
+	// without EPOLLEXCLUSIVE.
+	ExclusiveAccept bool
+
+	// AcceptTimeSlice bounds the time spent accepting connections per iteration of an event-loop which hosts both
+	// the listener and connections, i.e. with ReusePort or ExclusiveAccept. Connections are accepted one per
+	// iteration if it's zero.
+	AcceptTimeSlice time.Duration
+
+	// EdgeTriggered indicates whether to register connections to the poller in the edge-triggered mode, which
+	// drains sockets until EAGAIN on every event and reduces the wakeups of poller under high throughput.
+	// The level-triggered mode is the default, which is also used if the default pollers don't support
+	// the edge-triggered mode.
+	EdgeTriggered bool
+
+	// PollBatchSize is the maximum number of events returned by one epoll_wait/kevent, the batch grows on demand
+	// if it's zero.
+	PollBatchSize int
+
+	// PollTimeout is the maximum time of waiting for events in one epoll_wait/kevent, zero blocks until
+	// events arrive.
+	PollTimeout time.Duration
+
+	// AdaptivePolling indicates whether to poll without waiting as long as the previous poll returned events,
+	// which trades CPU for latency in latency-sensitive deployments.
+	AdaptivePolling bool
+
+	// WorkerPoolSize is the capacity of the worker pool for running the tasks of Conn.AsyncReact, zero disables
+	// the worker pool and the tasks are run in the event-loops.
+	WorkerPoolSize int
+
+	// HeartbeatInterval is the idle time after which a ping frame is sent to the connection, zero disables
+	// the heartbeat.
+	HeartbeatInterval time.Duration
+
+	// HeartbeatTimeout is the idle time after which the connection is closed with ErrHeartbeatTimeout,
+	// it defaults to three times of HeartbeatInterval.
+	HeartbeatTimeout time.Duration
+
+	// HeartbeatPing makes the ping frame sent to the idle connection, which is encoded by the codec like
+	// the output of React.
+	HeartbeatPing func(c Conn) []byte
+
+	// AcceptRate is the maximum number of connections accepted per second, zero disables the rate limiting.
+	// Pending connections are left in the backlog of listener while the rate is exceeded.
+	AcceptRate int
+
+	// AcceptBurst is the number of connections which are allowed to be accepted at once beyond AcceptRate,
+	// it defaults to AcceptRate.
+	AcceptBurst int
+
+	// AcceptSpareFd indicates whether to reserve a spare file descriptor, which is released to accept and close
+	// pending connections when file descriptors run out, so that clients are refused instead of hanging.
+	AcceptSpareFd bool
+
+	// ConnectionFilter is evaluated with the remote address of every accepted connection before any resources
+	// are allocated for it, the connection is closed right away if it returns false.
+	ConnectionFilter func(remote net.Addr) bool
+
+	// ReadBufferCap is the initial capacity of the inbound ring-buffer of each connection,
+	// 1024 bytes are used if it's not positive.
+	ReadBufferCap int
+
+	// WriteBufferCap is the initial capacity of the outbound ring-buffer of each connection,
+	// 1024 bytes are used if it's not positive.
+	WriteBufferCap int
+
+	// BufferGrowth decides how the ring-buffers of connections grow, they double their sizes without
+	// the maximum capacity by default. A connection whose buffer can't grow within the maximum capacity
+	// is closed with ErrBufferFull.
+	BufferGrowth ringbuffer.GrowthPolicy
+
+	// MirroredBuffers indicates whether to map the ring-buffers of connections twice in a row in the virtual memory
+	// on Linux, so that the data wrapping around the end of buffer appears contiguous and reading it doesn't copy two
+	// segments. The capacities of buffers are rounded up to the multiple of the page size, and the plain buffers are
+	// used on the other platforms.
+	MirroredBuffers bool
+
+	// OutboundBufferKind is the kind of outbound buffers of connections, OutboundRingBuffer by default.
+	OutboundBufferKind OutboundBufferKind
+
+	// Allocator allocates the byte slices of the ring-buffers of connections and the read buffers of event-loops,
+	// pool.DefaultAllocator pooling them with sync.Pool is used if it's nil.
+	Allocator pool.Allocator
+
+	// BufferShrink is the interval of sweeping the ring-buffers of connections, the ones which have grown beyond
+	// their initial capacities are shrunk back once they have stayed empty for a whole interval, zero disables it.
+	BufferShrink time.Duration
+
+	// UDPGRO indicates whether to set up UDP_GRO on the UDP listener on Linux, which lets the kernel coalesce
+	// the datagrams received from the same flow, they're still passed to React one by one.
+	UDPGRO bool
+
+	// PacketInfo indicates whether to receive the destination address, the interface and the traffic class of
+	// datagrams on the UDP listener, which are returned by Conn.PacketInfo in React, only Linux supports it.
+	PacketInfo bool
+
+	// MaxConnsPerHost is the maximum number of concurrent connections from a source IP, the connections beyond it
+	// are closed right after being accepted, which is reported to OnAcceptError with ErrTooManyConnections.
+	// Zero means no limit.
+	MaxConnsPerHost int
+
+	// AggregateIPv6Hosts indicates whether to count the IPv6 sources in the same /64 prefix as a single host for
+	// MaxConnsPerHost, since a client is usually assigned a whole /64.
+	AggregateIPv6Hosts bool
+
+	// HandshakeTimeout is the time within which a connection must produce its first complete frame, i.e. the
+	// first frame returned by Conn.ReadFrame, or be completed by CompleteHandshake, otherwise it's closed with
+	// ErrHandshakeTimeout, which protects servers from the clients trickling bytes forever. Zero disables it.
+	HandshakeTimeout time.Duration
+
+	// LeakTimeout is the time after which a connection without reads, writes, wakes or pending timers is
+	// reported as leaked, zero disables the leak detector.
+	LeakTimeout time.Duration
+
+	// OnLeak fires in the event-loop with the diagnostics of the connection suspected to be leaked, the connection
+	// is closed with ErrConnectionLeaked if it returns Close. The diagnostics are logged if it's nil.
+	OnLeak func(c Conn, info LeakInfo) Action
+
+	// StallThreshold is the time an iteration of event-loop may take before OnLoopStall fires, e.g. because
+	// a handler blocks the event-loop, zero disables the watchdog.
+	StallThreshold time.Duration
+
+	// OnLoopStall fires in the goroutine of watchdog once per iteration of event-loop exceeding StallThreshold,
+	// with the index of event-loop, the time it has taken so far and the stack of the event-loop.
+	OnLoopStall func(loopIdx int, d time.Duration, stack []byte)
+
+	// Tracer starts the spans around accepting connections, React and flushing outbound data, nil disables tracing.
+	Tracer Tracer
+
+	// Mirror copies the traffic of the selected connections to its sink, nil disables mirroring.
+	Mirror *Mirror
+
+	// Poller opens the pollers of event-loops, netpoll.OpenPoller based on epoll or kqueue is used if it's nil.
+	Poller func() (netpoll.Poller, error)
+
+	// AccessLog records connections being opened and closed, nil disables the access log.
+	AccessLog *accesslog.Logger
+}
+
+// UnixSocketOwner represents the user and group that own a Unix domain socket file.
+type UnixSocketOwner struct {
+	UID int
+	GID int
 }
 
 // WithOptions sets up all options.
@@ -68,6 +301,20 @@ func WithTCPKeepAlive(tcpKeepAlive time.Duration) Option {
 	}
 }
 
+// WithLinger sets up SO_LINGER socket option, the lingering sockets are closed off the event-loops.
+func WithLinger(secs int) Option {
+	return func(opts *Options) {
+		opts.Linger = secs
+	}
+}
+
+// WithTCPUserTimeout sets up TCP_USER_TIMEOUT socket option.
+func WithTCPUserTimeout(tcpUserTimeout time.Duration) Option {
+	return func(opts *Options) {
+		opts.TCPUserTimeout = tcpUserTimeout
+	}
+}
+
 // WithTicker indicates that a ticker is set.
 func WithTicker(ticker bool) Option {
 	return func(opts *Options) {
@@ -81,3 +328,304 @@ func WithCodec(codec ICodec) Option {
 		opts.Codec = codec
 	}
 }
+
+// WithFrameDeadline sets up the deadline of contexts from Conn.FrameContext.
+func WithFrameDeadline(deadline time.Duration) Option {
+	return func(opts *Options) {
+		opts.FrameDeadline = deadline
+	}
+}
+
+// WithDecodeOffload sets up decoding frames on a worker pool.
+func WithDecodeOffload(offload bool) Option {
+	return func(opts *Options) {
+		opts.DecodeOffload = offload
+	}
+}
+
+// WithMemoryLimit sets up the soft limit of memory used by connection buffers.
+func WithMemoryLimit(bytes int64) Option {
+	return func(opts *Options) {
+		opts.MemoryLimit = bytes
+	}
+}
+
+// WithShedSlowConsumers sets up closing the slowest consumers when exceeding the memory limit.
+func WithShedSlowConsumers(shed bool) Option {
+	return func(opts *Options) {
+		opts.ShedSlowConsumers = shed
+	}
+}
+
+// WithSocketOptionHook sets up a hook for setting up socket options.
+func WithSocketOptionHook(hook func(fd int, network string) error) Option {
+	return func(opts *Options) {
+		opts.SocketOptionHook = hook
+	}
+}
+
+// WithUnixSocketMode sets up the file mode of the Unix domain socket file.
+func WithUnixSocketMode(mode os.FileMode) Option {
+	return func(opts *Options) {
+		opts.UnixSocketMode = mode
+	}
+}
+
+// WithUnixSocketOwner sets up the owner of the Unix domain socket file.
+func WithUnixSocketOwner(uid, gid int) Option {
+	return func(opts *Options) {
+		opts.UnixSocketOwner = &UnixSocketOwner{UID: uid, GID: gid}
+	}
+}
+
+// WithKeepUnixSocket indicates whether to keep the Unix domain socket file when the server shuts down.
+func WithKeepUnixSocket(keep bool) Option {
+	return func(opts *Options) {
+		opts.KeepUnixSocket = keep
+	}
+}
+
+// WithBindToDevice sets up the network interface which the listener is bound to.
+func WithBindToDevice(ifname string) Option {
+	return func(opts *Options) {
+		opts.BindToDevice = ifname
+	}
+}
+
+// WithFreeBind sets up binding the listener to a nonlocal or not yet assigned IP address.
+func WithFreeBind(freeBind bool) Option {
+	return func(opts *Options) {
+		opts.FreeBind = freeBind
+	}
+}
+
+// WithMark sets up the firewall mark of the listener.
+func WithMark(mark int) Option {
+	return func(opts *Options) {
+		opts.Mark = mark
+	}
+}
+
+// WithTCPFastOpen sets up TCP Fast Open on the listener with the given queue length of pending requests.
+func WithTCPFastOpen(qlen int) Option {
+	return func(opts *Options) {
+		opts.TCPFastOpen = qlen
+	}
+}
+
+// WithDeferAccept sets up deferring accept of connections until data arrives on them.
+func WithDeferAccept(timeout time.Duration) Option {
+	return func(opts *Options) {
+		opts.DeferAccept = timeout
+	}
+}
+
+// WithExclusiveAccept sets up accepting connections on every event-loop with EPOLLEXCLUSIVE.
+func WithExclusiveAccept(exclusive bool) Option {
+	return func(opts *Options) {
+		opts.ExclusiveAccept = exclusive
+	}
+}
+
+// WithAcceptTimeSlice sets up the time spent accepting connections per iteration of an event-loop.
+func WithAcceptTimeSlice(slice time.Duration) Option {
+	return func(opts *Options) {
+		opts.AcceptTimeSlice = slice
+	}
+}
+
+// WithEdgeTriggered sets up registering connections to the poller in the edge-triggered mode.
+func WithEdgeTriggered(edgeTriggered bool) Option {
+	return func(opts *Options) {
+		opts.EdgeTriggered = edgeTriggered
+	}
+}
+
+// WithPollBatchSize sets up the maximum number of events returned by one poll.
+func WithPollBatchSize(size int) Option {
+	return func(opts *Options) {
+		opts.PollBatchSize = size
+	}
+}
+
+// WithPollTimeout sets up the maximum time of waiting for events in one poll.
+func WithPollTimeout(timeout time.Duration) Option {
+	return func(opts *Options) {
+		opts.PollTimeout = timeout
+	}
+}
+
+// WithAdaptivePolling sets up polling without waiting as long as the previous poll returned events.
+func WithAdaptivePolling(adaptive bool) Option {
+	return func(opts *Options) {
+		opts.AdaptivePolling = adaptive
+	}
+}
+
+// WithWorkerPool sets up the capacity of the worker pool for running the tasks of Conn.AsyncReact.
+func WithWorkerPool(size int) Option {
+	return func(opts *Options) {
+		opts.WorkerPoolSize = size
+	}
+}
+
+// WithHeartbeat sets up the heartbeat, which sends the ping frame made by ping to connections idle for interval,
+// and closes the connections idle for timeout, as any data received from the connection counts as a pong.
+func WithHeartbeat(interval, timeout time.Duration, ping func(c Conn) []byte) Option {
+	return func(opts *Options) {
+		opts.HeartbeatInterval = interval
+		opts.HeartbeatTimeout = timeout
+		opts.HeartbeatPing = ping
+	}
+}
+
+// WithAcceptRateLimit sets up the rate limiting of accepting connections with a token bucket.
+func WithAcceptRateLimit(rate, burst int) Option {
+	return func(opts *Options) {
+		opts.AcceptRate = rate
+		opts.AcceptBurst = burst
+	}
+}
+
+// WithAcceptSpareFd sets up the spare file descriptor for refusing connections when file descriptors run out.
+func WithAcceptSpareFd(spare bool) Option {
+	return func(opts *Options) {
+		opts.AcceptSpareFd = spare
+	}
+}
+
+// WithConnectionFilter sets up the filter of accepted connections, e.g. the one made by NewCIDRFilter.
+func WithConnectionFilter(filter func(remote net.Addr) bool) Option {
+	return func(opts *Options) {
+		opts.ConnectionFilter = filter
+	}
+}
+
+// WithReadBufferCap sets up the initial capacity of the inbound ring-buffer of each connection.
+func WithReadBufferCap(size int) Option {
+	return func(opts *Options) {
+		opts.ReadBufferCap = size
+	}
+}
+
+// WithWriteBufferCap sets up the initial capacity of the outbound ring-buffer of each connection.
+func WithWriteBufferCap(size int) Option {
+	return func(opts *Options) {
+		opts.WriteBufferCap = size
+	}
+}
+
+// WithBufferGrowth sets up the growth policy of the ring-buffers of connections.
+func WithBufferGrowth(policy ringbuffer.GrowthPolicy) Option {
+	return func(opts *Options) {
+		opts.BufferGrowth = policy
+	}
+}
+
+// WithMirroredBuffers sets up the mirror-mapped ring-buffers of connections on Linux.
+func WithMirroredBuffers(mirrored bool) Option {
+	return func(opts *Options) {
+		opts.MirroredBuffers = mirrored
+	}
+}
+
+// WithOutboundBufferKind sets up the kind of outbound buffers of connections.
+func WithOutboundBufferKind(kind OutboundBufferKind) Option {
+	return func(opts *Options) {
+		opts.OutboundBufferKind = kind
+	}
+}
+
+// WithAllocator sets up the allocator of the byte slices of buffers, e.g. an arena or slab allocator.
+func WithAllocator(alloc pool.Allocator) Option {
+	return func(opts *Options) {
+		opts.Allocator = alloc
+	}
+}
+
+// WithBufferShrink sets up the interval of shrinking the idle ring-buffers of connections.
+func WithBufferShrink(interval time.Duration) Option {
+	return func(opts *Options) {
+		opts.BufferShrink = interval
+	}
+}
+
+// WithUDPGRO sets up UDP_GRO on the UDP listener.
+func WithUDPGRO(gro bool) Option {
+	return func(opts *Options) {
+		opts.UDPGRO = gro
+	}
+}
+
+// WithPacketInfo sets up receiving the information of datagrams on the UDP listener.
+func WithPacketInfo(pktInfo bool) Option {
+	return func(opts *Options) {
+		opts.PacketInfo = pktInfo
+	}
+}
+
+// WithMaxConnsPerHost sets up the maximum number of concurrent connections from a source IP.
+func WithMaxConnsPerHost(n int) Option {
+	return func(opts *Options) {
+		opts.MaxConnsPerHost = n
+	}
+}
+
+// WithAggregateIPv6Hosts sets up counting the IPv6 sources in the same /64 prefix as a single host.
+func WithAggregateIPv6Hosts(aggregate bool) Option {
+	return func(opts *Options) {
+		opts.AggregateIPv6Hosts = aggregate
+	}
+}
+
+// WithHandshakeTimeout sets up the timeout of connections producing their first frames.
+func WithHandshakeTimeout(timeout time.Duration) Option {
+	return func(opts *Options) {
+		opts.HandshakeTimeout = timeout
+	}
+}
+
+// WithLeakDetector sets up the leak detector reporting the connections idle for timeout to onLeak.
+func WithLeakDetector(timeout time.Duration, onLeak func(c Conn, info LeakInfo) Action) Option {
+	return func(opts *Options) {
+		opts.LeakTimeout = timeout
+		opts.OnLeak = onLeak
+	}
+}
+
+// WithLoopWatchdog sets up the watchdog firing onStall when an iteration of event-loop takes longer than threshold.
+func WithLoopWatchdog(threshold time.Duration, onStall func(loopIdx int, d time.Duration, stack []byte)) Option {
+	return func(opts *Options) {
+		opts.StallThreshold = threshold
+		opts.OnLoopStall = onStall
+	}
+}
+
+// WithTracer sets up the tracer starting the spans around the events of server.
+func WithTracer(tracer Tracer) Option {
+	return func(opts *Options) {
+		opts.Tracer = tracer
+	}
+}
+
+// WithMirror sets up mirroring the traffic of the selected connections.
+func WithMirror(m *Mirror) Option {
+	return func(opts *Options) {
+		opts.Mirror = m
+	}
+}
+
+// WithPoller sets up the factory opening the pollers of event-loops, which supplies an alternative backend
+// to epoll or kqueue, e.g. a mock poller for tests.
+func WithPoller(factory func() (netpoll.Poller, error)) Option {
+	return func(opts *Options) {
+		opts.Poller = factory
+	}
+}
+
+// WithAccessLog sets up the access logger for recording connections being opened and closed.
+func WithAccessLog(logger *accesslog.Logger) Option {
+	return func(opts *Options) {
+		opts.AccessLog = logger
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import "github.com/panjf2000/gnet/pool"
+
+// bufferNode is a slice of outgoing data in linkedBuffer.
+type bufferNode struct {
+	buf    []byte
+	pooled []byte // slice allocated by the allocator of linkedBuffer, recycled once buf is written
+	next   *bufferNode
+}
+
+// linkedBuffer queues the slices of outgoing data by reference in a singly linked list, so that the large
+// writes neither copy the data nor grow a contiguous buffer.
+type linkedBuffer struct {
+	head, tail *bufferNode
+	size       int
+	alloc      pool.Allocator
+	bufs       [][]byte // reused by peek
+}
+
+func newLinkedBuffer(alloc pool.Allocator) *linkedBuffer {
+	return &linkedBuffer{alloc: alloc}
+}
+
+// pushRef queues the slice by reference, which mustn't be modified until it's written.
+func (b *linkedBuffer) pushRef(buf []byte) {
+	b.push(buf, nil)
+}
+
+// pushCopy queues a copy of the slice allocated by the allocator.
+func (b *linkedBuffer) pushCopy(buf []byte) {
+	data := b.alloc.Get(len(buf))
+	copy(data, buf)
+	b.push(data, data)
+}
+
+func (b *linkedBuffer) push(buf, pooled []byte) {
+	if len(buf) == 0 {
+		return
+	}
+	node := &bufferNode{buf: buf, pooled: pooled}
+	if b.tail == nil {
+		b.head = node
+	} else {
+		b.tail.next = node
+	}
+	b.tail = node
+	b.size += len(buf)
+}
+
+// peek returns at most max slices from the head of list without removing them.
+func (b *linkedBuffer) peek(max int) [][]byte {
+	b.bufs = b.bufs[:0]
+	for node := b.head; node != nil && len(b.bufs) < max; node = node.next {
+		b.bufs = append(b.bufs, node.buf)
+	}
+	return b.bufs
+}
+
+// discard removes n bytes from the head of list, the slices written completely are recycled.
+func (b *linkedBuffer) discard(n int) {
+	b.size -= n
+	for n > 0 && b.head != nil {
+		node := b.head
+		if n < len(node.buf) {
+			node.buf = node.buf[n:]
+			return
+		}
+		n -= len(node.buf)
+		b.pop()
+	}
+}
+
+func (b *linkedBuffer) pop() {
+	node := b.head
+	if b.head = node.next; b.head == nil {
+		b.tail = nil
+	}
+	if node.pooled != nil {
+		b.alloc.Put(node.pooled)
+	}
+}
+
+// bytes returns a copy of all the queued data.
+func (b *linkedBuffer) bytes() []byte {
+	data := make([]byte, 0, b.size)
+	for node := b.head; node != nil; node = node.next {
+		data = append(data, node.buf...)
+	}
+	return data
+}
+
+func (b *linkedBuffer) isEmpty() bool {
+	return b.head == nil
+}
+
+func (b *linkedBuffer) length() int {
+	return b.size
+}
+
+// reset removes all the queued data.
+func (b *linkedBuffer) reset() {
+	for b.head != nil {
+		b.pop()
+	}
+	b.size = 0
+}
@@ -0,0 +1,60 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build darwin netbsd freebsd openbsd dragonfly linux
+
+package gnet
+
+func (c *conn) PauseRead() {
+	if c.loop != nil {
+		sniffError(c.loop.poller.Trigger(func() error {
+			return c.loop.loopPauseRead(c)
+		}))
+	}
+}
+
+func (c *conn) ResumeRead() {
+	if c.loop != nil {
+		sniffError(c.loop.poller.Trigger(func() error {
+			return c.loop.loopResumeRead(c)
+		}))
+	}
+}
+
+// loopPauseRead stops watching the readable event of connection, only the exceptional events are handled until
+// reading is resumed, so that the connection is still closed on errors.
+func (lp *loop) loopPauseRead(c *conn) error {
+	if lp.connections.get(c.fd) != c || c.readPaused {
+		return nil // ignore stale pauses.
+	}
+	c.readPaused = true
+	lp.rewatch(c)
+	return nil
+}
+
+// loopResumeRead watches the readable event of connection again, the data arrived in the meantime is read right
+// away in the edge-triggered mode since it won't be reported again.
+func (lp *loop) loopResumeRead(c *conn) error {
+	if lp.connections.get(c.fd) != c || !c.readPaused {
+		return nil // ignore stale resumes.
+	}
+	c.readPaused = false
+	if c.readClosed {
+		return nil
+	}
+	if lp.svr.opts.EdgeTriggered {
+		return lp.loopIn(c)
+	}
+	lp.rewatch(c)
+	return nil
+}
+
+// rewatch renews the events watched for connection after its reading is paused or resumed.
+func (lp *loop) rewatch(c *conn) {
+	if c.outboundEmpty() {
+		lp.unwatchWrite(c)
+	} else {
+		lp.watchWrite(c)
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package pool
+
+import (
+	"math/bits"
+	"sync"
+)
+
+// maxAllocClass is the number of size classes of the default allocator, the slices larger than
+// 1 << (maxAllocClass-1) bytes are allocated and released without pooling.
+const maxAllocClass = 28
+
+// Allocator allocates and recycles the byte slices of buffers, which could be backed by an arena
+// or slab allocator to relieve the pressure on GC.
+type Allocator interface {
+	// Get returns a byte slice whose length is the given size.
+	Get(size int) []byte
+
+	// Put recycles the byte slice returned by Get, which mustn't be used afterwards.
+	Put(buf []byte)
+}
+
+// DefaultAllocator is the allocator used unless another one is set up.
+var DefaultAllocator = NewAllocator()
+
+// syncPoolAllocator pools byte slices with sync.Pool in size classes of power of two.
+type syncPoolAllocator struct {
+	pools [maxAllocClass]sync.Pool
+}
+
+// NewAllocator instantiates an Allocator which pools byte slices with sync.Pool in size classes of power of two.
+func NewAllocator() Allocator {
+	return new(syncPoolAllocator)
+}
+
+func (a *syncPoolAllocator) Get(size int) []byte {
+	if size <= 0 {
+		return nil
+	}
+	class := bits.Len(uint(size - 1))
+	if class >= maxAllocClass {
+		return make([]byte, size)
+	}
+	if buf, ok := a.pools[class].Get().([]byte); ok {
+		return buf[:size]
+	}
+	return make([]byte, size, 1<<uint(class))
+}
+
+func (a *syncPoolAllocator) Put(buf []byte) {
+	size := cap(buf)
+	if size == 0 {
+		return
+	}
+	class := bits.Len(uint(size - 1))
+	if class >= maxAllocClass || size != 1<<uint(class) {
+		return // not allocated by this allocator.
+	}
+	a.pools[class].Put(buf[:0])
+}
@@ -36,3 +36,10 @@ func NewWorkerPool() *WorkerPool {
 	defaultAntsPool, _ := ants.NewPool(DefaultAntsPoolSize, ants.WithOptions(options))
 	return defaultAntsPool
 }
+
+// NewWorkerPoolWithSize instantiates a non-blocking *WorkerPool with the given capacity.
+func NewWorkerPoolWithSize(size int) *WorkerPool {
+	options := ants.Options{ExpiryDuration: ExpiryDuration, Nonblocking: Nonblocking}
+	antsPool, _ := ants.NewPool(size, ants.WithOptions(options))
+	return antsPool
+}
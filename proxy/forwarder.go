@@ -0,0 +1,97 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package proxy
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/panjf2000/gnet"
+)
+
+// ErrNoBackend occurs when there is no backend to select.
+var ErrNoBackend = errors.New("no backend to select")
+
+// Backend selects the address of backend in form of "host:port" for the connection of client, the connection is
+// closed if it fails.
+type Backend func(c gnet.Conn) (addr string, err error)
+
+// RoundRobin returns the Backend selecting the addresses in turn.
+func RoundRobin(addrs ...string) Backend {
+	var next uint64
+	return func(c gnet.Conn) (string, error) {
+		if len(addrs) == 0 {
+			return "", ErrNoBackend
+		}
+		return addrs[(atomic.AddUint64(&next, 1)-1)%uint64(len(addrs))], nil
+	}
+}
+
+// Forwarder is a gnet.EventHandler of TCP port-forwarder, which relays every connection of client to the backend
+// selected for it in both directions. The data from client is buffered until the backend is connected.
+type Forwarder struct {
+	*gnet.EventServer
+
+	// Backend selects the backend of every connection of client.
+	Backend Backend
+
+	// DialTimeout is the timeout of dialing backends, DefaultDialTimeout is used if it's zero.
+	DialTimeout time.Duration
+
+	// OnRelayed fires with the accounting of bytes when a connection of client is closed, it's optional.
+	OnRelayed func(c gnet.Conn, stats Stats, err error)
+}
+
+// NewForwarder instantiates a Forwarder relaying to the backends selected by backend.
+func NewForwarder(backend Backend) *Forwarder {
+	return &Forwarder{EventServer: new(gnet.EventServer), Backend: backend}
+}
+
+// OnOpened fires when a client has connected to the forwarder, the backend is selected and dialed.
+func (f *Forwarder) OnOpened(c gnet.Conn) (out []byte, action gnet.Action) {
+	addr, err := f.Backend(c)
+	if err != nil {
+		action = gnet.Close
+		return
+	}
+	t := newTunnel()
+	t.bound = true
+	c.SetContext(t)
+
+	timeout := f.DialTimeout
+	if timeout <= 0 {
+		timeout = DefaultDialTimeout
+	}
+	go t.dial(c, addr, timeout, nil, nil)
+	return
+}
+
+// OnClosed fires when a client has disconnected from the forwarder, the connection to the backend is torn down.
+func (f *Forwarder) OnClosed(c gnet.Conn, err error) (action gnet.Action) {
+	t, ok := c.Context().(*tunnel)
+	if !ok {
+		return
+	}
+	t.close()
+	if f.OnRelayed != nil {
+		f.OnRelayed(c, t.stats(), err)
+	}
+	return
+}
+
+// React relays the data from client to the backend.
+func (f *Forwarder) React(c gnet.Conn) (out []byte, action gnet.Action) {
+	t, ok := c.Context().(*tunnel)
+	if !ok || t.failed() {
+		action = gnet.Close
+		return
+	}
+	if data := c.Read(); len(data) > 0 {
+		t.send(c, append([]byte(nil), data...))
+	}
+	c.ResetBuffer()
+	return
+}
@@ -0,0 +1,174 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// Package proxy implements a minimal HTTP forward proxy on gnet, which handles CONNECT tunnels as well as
+// requests in the absolute-URI form, with allowlists of destinations, and a TCP port-forwarder relaying
+// connections to the backends selected by callbacks.
+//
+// A connection of client is bound to the destination of its first request, the following data is relayed
+// to that destination as is. Dialing and writing to the destinations happen in background goroutines,
+// so that the event-loops are never blocked by slow destinations. The data buffered in either direction is bounded,
+// reading from the faster side pauses until the slower side catches up.
+package proxy
+
+import (
+	"bytes"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/panjf2000/gnet"
+)
+
+const (
+	// DefaultDialTimeout is the default timeout of dialing destinations.
+	DefaultDialTimeout = 10 * time.Second
+
+	// maxHeadSize is the maximum size of the head of the first request.
+	maxHeadSize = 8 << 10
+)
+
+var (
+	headEnd = []byte("\r\n\r\n")
+
+	respEstablished = []byte("HTTP/1.1 200 Connection Established\r\n\r\n")
+	respBadRequest  = []byte("HTTP/1.1 400 Bad Request\r\nConnection: close\r\n\r\n")
+	respForbidden   = []byte("HTTP/1.1 403 Forbidden\r\nConnection: close\r\n\r\n")
+	respTooLarge    = []byte("HTTP/1.1 431 Request Header Fields Too Large\r\nConnection: close\r\n\r\n")
+	respBadGateway  = []byte("HTTP/1.1 502 Bad Gateway\r\nConnection: close\r\n\r\n")
+)
+
+// Handler is a gnet.EventHandler of HTTP forward proxy.
+type Handler struct {
+	*gnet.EventServer
+
+	// Allowlist is the destinations that can be proxied to, an entry can be "host:port", "host" for any port or
+	// "*.domain" for any subdomain of domain. An empty Allowlist denies all destinations, so that the proxy isn't
+	// open to the destinations it isn't meant for by accident.
+	Allowlist []string
+
+	// DialTimeout is the timeout of dialing destinations, DefaultDialTimeout is used if it's zero.
+	DialTimeout time.Duration
+}
+
+// NewHandler instantiates a Handler proxying to the destinations in allowlist.
+func NewHandler(allowlist ...string) *Handler {
+	return &Handler{EventServer: new(gnet.EventServer), Allowlist: allowlist}
+}
+
+// OnOpened fires when a client has connected to the proxy.
+func (h *Handler) OnOpened(c gnet.Conn) (out []byte, action gnet.Action) {
+	c.SetContext(newTunnel())
+	return
+}
+
+// OnClosed fires when a client has disconnected from the proxy, the tunnel to the destination is torn down.
+func (h *Handler) OnClosed(c gnet.Conn, err error) (action gnet.Action) {
+	if t, ok := c.Context().(*tunnel); ok {
+		t.close()
+	}
+	return
+}
+
+// React parses the first request of client and relays the data from client to the destination afterwards.
+func (h *Handler) React(c gnet.Conn) (out []byte, action gnet.Action) {
+	t, ok := c.Context().(*tunnel)
+	if !ok {
+		action = gnet.Close
+		return
+	}
+	if t.failed() {
+		action = gnet.Close
+		return
+	}
+	data := c.Read()
+	if t.bound {
+		if len(data) > 0 {
+			t.send(c, append([]byte(nil), data...))
+		}
+		c.ResetBuffer()
+		return
+	}
+
+	i := bytes.Index(data, headEnd)
+	if i < 0 {
+		if len(data) > maxHeadSize {
+			out, action = respTooLarge, gnet.Close
+		}
+		return
+	}
+	dest, head, reply, ok := parseRequest(data[:i+len(headEnd)])
+	if !ok {
+		out, action = respBadRequest, gnet.Close
+		return
+	}
+	if !h.allowed(dest) {
+		out, action = respForbidden, gnet.Close
+		return
+	}
+	t.bound = true
+	if rest := data[i+len(headEnd):]; len(head)+len(rest) > 0 {
+		t.send(c, append(head, rest...))
+	}
+	c.ResetBuffer()
+
+	timeout := h.DialTimeout
+	if timeout <= 0 {
+		timeout = DefaultDialTimeout
+	}
+	go t.dial(c, dest, timeout, reply, respBadGateway)
+	return
+}
+
+// allowed reports whether the destination in form of "host:port" can be proxied to.
+func (h *Handler) allowed(dest string) bool {
+	host, _, err := net.SplitHostPort(dest)
+	if err != nil {
+		return false
+	}
+	for _, pattern := range h.Allowlist {
+		switch {
+		case pattern == dest, pattern == host:
+			return true
+		case strings.HasPrefix(pattern, "*.") && strings.HasSuffix(host, pattern[1:]):
+			return true
+		}
+	}
+	return false
+}
+
+// parseRequest parses the head of the first request, it returns the destination in form of "host:port",
+// the head to be forwarded to the destination and the reply to the client after the destination is connected.
+func parseRequest(head []byte) (dest string, forward, reply []byte, ok bool) {
+	lineEnd := bytes.Index(head, []byte("\r\n"))
+	line := strings.Fields(string(head[:lineEnd]))
+	if len(line) != 3 || !strings.HasPrefix(line[2], "HTTP/") {
+		return
+	}
+	method, target, proto := line[0], line[1], line[2]
+	if method == "CONNECT" {
+		if _, _, err := net.SplitHostPort(target); err != nil {
+			return
+		}
+		return target, nil, respEstablished, true
+	}
+
+	u, err := url.Parse(target)
+	if err != nil || u.Scheme != "http" || u.Host == "" {
+		return
+	}
+	dest = u.Host
+	if u.Port() == "" {
+		dest = net.JoinHostPort(u.Hostname(), "80")
+	}
+	// Rewrite the request line into the origin form.
+	forward = append(forward, method...)
+	forward = append(forward, ' ')
+	forward = append(forward, u.RequestURI()...)
+	forward = append(forward, ' ')
+	forward = append(forward, proto...)
+	forward = append(forward, head[lineEnd:]...)
+	return dest, forward, nil, true
+}
@@ -0,0 +1,355 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/panjf2000/gnet"
+)
+
+type testServer struct {
+	*Handler
+	done int32
+}
+
+func (s *testServer) Tick() (delay time.Duration, action gnet.Action) {
+	if atomic.LoadInt32(&s.done) == 1 {
+		action = gnet.Shutdown
+	}
+	delay = 10 * time.Millisecond
+	return
+}
+
+func TestProxy(t *testing.T) {
+	upstream, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer upstream.Close()
+	go func() {
+		for {
+			conn, err := upstream.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, _ = io.Copy(conn, conn)
+				_ = conn.Close()
+			}()
+		}
+	}()
+
+	_, port, _ := net.SplitHostPort(upstream.Addr().String())
+	svr := &testServer{Handler: NewHandler("127.0.0.1:" + port)}
+	errCh := make(chan error, 1)
+	go func() {
+		defer atomic.StoreInt32(&svr.done, 1)
+		errCh <- testClient(upstream.Addr().String())
+	}()
+	if err := gnet.Serve(svr, "tcp://127.0.0.1:9991", gnet.WithTicker(true)); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testClient(dest string) error {
+	var (
+		conn net.Conn
+		err  error
+	)
+	for i := 0; i < 100; i++ {
+		if conn, err = net.Dial("tcp", "127.0.0.1:9991"); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// The tunnel to the destination in the allowlist is established and relays data in both directions.
+	if _, err = io.WriteString(conn, "CONNECT "+dest+" HTTP/1.1\r\nHost: "+dest+"\r\n\r\nhello"); err != nil {
+		return err
+	}
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status of CONNECT: %s", resp.Status)
+	}
+	buf := make([]byte, 5)
+	if _, err = io.ReadFull(r, buf); err != nil {
+		return err
+	}
+	if string(buf) != "hello" {
+		return fmt.Errorf("unexpected data relayed: %q", buf)
+	}
+
+	// The destinations out of the allowlist are forbidden.
+	forbidden, err := net.Dial("tcp", "127.0.0.1:9991")
+	if err != nil {
+		return err
+	}
+	defer forbidden.Close()
+	_ = forbidden.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err = io.WriteString(forbidden, "GET http://example.com/ HTTP/1.1\r\nHost: example.com\r\n\r\n"); err != nil {
+		return err
+	}
+	resp, err = http.ReadResponse(bufio.NewReader(forbidden), nil)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusForbidden {
+		return fmt.Errorf("unexpected status of forbidden destination: %s", resp.Status)
+	}
+	return nil
+}
+
+func TestParseRequest(t *testing.T) {
+	dest, forward, reply, ok := parseRequest([]byte("GET http://example.com/a?b=c HTTP/1.1\r\nHost: example.com\r\n\r\n"))
+	if !ok || dest != "example.com:80" || reply != nil {
+		t.Fatalf("bad absolute-URI request: %s %v", dest, ok)
+	}
+	if !strings.HasPrefix(string(forward), "GET /a?b=c HTTP/1.1\r\n") {
+		t.Fatalf("bad request line forwarded: %q", forward)
+	}
+	if _, _, _, ok = parseRequest([]byte("CONNECT example.com HTTP/1.1\r\n\r\n")); ok {
+		t.Fatal("CONNECT without port should be rejected")
+	}
+}
+
+func TestAllowed(t *testing.T) {
+	if NewHandler().allowed("127.0.0.1:80") {
+		t.Fatal("empty allowlist should deny all destinations")
+	}
+	h := NewHandler("example.com", "*.example.org", "127.0.0.1:80")
+	for dest, allowed := range map[string]bool{
+		"example.com:443": true, "a.example.org:80": true, "127.0.0.1:80": true, "127.0.0.1:81": false,
+	} {
+		if h.allowed(dest) != allowed {
+			t.Fatalf("unexpected result of allowing %s", dest)
+		}
+	}
+}
+
+type testForwarder struct {
+	*Forwarder
+	done int32
+}
+
+func (f *testForwarder) Tick() (delay time.Duration, action gnet.Action) {
+	if atomic.LoadInt32(&f.done) == 1 {
+		action = gnet.Shutdown
+	}
+	delay = 10 * time.Millisecond
+	return
+}
+
+func TestForwarder(t *testing.T) {
+	backends := make([]string, 2)
+	for i := range backends {
+		backend, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer backend.Close()
+		backends[i] = backend.Addr().String()
+		prefix := fmt.Sprintf("%d:", i)
+		go func() {
+			for {
+				conn, err := backend.Accept()
+				if err != nil {
+					return
+				}
+				go func() {
+					_, _ = io.WriteString(conn, prefix)
+					_, _ = io.Copy(conn, conn)
+					_ = conn.Close()
+				}()
+			}
+		}()
+	}
+
+	relayed := make(chan Stats, 2)
+	svr := &testForwarder{Forwarder: NewForwarder(RoundRobin(backends...))}
+	svr.OnRelayed = func(c gnet.Conn, stats Stats, err error) {
+		relayed <- stats
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		defer atomic.StoreInt32(&svr.done, 1)
+		errCh <- testForwarderClient(relayed)
+	}()
+	if err := gnet.Serve(svr, "tcp://127.0.0.1:9990", gnet.WithTicker(true)); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testForwarderClient(relayed chan Stats) error {
+	var (
+		conn net.Conn
+		err  error
+	)
+	for i := 0; i < 100; i++ {
+		if conn, err = net.Dial("tcp", "127.0.0.1:9990"); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		return err
+	}
+	_ = conn.Close()
+	<-relayed
+
+	// The backends are selected in turn, the second connection goes to the second backend.
+	if conn, err = net.Dial("tcp", "127.0.0.1:9990"); err != nil {
+		return err
+	}
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err = io.WriteString(conn, "hello"); err != nil {
+		return err
+	}
+	buf := make([]byte, 7)
+	if _, err = io.ReadFull(conn, buf); err != nil {
+		return err
+	}
+	if string(buf) != "1:hello" {
+		return fmt.Errorf("unexpected data relayed: %q", buf)
+	}
+	_ = conn.Close()
+	if stats := <-relayed; stats.Upstream != 5 || stats.Downstream != 7 {
+		return fmt.Errorf("unexpected accounting of bytes: %+v", stats)
+	}
+	return nil
+}
+
+func TestForwarderBackpressure(t *testing.T) {
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+	go func() {
+		for {
+			conn, err := backend.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, _ = io.Copy(conn, conn)
+				_ = conn.Close()
+			}()
+		}
+	}()
+
+	t.Run("ring-buffer", func(t *testing.T) {
+		testForwarderBackpressure(t, backend.Addr().String(), "127.0.0.1:9959")
+	})
+	t.Run("linked-buffer", func(t *testing.T) {
+		testForwarderBackpressure(t, backend.Addr().String(), "127.0.0.1:9954",
+			gnet.WithOutboundBufferKind(gnet.OutboundLinkedBuffer))
+	})
+}
+
+type testBackpressureForwarder struct {
+	testForwarder
+	maxOutbound int
+}
+
+// OnOpened samples the outbound buffer of client, which is bounded by the data inflight.
+func (f *testBackpressureForwarder) OnOpened(c gnet.Conn) (out []byte, action gnet.Action) {
+	var sample func(c gnet.Conn) error
+	sample = func(c gnet.Conn) error {
+		if n := c.OutboundBuffered(); n > f.maxOutbound {
+			f.maxOutbound = n
+		}
+		_, err := c.SetTimer(time.Millisecond, sample)
+		return err
+	}
+	if _, err := c.SetTimer(time.Millisecond, sample); err != nil {
+		panic(err)
+	}
+	return f.Forwarder.OnOpened(c)
+}
+
+func testForwarderBackpressure(t *testing.T, backend, addr string, opts ...gnet.Option) {
+	svr := &testBackpressureForwarder{testForwarder: testForwarder{Forwarder: NewForwarder(RoundRobin(backend))}}
+	errCh := make(chan error, 1)
+	go func() {
+		defer atomic.StoreInt32(&svr.done, 1)
+		errCh <- testBackpressureClient(addr)
+	}()
+	if err := gnet.Serve(svr, "tcp://"+addr, append(opts, gnet.WithTicker(true))...); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatal(err)
+	}
+	if svr.maxOutbound > maxInflightBytes+relayBufferSize {
+		t.Fatalf("expected the outbound buffer to be bounded, got %d bytes", svr.maxOutbound)
+	}
+}
+
+// testBackpressureClient sends much more data than the queues of tunnel hold, and reads the echo slowly.
+func testBackpressureClient(addr string) error {
+	var (
+		conn net.Conn
+		err  error
+	)
+	for i := 0; i < 100; i++ {
+		if conn, err = net.Dial("tcp", addr); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(10 * time.Second))
+	data := make([]byte, 32*maxPendingBytes)
+	rand.Read(data)
+	go func() {
+		_, _ = conn.Write(data)
+	}()
+	echo := make([]byte, len(data))
+	for n := 0; n < len(echo); {
+		end := n + relayBufferSize
+		if end > len(echo) {
+			end = len(echo)
+		}
+		m, err := conn.Read(echo[n:end])
+		if err != nil {
+			return err
+		}
+		n += m
+		time.Sleep(time.Millisecond)
+	}
+	if !bytes.Equal(echo, data) {
+		return fmt.Errorf("mismatched data relayed")
+	}
+	return nil
+}
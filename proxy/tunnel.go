@@ -0,0 +1,238 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package proxy
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/panjf2000/gnet"
+)
+
+const (
+	// relayBufferSize is the size of buffer for reading data from destinations.
+	relayBufferSize = 32 << 10
+
+	// maxPendingBytes is the maximum size of data from client waiting to be written to the destination, reading
+	// from the client is paused until the destination takes the data once it's exceeded.
+	maxPendingBytes = 8 * relayBufferSize
+
+	// maxInflightBytes is the maximum size of data from the destination that the client hasn't taken yet,
+	// reading from the destination blocks until the client takes the data once it's reached.
+	maxInflightBytes = 8 * relayBufferSize
+
+	// drainCheckInterval is the interval of checking whether the client has taken the data from the destination.
+	drainCheckInterval = 10 * time.Millisecond
+)
+
+// Stats is the accounting of bytes relayed by a connection of client.
+type Stats struct {
+	// Upstream is the number of bytes relayed from the client to its destination.
+	Upstream uint64
+
+	// Downstream is the number of bytes relayed from the destination to its client.
+	Downstream uint64
+}
+
+// ConnStats returns the accounting of bytes relayed by the connection of Handler or Forwarder so far, it must be
+// invoked within the event-loop of connection, e.g. in OnClosed or in a task of Conn.Execute, since it reads
+// the context of connection.
+func ConnStats(c gnet.Conn) (stats Stats, ok bool) {
+	t, ok := c.Context().(*tunnel)
+	if !ok {
+		return
+	}
+	return t.stats(), true
+}
+
+// tunnel relays data between a client and its destination.
+type tunnel struct {
+	upstreamBytes   uint64 // bytes written to the destination, accessed atomically
+	downstreamBytes uint64 // bytes read from the destination, accessed atomically
+
+	bound bool // whether the destination has been parsed, only accessed by the event-loop
+
+	mu           sync.Mutex
+	upstream     net.Conn      // connection to the destination
+	pending      [][]byte      // data from client waiting to be written to the destination
+	pendingBytes int           // size of pending
+	paused       bool          // whether reading from the client has been paused since pending is full
+	inflight     int           // size of data from the destination that the client hasn't taken yet
+	drained      *sync.Cond    // notifies the reader of the data taken by the client or closing
+	signal       chan struct{} // notifies the writer of pending data or closing
+	err          error         // failure of dialing or relaying
+	closed       bool          // whether the client has disconnected
+}
+
+func newTunnel() *tunnel {
+	t := &tunnel{signal: make(chan struct{}, 1)}
+	t.drained = sync.NewCond(&t.mu)
+	return t
+}
+
+func (t *tunnel) notify() {
+	select {
+	case t.signal <- struct{}{}:
+	default:
+	}
+}
+
+// send queues data from client for writing to the destination, reading from the client is paused if too much
+// data is queued.
+func (t *tunnel) send(c gnet.Conn, buf []byte) {
+	t.mu.Lock()
+	t.pending = append(t.pending, buf)
+	t.pendingBytes += len(buf)
+	pause := !t.paused && t.pendingBytes >= maxPendingBytes
+	if pause {
+		t.paused = true
+	}
+	t.mu.Unlock()
+	t.notify()
+	if pause {
+		c.PauseRead()
+	}
+}
+
+// failed reports whether the tunnel has failed and the client should be disconnected.
+func (t *tunnel) failed() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.err != nil
+}
+
+// fail records the failure and wakes up the client, which will be disconnected in React.
+func (t *tunnel) fail(c gnet.Conn, err error) {
+	t.mu.Lock()
+	if t.err == nil {
+		t.err = err
+	}
+	t.mu.Unlock()
+	c.Wake()
+}
+
+func (t *tunnel) stats() Stats {
+	return Stats{Upstream: atomic.LoadUint64(&t.upstreamBytes), Downstream: atomic.LoadUint64(&t.downstreamBytes)}
+}
+
+func (t *tunnel) close() {
+	t.mu.Lock()
+	t.closed = true
+	if t.upstream != nil {
+		_ = t.upstream.Close()
+	}
+	t.mu.Unlock()
+	t.notify()
+	t.drained.Broadcast()
+}
+
+// dial connects to the destination, replies to the client and then starts relaying, failReply is written to
+// the client if the destination can't be connected.
+func (t *tunnel) dial(c gnet.Conn, dest string, timeout time.Duration, reply, failReply []byte) {
+	up, err := net.DialTimeout("tcp", dest, timeout)
+	if err != nil {
+		if failReply != nil {
+			c.AsyncWrite(failReply)
+		}
+		t.fail(c, err)
+		return
+	}
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		_ = up.Close()
+		return
+	}
+	t.upstream = up
+	t.mu.Unlock()
+
+	// The reply is queued before any data from the destination, AsyncWrite keeps the order.
+	if reply != nil {
+		c.AsyncWrite(reply)
+	}
+	go t.writeLoop(c)
+	t.readLoop(c)
+}
+
+// readLoop relays data from the destination to the client, it stops reading while the client hasn't taken
+// maxInflightBytes of data.
+func (t *tunnel) readLoop(c gnet.Conn) {
+	buf := make([]byte, relayBufferSize)
+	for {
+		t.mu.Lock()
+		for t.inflight >= maxInflightBytes && !t.closed {
+			t.drained.Wait()
+		}
+		closed := t.closed
+		t.mu.Unlock()
+		if closed {
+			return
+		}
+		n, err := t.upstream.Read(buf)
+		if n > 0 {
+			atomic.AddUint64(&t.downstreamBytes, uint64(n))
+			t.mu.Lock()
+			t.inflight += n
+			t.mu.Unlock()
+			c.AsyncWrite(append([]byte(nil), buf[:n]...))
+			c.Execute(t.taken(n))
+		}
+		if err != nil {
+			t.fail(c, err)
+			return
+		}
+	}
+}
+
+// taken returns the task run in the event-loop after n bytes from the destination have been written to the client,
+// which releases them once the client has taken most of its outbound buffer.
+func (t *tunnel) taken(n int) func(c gnet.Conn) error {
+	var check func(c gnet.Conn) error
+	check = func(c gnet.Conn) error {
+		if c.OutboundBuffered() > relayBufferSize {
+			_, err := c.SetTimer(drainCheckInterval, check)
+			return err
+		}
+		t.mu.Lock()
+		t.inflight -= n
+		t.mu.Unlock()
+		t.drained.Signal()
+		return nil
+	}
+	return check
+}
+
+// writeLoop relays data from the client to the destination, and resumes reading from the client once the data
+// paused it has been written.
+func (t *tunnel) writeLoop(c gnet.Conn) {
+	for range t.signal {
+		t.mu.Lock()
+		pending, closed := t.pending, t.closed
+		t.pending, t.pendingBytes = nil, 0
+		t.mu.Unlock()
+		if closed {
+			return
+		}
+		for _, buf := range pending {
+			n, err := t.upstream.Write(buf)
+			atomic.AddUint64(&t.upstreamBytes, uint64(n))
+			if err != nil {
+				t.fail(c, err)
+				return
+			}
+		}
+		t.mu.Lock()
+		resume := t.paused && t.pendingBytes < maxPendingBytes
+		if resume {
+			t.paused = false
+		}
+		t.mu.Unlock()
+		if resume {
+			c.ResumeRead()
+		}
+	}
+}
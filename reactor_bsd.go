@@ -21,28 +21,33 @@ func (svr *server) activateMainReactor() {
 
 func (svr *server) activateSubReactor(lp *loop) {
 	defer svr.signalShutdown()
+	lp.markGoroutine()
 
 	if lp.idx == 0 && svr.opts.Ticker {
-		go lp.loopTicker()
+		lp.startTicker()
 	}
 
 	_ = lp.poller.Polling(func(fd int, filter int16, job internal.Job) error {
-		if c, ack := lp.connections[fd]; ack {
+		if c := lp.connections.get(fd); c != nil {
 			switch filter {
 			// Don't change the ordering of processing EVFILT_WRITE | EVFILT_READ | EV_ERROR/EV_EOF unless you're 100%
 			// sure what you're doing!
 			// Re-ordering can easily introduce bugs and bad side-effects, as I found out painfully in the past.
 			case netpoll.EVFilterWrite:
-				if !c.outboundBuffer.IsEmpty() {
+				if !c.outboundEmpty() {
 					return lp.loopOut(c)
 				}
 				return nil
 			case netpoll.EVFilterRead:
 				return lp.loopIn(c)
 			case netpoll.EVFilterSock:
-				return lp.loopCloseConn(c, nil)
+				// Read the rest of data, then the half-close or the failure of connection is surfaced by loopIn.
+				return lp.loopIn(c)
 			}
 		}
+		if f := lp.external(fd); f != nil {
+			return lp.loopExternal(f, fdEvents(filter))
+		}
 		return nil
 	})
 }
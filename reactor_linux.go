@@ -21,14 +21,18 @@ func (svr *server) activateMainReactor() {
 
 func (svr *server) activateSubReactor(lp *loop) {
 	defer svr.signalShutdown()
+	lp.markGoroutine()
 
 	if lp.idx == 0 && svr.opts.Ticker {
-		go lp.loopTicker()
+		lp.startTicker()
 	}
 
 	_ = lp.poller.Polling(func(fd int, ev uint32, job internal.Job) error {
-		if c, ack := lp.connections[fd]; ack {
-			switch c.outboundBuffer.IsEmpty() {
+		if c := lp.connections.get(fd); c != nil {
+			if svr.opts.EdgeTriggered {
+				return lp.loopEdgeTriggered(c, ev)
+			}
+			switch c.outboundEmpty() {
 			// Don't change the ordering of processing EPOLLOUT | EPOLLRDHUP / EPOLLIN unless you're 100%
 			// sure what you're doing!
 			// Re-ordering can easily introduce bugs and bad side-effects, as I found out painfully in the past.
@@ -44,6 +48,12 @@ func (svr *server) activateSubReactor(lp *loop) {
 				return nil
 			}
 		}
+		if lp.tickerFd > 0 && fd == lp.tickerFd {
+			return lp.loopTick()
+		}
+		if f := lp.external(fd); f != nil {
+			return lp.loopExternal(f, fdEvents(ev))
+		}
 		return nil
 	})
 }
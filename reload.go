@@ -0,0 +1,141 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build darwin netbsd freebsd openbsd dragonfly linux
+
+package gnet
+
+import (
+	"net"
+	"reflect"
+	"time"
+
+	"github.com/panjf2000/gnet/internal"
+)
+
+// tunables is the subset of options which can be changed by Server.ApplyOptions while serving, it's replaced
+// as a whole so that the event-loops always see a consistent set.
+type tunables struct {
+	tcpKeepAlive     time.Duration
+	tcpUserTimeout   time.Duration
+	linger           int
+	frameDeadline    time.Duration
+	connectionFilter func(remote net.Addr) bool
+	acceptLimiter    *internal.TokenBucket
+	maxConnsPerHost  int
+}
+
+// reloadableOptions are the names of fields of Options backing tunables.
+var reloadableOptions = map[string]bool{
+	"TCPKeepAlive":     true,
+	"TCPUserTimeout":   true,
+	"Linger":           true,
+	"FrameDeadline":    true,
+	"ConnectionFilter": true,
+	"AcceptRate":       true,
+	"AcceptBurst":      true,
+	"MaxConnsPerHost":  true,
+}
+
+// ApplyOptions changes the options of the running server, only the following ones can be changed:
+//
+//	TCPKeepAlive, TCPUserTimeout and Linger, for the connections opened afterwards;
+//	FrameDeadline, for the contexts returned by Conn.FrameContext afterwards;
+//	ConnectionFilter, AcceptRate and AcceptBurst, for the connections accepted afterwards;
+//	MaxConnsPerHost, for the connections accepted afterwards, the ones accepted while it was zero aren't counted.
+//
+// The options are applied on top of the ones applied before, it fails with ErrUnreloadableOption and applies
+// none of them if any other option is changed, or with ErrServerShutdown once the server is shutting down.
+func (s Server) ApplyOptions(opts ...Option) error {
+	return s.svr.applyOptions(opts)
+}
+
+func (svr *server) applyOptions(opts []Option) error {
+	if svr.ctx.Err() != nil {
+		return ErrServerShutdown
+	}
+	svr.reloadMu.Lock()
+	defer svr.reloadMu.Unlock()
+	prev := svr.reloaded
+	next := prev
+	for _, opt := range opts {
+		opt(&next)
+	}
+	if !sameOptions(&prev, &next) {
+		return ErrUnreloadableOption
+	}
+	var limiter *internal.TokenBucket
+	if next.AcceptRate == prev.AcceptRate && next.AcceptBurst == prev.AcceptBurst {
+		// Keep the tokens of the limiter in use.
+		limiter = svr.tunables().acceptLimiter
+	} else {
+		limiter = newAcceptLimiter(&next)
+	}
+	svr.reloaded = next
+	svr.tuned.Store(newTunables(&next, limiter))
+	return nil
+}
+
+// initTunables sets up the tunables from the options the server is started with.
+func (svr *server) initTunables() {
+	svr.reloaded = *svr.opts
+	svr.tuned.Store(newTunables(svr.opts, newAcceptLimiter(svr.opts)))
+}
+
+// tunables returns the current tunables of server.
+func (svr *server) tunables() *tunables {
+	return svr.tuned.Load().(*tunables)
+}
+
+func newTunables(opts *Options, limiter *internal.TokenBucket) *tunables {
+	return &tunables{
+		tcpKeepAlive:     opts.TCPKeepAlive,
+		tcpUserTimeout:   opts.TCPUserTimeout,
+		linger:           opts.Linger,
+		frameDeadline:    opts.FrameDeadline,
+		connectionFilter: opts.ConnectionFilter,
+		acceptLimiter:    limiter,
+		maxConnsPerHost:  opts.MaxConnsPerHost,
+	}
+}
+
+// sameOptions reports whether the options which can't be reloaded are the same in both, functions are compared
+// by their code pointers as they aren't comparable.
+func sameOptions(prev, next *Options) bool {
+	pv, nv := reflect.ValueOf(prev).Elem(), reflect.ValueOf(next).Elem()
+	for i := 0; i < pv.NumField(); i++ {
+		if !reloadableOptions[pv.Type().Field(i).Name] && !sameOption(pv.Field(i), nv.Field(i)) {
+			return false
+		}
+	}
+	return true
+}
+
+func sameOption(p, n reflect.Value) bool {
+	switch p.Kind() {
+	case reflect.Func:
+		return p.Pointer() == n.Pointer()
+	case reflect.Interface:
+		if p.IsNil() || n.IsNil() {
+			return p.IsNil() == n.IsNil()
+		}
+		if p.Elem().Type() != n.Elem().Type() {
+			return false
+		}
+		return sameOption(p.Elem(), n.Elem())
+	}
+	return reflect.DeepEqual(p.Interface(), n.Interface())
+}
+
+// newAcceptLimiter instantiates the rate limiter of accepting connections, nil if the rate isn't limited.
+func newAcceptLimiter(opts *Options) *internal.TokenBucket {
+	if opts.AcceptRate <= 0 {
+		return nil
+	}
+	burst := opts.AcceptBurst
+	if burst <= 0 {
+		burst = opts.AcceptRate
+	}
+	return internal.NewTokenBucket(opts.AcceptRate, burst, time.Now())
+}
@@ -0,0 +1,71 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux
+
+package ringbuffer
+
+import (
+	"os"
+	"reflect"
+	"runtime"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// mirror is a virtual region twice the size of the buffer, whose both halves are mapped to the same memory,
+// so that the bytes wrapping around the end of buffer appear contiguous.
+type mirror struct {
+	addr uintptr
+	size int
+	mem  []byte
+}
+
+func mmap(addr uintptr, length, prot, flags, fd int) (uintptr, error) {
+	r0, _, errno := unix.Syscall6(unix.SYS_MMAP, addr, uintptr(length), uintptr(prot), uintptr(flags), uintptr(fd), 0)
+	if errno != 0 {
+		return 0, os.NewSyscallError("mmap", errno)
+	}
+	return r0, nil
+}
+
+func munmap(addr uintptr, length int) {
+	_, _, _ = unix.Syscall(unix.SYS_MUNMAP, addr, uintptr(length), 0)
+}
+
+// newMirror maps the memory of the given size, which must be the multiple of the page size, twice in a row.
+func newMirror(size int) (*mirror, error) {
+	fd, err := unix.MemfdCreate("gnet-ringbuffer", unix.MFD_CLOEXEC)
+	if err != nil {
+		return nil, os.NewSyscallError("memfd_create", err)
+	}
+	defer unix.Close(fd)
+	if err = unix.Ftruncate(fd, int64(size)); err != nil {
+		return nil, os.NewSyscallError("ftruncate", err)
+	}
+	// Reserve the whole region first so that both halves are guaranteed to be adjacent.
+	addr, err := mmap(0, 2*size, unix.PROT_NONE, unix.MAP_PRIVATE|unix.MAP_ANONYMOUS, -1)
+	if err != nil {
+		return nil, err
+	}
+	for _, half := range []uintptr{addr, addr + uintptr(size)} {
+		if _, err = mmap(half, size, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_FIXED, fd); err != nil {
+			munmap(addr, 2*size)
+			return nil, err
+		}
+	}
+	m := &mirror{addr: addr, size: size}
+	hdr := (*reflect.SliceHeader)(unsafe.Pointer(&m.mem))
+	hdr.Data = addr
+	hdr.Len = 2 * size
+	hdr.Cap = 2 * size
+	// The mapping is invisible to the garbage collector, so unmap it once the ring-buffer is gone.
+	runtime.SetFinalizer(m, (*mirror).unmap)
+	return m, nil
+}
+
+func (m *mirror) unmap() {
+	munmap(m.addr, 2*m.size)
+}
@@ -0,0 +1,15 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// +build !linux
+
+package ringbuffer
+
+type mirror struct {
+	mem []byte
+}
+
+func newMirror(size int) (*mirror, error) {
+	return nil, ErrMirrorUnsupported
+}
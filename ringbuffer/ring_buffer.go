@@ -8,38 +8,104 @@ package ringbuffer
 
 import (
 	"errors"
+	"os"
 	"unsafe"
 
 	"github.com/gobwas/pool/pbytes"
-	"github.com/panjf2000/gnet/internal"
+	"github.com/panjf2000/gnet/pool"
 )
 
-// ErrIsEmpty will be returned when trying to read a empty ring-buffer
-var ErrIsEmpty = errors.New("ring-buffer is empty")
+var (
+	// ErrIsEmpty will be returned when trying to read a empty ring-buffer
+	ErrIsEmpty = errors.New("ring-buffer is empty")
+	// ErrIsFull will be returned when trying to write more data than the maximum capacity of ring-buffer allows.
+	ErrIsFull = errors.New("ring-buffer is full")
+	// ErrMirrorUnsupported will be returned when creating a mirror-mapped ring-buffer on the unsupported platforms.
+	ErrMirrorUnsupported = errors.New("mirror-mapped ring-buffer is only supported on Linux")
+)
+
+var pageSize = os.Getpagesize()
+
+// mirrorSize rounds up the given size to the multiple of the page size.
+func mirrorSize(size int) int {
+	return (size + pageSize - 1) / pageSize * pageSize
+}
+
+// GrowthPolicy decides how a ring-buffer grows when it runs out of room for writing.
+type GrowthPolicy struct {
+	// Increment is the fixed number of bytes the ring-buffer grows by, the ring-buffer doubles its size
+	// when it's zero.
+	Increment int
+
+	// MaxCap is the maximum capacity the ring-buffer grows to, zero means unlimited.
+	MaxCap int
+}
 
 // RingBuffer is a circular buffer that implement io.ReaderWriter interface.
 type RingBuffer struct {
 	buf     []byte
 	size    int
-	mask    int
 	r       int // next position to read
 	w       int // next position to write
 	isEmpty bool
+	policy  GrowthPolicy
+	mirror  *mirror        // mirror-mapped memory of buf, nil for the plain ring-buffer
+	alloc   pool.Allocator // allocator of buf, nil for allocating it with make
 }
 
-// New returns a new RingBuffer whose buffer has the given size.
+// New returns a new RingBuffer whose buffer has the given size, it doubles its size when growing.
 func New(size int) *RingBuffer {
-	if !internal.IsPowerOfTwo(size) {
-		panic("the size of ring-buffer must be power of two integer value, e.g. 2, 4, 8, 16, 32, 64, etc.")
+	return NewWithPolicy(size, GrowthPolicy{})
+}
+
+// NewWithPolicy returns a new RingBuffer whose buffer has the given size, which grows by the given policy.
+func NewWithPolicy(size int, policy GrowthPolicy) *RingBuffer {
+	return NewWithAllocator(size, policy, nil)
+}
+
+// NewWithAllocator returns a new RingBuffer like NewWithPolicy, whose buffer is allocated by the given allocator,
+// the buffer outgrown is put back to the allocator, so is the buffer released by Release.
+func NewWithAllocator(size int, policy GrowthPolicy, alloc pool.Allocator) *RingBuffer {
+	if size <= 0 {
+		panic("the size of ring-buffer must be positive")
 	}
 	return &RingBuffer{
-		buf:     make([]byte, size),
+		buf:     allocate(alloc, size),
 		size:    size,
-		mask:    size - 1,
 		isEmpty: true,
+		policy:  policy,
+		alloc:   alloc,
 	}
 }
 
+func allocate(alloc pool.Allocator, size int) []byte {
+	if alloc == nil {
+		return make([]byte, size)
+	}
+	return alloc.Get(size)
+}
+
+// NewMirrored returns a new RingBuffer whose buffer is mapped twice in a row in the virtual memory, so that the data
+// wrapping around the end of buffer appears contiguous and LazyRead and LazyReadAll never return the tail. The size
+// is rounded up to the multiple of the page size, and ErrMirrorUnsupported is returned on the platforms other than Linux.
+func NewMirrored(size int, policy GrowthPolicy) (*RingBuffer, error) {
+	if size <= 0 {
+		panic("the size of ring-buffer must be positive")
+	}
+	size = mirrorSize(size)
+	m, err := newMirror(size)
+	if err != nil {
+		return nil, err
+	}
+	return &RingBuffer{
+		buf:     m.mem,
+		size:    size,
+		isEmpty: true,
+		policy:  policy,
+		mirror:  m,
+	}, nil
+}
+
 // LazyRead reads the bytes with given length but will not move the pointer of "read".
 func (r *RingBuffer) LazyRead(len int) (head []byte, tail []byte) {
 	if r.isEmpty {
@@ -65,7 +131,7 @@ func (r *RingBuffer) LazyRead(len int) (head []byte, tail []byte) {
 		n = len
 	}
 
-	if r.r+n <= r.size {
+	if r.r+n <= r.size || r.mirror != nil {
 		head = r.buf[r.r : r.r+n]
 	} else {
 		c1 := r.size - r.r
@@ -90,7 +156,7 @@ func (r *RingBuffer) LazyReadAll() (head []byte, tail []byte) {
 	}
 
 	n := r.size - r.r + r.w // Length
-	if r.r+n <= r.size {
+	if r.r+n <= r.size || r.mirror != nil {
 		head = r.buf[r.r : r.r+n]
 	} else {
 		c1 := r.size - r.r
@@ -109,7 +175,7 @@ func (r *RingBuffer) Shift(len int) {
 	}
 
 	if len < r.Length() {
-		r.r = (r.r + len) & r.mask
+		r.r = r.wrap(r.r + len)
 		if r.r == r.w {
 			r.isEmpty = true
 		}
@@ -140,7 +206,7 @@ func (r *RingBuffer) Read(p []byte) (n int, err error) {
 			n = len(p)
 		}
 		copy(p, r.buf[r.r:r.r+n])
-		r.r = (r.r + n) & r.mask
+		r.r = r.wrap(r.r + n)
 		if r.r == r.w {
 			r.isEmpty = true
 		}
@@ -152,7 +218,7 @@ func (r *RingBuffer) Read(p []byte) (n int, err error) {
 		n = len(p)
 	}
 
-	if r.r+n <= r.size {
+	if r.r+n <= r.size || r.mirror != nil {
 		copy(p, r.buf[r.r:r.r+n])
 	} else {
 		c1 := r.size - r.r
@@ -160,7 +226,7 @@ func (r *RingBuffer) Read(p []byte) (n int, err error) {
 		c2 := n - c1
 		copy(p[c1:], r.buf[0:c2])
 	}
-	r.r = (r.r + n) & r.mask
+	r.r = r.wrap(r.r + n)
 	if r.r == r.w {
 		r.isEmpty = true
 	}
@@ -187,7 +253,8 @@ func (r *RingBuffer) ReadByte() (b byte, err error) {
 
 // Write writes len(p) bytes from p to the underlying buf.
 // It returns the number of bytes written from p (n == len(p) > 0) and any error encountered that caused the write to stop early.
-// If the length of p is greater than the writable capacity of this ring-buffer, it will allocate more memory to this ring-buffer.
+// If the length of p is greater than the writable capacity of this ring-buffer, it will allocate more memory to this ring-buffer,
+// nothing is written and ErrIsFull is returned if the ring-buffer can't grow to hold p within the maximum capacity.
 // Write must not modify the slice data, even temporarily.
 func (r *RingBuffer) Write(p []byte) (n int, err error) {
 	n = len(p)
@@ -196,15 +263,15 @@ func (r *RingBuffer) Write(p []byte) (n int, err error) {
 	}
 
 	free := r.Free()
-	if n > free {
-		r.malloc(n - free)
+	if n > free && !r.malloc(n-free) {
+		return 0, ErrIsFull
 	}
 
 	if r.w >= r.r {
 		c1 := r.size - r.w
-		if c1 >= n {
+		if c1 >= n || r.mirror != nil {
 			copy(r.buf[r.w:], p)
-			r.w += n
+			r.w = r.wrap(r.w + n)
 		} else {
 			copy(r.buf[r.w:], p[:c1])
 			c2 := n - c1
@@ -227,8 +294,8 @@ func (r *RingBuffer) Write(p []byte) (n int, err error) {
 
 // WriteByte writes one byte into buffer
 func (r *RingBuffer) WriteByte(c byte) error {
-	if r.Free() < 1 {
-		r.malloc(1)
+	if r.Free() < 1 && !r.malloc(1) {
+		return ErrIsFull
 	}
 	r.buf[r.w] = c
 	r.w++
@@ -374,15 +441,73 @@ func (r *RingBuffer) Reset() {
 	r.isEmpty = true
 }
 
-func (r *RingBuffer) malloc(cap int) {
-	newCap := internal.CeilToPowerOfTwo(r.size + cap)
-	//newBuf := pbytes.GetLen(newCap)
-	newBuf := make([]byte, newCap)
+// Release puts the buffer back to the allocator, the ring-buffer mustn't be used afterwards.
+func (r *RingBuffer) Release() {
+	r.release()
+	r.buf = nil
+	r.size = 0
+	r.Reset()
+}
+
+// release puts the buffer back to the allocator if it's allocated by one.
+func (r *RingBuffer) release() {
+	if r.alloc != nil && r.mirror == nil {
+		r.alloc.Put(r.buf)
+	}
+}
+
+// wrap wraps the position which has gone beyond the end of buffer around.
+func (r *RingBuffer) wrap(pos int) int {
+	if pos >= r.size {
+		pos -= r.size
+	}
+	return pos
+}
+
+// malloc grows the buffer by at least cap bytes following the growth policy, it reports false if the buffer
+// can't grow that much within the maximum capacity.
+func (r *RingBuffer) malloc(cap int) bool {
+	need := r.size + cap
+	newCap := r.size
+	if inc := r.policy.Increment; inc > 0 {
+		newCap += (cap + inc - 1) / inc * inc
+	} else {
+		for newCap < need {
+			newCap *= 2
+		}
+	}
+	if r.mirror != nil {
+		newCap = mirrorSize(newCap)
+	}
+	if maxCap := r.policy.MaxCap; maxCap > 0 && newCap > maxCap {
+		if r.mirror != nil {
+			maxCap = maxCap / pageSize * pageSize
+		}
+		if need > maxCap {
+			return false
+		}
+		newCap = maxCap
+	}
+	var (
+		newBuf []byte
+		mirror *mirror
+	)
+	if r.mirror != nil {
+		m, err := newMirror(newCap)
+		if err != nil {
+			return false
+		}
+		newBuf, mirror = m.mem, m
+	} else {
+		newBuf = allocate(r.alloc, newCap)
+	}
 	oldLen := r.Length()
 	_, _ = r.Read(newBuf)
+	r.release()
 	r.r = 0
 	r.w = oldLen
 	r.size = newCap
-	r.mask = newCap - 1
 	r.buf = newBuf
+	r.mirror = mirror
+	return true
 }
@@ -0,0 +1,242 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+// StompHeartbeat is the heart-beat of STOMP, which is passed through by StompCodec.Encode as is, e.g. returned by
+// the ping function of WithHeartbeat.
+var StompHeartbeat = []byte{'\n'}
+
+// StompCodec encodes/decodes the frames of STOMP 1.2 into/from TCP stream, the decoded frames consist of the command,
+// headers and body without the terminating NUL, and can be parsed by ParseStompFrame. The heart-beats between frames
+// are skipped, outbound frames are terminated with NUL by Encode, e.g. the ones made by StompFrame.Marshal.
+type StompCodec struct {
+	// MaxFrameLength is the maximum length of frames, the connection is closed with ErrTooLongFrame once it's
+	// exceeded. Zero means no limit.
+	MaxFrameLength int
+}
+
+// Encode terminates the frame with NUL.
+func (cc *StompCodec) Encode(buf []byte) ([]byte, error) {
+	if bytes.Equal(buf, StompHeartbeat) {
+		return buf, nil
+	}
+	return append(buf, 0), nil
+}
+
+// Decode decodes a frame from TCP stream, the error is ErrMalformedFrame if the content-length header is invalid
+// or the body isn't terminated with NUL.
+func (cc *StompCodec) Decode(c Conn) ([]byte, error) {
+	buf := c.Read()
+	// Skip the heart-beats.
+	eols := 0
+	for eols < len(buf) && (buf[eols] == '\n' || buf[eols] == '\r') {
+		eols++
+	}
+	if eols > 0 {
+		c.Discard(eols)
+		buf = buf[eols:]
+	}
+	headerEnd, bodyStart := stompHeaderEnd(buf)
+	if headerEnd < 0 {
+		if exceedsFrameLength(cc.MaxFrameLength, len(buf)) {
+			return nil, ErrTooLongFrame
+		}
+		return nil, ErrUnexpectedEOF
+	}
+	var bodyEnd int
+	if length, ok := stompContentLength(buf[:headerEnd]); ok {
+		if length < 0 {
+			return nil, ErrMalformedFrame
+		}
+		// The length is compared before being added to bodyStart, so that a huge one can't overflow.
+		if cc.MaxFrameLength > 0 && length > cc.MaxFrameLength-bodyStart {
+			return nil, ErrTooLongFrame
+		}
+		if length >= len(buf)-bodyStart {
+			return nil, ErrUnexpectedEOF
+		}
+		bodyEnd = bodyStart + length
+		if buf[bodyEnd] != 0 {
+			return nil, ErrMalformedFrame
+		}
+	} else if bodyEnd = bytes.IndexByte(buf[bodyStart:], 0); bodyEnd < 0 {
+		if exceedsFrameLength(cc.MaxFrameLength, len(buf)) {
+			return nil, ErrTooLongFrame
+		}
+		return nil, ErrUnexpectedEOF
+	} else {
+		bodyEnd += bodyStart
+	}
+	if exceedsFrameLength(cc.MaxFrameLength, bodyEnd) {
+		return nil, ErrTooLongFrame
+	}
+	_, frame := c.ReadN(bodyEnd + 1)
+	return frame[:bodyEnd], nil
+}
+
+// stompHeaderEnd returns the end of headers and the start of body, namely the positions of the blank line and
+// the byte following it, or -1 if the blank line hasn't arrived.
+func stompHeaderEnd(buf []byte) (int, int) {
+	for i := 0; i < len(buf); i++ {
+		if buf[i] != '\n' {
+			continue
+		}
+		if i+1 < len(buf) && buf[i+1] == '\n' {
+			return i, i + 2
+		}
+		if i+2 < len(buf) && buf[i+1] == '\r' && buf[i+2] == '\n' {
+			return i, i + 3
+		}
+	}
+	return -1, -1
+}
+
+// stompContentLength returns the value of the first content-length header, which is -1 if it's invalid.
+func stompContentLength(header []byte) (int, bool) {
+	for _, line := range bytes.Split(header, []byte{'\n'}) {
+		line = bytes.TrimSuffix(line, []byte{'\r'})
+		if !bytes.HasPrefix(line, []byte("content-length:")) {
+			continue
+		}
+		length, err := strconv.Atoi(string(line[len("content-length:"):]))
+		if err != nil || length < 0 {
+			return -1, true
+		}
+		return length, true
+	}
+	return 0, false
+}
+
+// StompFrame is a frame of STOMP.
+type StompFrame struct {
+	// Command is the command of frame, e.g. SEND or MESSAGE.
+	Command string
+
+	// Headers are the headers of frame in order, each of which is a pair of name and value.
+	Headers [][2]string
+
+	// Body is the body of frame.
+	Body []byte
+}
+
+// Header returns the value of the first header with the name, repeated headers are ignored as STOMP requires.
+func (f *StompFrame) Header(name string) (string, bool) {
+	for _, h := range f.Headers {
+		if h[0] == name {
+			return h[1], true
+		}
+	}
+	return "", false
+}
+
+// AddHeader appends the header to the frame.
+func (f *StompFrame) AddHeader(name, value string) {
+	f.Headers = append(f.Headers, [2]string{name, value})
+}
+
+// Marshal makes the frame for StompCodec, the headers are escaped except for CONNECT and CONNECTED frames, and
+// the content-length header is added for the body unless it's present.
+func (f *StompFrame) Marshal() []byte {
+	var buf bytes.Buffer
+	buf.WriteString(f.Command)
+	buf.WriteByte('\n')
+	escape := stompEscapes(f.Command)
+	for _, h := range f.Headers {
+		buf.WriteString(escapeStompHeader(h[0], escape))
+		buf.WriteByte(':')
+		buf.WriteString(escapeStompHeader(h[1], escape))
+		buf.WriteByte('\n')
+	}
+	if _, ok := f.Header("content-length"); !ok && len(f.Body) > 0 {
+		buf.WriteString("content-length:")
+		buf.WriteString(strconv.Itoa(len(f.Body)))
+		buf.WriteByte('\n')
+	}
+	buf.WriteByte('\n')
+	buf.Write(f.Body)
+	return buf.Bytes()
+}
+
+// ParseStompFrame parses the frame decoded by StompCodec, the error is ErrMalformedFrame if it's invalid.
+func ParseStompFrame(frame []byte) (*StompFrame, error) {
+	headerEnd, bodyStart := stompHeaderEnd(frame)
+	if headerEnd < 0 {
+		return nil, ErrMalformedFrame
+	}
+	lines := strings.Split(string(frame[:headerEnd]), "\n")
+	f := &StompFrame{Command: strings.TrimSuffix(lines[0], "\r")}
+	if f.Command == "" {
+		return nil, ErrMalformedFrame
+	}
+	escape := stompEscapes(f.Command)
+	for _, line := range lines[1:] {
+		line = strings.TrimSuffix(line, "\r")
+		idx := strings.IndexByte(line, ':')
+		if idx < 0 {
+			return nil, ErrMalformedFrame
+		}
+		name, ok := unescapeStompHeader(line[:idx], escape)
+		if !ok {
+			return nil, ErrMalformedFrame
+		}
+		value, ok := unescapeStompHeader(line[idx+1:], escape)
+		if !ok {
+			return nil, ErrMalformedFrame
+		}
+		f.AddHeader(name, value)
+	}
+	f.Body = frame[bodyStart:]
+	return f, nil
+}
+
+// stompEscapes reports whether the headers of frames with the command are escaped.
+func stompEscapes(command string) bool {
+	return command != "CONNECT" && command != "CONNECTED"
+}
+
+var stompEscaper = strings.NewReplacer("\\", "\\\\", "\r", "\\r", "\n", "\\n", ":", "\\c")
+
+func escapeStompHeader(s string, escape bool) string {
+	if !escape {
+		return s
+	}
+	return stompEscaper.Replace(s)
+}
+
+// unescapeStompHeader unescapes the header, undefined escape sequences are invalid.
+func unescapeStompHeader(s string, escape bool) (string, bool) {
+	if !escape || strings.IndexByte(s, '\\') < 0 {
+		return s, true
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i++; i == len(s) {
+			return "", false
+		}
+		switch s[i] {
+		case 'r':
+			b.WriteByte('\r')
+		case 'n':
+			b.WriteByte('\n')
+		case 'c':
+			b.WriteByte(':')
+		case '\\':
+			b.WriteByte('\\')
+		default:
+			return "", false
+		}
+	}
+	return b.String(), true
+}
@@ -0,0 +1,131 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import (
+	"bytes"
+	"strconv"
+)
+
+// SyslogFraming is the framing of syslog messages over TCP as RFC 6587 specifies.
+type SyslogFraming int
+
+const (
+	// SyslogAutoDetect detects the framing of every connection from its first message.
+	SyslogAutoDetect SyslogFraming = iota
+
+	// SyslogOctetCounting prefixes every message with its length and a space, e.g. "11 <34>1 - ...".
+	SyslogOctetCounting
+
+	// SyslogNonTransparent terminates every message with LF, a.k.a. the traditional framing.
+	SyslogNonTransparent
+)
+
+// syslogMaxLengthDigits is the maximum digits of the length of octet-counting messages.
+const syslogMaxLengthDigits = 9
+
+// SyslogCodec encodes/decodes syslog messages into/from TCP stream, in either the octet-counting or non-transparent
+// framing of RFC 6587. With SyslogAutoDetect, the framing of a connection is detected from its first message, which
+// starts with a digit for the octet-counting framing, or with the PRI part, i.e. '<', otherwise, so that the messages
+// of both rsyslog and syslog-ng are accepted. The decoded frames are the bare messages without the framing.
+type SyslogCodec struct {
+	// Framing is the framing of inbound messages, SyslogAutoDetect by default.
+	Framing SyslogFraming
+
+	// MaxFrameLength is the maximum length of messages, the connection is closed with ErrTooLongFrame once it's
+	// exceeded. Zero means no limit.
+	MaxFrameLength int
+}
+
+// syslogKey is the key of the framing detected for connections.
+type syslogKey struct {
+	codec *SyslogCodec
+}
+
+// ConnFraming returns the framing of connection, which is SyslogAutoDetect until its first message arrives.
+func (cc *SyslogCodec) ConnFraming(c Conn) SyslogFraming {
+	if cc.Framing != SyslogAutoDetect {
+		return cc.Framing
+	}
+	framing, _ := c.Get(syslogKey{cc}).(SyslogFraming)
+	return framing
+}
+
+// Encode frames the message in the octet-counting framing, unless Framing is SyslogNonTransparent.
+func (cc *SyslogCodec) Encode(buf []byte) ([]byte, error) {
+	return encodeSyslog(cc.Framing, buf), nil
+}
+
+// EncodeConn frames the message in the framing of connection, so the replies of collectors are framed the same way
+// as the messages of senders.
+func (cc *SyslogCodec) EncodeConn(c Conn, buf []byte) ([]byte, error) {
+	return encodeSyslog(cc.ConnFraming(c), buf), nil
+}
+
+func encodeSyslog(framing SyslogFraming, buf []byte) []byte {
+	if framing == SyslogNonTransparent {
+		return append(buf, '\n')
+	}
+	out := strconv.AppendInt(make([]byte, 0, syslogMaxLengthDigits+1+len(buf)), int64(len(buf)), 10)
+	out = append(out, ' ')
+	return append(out, buf...)
+}
+
+// Decode decodes a message from TCP stream, the connection is closed with ErrMalformedFrame if the length of
+// octet-counting message is invalid.
+func (cc *SyslogCodec) Decode(c Conn) ([]byte, error) {
+	buf := c.Read()
+	if len(buf) == 0 {
+		return nil, ErrUnexpectedEOF
+	}
+	framing := cc.ConnFraming(c)
+	if framing == SyslogAutoDetect {
+		framing = SyslogNonTransparent
+		if buf[0] >= '0' && buf[0] <= '9' {
+			framing = SyslogOctetCounting
+		}
+		c.Set(syslogKey{cc}, framing)
+	}
+	if framing == SyslogNonTransparent {
+		idx := bytes.IndexByte(buf, '\n')
+		if idx == -1 {
+			if exceedsFrameLength(cc.MaxFrameLength, len(buf)) {
+				return nil, ErrTooLongFrame
+			}
+			return nil, ErrUnexpectedEOF
+		}
+		if exceedsFrameLength(cc.MaxFrameLength, idx) {
+			return nil, ErrTooLongFrame
+		}
+		_, buf = c.ReadN(idx + 1)
+		return buf[:idx], nil
+	}
+
+	idx := bytes.IndexByte(buf, ' ')
+	if idx == -1 {
+		if len(buf) > syslogMaxLengthDigits {
+			return nil, ErrMalformedFrame
+		}
+		return nil, ErrUnexpectedEOF
+	}
+	if idx == 0 || idx > syslogMaxLengthDigits || buf[0] == '0' {
+		return nil, ErrMalformedFrame
+	}
+	length := 0
+	for _, b := range buf[:idx] {
+		if b < '0' || b > '9' {
+			return nil, ErrMalformedFrame
+		}
+		length = length*10 + int(b-'0')
+	}
+	if exceedsFrameLength(cc.MaxFrameLength, length) {
+		return nil, ErrTooLongFrame
+	}
+	size, frame := c.ReadN(idx + 1 + length)
+	if size == 0 {
+		return nil, ErrUnexpectedEOF
+	}
+	return frame[idx+1:], nil
+}
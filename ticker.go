@@ -0,0 +1,75 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build darwin netbsd freebsd openbsd dragonfly linux
+
+package gnet
+
+import "time"
+
+// ticker is a periodic task registered by Server.Schedule.
+type ticker struct {
+	interval time.Duration
+	fn       func() Action
+	timer    *Timer
+}
+
+// Schedule registers a periodic task which runs fn in one of the event-loops every interval, until it's
+// removed by Unschedule or fn returns Shutdown, which shuts the server down. Tickers are independent of
+// each other and of EventHandler.Tick, they can be added and removed at any time.
+func (s Server) Schedule(name string, interval time.Duration, fn func() Action) error {
+	return s.svr.schedule(name, interval, fn)
+}
+
+// Unschedule removes the ticker registered by Schedule, it reports false if there is no such ticker.
+func (s Server) Unschedule(name string) bool {
+	return s.svr.unschedule(name)
+}
+
+func (svr *server) schedule(name string, interval time.Duration, fn func() Action) error {
+	if interval <= 0 {
+		return ErrInvalidTickerInterval
+	}
+	svr.timerMu.Lock()
+	defer svr.timerMu.Unlock()
+	if _, ok := svr.tickers[name]; ok {
+		return ErrTickerExists
+	}
+	tk := &ticker{interval: interval, fn: fn}
+	var fire func()
+	fire = func() {
+		svr.timerMu.Lock()
+		registered, t := svr.tickers[name] == tk, tk.timer
+		svr.timerMu.Unlock()
+		if !registered {
+			return
+		}
+		lp := t.lp
+		if tk.fn() == Shutdown {
+			lp.setTimerErr(ErrServerShutdown)
+			return
+		}
+		if !t.stopped {
+			// Keep the ticker in the same loop, a pending Unschedule stops the renewed timer.
+			t.t = lp.timers.AfterFunc(time.Now(), tk.interval, fire)
+		}
+	}
+	if svr.tickers == nil {
+		svr.tickers = make(map[string]*ticker)
+	}
+	svr.tickers[name] = tk
+	tk.timer = svr.afterFuncLocked(interval, fire)
+	return nil
+}
+
+func (svr *server) unschedule(name string) bool {
+	svr.timerMu.Lock()
+	tk, ok := svr.tickers[name]
+	delete(svr.tickers, name)
+	svr.timerMu.Unlock()
+	if ok {
+		tk.timer.Stop()
+	}
+	return ok
+}
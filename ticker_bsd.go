@@ -0,0 +1,15 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build darwin netbsd freebsd openbsd dragonfly
+
+package gnet
+
+// startTicker drives Tick with the ticker goroutine.
+func (lp *loop) startTicker() {
+	go lp.loopTicker()
+}
+
+// closeTicker is a no-op without the timerfd.
+func (lp *loop) closeTicker() {}
@@ -0,0 +1,49 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux
+
+package gnet
+
+import (
+	"github.com/panjf2000/gnet/netpoll"
+	"golang.org/x/sys/unix"
+)
+
+// startTicker drives Tick with a timerfd registered in the poller of loop, which fires Tick in the loop
+// without waking it up from another goroutine, it falls back to the ticker goroutine if timerfd is unavailable.
+func (lp *loop) startTicker() {
+	fd, err := netpoll.OpenTimerfd()
+	if err == nil {
+		if err = lp.poller.AddRead(fd); err == nil {
+			if err = netpoll.ArmTimerfd(fd, 0); err == nil {
+				lp.tickerFd = fd
+				return
+			}
+		}
+		_ = unix.Close(fd)
+	}
+	sniffError(err)
+	go lp.loopTicker()
+}
+
+// loopTick fires Tick when the timerfd expires and re-arms it with the delay returned by Tick.
+func (lp *loop) loopTick() error {
+	if err := netpoll.ReadTimerfd(lp.tickerFd); err != nil {
+		return err
+	}
+	delay, action := lp.svr.eventHandler.Tick()
+	if action == Shutdown {
+		return ErrServerShutdown
+	}
+	return netpoll.ArmTimerfd(lp.tickerFd, delay)
+}
+
+// closeTicker closes the timerfd of loop.
+func (lp *loop) closeTicker() {
+	if lp.tickerFd > 0 {
+		_ = unix.Close(lp.tickerFd)
+		lp.tickerFd = 0
+	}
+}
@@ -0,0 +1,185 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build darwin netbsd freebsd openbsd dragonfly linux
+
+package gnet
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/panjf2000/gnet/internal"
+)
+
+// timerResolution is the resolution of the timing wheels of event-loops.
+const timerResolution = time.Millisecond
+
+// Timer is a timer scheduled by Server.AfterFunc or Conn.SetTimer, whose function runs in an event-loop.
+type Timer struct {
+	svr     *server         // server owning the timer, nil for timers of connection
+	lp      *loop           // loop running the timer, protected by svr.timerMu for timers of server
+	c       *conn           // connection owning the timer, nil for timers of server
+	pending bool            // whether the timer of server waits for the loops, protected by svr.timerMu
+	t       *internal.Timer // timer in the timing wheel, only accessed by the event-loop
+	stopped bool            // only accessed by the event-loop
+}
+
+// Stop cancels the timer asynchronously, the function won't run if the timer hasn't fired
+// by the time the event-loop handles the cancellation.
+func (t *Timer) Stop() {
+	var lp *loop
+	if t.svr == nil {
+		lp = t.lp
+	} else {
+		t.svr.timerMu.Lock()
+		lp = t.lp
+		if t.pending {
+			t.pending = false
+			t.svr.timerMu.Unlock()
+			return
+		}
+		t.svr.timerMu.Unlock()
+	}
+	sniffError(lp.poller.Trigger(func() error {
+		lp.loopStopTimer(t)
+		return nil
+	}))
+}
+
+// AfterFunc runs f in one of the event-loops after d elapses.
+func (s Server) AfterFunc(d time.Duration, f func()) *Timer {
+	return s.svr.afterFunc(d, f)
+}
+
+// SetTimer runs f in the event-loop of connection after d elapses, the connection is closed with the error
+// returned by f. The timer is cancelled when the connection is closed.
+func (c *conn) SetTimer(d time.Duration, f func(c Conn) error) (*Timer, error) {
+	if c.loop == nil {
+		return nil, ErrNotStream // datagrams of UDP and unixgram aren't bound to loops.
+	}
+	t := &Timer{lp: c.loop, c: c}
+	sniffError(c.loop.poller.Trigger(func() error {
+		lp := c.loop
+		if t.stopped || lp.connections.get(c.fd) != c {
+			return nil // ignore timers of the closed connection.
+		}
+		lp.addConnTimer(t, d, f)
+		return nil
+	}))
+	return t, nil
+}
+
+// addConnTimer adds the timer of connection into the timing wheel of loop.
+func (lp *loop) addConnTimer(t *Timer, d time.Duration, f func(c Conn) error) {
+	c := t.c
+	if c.timers == nil {
+		c.timers = make(map[*Timer]struct{})
+	}
+	c.timers[t] = struct{}{}
+	t.t = lp.timers.AfterFunc(time.Now(), d, func() {
+		delete(c.timers, t)
+		if lp.connections.get(c.fd) != c {
+			return
+		}
+		c.touch(LeakEventTimer)
+		if err := f(c); err != nil && c.opened {
+			lp.setTimerErr(lp.loopCloseConn(c, err))
+		}
+	})
+}
+
+// setTimerErr records the first error of the expired timers, which is returned by Expire.
+func (lp *loop) setTimerErr(err error) {
+	if lp.timerErr == nil {
+		lp.timerErr = err
+	}
+}
+
+func (svr *server) afterFunc(d time.Duration, f func()) *Timer {
+	svr.timerMu.Lock()
+	defer svr.timerMu.Unlock()
+	return svr.afterFuncLocked(d, f)
+}
+
+// afterFuncLocked must be called with svr.timerMu held.
+func (svr *server) afterFuncLocked(d time.Duration, f func()) *Timer {
+	t := &Timer{svr: svr}
+	if !svr.timersReady {
+		// The event-loops haven't been started yet, e.g. in OnInitComplete.
+		t.pending = true
+		svr.pendingTimers = append(svr.pendingTimers, pendingTimer{t, d, f})
+		return t
+	}
+	svr.scheduleTimer(t, d, f)
+	return t
+}
+
+// pendingTimer is a timer scheduled before the event-loops are started.
+type pendingTimer struct {
+	t *Timer
+	d time.Duration
+	f func()
+}
+
+// startTimers schedules the timers pending for the event-loops.
+func (svr *server) startTimers() {
+	svr.timerMu.Lock()
+	defer svr.timerMu.Unlock()
+	for _, pt := range svr.pendingTimers {
+		if pt.t.pending {
+			pt.t.pending = false
+			svr.scheduleTimer(pt.t, pt.d, pt.f)
+		}
+	}
+	svr.pendingTimers = nil
+	svr.timersReady = true
+}
+
+// scheduleTimer must be called with svr.timerMu held, it distributes the timer to the event-loops in a round-robin fashion.
+func (svr *server) scheduleTimer(t *Timer, d time.Duration, f func()) {
+	idx := int(atomic.AddUint32(&svr.timerSeq, 1) % uint32(svr.subLoopGroupSize))
+	svr.subLoopGroup.iterate(func(i int, lp *loop) bool {
+		if i != idx {
+			return true
+		}
+		t.lp = lp
+		sniffError(lp.poller.Trigger(func() error {
+			if !t.stopped {
+				t.t = lp.timers.AfterFunc(time.Now(), d, f)
+			}
+			return nil
+		}))
+		return false
+	})
+}
+
+func (lp *loop) loopStopTimer(t *Timer) {
+	t.stopped = true
+	if t.t != nil {
+		t.t.Stop()
+	}
+	if t.c != nil && t.c.timers != nil {
+		delete(t.c.timers, t)
+	}
+}
+
+// Next implements netpoll.TimerHook.
+func (lp *loop) Next() time.Duration {
+	lp.markIdle()
+	if lp.timers.Len() == 0 {
+		return -1
+	}
+	return lp.timers.Next(time.Now())
+}
+
+// Expire implements netpoll.TimerHook.
+func (lp *loop) Expire() (err error) {
+	if lp.timers.Len() == 0 {
+		return nil
+	}
+	lp.timers.Advance(lp.now)
+	err, lp.timerErr = lp.timerErr, nil
+	return
+}
@@ -0,0 +1,116 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build darwin netbsd freebsd openbsd dragonfly linux
+
+package gnet
+
+import "context"
+
+// The names of spans started by Tracer.
+const (
+	// SpanAccept is the span around OnOpened of an accepted connection.
+	SpanAccept = "gnet.accept"
+
+	// SpanReact is the span around every invocation of React.
+	SpanReact = "gnet.react"
+
+	// SpanFlush is the span around flushing the outbound buffer of a connection once it's writable.
+	SpanFlush = "gnet.flush"
+)
+
+// The keys of attributes of spans.
+const (
+	AttrPeerAddr      = "net.peer.addr"
+	AttrHostAddr      = "net.host.addr"
+	AttrInboundBytes  = "gnet.inbound.bytes"
+	AttrOutboundBytes = "gnet.outbound.bytes"
+	AttrAction        = "gnet.action"
+)
+
+// TraceAttribute is an attribute of span.
+type TraceAttribute struct {
+	Key   string
+	Value interface{}
+}
+
+// Tracer starts the spans around the events of server, it mirrors the tracer of OpenTelemetry, so that a provider
+// like otel.Tracer("gnet") can be plugged in by an adapter of a few lines without gnet depending on it.
+type Tracer interface {
+	// Start starts the span named name as a child of the span in ctx, if any.
+	Start(ctx context.Context, name string, attrs ...TraceAttribute) (context.Context, Span)
+}
+
+// Span is a span started by Tracer.
+type Span interface {
+	// SetAttributes sets the attributes known once the event has been handled.
+	SetAttributes(attrs ...TraceAttribute)
+
+	// End ends the span, err is the error the event failed with, if any.
+	End(err error)
+}
+
+// TraceContext returns the context carrying the span of React in progress, for starting child spans within
+// React, or nil outside React or without WithTracer.
+func TraceContext(c Conn) context.Context {
+	if cc, ok := c.(*conn); ok {
+		return cc.traceCtx
+	}
+	return nil
+}
+
+// tracedHandler starts the spans of accept and React around the events of wrapped handler.
+type tracedHandler struct {
+	EventHandler
+	svr *server
+}
+
+func (h *tracedHandler) OnOpened(c Conn) (out []byte, action Action) {
+	_, span := h.svr.opts.Tracer.Start(h.svr.ctx, SpanAccept, connAttributes(c)...)
+	out, action = h.EventHandler.OnOpened(c)
+	span.SetAttributes(
+		TraceAttribute{Key: AttrOutboundBytes, Value: len(out)},
+		TraceAttribute{Key: AttrAction, Value: int(action)},
+	)
+	span.End(nil)
+	return
+}
+
+func (h *tracedHandler) React(c Conn) (out []byte, action Action) {
+	cc := c.(*conn)
+	parent := context.Context(h.svr.ctx)
+	if ctx := cc.closeContext(); ctx != nil {
+		parent = ctx
+	}
+	attrs := append(connAttributes(c), TraceAttribute{Key: AttrInboundBytes, Value: c.BufferLength()})
+	ctx, span := h.svr.opts.Tracer.Start(parent, SpanReact, attrs...)
+	cc.traceCtx = ctx
+	out, action = h.EventHandler.React(c)
+	cc.traceCtx = nil
+	span.SetAttributes(
+		TraceAttribute{Key: AttrOutboundBytes, Value: len(out)},
+		TraceAttribute{Key: AttrAction, Value: int(action)},
+	)
+	span.End(nil)
+	return
+}
+
+// loopTracedOut flushes the outbound buffer of connection within the span of flush.
+func (lp *loop) loopTracedOut(c *conn) error {
+	pending := c.outboundLength()
+	_, span := lp.svr.opts.Tracer.Start(lp.svr.ctx, SpanFlush, connAttributes(c)...)
+	err := lp.loopFlush(c)
+	if c.opened {
+		span.SetAttributes(TraceAttribute{Key: AttrOutboundBytes, Value: pending - c.outboundLength()})
+	}
+	span.End(err)
+	return err
+}
+
+func connAttributes(c Conn) []TraceAttribute {
+	return []TraceAttribute{
+		{Key: AttrPeerAddr, Value: addrString(c.RemoteAddr())},
+		{Key: AttrHostAddr, Value: addrString(c.LocalAddr())},
+	}
+}
@@ -0,0 +1,40 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build darwin netbsd freebsd openbsd dragonfly linux
+
+package gnet
+
+// TriggerStats records the counters of jobs triggered to event-loops, e.g. by AsyncWrite and Wake,
+// for verifying how well the wakeups of event-loops are coalesced.
+type TriggerStats struct {
+	// Triggered is the number of jobs triggered to event-loops.
+	Triggered int64
+
+	// Wakeups is the number of times event-loops were woken up for the triggered jobs, the jobs triggered
+	// to an event-loop before it runs them share one wakeup.
+	Wakeups int64
+
+	// Pending is the number of jobs waiting to be run by event-loops, namely the total depth of job queues.
+	Pending int64
+}
+
+func (s *TriggerStats) add(lp *loop) {
+	stats := lp.poller.TriggerStats()
+	s.Triggered += stats.Triggered
+	s.Wakeups += stats.Wakeups
+	s.Pending += stats.Pending
+}
+
+// TriggerStats returns the counters of jobs triggered to event-loops.
+func (s Server) TriggerStats() (stats TriggerStats) {
+	s.svr.subLoopGroup.iterate(func(i int, lp *loop) bool {
+		stats.add(lp)
+		return true
+	})
+	if s.svr.mainLoop != nil {
+		stats.add(s.svr.mainLoop)
+	}
+	return
+}
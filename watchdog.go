@@ -0,0 +1,116 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build darwin netbsd freebsd openbsd dragonfly linux
+
+package gnet
+
+import (
+	"bytes"
+	"runtime"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// minWatchdogInterval is the minimum interval of checking event-loops for stalls.
+const minWatchdogInterval = time.Millisecond
+
+// markGoroutine records the goroutine running the event-loop, for capturing its stack on stalls.
+func (lp *loop) markGoroutine() {
+	if lp.svr.opts.OnLoopStall == nil {
+		return
+	}
+	buf := make([]byte, 64)
+	buf = buf[:runtime.Stack(buf, false)]
+	// The stack starts with "goroutine N [running]:".
+	buf = bytes.TrimPrefix(buf, []byte("goroutine "))
+	if i := bytes.IndexByte(buf, ' '); i > 0 {
+		id, _ := strconv.ParseInt(string(buf[:i]), 10, 64)
+		atomic.StoreInt64(&lp.counters.goroutine, id)
+	}
+}
+
+// markBusy records the time when the event-loop returned from polling, it's invoked by the wakeup hook of poller.
+func (lp *loop) markBusy() {
+	if lp.svr.opts.OnLoopStall != nil {
+		atomic.StoreInt64(&lp.counters.busySince, lp.now.UnixNano())
+	}
+}
+
+// markIdle records that the event-loop is going to wait for events, it's invoked by the timer hook of poller
+// right before polling.
+func (lp *loop) markIdle() {
+	if lp.svr.opts.OnLoopStall != nil {
+		atomic.StoreInt64(&lp.counters.busySince, 0)
+	}
+}
+
+// startWatchdog starts the goroutine checking whether any event-loop has been busy for longer than
+// StallThreshold in an iteration, which fires OnLoopStall once per stalled iteration until the server shuts down.
+func (svr *server) startWatchdog() {
+	threshold, onStall := svr.opts.StallThreshold, svr.opts.OnLoopStall
+	if threshold <= 0 || onStall == nil {
+		return
+	}
+	interval := threshold / 4
+	if interval < minWatchdogInterval {
+		interval = minWatchdogInterval
+	}
+	var loops []*loop
+	svr.subLoopGroup.iterate(func(i int, lp *loop) bool {
+		loops = append(loops, lp)
+		return true
+	})
+	reported := make([]int64, len(loops))
+	svr.wg.Add(1)
+	go func() {
+		defer svr.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				for i, lp := range loops {
+					busySince := atomic.LoadInt64(&lp.counters.busySince)
+					if busySince == 0 || busySince == reported[i] {
+						continue
+					}
+					if d := now.Sub(time.Unix(0, busySince)); d > threshold {
+						reported[i] = busySince
+						onStall(lp.idx, d, goroutineStack(atomic.LoadInt64(&lp.counters.goroutine)))
+					}
+				}
+			case <-svr.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// goroutineStack returns the stack of the goroutine, or nil if it isn't found.
+func goroutineStack(id int64) []byte {
+	if id == 0 {
+		return nil
+	}
+	buf := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	header := []byte("goroutine " + strconv.FormatInt(id, 10) + " [")
+	start := bytes.Index(buf, header)
+	if start < 0 {
+		return nil
+	}
+	stack := buf[start:]
+	if end := bytes.Index(stack, []byte("\n\n")); end >= 0 {
+		stack = stack[:end+1]
+	}
+	return stack
+}
@@ -0,0 +1,114 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build darwin netbsd freebsd openbsd dragonfly linux
+
+package gnet
+
+import (
+	"bytes"
+	"io"
+)
+
+// maxFillChunks is the maximum number of chunks pulled from the streams of a connection at once, the rest of
+// them are pulled in the next iteration of event-loop so that the other connections aren't starved.
+const maxFillChunks = 16
+
+// writeSource is a stream queued by AsyncWriteFrom.
+type writeSource struct {
+	r io.Reader
+	n int64 // number of bytes left, negative for the stream written until EOF
+}
+
+// close closes the reader of stream if it's an io.Closer.
+func (src *writeSource) close() {
+	if closer, ok := src.r.(io.Closer); ok {
+		_ = closer.Close()
+	}
+}
+
+func (c *conn) AsyncWriteFrom(r io.Reader, n int64) {
+	if c.loop == nil {
+		return
+	}
+	src := &writeSource{r: r, n: n}
+	sniffError(c.loop.poller.Trigger(func() error {
+		return c.loop.loopWriteFrom(c, src)
+	}))
+}
+
+func (lp *loop) loopWriteFrom(c *conn, src *writeSource) error {
+	if lp.connections.get(c.fd) != c || c.writeClosed {
+		src.close()
+		return nil // ignore streams of the closed connection.
+	}
+	c.sources = append(c.sources, src)
+	if len(c.sources) > 1 || !c.outboundEmpty() {
+		return nil // pulled once the data ahead of it has been written.
+	}
+	return lp.loopFill(c)
+}
+
+// queueSource queues the data behind the pending streams to keep the order of writes.
+func (c *conn) queueSource(buf []byte) {
+	data := append([]byte(nil), buf...)
+	c.sources = append(c.sources, &writeSource{r: bytes.NewReader(data), n: int64(len(data))})
+}
+
+// loopFill pulls chunks from the pending streams and writes them until the socket isn't able to take more,
+// in which case loopOut resumes it once the outbound buffer is drained.
+func (lp *loop) loopFill(c *conn) error {
+	for i := 0; i < maxFillChunks && len(c.sources) > 0 && c.outboundEmpty(); i++ {
+		src := c.sources[0]
+		buf := lp.packet
+		if src.n >= 0 && src.n < int64(len(buf)) {
+			buf = buf[:src.n]
+		}
+		var (
+			n   int
+			err error
+		)
+		if len(buf) > 0 {
+			n, err = src.r.Read(buf)
+		}
+		if n > 0 {
+			if src.n > 0 {
+				src.n -= int64(n)
+			}
+			c.writeOut(buf[:n], false)
+			if !c.opened {
+				return nil // closed by the failure of writing.
+			}
+		}
+		if err == io.EOF {
+			err = nil
+			if src.n > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			src.n = 0
+		}
+		if err != nil {
+			return lp.loopCloseConn(c, err)
+		}
+		if src.n == 0 {
+			src.close()
+			c.sources[0] = nil
+			c.sources = c.sources[1:]
+		}
+	}
+	switch {
+	case !c.outboundEmpty():
+		return nil
+	case len(c.sources) > 0:
+		return lp.poller.Trigger(func() error {
+			if lp.connections.get(c.fd) != c || !c.outboundEmpty() {
+				return nil
+			}
+			return lp.loopFill(c)
+		})
+	case c.writeClosed:
+		return lp.loopShutdownWrite(c)
+	}
+	return nil
+}